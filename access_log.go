@@ -0,0 +1,243 @@
+package goproxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Fetch sources recorded by [fetchTrace].
+const (
+	fetchSourceCache  = "cache"
+	fetchSourceProxy  = "proxy"
+	fetchSourceDirect = "direct"
+)
+
+// fetchTrace records how a request is served, mainly for access logging.
+//
+// All methods of fetchTrace are safe to call on a nil receiver.
+type fetchTrace struct {
+	mu            sync.Mutex
+	target        string
+	modulePath    string
+	moduleVersion string
+	source        string
+	upstream      string
+	fetched       bool
+	cached        []string
+}
+
+// fetchTraceContextKey is the context key for [fetchTrace].
+type fetchTraceContextKey struct{}
+
+// withFetchTrace returns a copy of the ctx that carries the ft.
+func withFetchTrace(ctx context.Context, ft *fetchTrace) context.Context {
+	return context.WithValue(ctx, fetchTraceContextKey{}, ft)
+}
+
+// fetchTraceFromContext returns the [fetchTrace] carried by the ctx, or nil if
+// there is none.
+func fetchTraceFromContext(ctx context.Context) *fetchTrace {
+	ft, _ := ctx.Value(fetchTraceContextKey{}).(*fetchTrace)
+	return ft
+}
+
+// setTarget sets the request target of the ft.
+func (ft *fetchTrace) setTarget(target string) {
+	if ft == nil {
+		return
+	}
+	ft.mu.Lock()
+	ft.target = target
+	ft.mu.Unlock()
+}
+
+// setModule sets the module path and version (or version query) of the ft.
+func (ft *fetchTrace) setModule(modulePath, moduleVersion string) {
+	if ft == nil {
+		return
+	}
+	ft.mu.Lock()
+	ft.modulePath = modulePath
+	ft.moduleVersion = moduleVersion
+	ft.mu.Unlock()
+}
+
+// setSource sets the source of the content served by the ft. The upstream is
+// the redacted URL of the upstream proxy when the source is
+// [fetchSourceProxy].
+func (ft *fetchTrace) setSource(source, upstream string) {
+	if ft == nil {
+		return
+	}
+	ft.mu.Lock()
+	ft.source = source
+	ft.upstream = upstream
+	ft.mu.Unlock()
+}
+
+// setFetched marks the ft as having fetched content from the [Fetcher].
+func (ft *fetchTrace) setFetched() {
+	if ft == nil {
+		return
+	}
+	ft.mu.Lock()
+	ft.fetched = true
+	ft.mu.Unlock()
+}
+
+// addCached records that the content for the name has been put to the
+// [Cacher].
+func (ft *fetchTrace) addCached(name string) {
+	if ft == nil {
+		return
+	}
+	ft.mu.Lock()
+	ft.cached = append(ft.cached, name)
+	ft.mu.Unlock()
+}
+
+// FetchEvent describes a fetch from the [Fetcher] performed by [Goproxy] to
+// serve a request or to prefetch a new version of a hot module. See
+// [Goproxy.OnFetch].
+type FetchEvent struct {
+	// Time is the time when the request was received.
+	Time time.Time
+
+	// Target is the request target, such as
+	// "example.com/@v/v1.0.0.info".
+	Target string
+
+	// ModulePath is the module path.
+	ModulePath string
+
+	// ModuleVersion is the module version or version query. It is empty
+	// for version list requests.
+	ModuleVersion string
+
+	// Source is where the content came from. It is "proxy" for an upstream
+	// proxy from the GOPROXY list, "direct" for a direct fetch, or empty if
+	// unknown. It is only known for the default [GoFetcher].
+	Source string
+
+	// Upstream is the redacted URL of the upstream proxy when Source is
+	// "proxy".
+	Upstream string
+
+	// Cached is the names of the content put to the [Cacher] during the
+	// fetch.
+	Cached []string
+
+	// Client is the network address of the client. It is empty for
+	// prefetches.
+	Client string
+
+	// User is the user name that the client is authenticated as using HTTP
+	// Basic Authentication, typically set by a reverse proxy in front of
+	// [Goproxy]. It is empty if there is none.
+	User string
+}
+
+// fetchEvent returns the [FetchEvent] recorded by the ft for the req that was
+// received at the startTime. The req is nil for fetches that do not serve a
+// request, such as prefetches. It returns false if the ft has not fetched
+// anything.
+func (ft *fetchTrace) fetchEvent(req *http.Request, startTime time.Time) (FetchEvent, bool) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if !ft.fetched {
+		return FetchEvent{}, false
+	}
+	fe := FetchEvent{
+		Time:          startTime,
+		Target:        ft.target,
+		ModulePath:    ft.modulePath,
+		ModuleVersion: ft.moduleVersion,
+		Source:        ft.source,
+		Upstream:      ft.upstream,
+		Cached:        slices.Clone(ft.cached),
+	}
+	if req != nil {
+		fe.Client = req.RemoteAddr
+		fe.User = requestUser(req)
+	}
+	return fe, true
+}
+
+// accessLogResponseWriter is an [http.ResponseWriter] that records the status
+// code and the number of bytes written for access logging.
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	written    int64
+}
+
+// WriteHeader implements [http.ResponseWriter].
+func (rw *accessLogResponseWriter) WriteHeader(statusCode int) {
+	if rw.statusCode == 0 {
+		rw.statusCode = statusCode
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements [http.ResponseWriter].
+func (rw *accessLogResponseWriter) Write(b []byte) (int, error) {
+	if rw.statusCode == 0 {
+		rw.statusCode = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.written += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying [http.ResponseWriter]. It is used by
+// [http.ResponseController].
+func (rw *accessLogResponseWriter) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
+
+// logAccess logs an access record for the req to the g.AccessLogger.
+func (g *Goproxy) logAccess(req *http.Request, ft *fetchTrace, rw *accessLogResponseWriter, duration time.Duration) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	attrs := []slog.Attr{
+		slog.String("method", req.Method),
+		slog.String("target", ft.target),
+	}
+	if ft.modulePath != "" {
+		attrs = append(attrs, slog.String("module_path", ft.modulePath))
+	}
+	if ft.moduleVersion != "" {
+		attrs = append(attrs, slog.String("module_version", ft.moduleVersion))
+	}
+	statusCode := rw.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	attrs = append(
+		attrs,
+		slog.Int("status", statusCode),
+		slog.Int64("bytes", rw.written),
+		slog.Duration("duration", duration),
+	)
+	if ft.source != "" {
+		attrs = append(attrs, slog.String("source", ft.source))
+	}
+	if ft.upstream != "" {
+		attrs = append(attrs, slog.String("upstream", ft.upstream))
+	}
+	attrs = append(attrs, slog.String("client", req.RemoteAddr))
+	if user := requestUser(req); user != "" {
+		attrs = append(attrs, slog.String("user", user))
+	}
+	g.AccessLogger.LogAttrs(req.Context(), slog.LevelInfo, "access", attrs...)
+}
+
+// requestUser returns the user name that the req is authenticated as using
+// HTTP Basic Authentication, typically set by a reverse proxy in front of
+// [Goproxy]. It returns an empty string if there is none.
+func requestUser(req *http.Request) string {
+	user, _, _ := req.BasicAuth()
+	return user
+}
@@ -0,0 +1,360 @@
+package goproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchTrace(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		ft := &fetchTrace{}
+		ctx := withFetchTrace(t.Context(), ft)
+		if got, want := fetchTraceFromContext(ctx), ft; got != want {
+			t.Errorf("got %p, want %p", got, want)
+		}
+
+		ft.setTarget("example.com/@latest")
+		ft.setModule("example.com", "latest")
+		ft.setSource(fetchSourceProxy, "https://proxy.example.com")
+		if got, want := ft.target, "example.com/@latest"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := ft.modulePath, "example.com"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := ft.moduleVersion, "latest"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := ft.source, fetchSourceProxy; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := ft.upstream, "https://proxy.example.com"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("FetchEvent", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.0.0.mod", nil)
+		req.SetBasicAuth("gopher", "")
+		startTime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		ft := &fetchTrace{target: "example.com/@v/v1.0.0.mod"}
+		ft.setModule("example.com", "v1.0.0")
+		if _, ok := ft.fetchEvent(req, startTime); ok {
+			t.Error("expected false")
+		}
+
+		ft.setFetched()
+		ft.setSource(fetchSourceProxy, "https://proxy.example.com")
+		ft.addCached("example.com/@v/v1.0.0.mod")
+		fe, ok := ft.fetchEvent(req, startTime)
+		if !ok {
+			t.Fatal("expected true")
+		}
+		if got, want := fe.Time, startTime; !got.Equal(want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := fe.Target, "example.com/@v/v1.0.0.mod"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := fe.ModulePath, "example.com"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := fe.ModuleVersion, "v1.0.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := fe.Source, fetchSourceProxy; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := fe.Upstream, "https://proxy.example.com"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := strings.Join(fe.Cached, ","), "example.com/@v/v1.0.0.mod"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := fe.Client, req.RemoteAddr; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := fe.User, "gopher"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		ft := fetchTraceFromContext(t.Context())
+		if ft != nil {
+			t.Fatalf("got %#v, want nil", ft)
+		}
+		ft.setTarget("example.com/@latest")
+		ft.setModule("example.com", "latest")
+		ft.setSource(fetchSourceDirect, "")
+		ft.setFetched()
+		ft.addCached("example.com/@latest")
+	})
+}
+
+func TestAccessLogResponseWriter(t *testing.T) {
+	for _, tt := range []struct {
+		n              int
+		writeHeader    []int
+		write          string
+		wantStatusCode int
+		wantWritten    int64
+	}{
+		{1, nil, "", 0, 0},
+		{2, nil, "foobar", http.StatusOK, 6},
+		{3, []int{http.StatusNotFound}, "not found", http.StatusNotFound, 9},
+		{4, []int{http.StatusNoContent, http.StatusOK}, "", http.StatusNoContent, 0},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rw := &accessLogResponseWriter{ResponseWriter: rec}
+			for _, statusCode := range tt.writeHeader {
+				rw.WriteHeader(statusCode)
+			}
+			if tt.write != "" {
+				if _, err := rw.Write([]byte(tt.write)); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+			}
+			if got, want := rw.statusCode, tt.wantStatusCode; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+			if got, want := rw.written, tt.wantWritten; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+			if got, want := rw.Unwrap(), http.ResponseWriter(rec); got != want {
+				t.Errorf("got %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestGoproxyLogAccess(t *testing.T) {
+	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	mod := "module example.com"
+	zip, err := makeZip(map[string][]byte{"example.com@v1.0.0/go.mod": []byte(mod)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	proxyServer := newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch path.Ext(req.URL.Path) {
+		case ".info":
+			responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+		case ".mod":
+			responseSuccess(rw, req, strings.NewReader(mod), "text/plain; charset=utf-8", -2)
+		case ".zip":
+			responseSuccess(rw, req, bytes.NewReader(zip), "application/zip", -2)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+
+	var logBuf bytes.Buffer
+	g := &Goproxy{
+		Fetcher: &GoFetcher{
+			Env:     []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			TempDir: t.TempDir(),
+		},
+		Cacher:       DirCacher(t.TempDir()),
+		TempDir:      t.TempDir(),
+		Logger:       slog.New(slog.DiscardHandler),
+		AccessLogger: slog.New(slog.NewJSONHandler(&logBuf, nil)),
+	}
+	for _, tt := range []struct {
+		n                 int
+		path              string
+		user              string
+		wantStatusCode    int
+		wantModulePath    string
+		wantModuleVersion string
+		wantBytes         int
+		wantSource        string
+		wantUpstream      string
+		wantUser          string
+	}{
+		{
+			n:                 1,
+			path:              "/example.com/@v/v1.0.0.zip",
+			wantStatusCode:    http.StatusOK,
+			wantModulePath:    "example.com",
+			wantModuleVersion: "v1.0.0",
+			wantBytes:         len(zip),
+			wantSource:        fetchSourceProxy,
+			wantUpstream:      proxyServer.URL,
+		},
+		{
+			n:                 2,
+			path:              "/example.com/@v/v1.0.0.zip",
+			user:              "gopher",
+			wantStatusCode:    http.StatusOK,
+			wantModulePath:    "example.com",
+			wantModuleVersion: "v1.0.0",
+			wantBytes:         len(zip),
+			wantSource:        fetchSourceCache,
+			wantUser:          "gopher",
+		},
+		{
+			n:              3,
+			path:           "/example.com/@v/list",
+			wantStatusCode: http.StatusNotFound,
+			wantModulePath: "example.com",
+			wantBytes:      len("not found"),
+		},
+		{
+			n:              4,
+			path:           "/",
+			wantStatusCode: http.StatusNotFound,
+			wantBytes:      len("not found"),
+		},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			logBuf.Reset()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, "")
+			}
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, req)
+			if got, want := rec.Code, tt.wantStatusCode; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+
+			var record struct {
+				Msg           string
+				Method        string
+				Target        string
+				ModulePath    string `json:"module_path"`
+				ModuleVersion string `json:"module_version"`
+				Status        int
+				Bytes         int
+				Duration      *int64
+				Source        string
+				Upstream      string
+				Client        string
+				User          string
+			}
+			if err := json.Unmarshal(logBuf.Bytes(), &record); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got, want := record.Msg, "access"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := record.Method, http.MethodGet; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := record.Target, tt.path[1:]; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := record.ModulePath, tt.wantModulePath; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := record.ModuleVersion, tt.wantModuleVersion; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := record.Status, tt.wantStatusCode; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+			if got, want := record.Bytes, tt.wantBytes; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+			if record.Duration == nil {
+				t.Error("unexpected nil")
+			}
+			if got, want := record.Source, tt.wantSource; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := record.Upstream, tt.wantUpstream; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := record.Client, req.RemoteAddr; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := record.User, tt.wantUser; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestGoproxyOnFetch(t *testing.T) {
+	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	proxyServer := newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if path.Ext(req.URL.Path) == ".info" {
+			responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+			return
+		}
+		responseNotFound(rw, req, -2)
+	}))
+
+	var fetchEvents []FetchEvent
+	g := &Goproxy{
+		Fetcher: &GoFetcher{
+			Env:     []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+			TempDir: t.TempDir(),
+		},
+		Cacher:  DirCacher(t.TempDir()),
+		TempDir: t.TempDir(),
+		Logger:  slog.New(slog.DiscardHandler),
+		OnFetch: func(fe FetchEvent) { fetchEvents = append(fetchEvents, fe) },
+	}
+	for _, tt := range []struct {
+		n              int
+		path           string
+		wantStatusCode int
+		wantFetched    bool
+	}{
+		{1, "/example.com/@v/v1.0.0.info", http.StatusOK, true},
+		{2, "/example.com/@v/v1.0.0.info", http.StatusOK, false},
+		{3, "/example.com/@v/v1.0.0.mod", http.StatusNotFound, false},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			fetchEvents = nil
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if got, want := rec.Code, tt.wantStatusCode; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+			if !tt.wantFetched {
+				if got, want := len(fetchEvents), 0; got != want {
+					t.Errorf("got %d, want %d", got, want)
+				}
+				return
+			}
+			if got, want := len(fetchEvents), 1; got != want {
+				t.Fatalf("got %d, want %d", got, want)
+			}
+			fe := fetchEvents[0]
+			if got, want := fe.Target, tt.path[1:]; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := fe.Source, fetchSourceProxy; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := fe.Upstream, proxyServer.URL; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := strings.Join(fe.Cached, ","), tt.path[1:]; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRequestUser(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if got, want := requestUser(req), ""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	req.SetBasicAuth("gopher", "secret")
+	if got, want := requestUser(req), "gopher"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,454 @@
+/*
+Package azblobcacher implements [github.com/goproxy/goproxy.Cacher] using Azure
+Blob Storage.
+*/
+package azblobcacher
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goproxy/goproxy/internal/cachecontent"
+)
+
+// apiVersion is the version of the Azure Blob Storage REST API used by
+// [Cacher].
+const apiVersion = "2021-08-06"
+
+// Cacher implements [github.com/goproxy/goproxy.Cacher] using Azure Blob
+// Storage.
+//
+// In addition to Get and Put, Cacher also implements the List and Delete
+// methods documented in [github.com/goproxy/goproxy.Cacher].
+//
+// The content returned by Get implements [io.Seeker] if the size of the
+// blob is known, so that it can be verified before being served. Reading
+// after seeking sends a range request for the same ETag of the blob.
+//
+// Make sure to set all fields before calling any methods. A Cacher must not
+// be copied after first use.
+type Cacher struct {
+	// AccountName is the name of the storage account.
+	AccountName string
+
+	// AccountKey is the base64-encoded key of the storage account used to
+	// authorize requests with Shared Key.
+	AccountKey string
+
+	// SASToken is the shared access signature used to authorize requests
+	// if AccountKey is empty.
+	//
+	// If both AccountKey and SASToken are empty, requests are sent
+	// anonymously.
+	SASToken string
+
+	// Endpoint is the endpoint of the Blob service, such as
+	// "http://127.0.0.1:10000/devstoreaccount1" for Azurite.
+	//
+	// If Endpoint is empty, "https://<AccountName>.blob.core.windows.net"
+	// is used.
+	Endpoint string
+
+	// Container is the name of the container.
+	Container string
+
+	// KeyPrefix is the prefix prepended to cache names to form blob names,
+	// such as "goproxy/".
+	KeyPrefix string
+
+	// Transport is used to perform HTTP requests.
+	//
+	// If Transport is nil, [http.DefaultTransport] is used.
+	Transport http.RoundTripper
+
+	initOnce   sync.Once
+	initErr    error
+	endpoint   string
+	accountKey []byte
+	sasQuery   url.Values
+	client     *http.Client
+}
+
+// init initializes the c.
+func (c *Cacher) init() {
+	c.endpoint = strings.TrimSuffix(c.Endpoint, "/")
+	if c.endpoint == "" {
+		if c.AccountName == "" {
+			c.initErr = errors.New("missing account name")
+			return
+		}
+		c.endpoint = "https://" + c.AccountName + ".blob.core.windows.net"
+	}
+
+	if c.AccountKey != "" {
+		c.accountKey, c.initErr = base64.StdEncoding.DecodeString(c.AccountKey)
+		if c.initErr != nil {
+			c.initErr = fmt.Errorf("invalid account key: %w", c.initErr)
+			return
+		}
+	} else if c.SASToken != "" {
+		c.sasQuery, c.initErr = url.ParseQuery(strings.TrimPrefix(c.SASToken, "?"))
+		if c.initErr != nil {
+			c.initErr = fmt.Errorf("invalid SAS token: %w", c.initErr)
+			return
+		}
+	}
+
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	c.client = &http.Client{Transport: transport}
+}
+
+// newRequest returns a new [http.Request] for the Blob service. The path is
+// relative to the container, and the query is merged with the SASToken.
+func (c *Cacher) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(c.endpoint + "/" + url.PathEscape(c.Container) + path)
+	if err != nil {
+		return nil, err
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	for k, vs := range c.sasQuery {
+		query[k] = vs
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Ms-Version", apiVersion)
+	return req, nil
+}
+
+// blobPath returns the path, relative to the container, of the blob for the
+// name.
+func (c *Cacher) blobPath(name string) string {
+	elems := strings.Split(c.KeyPrefix+name, "/")
+	for i, elem := range elems {
+		elems[i] = url.PathEscape(elem)
+	}
+	return "/" + strings.Join(elems, "/")
+}
+
+// do authorizes and sends the req, and returns its response. It returns
+// [fs.ErrNotExist] if the response status code is 404.
+func (c *Cacher) do(req *http.Request) (*http.Response, error) {
+	if c.accountKey != nil {
+		req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+		req.Header.Set("Authorization", "SharedKey "+c.AccountName+":"+c.sign(req))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fs.ErrNotExist
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if err != nil {
+		return nil, err
+	}
+	var errorResponse struct {
+		Code    string
+		Message string
+	}
+	msg := strings.TrimSpace(string(b))
+	if xml.Unmarshal(b, &errorResponse) == nil && errorResponse.Code != "" {
+		msg = errorResponse.Code
+		if m, _, _ := strings.Cut(errorResponse.Message, "\n"); m != "" {
+			msg += ": " + m
+		}
+	}
+	return nil, fmt.Errorf("unexpected response status %s: %s", resp.Status, msg)
+}
+
+// sign returns the Shared Key signature of the req.
+func (c *Cacher) sign(req *http.Request) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(req.Method + "\n")
+	for _, v := range []string{
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-Md5"),
+		req.Header.Get("Content-Type"),
+		req.Header.Get("Date"),
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	} {
+		sb.WriteString(v + "\n")
+	}
+
+	var headerNames []string
+	for k := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			headerNames = append(headerNames, k)
+		}
+	}
+	slices.Sort(headerNames)
+	for _, k := range headerNames {
+		sb.WriteString(k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n")
+	}
+
+	sb.WriteString("/" + c.AccountName + req.URL.EscapedPath())
+	query := req.URL.Query()
+	queryNames := make([]string, 0, len(query))
+	for k := range query {
+		queryNames = append(queryNames, k)
+	}
+	slices.Sort(queryNames)
+	for _, k := range queryNames {
+		vs := slices.Clone(query[k])
+		slices.Sort(vs)
+		sb.WriteString("\n" + strings.ToLower(k) + ":" + strings.Join(vs, ","))
+	}
+
+	mac := hmac.New(sha256.New, c.accountKey)
+	mac.Write([]byte(sb.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Get implements [github.com/goproxy/goproxy.Cacher].
+func (c *Cacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return nil, c.initErr
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, c.blobPath(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	cache := &cache{ReadCloser: resp.Body, size: resp.ContentLength, etag: resp.Header.Get("ETag")}
+	cache.lastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	if cache.size < 0 {
+		return cache, nil
+	}
+	return &seekableCache{cache: cache, ctx: ctx, c: c, name: name}, nil
+}
+
+// Put implements [github.com/goproxy/goproxy.Cacher].
+func (c *Cacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return c.initErr
+	}
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := c.newRequest(ctx, http.MethodPut, c.blobPath(name), nil, io.NopCloser(content))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Content-Type", cachecontent.Type(name))
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// List lists the names of all caches that start with the prefix, in lexical
+// order. See [github.com/goproxy/goproxy.Cacher] for details.
+func (c *Cacher) List(ctx context.Context, prefix string) ([]string, error) {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return nil, c.initErr
+	}
+	var (
+		names  []string
+		marker string
+	)
+	for {
+		query := url.Values{
+			"restype": {"container"},
+			"comp":    {"list"},
+			"prefix":  {c.KeyPrefix + prefix},
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		req, err := c.newRequest(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Blobs struct {
+				Blob []struct {
+					Name string
+				}
+			}
+			NextMarker string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range result.Blobs.Blob {
+			names = append(names, strings.TrimPrefix(blob.Name, c.KeyPrefix))
+		}
+		if result.NextMarker == "" {
+			return names, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+// Delete deletes the cache for the name. It returns [fs.ErrNotExist] if not
+// found. See [github.com/goproxy/goproxy.Cacher] for details.
+func (c *Cacher) Delete(ctx context.Context, name string) error {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return c.initErr
+	}
+	req, err := c.newRequest(ctx, http.MethodDelete, c.blobPath(name), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// cache is the cache returned by [Cacher.Get].
+type cache struct {
+	io.ReadCloser
+	size         int64
+	lastModified time.Time
+	etag         string
+}
+
+// Size implements [github.com/goproxy/goproxy.Cacher.Get].
+func (c *cache) Size() int64 {
+	return c.size
+}
+
+// LastModified implements [github.com/goproxy/goproxy.Cacher.Get].
+func (c *cache) LastModified() time.Time {
+	return c.lastModified
+}
+
+// ETag implements [github.com/goproxy/goproxy.Cacher.Get].
+func (c *cache) ETag() string {
+	return c.etag
+}
+
+// seekableCache is the cache returned by [Cacher.Get] when the size of the
+// blob is known. It implements [io.Seeker] by reading the blob with range
+// requests after seeking, so that the content can be verified before being
+// served.
+type seekableCache struct {
+	*cache
+	ctx    context.Context
+	c      *Cacher
+	name   string
+	offset int64
+}
+
+// Read implements [io.Reader].
+func (sc *seekableCache) Read(p []byte) (int, error) {
+	if sc.ReadCloser == nil {
+		if sc.offset >= sc.size {
+			return 0, io.EOF
+		}
+		req, err := sc.c.newRequest(sc.ctx, http.MethodGet, sc.c.blobPath(sc.name), nil, nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", sc.offset))
+		if sc.etag != "" {
+			// Never mix the content of different versions of the blob.
+			req.Header.Set("If-Match", sc.etag)
+		}
+		resp, err := sc.c.do(req)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return 0, fmt.Errorf("unexpected response status %s for range request", resp.Status)
+		}
+		sc.ReadCloser = resp.Body
+	}
+	n, err := sc.ReadCloser.Read(p)
+	sc.offset += int64(n)
+	return n, err
+}
+
+// Seek implements [io.Seeker].
+func (sc *seekableCache) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += sc.offset
+	case io.SeekEnd:
+		offset += sc.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != sc.offset && sc.ReadCloser != nil {
+		sc.ReadCloser.Close()
+		sc.ReadCloser = nil
+	}
+	sc.offset = offset
+	return offset, nil
+}
+
+// Close implements [io.Closer].
+func (sc *seekableCache) Close() error {
+	if sc.ReadCloser == nil {
+		return nil
+	}
+	return sc.ReadCloser.Close()
+}
@@ -0,0 +1,359 @@
+package azblobcacher
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAzblobBlob is a blob stored by [fakeAzblob].
+type fakeAzblobBlob struct {
+	content     []byte
+	contentType string
+	etag        string
+	modTime     time.Time
+}
+
+// fakeAzblob is an in-process fake of the Azure Blob Storage REST API that
+// supports path-style addressing of a single container like Azurite.
+type fakeAzblob struct {
+	account    string
+	accountKey []byte
+	sasToken   string
+	container  string
+	pageSize   int
+	mu         sync.Mutex
+	generation int
+	blobs      map[string]fakeAzblobBlob
+}
+
+// ServeHTTP implements [http.Handler].
+func (fa *fakeAzblob) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !fa.authorized(req) {
+		fa.responseError(rw, http.StatusForbidden, "AuthenticationFailed")
+		return
+	}
+	if req.Header.Get("X-Ms-Version") == "" {
+		fa.responseError(rw, http.StatusBadRequest, "MissingRequiredHeader")
+		return
+	}
+
+	container, name, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/"+fa.account+"/"), "/")
+	if container != fa.container {
+		fa.responseError(rw, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	switch {
+	case req.Method == http.MethodGet && name == "" && req.URL.Query().Get("comp") == "list":
+		prefix := req.URL.Query().Get("prefix")
+		var names []string
+		for name := range fa.blobs {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		start := 0
+		if marker := req.URL.Query().Get("marker"); marker != "" {
+			start, _ = strconv.Atoi(marker)
+		}
+		end := min(start+fa.pageSize, len(names))
+		type blob struct {
+			Name string
+		}
+		var result struct {
+			XMLName    xml.Name `xml:"EnumerationResults"`
+			Blobs      []blob   `xml:"Blobs>Blob"`
+			NextMarker string
+		}
+		for _, name := range names[start:end] {
+			result.Blobs = append(result.Blobs, blob{name})
+		}
+		if end < len(names) {
+			result.NextMarker = strconv.Itoa(end)
+		}
+		rw.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(rw).Encode(result)
+	case req.Method == http.MethodPut && name != "":
+		if req.Header.Get("X-Ms-Blob-Type") != "BlockBlob" {
+			fa.responseError(rw, http.StatusBadRequest, "InvalidHeaderValue")
+			return
+		}
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			fa.responseError(rw, http.StatusBadRequest, "InvalidInput")
+			return
+		}
+		fa.generation++
+		fa.blobs[name] = fakeAzblobBlob{
+			content:     b,
+			contentType: req.Header.Get("Content-Type"),
+			etag:        `"0x8D` + strconv.Itoa(fa.generation) + `"`,
+			modTime:     time.Now().UTC().Truncate(time.Second),
+		}
+		rw.WriteHeader(http.StatusCreated)
+	case req.Method == http.MethodGet && name != "":
+		blob, ok := fa.blobs[name]
+		if !ok {
+			fa.responseError(rw, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		rw.Header().Set("Content-Type", blob.contentType)
+		rw.Header().Set("ETag", blob.etag)
+		http.ServeContent(rw, req, "", blob.modTime, bytes.NewReader(blob.content))
+	case req.Method == http.MethodDelete && name != "":
+		if _, ok := fa.blobs[name]; !ok {
+			fa.responseError(rw, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(fa.blobs, name)
+		rw.WriteHeader(http.StatusAccepted)
+	default:
+		fa.responseError(rw, http.StatusBadRequest, "UnsupportedHttpVerb")
+	}
+}
+
+// authorized reports whether the req is authorized.
+func (fa *fakeAzblob) authorized(req *http.Request) bool {
+	switch {
+	case fa.accountKey != nil:
+		// See https://learn.microsoft.com/rest/api/storageservices/authorize-with-shared-key.
+		stringToSign := req.Method + "\n\n\n"
+		if req.ContentLength > 0 {
+			stringToSign += strconv.FormatInt(req.ContentLength, 10)
+		}
+		stringToSign += "\n\n" + req.Header.Get("Content-Type") + "\n\n\n\n\n\n\n"
+		var headers []string
+		for k, vs := range req.Header {
+			if k := strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+				headers = append(headers, k+":"+vs[0]+"\n")
+			}
+		}
+		slices.Sort(headers)
+		stringToSign += strings.Join(headers, "") + "/" + fa.account + req.URL.EscapedPath()
+		var params []string
+		for k, vs := range req.URL.Query() {
+			params = append(params, "\n"+k+":"+strings.Join(vs, ","))
+		}
+		slices.Sort(params)
+		stringToSign += strings.Join(params, "")
+		mac := hmac.New(sha256.New, fa.accountKey)
+		mac.Write([]byte(stringToSign))
+		return req.Header.Get("Authorization") == "SharedKey "+fa.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	case fa.sasToken != "":
+		return req.URL.Query().Get("sig") == fa.sasToken
+	}
+	return true
+}
+
+// responseError responses an Azure Blob Storage error to the client.
+func (fa *fakeAzblob) responseError(rw http.ResponseWriter, statusCode int, code string) {
+	rw.Header().Set("Content-Type", "application/xml")
+	rw.WriteHeader(statusCode)
+	xml.NewEncoder(rw).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: "Test error.\nRequestId:test"})
+}
+
+// newFakeAzblobCacher returns a new [Cacher] backed by a new [fakeAzblob].
+func newFakeAzblobCacher(t *testing.T) (*Cacher, *fakeAzblob) {
+	fa := &fakeAzblob{
+		account:   "devstoreaccount1",
+		container: "test",
+		pageSize:  2,
+		blobs:     map[string]fakeAzblobBlob{},
+	}
+	server := httptest.NewServer(fa)
+	t.Cleanup(server.Close)
+	c := &Cacher{
+		AccountName: fa.account,
+		Endpoint:    server.URL + "/" + fa.account,
+		Container:   fa.container,
+		Transport:   server.Client().Transport,
+	}
+	return c, fa
+}
+
+func TestCacher(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		c, fa := newFakeAzblobCacher(t)
+		fa.accountKey = []byte("test-account-key")
+		c.AccountKey = base64.StdEncoding.EncodeToString(fa.accountKey)
+		c.KeyPrefix = "goproxy/"
+		for name, content := range map[string]string{
+			"example.com/@v/v1.0.0.info": "{}",
+			"example.com/@v/v1.0.0.mod":  "module example.com",
+			"example.com/@v/v1.0.0.zip":  "zip",
+			"example.com/@v/list":        "",
+		} {
+			if err := c.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+
+		for name, want := range map[string]string{
+			"goproxy/example.com/@v/v1.0.0.info": "application/json; charset=utf-8",
+			"goproxy/example.com/@v/v1.0.0.mod":  "text/plain; charset=utf-8",
+			"goproxy/example.com/@v/v1.0.0.zip":  "application/zip",
+		} {
+			blob, ok := fa.blobs[name]
+			if !ok {
+				t.Fatalf("expected blob %s to exist", name)
+			}
+			if got := blob.contentType; got != want {
+				t.Errorf("%s: got %q, want %q", name, got, want)
+			}
+		}
+
+		rc, err := c.Get(t.Context(), "example.com/@v/v1.0.0.mod")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(rc); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "module example.com"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := rc.(interface{ Size() int64 }).Size(), int64(len("module example.com")); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := rc.(interface{ ETag() string }).ETag(), fa.blobs["goproxy/example.com/@v/v1.0.0.mod"].etag; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := rc.(interface{ LastModified() time.Time }).LastModified(), fa.blobs["goproxy/example.com/@v/v1.0.0.mod"].modTime; !got.Equal(want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		rc.Close()
+
+		names, err := c.List(t.Context(), "example.com/@v/")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := names, []string{
+			"example.com/@v/list",
+			"example.com/@v/v1.0.0.info",
+			"example.com/@v/v1.0.0.mod",
+			"example.com/@v/v1.0.0.zip",
+		}; !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if err := c.Delete(t.Context(), "example.com/@v/v1.0.0.zip"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := c.Get(t.Context(), "example.com/@v/v1.0.0.zip"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+		if err := c.Delete(t.Context(), "example.com/@v/v1.0.0.zip"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		c, _ := newFakeAzblobCacher(t)
+		if err := c.Put(t.Context(), "example.com/@v/v1.0.0.zip", strings.NewReader("foobar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		rc, err := c.Get(t.Context(), "example.com/@v/v1.0.0.zip")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer rc.Close()
+		rs, ok := rc.(io.ReadSeeker)
+		if !ok {
+			t.Fatal("expected io.ReadSeeker")
+		}
+		if b, err := io.ReadAll(rs); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "foobar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if size, err := rs.Seek(0, io.SeekEnd); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := size, int64(6); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if _, err := rs.Seek(3, io.SeekStart); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(rs); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "bar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if err := c.Put(t.Context(), "example.com/@v/v1.0.0.zip", strings.NewReader("bazqux")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := io.ReadAll(rs); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), "unexpected response status 412 Precondition Failed"; !strings.HasPrefix(got, want) {
+			t.Errorf("got %q, want prefix %q", got, want)
+		}
+	})
+
+	t.Run("SASToken", func(t *testing.T) {
+		c, fa := newFakeAzblobCacher(t)
+		fa.sasToken = "test-sig"
+		c.SASToken = "?sv=2021-08-06&sp=rwdl&sig=test-sig"
+		if err := c.Put(t.Context(), "example.com/@v/v1.0.0.info", strings.NewReader("{}")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if names, err := c.List(t.Context(), ""); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if got, want := names, []string{"example.com/@v/v1.0.0.info"}; !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		c, fa := newFakeAzblobCacher(t)
+		fa.accountKey = []byte("test-account-key")
+		c.AccountKey = base64.StdEncoding.EncodeToString([]byte("wrong-account-key"))
+		if _, err := c.Get(t.Context(), "example.com/@v/v1.0.0.info"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), "unexpected response status 403 Forbidden: AuthenticationFailed: Test error."; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("InvalidAccountKey", func(t *testing.T) {
+		c, _ := newFakeAzblobCacher(t)
+		c.AccountKey = "!"
+		if _, err := c.Get(t.Context(), "example.com/@v/v1.0.0.info"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), "invalid account key: illegal base64 data at input byte 0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("MissingAccountName", func(t *testing.T) {
+		c := &Cacher{Container: "test"}
+		if _, err := c.Get(t.Context(), "example.com/@v/v1.0.0.info"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), "missing account name"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
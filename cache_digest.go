@@ -0,0 +1,244 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+const (
+	// cacheDigestSuffix is the name suffix of the caches storing the
+	// digests of other caches. See [Goproxy.CacheDigests] for details.
+	cacheDigestSuffix = ".sha256"
+
+	// quarantineCachePrefix is the name prefix of quarantined caches. See
+	// [Goproxy.CacheDigests] for details.
+	quarantineCachePrefix = "quarantine/"
+)
+
+// hasCacheDigest reports whether the cache for the name is covered by
+// [Goproxy.CacheDigests]. Only immutable caches are covered, namely module
+// files of canonical versions, module zip hashes, and checksum database
+// lookups and tiles. A mutable cache (such as "@v/list") may be rewritten
+// between the puts of its content and its digest, and would then be mistaken
+// for a corrupted one.
+func hasCacheDigest(name string) bool {
+	if strings.HasPrefix(name, quarantineCachePrefix) {
+		return false
+	}
+	if sumdbName, ok := strings.CutPrefix(name, "sumdb/"); ok {
+		_, p, ok := strings.Cut(sumdbName, "/")
+		return ok && (strings.HasPrefix(p, "lookup/") || strings.HasPrefix(p, "tile/"))
+	}
+	_, file, ok := strings.Cut(name, "/@v/")
+	if !ok {
+		return false
+	}
+	ext := path.Ext(file)
+	switch ext {
+	case ".info", ".mod", ".zip", ".ziphash":
+	default:
+		return false
+	}
+	version, err := module.UnescapeVersion(strings.TrimSuffix(file, ext))
+	return err == nil && module.CanonicalVersion(version) == version
+}
+
+// sha256Digest returns the hex-encoded SHA-256 digest of the content. The
+// content is rewound before and after the digest is computed.
+func sha256Digest(content io.ReadSeeker) (string, error) {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyCacheDigest reports whether the cached content for the name matches
+// its stored digest. It also reports true if there is no stored digest or the
+// content does not implement [io.Seeker]. The content is rewound before
+// returning.
+func (g *Goproxy) verifyCacheDigest(ctx context.Context, name string, content io.ReadCloser) (bool, error) {
+	rs, ok := content.(io.ReadSeeker)
+	if !ok {
+		return true, nil
+	}
+	digestContent, err := g.Cacher.Get(ctx, name+cacheDigestSuffix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer digestContent.Close()
+	b, err := io.ReadAll(digestContent)
+	if err != nil {
+		return false, err
+	}
+	digest, err := sha256Digest(rs)
+	if err != nil {
+		return false, err
+	}
+	return digest == strings.TrimSpace(string(b)), nil
+}
+
+// quarantineCache copies the corrupted cached content for the name to the
+// quarantine, and then deletes the cache and its digest if the g.Cacher
+// supports deletion.
+func (g *Goproxy) quarantineCache(ctx context.Context, name string, content io.ReadSeeker) error {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := g.Cacher.Put(ctx, quarantineCachePrefix+name, content); err != nil {
+		return err
+	}
+	cd, ok := g.Cacher.(interface {
+		Delete(ctx context.Context, name string) error
+	})
+	if !ok {
+		return nil
+	}
+	for _, n := range []string{name, name + cacheDigestSuffix} {
+		if err := cd.Delete(ctx, n); errors.Is(err, errors.ErrUnsupported) {
+			return nil
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScrubCache verifies all content cached by g.Cacher against its stored
+// digest (see [Goproxy.CacheDigests]), and against its stored "h1:" hash for
+// module zip files if [Goproxy.VerifyCachedZips] is true. Corrupted caches are
+// quarantined, and module files among them are fetched again through the
+// Fetcher. Other corrupted caches, such as proxied checksum database
+// responses, are fetched again on their next request.
+//
+// ScrubCache requires the Cacher to implement the List method documented in
+// [Cacher]. It is safe to call ScrubCache while g is serving requests, such
+// as periodically in a background goroutine.
+func (g *Goproxy) ScrubCache(ctx context.Context) error {
+	g.initOnce.Do(g.init)
+	if g.Cacher == nil {
+		return nil
+	}
+	cl, ok := g.Cacher.(interface {
+		List(ctx context.Context, prefix string) (names []string, err error)
+	})
+	if !ok {
+		return errors.New("cacher does not support listing")
+	}
+	names, err := cl.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !hasCacheDigest(name) {
+			continue
+		}
+		content, err := g.cache(ctx, name)
+		if err == nil {
+			content.Close()
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("verify %s: %w", name, err))
+			continue
+		}
+		if err := g.refetchCache(ctx, name); err != nil {
+			errs = append(errs, fmt.Errorf("refetch %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// refetchCache fetches the module file for the cache name again through the
+// g.fetcher and puts it to the g.Cacher. It does nothing if the name is not
+// for a module file.
+func (g *Goproxy) refetchCache(ctx context.Context, name string) error {
+	escapedModulePath, file, ok := strings.Cut(name, "/@v/")
+	if !ok {
+		return nil
+	}
+	ext := path.Ext(file)
+	switch ext {
+	case ".info", ".mod", ".zip":
+	default:
+		return nil
+	}
+	modulePath, err := module.UnescapePath(escapedModulePath)
+	if err != nil {
+		return nil
+	}
+	moduleVersion, err := module.UnescapeVersion(strings.TrimSuffix(file, ext))
+	if err != nil || checkCanonicalVersion(modulePath, moduleVersion) != nil {
+		return nil
+	}
+
+	var downloadFile func(ctx context.Context, path, version string) (io.ReadSeekCloser, error)
+	switch ext {
+	case ".info":
+		if df, ok := g.fetcher.(interface {
+			DownloadInfo(ctx context.Context, path, version string) (info io.ReadSeekCloser, err error)
+		}); ok {
+			downloadFile = df.DownloadInfo
+		}
+	case ".mod":
+		if df, ok := g.fetcher.(interface {
+			DownloadMod(ctx context.Context, path, version string) (mod io.ReadSeekCloser, err error)
+		}); ok {
+			downloadFile = df.DownloadMod
+		}
+	case ".zip":
+		if df, ok := g.fetcher.(interface {
+			DownloadZip(ctx context.Context, path, version string) (zip io.ReadSeekCloser, err error)
+		}); ok {
+			downloadFile = df.DownloadZip
+		}
+	}
+	if downloadFile != nil {
+		content, err := downloadFile(ctx, modulePath, moduleVersion)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		return g.putCache(ctx, name, content)
+	}
+
+	info, mod, zip, err := g.fetcher.Download(ctx, modulePath, moduleVersion)
+	if err != nil {
+		return err
+	}
+	defer info.Close()
+	defer mod.Close()
+	defer zip.Close()
+	var content io.ReadSeeker
+	switch ext {
+	case ".info":
+		content = info
+	case ".mod":
+		content = mod
+	case ".zip":
+		content = zip
+	}
+	return g.putCache(ctx, name, content)
+}
@@ -0,0 +1,331 @@
+package goproxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSHA256Digest(t *testing.T) {
+	sum := sha256.Sum256([]byte("foobar"))
+	content := strings.NewReader("foobar")
+	if _, err := content.Seek(3, io.SeekStart); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	digest, err := sha256Digest(content)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got, want := digest, hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := content.Len(), 6; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestHasCacheDigest(t *testing.T) {
+	for _, tt := range []struct {
+		n    int
+		name string
+		want bool
+	}{
+		{1, "example.com/@v/v1.0.0.info", true},
+		{2, "example.com/@v/v1.0.0.mod", true},
+		{3, "example.com/@v/v1.0.0.zip", true},
+		{4, "example.com/@v/v1.0.0.ziphash", true},
+		{5, "example.com/@v/v2.0.0+incompatible.zip", true},
+		{6, "example.com/@v/v0.0.0-20000101000000-abcdefabcdef.info", true},
+		{7, "sumdb/sum.golang.org/lookup/example.com@v1.0.0", true},
+		{8, "sumdb/sum.golang.org/tile/8/0/000", true},
+		{9, "example.com/@v/list", false},
+		{10, "example.com/@v/list.json", false},
+		{11, "example.com/@latest", false},
+		{12, "example.com/@v/master.info", false},
+		{13, "example.com/@v/v1.info", false},
+		{14, "example.com/@v/v1.0.0.info.sha256", false},
+		{15, "sumdb/sum.golang.org/latest", false},
+		{16, "quarantine/example.com/@v/v1.0.0.info", false},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			if got, want := hasCacheDigest(tt.name), tt.want; got != want {
+				t.Errorf("got %t, want %t", got, want)
+			}
+		})
+	}
+}
+
+func TestGoproxyCacheDigests(t *testing.T) {
+	sum := sha256.Sum256([]byte("bar"))
+	digest := hex.EncodeToString(sum[:])
+	name := "example.com/@v/v1.0.0.info"
+
+	t.Run("Normal", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		g := &Goproxy{Cacher: DirCacher(cacheDir), TempDir: t.TempDir(), CacheDigests: true, Logger: slog.New(slog.DiscardHandler)}
+		g.initOnce.Do(g.init)
+
+		if err := g.putCache(t.Context(), name, strings.NewReader("bar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := os.ReadFile(filepath.Join(cacheDir, filepath.FromSlash(name+".sha256"))); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), digest; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		rc, err := g.cache(t.Context(), name)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(rc); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "bar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		rc.Close()
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		g := &Goproxy{Cacher: DirCacher(cacheDir), TempDir: t.TempDir(), CacheDigests: true, Logger: slog.New(slog.DiscardHandler)}
+		g.initOnce.Do(g.init)
+
+		if err := g.putCache(t.Context(), name, strings.NewReader("bar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(cacheDir, filepath.FromSlash(name)), []byte("baz"), 0o644); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		_, err := g.cache(t.Context(), name)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if got, want := err, fs.ErrNotExist; !compareErrors(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if b, err := os.ReadFile(filepath.Join(cacheDir, "quarantine", filepath.FromSlash(name))); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "baz"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		for _, name := range []string{name, name + ".sha256"} {
+			if _, err := os.Stat(filepath.Join(cacheDir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+				t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+			}
+		}
+	})
+
+	t.Run("CorruptedNotDeletable", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		g := &Goproxy{Cacher: struct{ Cacher }{DirCacher(cacheDir)}, TempDir: t.TempDir(), CacheDigests: true, Logger: slog.New(slog.DiscardHandler)}
+		g.initOnce.Do(g.init)
+
+		if err := g.putCache(t.Context(), name, strings.NewReader("bar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(cacheDir, filepath.FromSlash(name)), []byte("baz"), 0o644); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		if _, err := g.cache(t.Context(), name); err == nil {
+			t.Fatal("expected error")
+		}
+		if _, err := os.Stat(filepath.Join(cacheDir, "quarantine", filepath.FromSlash(name))); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(cacheDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+
+	t.Run("MutableRace", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		g := &Goproxy{Cacher: DirCacher(cacheDir), TempDir: t.TempDir(), CacheDigests: true, Logger: slog.New(slog.DiscardHandler)}
+		g.initOnce.Do(g.init)
+
+		const name = "example.com/@v/list"
+		if err := g.putCache(t.Context(), name, strings.NewReader("v1.0.0")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		var wg sync.WaitGroup
+		wg.Go(func() {
+			for i := range 100 {
+				if err := g.putCache(t.Context(), name, strings.NewReader(fmt.Sprintf("v1.0.%d", i))); err != nil {
+					t.Errorf("unexpected error %v", err)
+					return
+				}
+			}
+		})
+		wg.Go(func() {
+			for range 100 {
+				rc, err := g.cache(t.Context(), name)
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+					return
+				}
+				rc.Close()
+			}
+		})
+		wg.Wait()
+		if _, err := os.Stat(filepath.Join(cacheDir, "quarantine")); !os.IsNotExist(err) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+		if _, err := os.Stat(filepath.Join(cacheDir, filepath.FromSlash(name+".sha256"))); !os.IsNotExist(err) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("NoDigest", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(cacheDir, "example.com", "@v"), 0o755); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(cacheDir, filepath.FromSlash(name)), []byte("bar"), 0o644); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		g := &Goproxy{Cacher: DirCacher(cacheDir), TempDir: t.TempDir(), CacheDigests: true, Logger: slog.New(slog.DiscardHandler)}
+		g.initOnce.Do(g.init)
+
+		rc, err := g.cache(t.Context(), name)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		rc.Close()
+	})
+}
+
+func TestGoproxyScrubCache(t *testing.T) {
+	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	mod := "module example.com"
+	zip, err := makeZip(map[string][]byte{"example.com@v1.0.0/go.mod": []byte(mod)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	proxyServer := newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/example.com/@v/v1.0.0.info":
+			responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.mod":
+			responseSuccess(rw, req, strings.NewReader(mod), "text/plain; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.zip":
+			responseSuccess(rw, req, bytes.NewReader(zip), "application/zip", -2)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+
+	t.Run("Normal", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		g := &Goproxy{
+			Fetcher: &GoFetcher{
+				Env:     []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+				TempDir: t.TempDir(),
+			},
+			Cacher:       DirCacher(cacheDir),
+			TempDir:      t.TempDir(),
+			CacheDigests: true,
+			Logger:       slog.New(slog.DiscardHandler),
+		}
+		g.initOnce.Do(g.init)
+		for name, content := range map[string]string{
+			"example.com/@v/v1.0.0.mod":         mod,
+			"example.com/@v/v1.0.0.zip":         string(zip),
+			"sumdb/sum.golang.org/tile/8/0/000": "tile",
+		} {
+			if err := g.putCache(t.Context(), name, strings.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		for name, content := range map[string]string{
+			"example.com/@v/v1.0.0.zip":         "corrupted",
+			"sumdb/sum.golang.org/tile/8/0/000": "corrupted",
+		} {
+			if err := os.WriteFile(filepath.Join(cacheDir, filepath.FromSlash(name)), []byte(content), 0o644); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+
+		if err := g.ScrubCache(t.Context()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for name, want := range map[string]string{
+			"example.com/@v/v1.0.0.mod":                    mod,
+			"example.com/@v/v1.0.0.zip":                    string(zip),
+			"quarantine/example.com/@v/v1.0.0.zip":         "corrupted",
+			"quarantine/sumdb/sum.golang.org/tile/8/0/000": "corrupted",
+		} {
+			if b, err := os.ReadFile(filepath.Join(cacheDir, filepath.FromSlash(name))); err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if got := string(b); got != want {
+				t.Errorf("%s: got %q, want %q", name, got, want)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(cacheDir, filepath.FromSlash("sumdb/sum.golang.org/tile/8/0/000"))); !os.IsNotExist(err) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.0.0.zip", nil)
+		req.Header.Set("Disable-Module-Fetch", "true")
+		g.ServeHTTP(rec, req)
+		if got, want := rec.Code, http.StatusOK; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("RefetchError", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		g := &Goproxy{
+			Fetcher: &GoFetcher{
+				Env:     []string{"GOPROXY=off", "GOSUMDB=off"},
+				TempDir: t.TempDir(),
+			},
+			Cacher:       DirCacher(cacheDir),
+			TempDir:      t.TempDir(),
+			CacheDigests: true,
+			Logger:       slog.New(slog.DiscardHandler),
+		}
+		g.initOnce.Do(g.init)
+		if err := g.putCache(t.Context(), "example.com/@v/v1.0.0.mod", strings.NewReader(mod)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(cacheDir, filepath.FromSlash("example.com/@v/v1.0.0.mod")), []byte("corrupted"), 0o644); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		if err := g.ScrubCache(t.Context()); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("NotListable", func(t *testing.T) {
+		g := &Goproxy{Cacher: struct{ Cacher }{DirCacher(t.TempDir())}, CacheDigests: true}
+		if err := g.ScrubCache(t.Context()); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), "cacher does not support listing"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("NoCacher", func(t *testing.T) {
+		g := &Goproxy{CacheDigests: true}
+		if err := g.ScrubCache(t.Context()); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
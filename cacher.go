@@ -2,13 +2,40 @@ package goproxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strings"
 )
 
 // Cacher defines a set of intuitive methods used to cache content served by [Goproxy].
+//
+// A Cacher may optionally implement the following interfaces:
+//  1. interface{ List(ctx context.Context, prefix string) (names []string, err error) },
+//     which lists the names of all caches that start with the prefix, in
+//     lexical order. It is used by [Goproxy] to find cached
+//     pseudo-versions when [Goproxy.ServeEnrichedList] is true.
+//  2. interface{ Delete(ctx context.Context, name string) error }, which
+//     deletes the cache for the name. It returns [fs.ErrNotExist] if not
+//     found. It is used by [Goproxy] to remove corrupted caches when
+//     [Goproxy.CacheDigests] is true.
+//  3. interface{ RedirectURL(ctx context.Context, name string) (url string, err error) },
+//     which returns a short-lived URL (such as a presigned object storage
+//     URL or a CDN URL) from which the client can get the cache for the
+//     name directly. It returns [fs.ErrNotExist] if not found, and an empty
+//     url if the cache cannot be got directly. It is used by [Goproxy] to
+//     redirect requests for cached module zip files when
+//     [Goproxy.RedirectCachedZips] is true.
+//
+// A Cacher that wraps another one, such as [DedupCacher], may implement 1 and
+// 2 regardless of whether the wrapped Cacher does. Such methods return an
+// error matching [errors.ErrUnsupported] if the wrapped Cacher does not
+// support them, and callers treat that as if they were not implemented.
 type Cacher interface {
 	// Get gets the matched cache for the name. It returns [fs.ErrNotExist]
 	// if not found.
@@ -29,6 +56,13 @@ type Cacher interface {
 	//     headers when 1 is implemented. Note that the return value will be
 	//     assumed to have complied with RFC 7232, section 2.3, so it will
 	//     be used directly without further processing.
+	//  6. interface{ EncodedContent() (content io.Reader, contentCoding string) },
+	//     which returns the content encoded with the contentCoding (such as
+	//     "gzip"). The encoded content is served directly with the
+	//     Content-Encoding response header to clients whose Accept-Encoding
+	//     request header accepts the contentCoding. It may optionally
+	//     implement 1 to 5 for the encoded content, and its ETag must
+	//     differ from the ETag of the content.
 	Get(ctx context.Context, name string) (io.ReadCloser, error)
 
 	// Put puts a cache for the name with the content.
@@ -84,3 +118,45 @@ func (dc DirCacher) Put(ctx context.Context, name string, content io.ReadSeeker)
 	}
 	return os.Rename(f.Name(), file)
 }
+
+// List lists the names of all caches that start with the prefix, in lexical
+// order. See [Cacher] for details.
+func (dc DirCacher) List(ctx context.Context, prefix string) ([]string, error) {
+	root := filepath.Join(string(dc), filepath.FromSlash(path.Dir(prefix+"_")))
+	var names []string
+	err := filepath.WalkDir(root, func(file string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if file != root && strings.HasPrefix(de.Name(), ".") {
+			if de.IsDir() {
+				return fs.SkipDir
+			}
+			return nil // Skip temporary files created by Put.
+		}
+		if !de.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(string(dc), file)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	slices.Sort(names) // WalkDir visits "a/b/c" before "a/b.c/d".
+	return names, nil
+}
+
+// Delete deletes the cache for the name. See [Cacher] for details.
+func (dc DirCacher) Delete(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(string(dc), filepath.FromSlash(name)))
+}
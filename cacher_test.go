@@ -6,6 +6,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -91,4 +92,56 @@ func TestDirCacher(t *testing.T) {
 			t.Fatal("expected error")
 		}
 	})
+
+	t.Run("List", func(t *testing.T) {
+		dirCacher := DirCacher(t.TempDir())
+		for _, name := range []string{"a/b/c", "a/b/d", "a/b.c/d", "a/bc", "a/e/f", "g"} {
+			if err := dirCacher.Put(t.Context(), name, strings.NewReader("foobar")); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(string(dirCacher), filepath.FromSlash("a/b/.c.tmp.0")), nil, 0o644); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		for _, tt := range []struct {
+			n         int
+			prefix    string
+			wantNames []string
+		}{
+			{1, "a/b/", []string{"a/b/c", "a/b/d"}},
+			{2, "a/b", []string{"a/b.c/d", "a/b/c", "a/b/d", "a/bc"}},
+			{3, "", []string{"a/b.c/d", "a/b/c", "a/b/d", "a/bc", "a/e/f", "g"}},
+			{4, "h/", nil},
+		} {
+			t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+				names, err := dirCacher.List(t.Context(), tt.prefix)
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if got, want := strings.Join(names, ","), strings.Join(tt.wantNames, ","); got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			})
+		}
+	})
+	t.Run("Delete", func(t *testing.T) {
+		dirCacher := DirCacher(t.TempDir())
+		if err := dirCacher.Put(t.Context(), "a/b", strings.NewReader("foobar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := dirCacher.Delete(t.Context(), "a/b"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := dirCacher.Get(t.Context(), "a/b"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err, fs.ErrNotExist; !compareErrors(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if err := dirCacher.Delete(t.Context(), "a/b"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err, fs.ErrNotExist; !compareErrors(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
 }
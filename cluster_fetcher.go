@@ -0,0 +1,602 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clusterForwardedHeader is the HTTP header that marks requests forwarded by a
+// [ClusterFetcher] to the owner of a module.
+const clusterForwardedHeader = "Goproxy-Cluster-Forwarded"
+
+// errClusterPeerUnreachable indicates a peer of a [ClusterFetcher] cannot be
+// reached.
+var errClusterPeerUnreachable = errors.New("cluster peer unreachable")
+
+// ClusterFetcher implements [Fetcher] for a cluster of [Goproxy] replicas that
+// share the same [Cacher], so that a module missing from the cache is fetched
+// by only one of them.
+//
+// Each module path is owned by one replica, chosen using a consistent-hash
+// ring over all replicas. Fetches of modules owned by this replica are done
+// by the Fetcher. Fetches of other modules are forwarded to their owners
+// using the module proxy protocol, and the owners always fetch them locally.
+// If an owner cannot be reached, it is skipped for the PeerDownDuration, and
+// the next replica on the ring takes over, with this replica as the last
+// resort.
+//
+// Make sure to set all fields before calling any methods. A ClusterFetcher
+// must not be copied after first use.
+type ClusterFetcher struct {
+	// Fetcher is the [Fetcher] used to fetch modules owned by this replica
+	// and modules whose owners cannot be reached.
+	//
+	// If Fetcher is nil, [GoFetcher] is used.
+	Fetcher Fetcher
+
+	// Self is the base URL of this replica as seen by its peers, such as
+	// "http://10.0.0.1:8080".
+	//
+	// If Self is empty, this replica owns no modules and forwards all
+	// fetches to its peers.
+	Self string
+
+	// Peers is the list of base URLs of the replicas in the cluster. It may
+	// include Self.
+	Peers []string
+
+	// PeersDNS is a URL, such as "http://goproxy.default.svc:8080", whose
+	// host is resolved to discover replicas in addition to the Peers. Each
+	// resolved IP address forms a base URL by replacing the host of PeersDNS.
+	//
+	// If PeersDNS is empty, only the Peers are used.
+	PeersDNS string
+
+	// PeersDNSRefreshInterval is the minimum interval between resolutions of
+	// the PeersDNS.
+	//
+	// If PeersDNSRefreshInterval is zero, 30 seconds is used.
+	PeersDNSRefreshInterval time.Duration
+
+	// Resolver is used to resolve the PeersDNS.
+	//
+	// If Resolver is nil, [net.DefaultResolver] is used.
+	Resolver *net.Resolver
+
+	// VirtualNodes is the number of points each replica has on the
+	// consistent-hash ring.
+	//
+	// If VirtualNodes is zero, 128 is used.
+	VirtualNodes int
+
+	// PeerDownDuration is the amount of time a peer is skipped after a
+	// forwarded fetch to it fails.
+	//
+	// If PeerDownDuration is zero, 10 seconds is used.
+	PeerDownDuration time.Duration
+
+	// TempDir is the directory for storing temporary files.
+	//
+	// If TempDir is empty, [os.TempDir] is used.
+	TempDir string
+
+	// Transport is used to forward fetches to peers.
+	//
+	// If Transport is nil, [http.DefaultTransport] is used.
+	Transport http.RoundTripper
+
+	// Secret is the secret shared by all replicas in the cluster. It is sent
+	// with forwarded fetches, and a [Goproxy] using the ClusterFetcher
+	// fetches a module locally instead of routing it again only if the
+	// request carries it.
+	//
+	// If Secret is empty, a request is treated as forwarded only if it comes
+	// from the IP address of a known peer, which requires the peers to be
+	// given by IP address or discovered using the PeersDNS.
+	Secret string
+
+	initOnce                sync.Once
+	initErr                 error
+	fetcher                 Fetcher
+	self                    string
+	staticPeers             []string
+	peersDNS                *url.URL
+	peersDNSRefreshInterval time.Duration
+	resolver                *net.Resolver
+	virtualNodes            int
+	peerDownDuration        time.Duration
+	transport               http.RoundTripper
+
+	mu             sync.Mutex
+	peers          []string
+	ring           []clusterRingPoint
+	dnsPeers       []string
+	dnsResolvedAt  time.Time
+	peerDownUntil  map[string]time.Time
+	peerGoFetchers map[string]*GoFetcher
+}
+
+// clusterRingPoint is a point on the consistent-hash ring of a
+// [ClusterFetcher].
+type clusterRingPoint struct {
+	hash uint64
+	peer string
+}
+
+// init initializes the cf.
+func (cf *ClusterFetcher) init() {
+	cf.fetcher = cf.Fetcher
+	if cf.fetcher == nil {
+		cf.fetcher = &GoFetcher{TempDir: cf.TempDir, Transport: cf.Transport}
+	}
+	if cf.Self != "" {
+		cf.self, cf.initErr = cleanClusterPeerURL(cf.Self)
+		if cf.initErr != nil {
+			return
+		}
+		cf.staticPeers = append(cf.staticPeers, cf.self)
+	}
+	for _, peer := range cf.Peers {
+		peer, err := cleanClusterPeerURL(peer)
+		if err != nil {
+			cf.initErr = err
+			return
+		}
+		cf.staticPeers = append(cf.staticPeers, peer)
+	}
+	if cf.PeersDNS != "" {
+		peersDNS, err := cleanClusterPeerURL(cf.PeersDNS)
+		if err != nil {
+			cf.initErr = err
+			return
+		}
+		cf.peersDNS, _ = url.Parse(peersDNS)
+	}
+	cf.peersDNSRefreshInterval = cf.PeersDNSRefreshInterval
+	if cf.peersDNSRefreshInterval == 0 {
+		cf.peersDNSRefreshInterval = 30 * time.Second
+	}
+	cf.resolver = cf.Resolver
+	if cf.resolver == nil {
+		cf.resolver = net.DefaultResolver
+	}
+	cf.virtualNodes = cf.VirtualNodes
+	if cf.virtualNodes == 0 {
+		cf.virtualNodes = 128
+	}
+	cf.peerDownDuration = cf.PeerDownDuration
+	if cf.peerDownDuration == 0 {
+		cf.peerDownDuration = 10 * time.Second
+	}
+	cf.transport = cf.Transport
+	if cf.transport == nil {
+		cf.transport = http.DefaultTransport
+	}
+	cf.peerDownUntil = map[string]time.Time{}
+	cf.peerGoFetchers = map[string]*GoFetcher{}
+	cf.setPeers(nil)
+}
+
+// setPeers sets the peers discovered using the PeersDNS to the dnsPeers, and
+// rebuilds the ring if the set of peers has changed.
+//
+// NOTE: The cf.mu must be held when calling setPeers.
+func (cf *ClusterFetcher) setPeers(dnsPeers []string) {
+	cf.dnsPeers = dnsPeers
+	peers := slices.Concat(cf.staticPeers, dnsPeers)
+	slices.Sort(peers)
+	peers = slices.Compact(peers)
+	if cf.ring != nil && slices.Equal(peers, cf.peers) {
+		return
+	}
+	cf.peers = peers
+	cf.ring = make([]clusterRingPoint, 0, len(peers)*cf.virtualNodes)
+	for _, peer := range peers {
+		for i := range cf.virtualNodes {
+			cf.ring = append(cf.ring, clusterRingPoint{hash: clusterHash(peer + "#" + strconv.Itoa(i)), peer: peer})
+		}
+	}
+	slices.SortFunc(cf.ring, func(a, b clusterRingPoint) int {
+		if a.hash != b.hash {
+			if a.hash < b.hash {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.peer, b.peer)
+	})
+}
+
+// refreshPeers resolves the PeersDNS if it has not been resolved within the
+// PeersDNSRefreshInterval. The previously discovered peers are kept if the
+// resolution fails.
+func (cf *ClusterFetcher) refreshPeers(ctx context.Context) {
+	if cf.peersDNS == nil {
+		return
+	}
+	cf.mu.Lock()
+	if time.Since(cf.dnsResolvedAt) < cf.peersDNSRefreshInterval {
+		cf.mu.Unlock()
+		return
+	}
+	cf.dnsResolvedAt = time.Now()
+	cf.mu.Unlock()
+
+	addrs, err := cf.resolver.LookupHost(ctx, cf.peersDNS.Hostname())
+	if err != nil {
+		return
+	}
+	dnsPeers := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		u := *cf.peersDNS
+		if port := cf.peersDNS.Port(); port != "" {
+			u.Host = net.JoinHostPort(addr, port)
+		} else if strings.Contains(addr, ":") {
+			u.Host = "[" + addr + "]"
+		} else {
+			u.Host = addr
+		}
+		dnsPeers = append(dnsPeers, u.String())
+	}
+
+	cf.mu.Lock()
+	cf.setPeers(dnsPeers)
+	cf.mu.Unlock()
+}
+
+// owners returns all peers in the order in which they take ownership of the
+// module path.
+func (cf *ClusterFetcher) owners(ctx context.Context, path string) []string {
+	cf.refreshPeers(ctx)
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	if len(cf.ring) == 0 {
+		return nil
+	}
+	h := clusterHash(path)
+	i, _ := slices.BinarySearchFunc(cf.ring, h, func(p clusterRingPoint, h uint64) int {
+		if p.hash < h {
+			return -1
+		} else if p.hash > h {
+			return 1
+		}
+		return 0
+	})
+	owners := make([]string, 0, len(cf.peers))
+	for j := 0; j < len(cf.ring) && len(owners) < len(cf.peers); j++ {
+		if peer := cf.ring[(i+j)%len(cf.ring)].peer; !slices.Contains(owners, peer) {
+			owners = append(owners, peer)
+		}
+	}
+	return owners
+}
+
+// peerGoFetcher returns the [GoFetcher] used to forward fetches to the peer,
+// and reports whether the peer is currently considered down.
+func (cf *ClusterFetcher) peerGoFetcher(peer string) (gf *GoFetcher, down bool) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	if time.Now().Before(cf.peerDownUntil[peer]) {
+		return nil, true
+	}
+	gf, ok := cf.peerGoFetchers[peer]
+	if !ok {
+		gf = &GoFetcher{
+			Env:       []string{"GOPROXY=" + peer, "GOSUMDB=off"},
+			TempDir:   cf.TempDir,
+			Transport: &clusterForwardingTransport{base: cf.transport, secret: cf.Secret},
+		}
+		cf.peerGoFetchers[peer] = gf
+	}
+	return gf, false
+}
+
+// markPeerDown marks the peer as down for the PeerDownDuration.
+func (cf *ClusterFetcher) markPeerDown(peer string) {
+	cf.mu.Lock()
+	cf.peerDownUntil[peer] = time.Now().Add(cf.peerDownDuration)
+	cf.mu.Unlock()
+}
+
+// route calls the remote with the [GoFetcher] of each owner of the module path
+// in turn until one of them succeeds or returns an error that should not
+// cause a failover, or calls the local with the Fetcher once this replica is
+// reached or all owners have failed.
+func (cf *ClusterFetcher) route(ctx context.Context, path string, local func(f Fetcher) error, remote func(gf *GoFetcher) error) error {
+	if cf.initOnce.Do(cf.init); cf.initErr != nil {
+		return cf.initErr
+	}
+	if clusterForwardedFromContext(ctx) {
+		return local(cf.fetcher)
+	}
+	for _, peer := range cf.owners(ctx, path) {
+		if peer == cf.self {
+			break
+		}
+		gf, down := cf.peerGoFetcher(peer)
+		if down {
+			continue
+		}
+		err := remote(gf)
+		if err == nil || errors.Is(err, fs.ErrNotExist) || ctx.Err() != nil {
+			return err
+		}
+		var se *clusterStreamError
+		if errors.As(err, &se) {
+			return se.err
+		}
+		cf.markPeerDown(peer)
+	}
+	return local(cf.fetcher)
+}
+
+// Query implements [Fetcher].
+func (cf *ClusterFetcher) Query(ctx context.Context, path, query string) (version string, time time.Time, err error) {
+	err = cf.route(ctx, path, func(f Fetcher) (err error) {
+		version, time, err = f.Query(ctx, path, query)
+		return
+	}, func(gf *GoFetcher) (err error) {
+		version, time, err = gf.Query(ctx, path, query)
+		return
+	})
+	return
+}
+
+// List implements [Fetcher].
+func (cf *ClusterFetcher) List(ctx context.Context, path string) (versions []string, err error) {
+	err = cf.route(ctx, path, func(f Fetcher) (err error) {
+		versions, err = f.List(ctx, path)
+		return
+	}, func(gf *GoFetcher) (err error) {
+		versions, err = gf.List(ctx, path)
+		return
+	})
+	return
+}
+
+// Download implements [Fetcher].
+func (cf *ClusterFetcher) Download(ctx context.Context, path, version string) (info, mod, zip io.ReadSeekCloser, err error) {
+	err = cf.route(ctx, path, func(f Fetcher) (err error) {
+		info, mod, zip, err = f.Download(ctx, path, version)
+		return
+	}, func(gf *GoFetcher) (err error) {
+		info, mod, zip, err = gf.Download(ctx, path, version)
+		return
+	})
+	return
+}
+
+// DownloadStream is like [ClusterFetcher.Download] but also writes the content
+// of the zip file to the zipDst as it is received. It implements the optional
+// interface documented in [Fetcher].
+//
+// If the Fetcher does not implement DownloadStream, the zip file is written to
+// the zipDst only after it has been downloaded. Once anything has been
+// written to the zipDst, ClusterFetcher no longer fails over to other
+// replicas.
+func (cf *ClusterFetcher) DownloadStream(ctx context.Context, path, version string, zipDst io.Writer) (info, mod, zip io.ReadSeekCloser, err error) {
+	err = cf.route(ctx, path, func(f Fetcher) (err error) {
+		if ds, ok := f.(interface {
+			DownloadStream(ctx context.Context, path, version string, zipDst io.Writer) (info, mod, zip io.ReadSeekCloser, err error)
+		}); ok {
+			info, mod, zip, err = ds.DownloadStream(ctx, path, version, zipDst)
+			return
+		}
+		if info, mod, zip, err = f.Download(ctx, path, version); err != nil {
+			return
+		}
+		if _, err = io.Copy(zipDst, zip); err == nil {
+			_, err = zip.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			info.Close()
+			mod.Close()
+			zip.Close()
+		}
+		return
+	}, func(gf *GoFetcher) (err error) {
+		cw := &countingWriter{w: zipDst}
+		info, mod, zip, err = gf.DownloadStream(ctx, path, version, cw)
+		if err != nil && cw.n > 0 {
+			err = &clusterStreamError{err: err}
+		}
+		return
+	})
+	return
+}
+
+// DownloadInfo is like [ClusterFetcher.Download] but downloads only the info
+// file. It implements the optional interface documented in [Fetcher].
+func (cf *ClusterFetcher) DownloadInfo(ctx context.Context, path, version string) (info io.ReadSeekCloser, err error) {
+	return cf.downloadFile(ctx, path, version, ".info")
+}
+
+// DownloadMod is like [ClusterFetcher.Download] but downloads only the mod
+// file. It implements the optional interface documented in [Fetcher].
+func (cf *ClusterFetcher) DownloadMod(ctx context.Context, path, version string) (mod io.ReadSeekCloser, err error) {
+	return cf.downloadFile(ctx, path, version, ".mod")
+}
+
+// DownloadZip is like [ClusterFetcher.Download] but downloads only the zip
+// file. It implements the optional interface documented in [Fetcher].
+func (cf *ClusterFetcher) DownloadZip(ctx context.Context, path, version string) (zip io.ReadSeekCloser, err error) {
+	return cf.downloadFile(ctx, path, version, ".zip")
+}
+
+// downloadFile downloads the module file with the ext (".info", ".mod", or
+// ".zip") for the given module path and version.
+func (cf *ClusterFetcher) downloadFile(ctx context.Context, path, version, ext string) (content io.ReadSeekCloser, err error) {
+	err = cf.route(ctx, path, func(f Fetcher) (err error) {
+		content, err = fetcherDownloadFile(ctx, f, path, version, ext)
+		return
+	}, func(gf *GoFetcher) (err error) {
+		content, err = gf.downloadFile(ctx, path, version, ext)
+		return
+	})
+	return
+}
+
+// fetcherDownloadFile downloads the module file with the ext (".info", ".mod",
+// or ".zip") for the given module path and version using the f, preferring the
+// optional interfaces documented in [Fetcher] over Download.
+func fetcherDownloadFile(ctx context.Context, f Fetcher, path, version, ext string) (io.ReadSeekCloser, error) {
+	switch ext {
+	case ".info":
+		if df, ok := f.(interface {
+			DownloadInfo(ctx context.Context, path, version string) (info io.ReadSeekCloser, err error)
+		}); ok {
+			return df.DownloadInfo(ctx, path, version)
+		}
+	case ".mod":
+		if df, ok := f.(interface {
+			DownloadMod(ctx context.Context, path, version string) (mod io.ReadSeekCloser, err error)
+		}); ok {
+			return df.DownloadMod(ctx, path, version)
+		}
+	case ".zip":
+		if df, ok := f.(interface {
+			DownloadZip(ctx context.Context, path, version string) (zip io.ReadSeekCloser, err error)
+		}); ok {
+			return df.DownloadZip(ctx, path, version)
+		}
+	}
+	info, mod, zip, err := f.Download(ctx, path, version)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]io.ReadSeekCloser{".info": info, ".mod": mod, ".zip": zip}
+	for e, file := range files {
+		if e != ext {
+			file.Close()
+		}
+	}
+	return files[ext], nil
+}
+
+// clusterStreamError is an error returned by a forwarded
+// [ClusterFetcher.DownloadStream] after something has been written to the
+// zipDst, which must not cause a failover.
+type clusterStreamError struct{ err error }
+
+// Error implements [error].
+func (e *clusterStreamError) Error() string { return e.err.Error() }
+
+// Unwrap returns the underlying error.
+func (e *clusterStreamError) Unwrap() error { return e.err }
+
+// forwarded reports whether the req is a fetch forwarded by a peer, which must
+// be marked as forwarded and carry the Secret, or come from a known peer if the
+// Secret is empty.
+func (cf *ClusterFetcher) forwarded(req *http.Request) bool {
+	marker := req.Header.Get(clusterForwardedHeader)
+	if marker == "" {
+		return false
+	}
+	if cf.initOnce.Do(cf.init); cf.initErr != nil {
+		return false
+	}
+	if cf.Secret != "" {
+		return subtle.ConstantTimeCompare([]byte(marker), []byte(cf.Secret)) == 1
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	remoteAddr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	cf.refreshPeers(req.Context())
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	for _, peer := range cf.peers {
+		u, err := url.Parse(peer)
+		if err != nil {
+			continue
+		}
+		if addr, err := netip.ParseAddr(u.Hostname()); err == nil && addr.Unmap() == remoteAddr.Unmap() {
+			return true
+		}
+	}
+	return false
+}
+
+// clusterForwardingTransport is an [http.RoundTripper] used by
+// [ClusterFetcher] to forward fetches to peers.
+type clusterForwardingTransport struct {
+	base   http.RoundTripper
+	secret string
+}
+
+// RoundTrip implements [http.RoundTripper]. It marks the req as forwarded with
+// the secret, and reports dial failures as [errClusterPeerUnreachable] so that
+// they are not retried.
+func (t *clusterForwardingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	marker := t.secret
+	if marker == "" {
+		marker = "1"
+	}
+	req.Header.Set(clusterForwardedHeader, marker)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		var oe *net.OpError
+		if errors.As(err, &oe) && oe.Op == "dial" {
+			return nil, fmt.Errorf("%w: %w", errClusterPeerUnreachable, err)
+		}
+	}
+	return resp, err
+}
+
+// clusterForwardedContextKey is the context key for marking fetches forwarded
+// by a [ClusterFetcher].
+type clusterForwardedContextKey struct{}
+
+// withClusterForwarded returns a copy of the ctx that is marked as forwarded by
+// a [ClusterFetcher].
+func withClusterForwarded(ctx context.Context) context.Context {
+	return context.WithValue(ctx, clusterForwardedContextKey{}, true)
+}
+
+// clusterForwardedFromContext reports whether the ctx is marked as forwarded by
+// a [ClusterFetcher].
+func clusterForwardedFromContext(ctx context.Context) bool {
+	forwarded, _ := ctx.Value(clusterForwardedContextKey{}).(bool)
+	return forwarded
+}
+
+// cleanClusterPeerURL returns the cleaned form of the base URL of a peer of a
+// [ClusterFetcher].
+func cleanClusterPeerURL(peer string) (string, error) {
+	u, err := url.Parse(peer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid peer URL: %q", peer)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// clusterHash returns the position of the s on the consistent-hash ring of a
+// [ClusterFetcher].
+func clusterHash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,354 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clusterTestFetcher is a [Fetcher] that records the module paths it fetches.
+type clusterTestFetcher struct {
+	t     *testing.T
+	err   error
+	mu    sync.Mutex
+	paths []string
+}
+
+// fetch records the path and returns the f.err.
+func (f *clusterTestFetcher) fetch(path string) error {
+	f.mu.Lock()
+	f.paths = append(f.paths, path)
+	f.mu.Unlock()
+	return f.err
+}
+
+// count returns the number of fetches of the path.
+func (f *clusterTestFetcher) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, p := range f.paths {
+		if p == path {
+			n++
+		}
+	}
+	return n
+}
+
+// Query implements [Fetcher].
+func (f *clusterTestFetcher) Query(ctx context.Context, path, query string) (string, time.Time, error) {
+	if err := f.fetch(path); err != nil {
+		return "", time.Time{}, err
+	}
+	return "v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), nil
+}
+
+// List implements [Fetcher].
+func (f *clusterTestFetcher) List(ctx context.Context, path string) ([]string, error) {
+	if err := f.fetch(path); err != nil {
+		return nil, err
+	}
+	return []string{"v1.0.0"}, nil
+}
+
+// Download implements [Fetcher].
+func (f *clusterTestFetcher) Download(ctx context.Context, path, version string) (io.ReadSeekCloser, io.ReadSeekCloser, io.ReadSeekCloser, error) {
+	if err := f.fetch(path); err != nil {
+		return nil, nil, nil, err
+	}
+	zipContent, err := makeZip(map[string][]byte{path + "@" + version + "/go.mod": []byte("module " + path)})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var files []io.ReadSeekCloser
+	for _, content := range [][]byte{
+		[]byte(marshalInfo(version, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))),
+		[]byte("module " + path),
+		zipContent,
+	} {
+		name, err := makeTempFile(f.t, content)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, file)
+	}
+	return files[0], files[1], files[2], nil
+}
+
+// newClusterTestReplicas starts n [Goproxy] replicas that use [ClusterFetcher]
+// with the extraPeers, and returns their fetchers and local fetchers.
+func newClusterTestReplicas(t *testing.T, n int, extraPeers ...string) ([]*ClusterFetcher, []*clusterTestFetcher) {
+	cacheDir := t.TempDir()
+	handlers := make([]http.Handler, n)
+	peers := slices.Clone(extraPeers)
+	for i := range n {
+		server := newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			handlers[i].ServeHTTP(rw, req)
+		}))
+		peers = append(peers, server.URL)
+	}
+	cfs := make([]*ClusterFetcher, n)
+	fetchers := make([]*clusterTestFetcher, n)
+	for i := range n {
+		fetchers[i] = &clusterTestFetcher{t: t}
+		cfs[i] = &ClusterFetcher{
+			Fetcher: fetchers[i],
+			Self:    peers[len(extraPeers)+i],
+			Peers:   peers,
+			TempDir: t.TempDir(),
+		}
+		cfs[i].initOnce.Do(cfs[i].init)
+		handlers[i] = &Goproxy{
+			Fetcher: cfs[i],
+			Cacher:  DirCacher(cacheDir),
+			TempDir: t.TempDir(),
+		}
+	}
+	return cfs, fetchers
+}
+
+func TestClusterFetcher(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		cfs, fetchers := newClusterTestReplicas(t, 3)
+		owned := map[string]bool{}
+		for i := range 10 {
+			path := fmt.Sprintf("example.com/m%d", i)
+			for _, cf := range cfs {
+				if version, _, err := cf.Query(t.Context(), path, "latest"); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				} else if got, want := version, "v1.0.0"; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+				if versions, err := cf.List(t.Context(), path); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				} else if got, want := versions, []string{"v1.0.0"}; !slices.Equal(got, want) {
+					t.Errorf("got %q, want %q", got, want)
+				}
+				info, mod, zip, err := cf.Download(t.Context(), path, "v1.0.0")
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if b, err := io.ReadAll(mod); err != nil {
+					t.Errorf("unexpected error %v", err)
+				} else if got, want := string(b), "module "+path; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+				info.Close()
+				mod.Close()
+				zip.Close()
+				mod, err = cf.DownloadMod(t.Context(), path, "v1.0.0")
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				mod.Close()
+			}
+
+			owner := cfs[0].owners(t.Context(), path)[0]
+			owned[owner] = true
+			for j, cf := range cfs {
+				if got := fetchers[j].count(path); (cf.self == owner) != (got > 0) {
+					t.Errorf("got %d fetches of %s by %s, want fetches only by %s", got, path, cf.self, owner)
+				}
+			}
+		}
+		if got := len(owned); got < 2 {
+			t.Errorf("got %d owners, want at least 2", got)
+		}
+	})
+
+	t.Run("Failover", func(t *testing.T) {
+		deadServer := newHTTPTestServer(t, http.NotFoundHandler())
+		deadServer.Close()
+		cfs, fetchers := newClusterTestReplicas(t, 2, deadServer.URL)
+		var path string
+		for i := 0; path == ""; i++ {
+			if p := fmt.Sprintf("example.com/m%d", i); cfs[0].owners(t.Context(), p)[0] == deadServer.URL {
+				path = p
+			}
+		}
+
+		startTime := time.Now()
+		if _, _, err := cfs[0].Query(t.Context(), path, "latest"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if elapsed := time.Since(startTime); elapsed > 5*time.Second {
+			t.Errorf("got %v, want fast failover", elapsed)
+		}
+		if got, want := fetchers[0].count(path)+fetchers[1].count(path), 1; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if _, down := cfs[0].peerGoFetcher(deadServer.URL); !down {
+			t.Error("expected peer down")
+		}
+	})
+
+	t.Run("NotExist", func(t *testing.T) {
+		cfs, fetchers := newClusterTestReplicas(t, 2)
+		for _, f := range fetchers {
+			f.err = fs.ErrNotExist
+		}
+		var path string
+		for i := 0; path == ""; i++ {
+			if p := fmt.Sprintf("example.com/m%d", i); cfs[0].owners(t.Context(), p)[0] == cfs[1].self {
+				path = p
+			}
+		}
+		if _, _, err := cfs[0].Query(t.Context(), path, "latest"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+		if got, want := fetchers[0].count(path), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := fetchers[1].count(path), 1; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if _, down := cfs[0].peerGoFetcher(cfs[1].self); down {
+			t.Error("unexpected peer down")
+		}
+	})
+
+	t.Run("Forwarded", func(t *testing.T) {
+		cfs, fetchers := newClusterTestReplicas(t, 2)
+		var path string
+		for i := 0; path == ""; i++ {
+			if p := fmt.Sprintf("example.com/m%d", i); cfs[0].owners(t.Context(), p)[0] == cfs[1].self {
+				path = p
+			}
+		}
+		if _, err := cfs[0].List(withClusterForwarded(t.Context()), path); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := fetchers[0].count(path), 1; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := fetchers[1].count(path), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("PeersDNS", func(t *testing.T) {
+		cf := &ClusterFetcher{PeersDNS: "http://localhost:8080/"}
+		cf.initOnce.Do(cf.init)
+		if got, want := cf.owners(t.Context(), "example.com"), "http://127.0.0.1:8080"; !slices.Contains(got, want) {
+			t.Errorf("got %q, want containing %q", got, want)
+		}
+	})
+
+	t.Run("InvalidPeer", func(t *testing.T) {
+		cf := &ClusterFetcher{Peers: []string{"localhost:8080"}}
+		if _, err := cf.List(t.Context(), "example.com"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), `invalid peer URL: "localhost:8080"`; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestClusterFetcherForwarded(t *testing.T) {
+	for _, tt := range []struct {
+		n          int
+		secret     string
+		marker     string
+		remoteAddr string
+		want       bool
+	}{
+		{n: 1, marker: "1", remoteAddr: "127.0.0.1:1234", want: true},
+		{n: 2, marker: "1", remoteAddr: "[::1]:1234", want: true},
+		{n: 3, marker: "1", remoteAddr: "[::ffff:127.0.0.1]:1234", want: true},
+		{n: 4, marker: "1", remoteAddr: "192.0.2.1:1234"},
+		{n: 5, remoteAddr: "127.0.0.1:1234"},
+		{n: 6, marker: "1", remoteAddr: "invalid"},
+		{n: 7, secret: "foobar", marker: "foobar", remoteAddr: "192.0.2.1:1234", want: true},
+		{n: 8, secret: "foobar", marker: "1", remoteAddr: "127.0.0.1:1234"},
+		{n: 9, secret: "foobar", remoteAddr: "127.0.0.1:1234"},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			cf := &ClusterFetcher{
+				Peers:  []string{"http://127.0.0.1:8080", "http://[::1]:8080", "http://peer.example.com:8080"},
+				Secret: tt.secret,
+			}
+			req := httptest.NewRequest(http.MethodGet, "/example.com/@v/list", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.marker != "" {
+				req.Header.Set(clusterForwardedHeader, tt.marker)
+			}
+			if got, want := cf.forwarded(req), tt.want; got != want {
+				t.Errorf("got %t, want %t", got, want)
+			}
+		})
+	}
+}
+
+func TestClusterFetcherOwners(t *testing.T) {
+	cf := &ClusterFetcher{Peers: []string{"http://a", "http://b", "http://c"}}
+	cf.initOnce.Do(cf.init)
+	cf2 := &ClusterFetcher{Peers: []string{"http://a", "http://b", "http://c", "http://d"}}
+	cf2.initOnce.Do(cf2.init)
+
+	counts := map[string]int{}
+	for i := range 1000 {
+		path := "example.com/m" + strconv.Itoa(i)
+		owners := cf.owners(t.Context(), path)
+		if got, want := len(owners), 3; got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		}
+		counts[owners[0]]++
+		if owner2 := cf2.owners(t.Context(), path)[0]; owner2 != owners[0] && owner2 != "http://d" {
+			t.Errorf("got %q, want %q or %q", owner2, owners[0], "http://d")
+		}
+	}
+	for peer, count := range counts {
+		if count < 200 {
+			t.Errorf("got %d modules owned by %s, want at least 200", count, peer)
+		}
+	}
+}
+
+func TestCleanClusterPeerURL(t *testing.T) {
+	for _, tt := range []struct {
+		n       int
+		peer    string
+		want    string
+		wantErr error
+	}{
+		{1, "http://10.0.0.1:8080", "http://10.0.0.1:8080", nil},
+		{2, "https://goproxy.example.com/prefix/", "https://goproxy.example.com/prefix", nil},
+		{3, "http://10.0.0.1:8080/?foo=bar#baz", "http://10.0.0.1:8080", nil},
+		{4, "10.0.0.1:8080", "", errors.New(`invalid peer URL: "10.0.0.1:8080"`)},
+		{5, "ftp://10.0.0.1", "", errors.New(`invalid peer URL: "ftp://10.0.0.1"`)},
+		{6, "http://", "", errors.New(`invalid peer URL: "http://"`)},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			got, err := cleanClusterPeerURL(tt.peer)
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if got, want := err.Error(), tt.wantErr.Error(); got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if want := tt.want; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/goproxy/goproxy"
+)
+
+// adminAPI is an [http.Handler] that serves the health of the upstream
+// proxies of a [github.com/goproxy/goproxy.GoFetcher] as JSON at
+// "/upstreams" and as Prometheus metrics at "/metrics".
+type adminAPI struct {
+	upstreamHealth func() []goproxy.UpstreamHealth
+}
+
+// ServeHTTP implements [http.Handler].
+func (api *adminAPI) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("Cache-Control", "no-store")
+	switch req.URL.Path {
+	case "/upstreams":
+		api.serveUpstreams(rw)
+	case "/metrics":
+		api.serveMetrics(rw)
+	default:
+		http.NotFound(rw, req)
+	}
+}
+
+// serveUpstreams serves the health of the upstream proxies as JSON.
+func (api *adminAPI) serveUpstreams(rw http.ResponseWriter) {
+	health := api.upstreamHealth()
+	if health == nil {
+		health = []goproxy.UpstreamHealth{}
+	}
+	b, err := json.Marshal(health)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.Write(b)
+}
+
+// serveMetrics serves the health of the upstream proxies in the Prometheus
+// text exposition format.
+func (api *adminAPI) serveMetrics(rw http.ResponseWriter) {
+	health := api.upstreamHealth()
+	var sb strings.Builder
+	writeMetric := func(name, typ, help string, value func(uh goproxy.UpstreamHealth) string) {
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, uh := range health {
+			fmt.Fprintf(&sb, "%s{upstream=\"%s\"} %s\n", name, prometheusLabelValueReplacer.Replace(uh.URL), value(uh))
+		}
+	}
+	writeMetric("goproxy_upstream_up", "gauge", "Whether the circuit breaker of the upstream proxy is closed.", func(uh goproxy.UpstreamHealth) string {
+		if uh.State == goproxy.UpstreamClosed {
+			return "1"
+		}
+		return "0"
+	})
+	writeMetric("goproxy_upstream_circuit_breaker_state", "gauge", "State of the circuit breaker of the upstream proxy (0: closed, 1: open, 2: half-open).", func(uh goproxy.UpstreamHealth) string {
+		switch uh.State {
+		case goproxy.UpstreamOpen:
+			return "1"
+		case goproxy.UpstreamHalfOpen:
+			return "2"
+		}
+		return "0"
+	})
+	writeMetric("goproxy_upstream_consecutive_failures", "gauge", "Number of failed requests to the upstream proxy since the last success.", func(uh goproxy.UpstreamHealth) string {
+		return fmt.Sprint(uh.ConsecutiveFailures)
+	})
+	writeMetric("goproxy_upstream_successes_total", "counter", "Total number of successful requests to the upstream proxy.", func(uh goproxy.UpstreamHealth) string {
+		return fmt.Sprint(uh.Successes)
+	})
+	writeMetric("goproxy_upstream_failures_total", "counter", "Total number of failed requests to the upstream proxy.", func(uh goproxy.UpstreamHealth) string {
+		return fmt.Sprint(uh.Failures)
+	})
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(rw, sb.String())
+}
+
+// prometheusLabelValueReplacer escapes label values in the Prometheus text
+// exposition format.
+var prometheusLabelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goproxy/goproxy"
+)
+
+func TestAdminAPI(t *testing.T) {
+	health := []goproxy.UpstreamHealth{
+		{
+			URL:                 "https://proxy.example.com",
+			State:               goproxy.UpstreamOpen,
+			ConsecutiveFailures: 5,
+			Successes:           10,
+			Failures:            5,
+			LastError:           "bad upstream",
+			LastFailureTime:     time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			URL:       `https://alt.example.com/"quoted"`,
+			State:     goproxy.UpstreamClosed,
+			Successes: 3,
+		},
+	}
+	api := &adminAPI{upstreamHealth: func() []goproxy.UpstreamHealth { return health }}
+
+	for _, tt := range []struct {
+		name            string
+		method          string
+		path            string
+		wantStatusCode  int
+		wantContentType string
+		wantContains    []string
+	}{
+		{
+			name:            "Upstreams",
+			path:            "/upstreams",
+			wantStatusCode:  http.StatusOK,
+			wantContentType: "application/json; charset=utf-8",
+		},
+		{
+			name:            "Metrics",
+			path:            "/metrics",
+			wantStatusCode:  http.StatusOK,
+			wantContentType: "text/plain; version=0.0.4; charset=utf-8",
+			wantContains: []string{
+				"# TYPE goproxy_upstream_up gauge\n",
+				`goproxy_upstream_up{upstream="https://proxy.example.com"} 0` + "\n",
+				`goproxy_upstream_up{upstream="https://alt.example.com/\"quoted\""} 1` + "\n",
+				`goproxy_upstream_circuit_breaker_state{upstream="https://proxy.example.com"} 1` + "\n",
+				`goproxy_upstream_consecutive_failures{upstream="https://proxy.example.com"} 5` + "\n",
+				"# TYPE goproxy_upstream_successes_total counter\n",
+				`goproxy_upstream_successes_total{upstream="https://alt.example.com/\"quoted\""} 3` + "\n",
+				`goproxy_upstream_failures_total{upstream="https://proxy.example.com"} 5` + "\n",
+			},
+		},
+		{
+			name:           "NotFound",
+			path:           "/foobar",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "MethodNotAllowed",
+			method:         http.MethodPost,
+			path:           "/upstreams",
+			wantStatusCode: http.StatusMethodNotAllowed,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.method == "" {
+				tt.method = http.MethodGet
+			}
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if got, want := rec.Code, tt.wantStatusCode; got != want {
+				t.Fatalf("got %d, want %d", got, want)
+			}
+			if tt.wantContentType != "" {
+				if got, want := rec.Header().Get("Content-Type"), tt.wantContentType; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+			for _, want := range tt.wantContains {
+				if got := rec.Body.String(); !strings.Contains(got, want) {
+					t.Errorf("got %q, want it to contain %q", got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("UpstreamsJSON", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/upstreams", nil))
+		var got []goproxy.UpstreamHealth
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if len(got) != len(health) || got[0] != health[0] || got[1] != health[1] {
+			t.Errorf("got %+v, want %+v", got, health)
+		}
+		if strings.Contains(rec.Body.String(), `"LastFailureTime":"0001`) {
+			t.Errorf("got %q, want zero last failure time omitted", rec.Body.String())
+		}
+	})
+
+	t.Run("NoUpstreams", func(t *testing.T) {
+		api := &adminAPI{upstreamHealth: func() []goproxy.UpstreamHealth { return nil }}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/upstreams", nil))
+		if got, want := rec.Body.String(), "[]"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,806 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/goproxy/goproxy"
+	"github.com/goproxy/goproxy/azblobcacher"
+	"github.com/goproxy/goproxy/gcscacher"
+	"github.com/goproxy/goproxy/rediscacher"
+	"github.com/goproxy/goproxy/s3cacher"
+	"github.com/goproxy/goproxy/sqlcacher"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+	modzip "golang.org/x/mod/zip"
+	_ "modernc.org/sqlite"
+)
+
+// cacherConfig is the configuration for creating a
+// [github.com/goproxy/goproxy.Cacher].
+type cacherConfig struct {
+	cacher           string
+	cacherDir        string
+	s3CacherOpts     s3CacherOptions
+	gcsCacherOpts    gcsCacherOptions
+	azblobCacherOpts azblobCacherOptions
+	redisCacherOpts  redisCacherOptions
+	sqliteFile       string
+	sqliteBlobDir    string
+	dedup            bool
+	compress         bool
+
+	encryptionKeyFile        string
+	encryptionModulePatterns string
+	encryptionAllowPlaintext bool
+}
+
+// s3CacherOptions is the options for creating a new
+// [github.com/goproxy/goproxy/s3cacher.Cacher].
+type s3CacherOptions struct {
+	accessKeyID          string
+	secretAccessKey      string
+	sessionToken         string
+	endpoint             string
+	disableTLS           bool
+	region               string
+	bucket               string
+	keyPrefix            string
+	forcePathStyle       bool
+	serverSideEncryption string
+	sseKMSKeyID          string
+	storageClass         string
+	redirectBaseURL      string
+	presignExpiry        time.Duration
+	partSize             int64
+}
+
+// gcsCacherOptions is the options for creating a new
+// [github.com/goproxy/goproxy/gcscacher.Cacher].
+type gcsCacherOptions struct {
+	endpoint        string
+	bucket          string
+	keyPrefix       string
+	credentialsFile string
+	anonymous       bool
+}
+
+// azblobCacherOptions is the options for creating a new
+// [github.com/goproxy/goproxy/azblobcacher.Cacher].
+type azblobCacherOptions struct {
+	accountName string
+	accountKey  string
+	sasToken    string
+	endpoint    string
+	container   string
+	keyPrefix   string
+}
+
+// redisCacherOptions is the options for creating a new
+// [github.com/goproxy/goproxy/rediscacher.Cacher].
+type redisCacherOptions struct {
+	addr      string
+	username  string
+	password  string
+	db        int
+	tls       bool
+	keyPrefix string
+	ttl       time.Duration
+	maxSize   int64
+}
+
+// bindFlags binds the flags of the cfg to the fs.
+func (cfg *cacherConfig) bindFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&cfg.cacher, "cacher", "dir", "cacher to use (valid values: dir, s3, gcs, azblob, sqlite)")
+	fs.StringVar(&cfg.cacherDir, "cacher-dir", "caches", "directory for the dir cacher")
+	fs.StringVar(&cfg.s3CacherOpts.accessKeyID, "cacher-s3-access-key-id", "", "access key ID for the S3 cacher (empty means from the environment, shared credentials file, or IAM role)")
+	fs.StringVar(&cfg.s3CacherOpts.secretAccessKey, "cacher-s3-secret-access-key", "", "secret access key for the S3 cacher")
+	fs.StringVar(&cfg.s3CacherOpts.sessionToken, "cacher-s3-session-token", "", "session token for the S3 cacher")
+	fs.StringVar(&cfg.s3CacherOpts.endpoint, "cacher-s3-endpoint", "s3.amazonaws.com", "endpoint for the S3 cacher")
+	fs.BoolVar(&cfg.s3CacherOpts.disableTLS, "cacher-s3-disable-tls", false, "disable TLS for the S3 cacher")
+	fs.StringVar(&cfg.s3CacherOpts.region, "cacher-s3-region", "us-east-1", "region for the S3 cacher")
+	fs.StringVar(&cfg.s3CacherOpts.bucket, "cacher-s3-bucket", "", "bucket name for the S3 cacher")
+	fs.StringVar(&cfg.s3CacherOpts.keyPrefix, "cacher-s3-key-prefix", "", "object key prefix for the S3 cacher")
+	fs.BoolVar(&cfg.s3CacherOpts.forcePathStyle, "cacher-s3-force-path-style", false, "force path-style addressing for the S3 cacher")
+	fs.StringVar(&cfg.s3CacherOpts.serverSideEncryption, "cacher-s3-server-side-encryption", "", "server-side encryption for the S3 cacher (valid values: AES256, aws:kms)")
+	fs.StringVar(&cfg.s3CacherOpts.sseKMSKeyID, "cacher-s3-sse-kms-key-id", "", "KMS key ID for the aws:kms server-side encryption of the S3 cacher")
+	fs.StringVar(&cfg.s3CacherOpts.storageClass, "cacher-s3-storage-class", "", "storage class for the S3 cacher")
+	fs.StringVar(&cfg.s3CacherOpts.redirectBaseURL, "cacher-s3-redirect-base-url", "", "base URL (such as a CDN URL) for redirects to the S3 cacher (empty means presigned URLs)")
+	fs.DurationVar(&cfg.s3CacherOpts.presignExpiry, "cacher-s3-presign-expiry", 15*time.Minute, "validity duration of presigned URLs for redirects to the S3 cacher")
+	fs.Int64Var(&cfg.s3CacherOpts.partSize, "cacher-s3-part-size", 100<<20, "multipart upload part size for the S3 cacher")
+	fs.StringVar(&cfg.gcsCacherOpts.endpoint, "cacher-gcs-endpoint", "https://storage.googleapis.com", "JSON API endpoint for the GCS cacher")
+	fs.StringVar(&cfg.gcsCacherOpts.bucket, "cacher-gcs-bucket", "", "bucket name for the GCS cacher")
+	fs.StringVar(&cfg.gcsCacherOpts.keyPrefix, "cacher-gcs-key-prefix", "", "object name prefix for the GCS cacher")
+	fs.StringVar(&cfg.gcsCacherOpts.credentialsFile, "cacher-gcs-credentials-file", "", "service account key file for the GCS cacher (empty means Application Default Credentials)")
+	fs.BoolVar(&cfg.gcsCacherOpts.anonymous, "cacher-gcs-anonymous", false, "send requests anonymously for the GCS cacher")
+	fs.StringVar(&cfg.azblobCacherOpts.accountName, "cacher-azblob-account-name", "", "storage account name for the Azure Blob cacher")
+	fs.StringVar(&cfg.azblobCacherOpts.accountKey, "cacher-azblob-account-key", "", "storage account key for the Azure Blob cacher")
+	fs.StringVar(&cfg.azblobCacherOpts.sasToken, "cacher-azblob-sas-token", "", "shared access signature for the Azure Blob cacher (used if the account key is empty)")
+	fs.StringVar(&cfg.azblobCacherOpts.endpoint, "cacher-azblob-endpoint", "", "Blob service endpoint for the Azure Blob cacher (empty means https://<account-name>.blob.core.windows.net)")
+	fs.StringVar(&cfg.azblobCacherOpts.container, "cacher-azblob-container", "", "container name for the Azure Blob cacher")
+	fs.StringVar(&cfg.azblobCacherOpts.keyPrefix, "cacher-azblob-key-prefix", "", "blob name prefix for the Azure Blob cacher")
+	fs.StringVar(&cfg.sqliteFile, "cacher-sqlite-file", "caches.db", "database file for the sqlite cacher")
+	fs.StringVar(&cfg.sqliteBlobDir, "cacher-sqlite-blob-dir", "", "directory for the content of caches of the sqlite cacher (empty means in the database file)")
+	fs.StringVar(&cfg.redisCacherOpts.addr, "cacher-redis-addr", "", "address of a Redis-protocol server (such as Redis or Valkey) in front of the cacher for small caches shared by replicas (empty means disabled)")
+	fs.StringVar(&cfg.redisCacherOpts.username, "cacher-redis-username", "", "username for the Redis cacher")
+	fs.StringVar(&cfg.redisCacherOpts.password, "cacher-redis-password", "", "password for the Redis cacher")
+	fs.IntVar(&cfg.redisCacherOpts.db, "cacher-redis-db", 0, "logical database for the Redis cacher")
+	fs.BoolVar(&cfg.redisCacherOpts.tls, "cacher-redis-tls", false, "use TLS for the Redis cacher")
+	fs.StringVar(&cfg.redisCacherOpts.keyPrefix, "cacher-redis-key-prefix", "goproxy:", "key prefix for the Redis cacher (must not overlap with the \"goproxy-lock:\" key prefix of the redis direct fetch locker)")
+	fs.DurationVar(&cfg.redisCacherOpts.ttl, "cacher-redis-ttl", 0, "time to live of caches in the Redis cacher (0 means never expire)")
+	fs.Int64Var(&cfg.redisCacherOpts.maxSize, "cacher-redis-max-size", 1<<20, "maximum size of caches in the Redis cacher (larger caches go to the cacher)")
+	fs.BoolVar(&cfg.compress, "cacher-compress", false, "compress module mod files, module version lists, and checksum database tiles in the cacher with gzip")
+	fs.BoolVar(&cfg.dedup, "cacher-dedup", false, "store identical content only once in the cacher, addressed by its SHA-256 digest (run the cache gc command periodically to reclaim unreferenced content)")
+	fs.StringVar(&cfg.encryptionKeyFile, "cacher-encryption-key-file", "", "file of base64-encoded AES-256 keys for encrypting content in the cacher, one per line (the first is used for new content)")
+	fs.StringVar(&cfg.encryptionModulePatterns, "cacher-encryption-module-patterns", "", "comma-separated list of glob patterns of module path prefixes whose content is encrypted in the cacher (empty means all content)")
+	fs.BoolVar(&cfg.encryptionAllowPlaintext, "cacher-encryption-allow-plaintext", false, "serve content stored unencrypted in the cacher for modules whose content is encrypted (for migrating existing caches)")
+}
+
+// newCacher creates a new [github.com/goproxy/goproxy.Cacher] from the cfg.
+func (cfg *cacherConfig) newCacher(transport http.RoundTripper) (goproxy.Cacher, error) {
+	var cacher goproxy.Cacher
+	switch cfg.cacher {
+	case "dir":
+		cacher = goproxy.DirCacher(cfg.cacherDir)
+	case "s3":
+		opts := cfg.s3CacherOpts
+		cacher = &s3cacher.Cacher{
+			Endpoint:             opts.endpoint,
+			DisableTLS:           opts.disableTLS,
+			ForcePathStyle:       opts.forcePathStyle,
+			Region:               opts.region,
+			Bucket:               opts.bucket,
+			KeyPrefix:            opts.keyPrefix,
+			AccessKeyID:          opts.accessKeyID,
+			SecretAccessKey:      opts.secretAccessKey,
+			SessionToken:         opts.sessionToken,
+			ServerSideEncryption: opts.serverSideEncryption,
+			SSEKMSKeyID:          opts.sseKMSKeyID,
+			StorageClass:         opts.storageClass,
+			RedirectBaseURL:      opts.redirectBaseURL,
+			PresignExpiry:        opts.presignExpiry,
+			PartSize:             opts.partSize,
+			Transport:            transport,
+		}
+	case "gcs":
+		opts := cfg.gcsCacherOpts
+		cacher = &gcscacher.Cacher{
+			Endpoint:        opts.endpoint,
+			Bucket:          opts.bucket,
+			KeyPrefix:       opts.keyPrefix,
+			CredentialsFile: opts.credentialsFile,
+			Anonymous:       opts.anonymous,
+			Transport:       transport,
+		}
+	case "azblob":
+		opts := cfg.azblobCacherOpts
+		cacher = &azblobcacher.Cacher{
+			AccountName: opts.accountName,
+			AccountKey:  opts.accountKey,
+			SASToken:    opts.sasToken,
+			Endpoint:    opts.endpoint,
+			Container:   opts.container,
+			KeyPrefix:   opts.keyPrefix,
+			Transport:   transport,
+		}
+	case "sqlite":
+		sc, err := cfg.newSQLiteCacher()
+		if err != nil {
+			return nil, err
+		}
+		cacher = sc
+	default:
+		return nil, fmt.Errorf("invalid --cacher: %q", cfg.cacher)
+	}
+	if cfg.redisCacherOpts.addr != "" {
+		cacher = cfg.newRedisCacher(cacher)
+	}
+	if cfg.dedup {
+		cacher = &goproxy.DedupCacher{Cacher: cacher}
+	}
+	if cfg.encryptionKeyFile != "" {
+		cacher = &goproxy.EncryptCacher{
+			Cacher:         cacher,
+			KeyProvider:    &goproxy.FileKeyProvider{File: cfg.encryptionKeyFile},
+			ModulePatterns: cfg.encryptionModulePatterns,
+			AllowPlaintext: cfg.encryptionAllowPlaintext,
+		}
+	}
+	if cfg.compress {
+		cacher = &goproxy.CompressCacher{Cacher: cacher}
+	}
+	return cacher, nil
+}
+
+// newSQLiteCacher creates a new [sqlcacher.Cacher] for the sqlite cacher based
+// on the cfg.
+func (cfg *cacherConfig) newSQLiteCacher() (*sqlcacher.Cacher, error) {
+	db, err := sql.Open("sqlite", "file:"+cfg.sqliteFile+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	sc := &sqlcacher.Cacher{DB: db, Dialect: "sqlite"}
+	if cfg.sqliteBlobDir != "" {
+		sc.BlobCacher = goproxy.DirCacher(cfg.sqliteBlobDir)
+	}
+	return sc, nil
+}
+
+// newRedisCacher creates a new [rediscacher.Cacher] for the Redis cacher based
+// on the cfg, with the fallback as its Fallback.
+func (cfg *cacherConfig) newRedisCacher(fallback goproxy.Cacher) *rediscacher.Cacher {
+	opts := cfg.redisCacherOpts
+	rc := &rediscacher.Cacher{
+		Addr:      opts.addr,
+		Username:  opts.username,
+		Password:  opts.password,
+		DB:        opts.db,
+		KeyPrefix: opts.keyPrefix,
+		TTL:       opts.ttl,
+		MaxSize:   opts.maxSize,
+		Fallback:  fallback,
+	}
+	if opts.tls {
+		rc.TLSConfig = &tls.Config{}
+	}
+	return rc
+}
+
+// newCacheCmd creates a new cache command.
+func newCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect and maintain the caches of a Go module proxy server",
+		Long: strings.TrimSpace(`
+Inspect and maintain the caches of a Go module proxy server.
+
+The subcommands work against the same cacher flags as the server command, and
+can be run safely while a server is using the caches.
+`),
+	}
+	cfg := &cacherConfig{}
+	cfg.bindFlags(cmd.PersistentFlags())
+	cmd.AddCommand(newCacheVerifyCmd(cfg))
+	cmd.AddCommand(newCacheGCCmd(cfg))
+	cmd.AddCommand(newCacheDUCmd(cfg))
+	return cmd
+}
+
+// newCacheVerifyCmd creates a new cache verify command.
+func newCacheVerifyCmd(cfg *cacherConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify cached module zip files",
+		Long: strings.TrimSpace(`
+Verify cached module zip files.
+
+Each cached module zip file is checked for a valid module zip layout, and its
+hash is compared against the stored ".ziphash" (if any) and the checksum
+database. Mismatches and checksum database security errors are reported, and
+the command fails if there is any. Module versions that are not found in the
+checksum database (such as private modules) are reported but not treated as
+mismatches.
+
+The checksum database is specified by GOSUMDB, and is connected to through the
+proxies in GOPROXY that support proxying it, as the go command does. Modules
+matching GONOSUMDB (or GOPRIVATE) are not compared against it.
+`),
+		Args: cobra.NoArgs,
+	}
+	var tempDir string
+	cmd.Flags().StringVar(&tempDir, "temp-dir", os.TempDir(), "directory for storing temporary files")
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		cacher, err := cfg.newCacher(http.DefaultTransport)
+		if err != nil {
+			return err
+		}
+		return runCacheVerify(cmd.Context(), cacher, newSumDBLookup(nil, http.DefaultTransport), tempDir, cmd.OutOrStdout())
+	}
+	return cmd
+}
+
+// sumDBLookup looks up the go.sum lines for the module path and version in a
+// checksum database. The returned error matches [sumdb.ErrSecurity] if the
+// checksum database is misbehaving.
+type sumDBLookup func(modulePath, moduleVersion string) (lines []string, err error)
+
+// newSumDBLookup returns a [sumDBLookup] that uses a
+// [github.com/goproxy/goproxy.GoFetcher] with the env (see
+// [github.com/goproxy/goproxy.GoFetcher.Env]) and the transport.
+func newSumDBLookup(env []string, transport http.RoundTripper) sumDBLookup {
+	var (
+		mu             sync.Mutex
+		securityErrMsg string
+	)
+	gf := &goproxy.GoFetcher{
+		Env:       env,
+		Transport: transport,
+		OnSumDBSecurityError: func(msg string) {
+			mu.Lock()
+			defer mu.Unlock()
+			securityErrMsg = msg
+		},
+	}
+	return func(modulePath, moduleVersion string) ([]string, error) {
+		lines, err := gf.LookupSumDB(modulePath, moduleVersion)
+		mu.Lock()
+		defer mu.Unlock()
+		if msg := securityErrMsg; msg != "" {
+			securityErrMsg = ""
+			return nil, fmt.Errorf("%w: %s", sumdb.ErrSecurity, strings.TrimSpace(msg))
+		}
+		return lines, err
+	}
+}
+
+// runCacheVerify verifies the module zip files cached by the cacher and writes
+// the results to the w. The lookupSumDB is optional.
+func runCacheVerify(ctx context.Context, cacher goproxy.Cacher, lookupSumDB sumDBLookup, tempDir string, w io.Writer) error {
+	names, err := listCaches(ctx, cacher, "")
+	if err != nil {
+		return err
+	}
+	checked, mismatches := 0, 0
+	for _, name := range names {
+		modulePath, moduleVersion, ext, ok := parseModuleFileName(name)
+		if !ok || ext != ".zip" {
+			continue
+		}
+		checked++
+		problems, notes, err := verifyCachedZip(ctx, cacher, lookupSumDB, tempDir, name, modulePath, moduleVersion)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // Removed concurrently.
+			}
+			return fmt.Errorf("verify %s: %w", name, err)
+		}
+		for _, s := range slices.Concat(problems, notes) {
+			fmt.Fprintf(w, "%s: %s\n", name, s)
+		}
+		if len(problems) > 0 {
+			mismatches++
+		}
+	}
+	fmt.Fprintf(w, "verified %d module zip files, %d mismatched\n", checked, mismatches)
+	if mismatches > 0 {
+		return fmt.Errorf("found %d mismatched module zip files", mismatches)
+	}
+	return nil
+}
+
+// verifyCachedZip verifies the module zip file cached by the cacher for the
+// name. It returns the problems found, and notes about the checks that could
+// not be done.
+func verifyCachedZip(ctx context.Context, cacher goproxy.Cacher, lookupSumDB sumDBLookup, tempDir, name, modulePath, moduleVersion string) (problems, notes []string, err error) {
+	file, cleanup, err := cacheTempFile(ctx, cacher, name, tempDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cleanup()
+
+	if cf, err := modzip.CheckZip(module.Version{Path: modulePath, Version: moduleVersion}, file); err != nil {
+		return []string{fmt.Sprintf("invalid module zip file: %v", err)}, nil, nil
+	} else if err := cf.Err(); err != nil {
+		return []string{fmt.Sprintf("invalid module zip file: %v", err)}, nil, nil
+	}
+	zipHash, err := dirhash.HashZip(file, dirhash.DefaultHash)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid module zip file: %v", err)}, nil, nil
+	}
+
+	if rc, err := cacher.Get(ctx, strings.TrimSuffix(name, ".zip")+".ziphash"); err == nil {
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if storedHash := strings.TrimSpace(string(b)); storedHash != zipHash {
+			problems = append(problems, fmt.Sprintf("hash mismatch: got %s, stored %s", zipHash, storedHash))
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	if lookupSumDB != nil {
+		sumLines, err := lookupSumDB(modulePath, moduleVersion)
+		if errors.Is(err, sumdb.ErrSecurity) {
+			problems = append(problems, fmt.Sprintf("checksum database %v", err))
+		} else if err != nil {
+			notes = append(notes, fmt.Sprintf("not verified against checksum database: %v", err))
+		} else if zipSumLine := fmt.Sprintf("%s %s %s", modulePath, moduleVersion, zipHash); !slices.Contains(sumLines, zipSumLine) {
+			problems = append(problems, fmt.Sprintf("checksum database mismatch: got %s", zipHash))
+		}
+	}
+	return problems, notes, nil
+}
+
+// newCacheGCCmd creates a new cache gc command.
+func newCacheGCCmd(cfg *cacherConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove stale caches",
+		Long: strings.TrimSpace(`
+Remove stale caches.
+
+The following caches are removed:
+  - temporary files left over by interrupted writes of the dir cacher
+  - stored hashes and digests without the caches they belong to
+  - results of direct fetches shared between replicas
+  - ".info" files without the corresponding ".zip" files (only with
+    --remove-orphaned-info)
+  - caches older than the retention (if any)
+  - content no longer referenced by any cache (only with --cacher-dedup)
+
+Note that ".info" files are also cached alone for version queries (such as
+"@v/master.info" resolving to a pseudo-version) and are legitimate then, so
+removing orphaned ".info" files only makes sense for caches that are meant
+to hold complete module versions.
+
+Only caches older than the minimum age are removed, so that caches being
+written by a running server are not affected. Removing caches requires the
+cacher to support deletion.
+`),
+		Args: cobra.NoArgs,
+	}
+	var opts cacheGCOptions
+	cmd.Flags().DurationVar(&opts.minAge, "min-age", time.Hour, "minimum age of caches to remove")
+	cmd.Flags().DurationVar(&opts.retention, "retention", 0, "maximum age (0 means no limit) of caches to keep")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "only report the caches that would be removed")
+	cmd.Flags().BoolVar(&opts.removeOrphanedInfo, "remove-orphaned-info", false, `remove ".info" files without the corresponding ".zip" files (including those cached for version queries)`)
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		cacher, err := cfg.newCacher(http.DefaultTransport)
+		if err != nil {
+			return err
+		}
+		return runCacheGC(cmd.Context(), cacher, opts, cmd.OutOrStdout())
+	}
+	return cmd
+}
+
+// cacheGCOptions is the options for [runCacheGC].
+type cacheGCOptions struct {
+	minAge             time.Duration
+	retention          time.Duration
+	dryRun             bool
+	removeOrphanedInfo bool
+}
+
+// runCacheGC removes stale caches from the cacher and writes the results to
+// the w.
+func runCacheGC(ctx context.Context, cacher goproxy.Cacher, opts cacheGCOptions, w io.Writer) error {
+	cd, ok := cacher.(interface {
+		Delete(ctx context.Context, name string) error
+	})
+	if !ok && !opts.dryRun {
+		return errors.New("cacher does not support deletion")
+	}
+	action := "removed"
+	if opts.dryRun {
+		action = "would remove"
+	}
+	now := time.Now()
+	removed := 0
+
+	if dc, ok := cacher.(goproxy.DirCacher); ok {
+		err := filepath.WalkDir(string(dc), func(file string, de fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !de.Type().IsRegular() || !strings.HasPrefix(de.Name(), ".") || !strings.Contains(de.Name(), ".tmp.") {
+				return nil
+			}
+			fi, err := de.Info()
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if now.Sub(fi.ModTime()) < opts.minAge {
+				return nil
+			}
+			if !opts.dryRun {
+				if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			}
+			fmt.Fprintf(w, "%s %s\n", action, file)
+			removed++
+			return nil
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	names, err := listCaches(ctx, cacher, "")
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(names))
+	for _, name := range names {
+		exists[name] = true
+	}
+	for _, name := range names {
+		reason := ""
+		switch ext := path.Ext(name); {
+		case strings.HasPrefix(name, "direct-fetches/"):
+			reason = "shared direct fetch result"
+		case ext == ".sha256":
+			if !exists[strings.TrimSuffix(name, ext)] {
+				reason = "orphaned"
+			}
+		case ext == ".ziphash", ext == ".info" && opts.removeOrphanedInfo:
+			if _, _, _, ok := parseModuleFileName(name); ok && !exists[strings.TrimSuffix(name, ext)+".zip"] {
+				reason = "orphaned"
+			}
+		}
+		modTime, err := cacheModTime(ctx, cacher, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // Removed concurrently.
+			}
+			return err
+		}
+		if modTime.IsZero() {
+			continue
+		}
+		age := now.Sub(modTime)
+		if age < opts.minAge {
+			continue
+		}
+		if reason == "" && opts.retention > 0 && age > opts.retention {
+			reason = "expired"
+		}
+		if reason == "" {
+			continue
+		}
+		if !opts.dryRun {
+			if err := cd.Delete(ctx, name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "%s %s (%s)\n", action, name, reason)
+		removed++
+	}
+
+	if dc := dedupCacherOf(cacher); dc != nil {
+		names, err := dc.CollectGarbage(ctx, opts.minAge, opts.dryRun)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Fprintf(w, "%s %s (unreferenced)\n", action, name)
+		}
+		removed += len(names)
+	}
+	fmt.Fprintf(w, "%s %d caches\n", action, removed)
+	return nil
+}
+
+// dedupCacherOf returns the [goproxy.DedupCacher] wrapped by the cacher (or
+// the cacher itself), or nil if there is none.
+func dedupCacherOf(cacher goproxy.Cacher) *goproxy.DedupCacher {
+	for {
+		switch c := cacher.(type) {
+		case *goproxy.DedupCacher:
+			return c
+		case *goproxy.CompressCacher:
+			cacher = c.Cacher
+		case *goproxy.EncryptCacher:
+			cacher = c.Cacher
+		default:
+			return nil
+		}
+	}
+}
+
+// newCacheDUCmd creates a new cache du command.
+func newCacheDUCmd(cfg *cacherConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "du",
+		Short: "Report the disk usage of caches per module",
+		Long: strings.TrimSpace(`
+Report the disk usage of caches per module.
+
+Caches of proxied checksum databases are reported per checksum database, and
+quarantined caches and results of direct fetches shared between replicas are
+each reported as a whole.
+`),
+		Args: cobra.NoArgs,
+	}
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		cacher, err := cfg.newCacher(http.DefaultTransport)
+		if err != nil {
+			return err
+		}
+		return runCacheDU(cmd.Context(), cacher, cmd.OutOrStdout())
+	}
+	return cmd
+}
+
+// cacheUsage is the usage of caches reported by [runCacheDU].
+type cacheUsage struct {
+	name  string
+	files int
+	size  int64
+}
+
+// runCacheDU writes the usage of caches in the cacher per module to the w.
+func runCacheDU(ctx context.Context, cacher goproxy.Cacher, w io.Writer) error {
+	names, err := listCaches(ctx, cacher, "")
+	if err != nil {
+		return err
+	}
+	usages := map[string]*cacheUsage{}
+	total := &cacheUsage{name: "total"}
+	for _, name := range names {
+		size, err := cacheSize(ctx, cacher, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // Removed concurrently.
+			}
+			return err
+		}
+		group := cacheUsageGroup(name)
+		u := usages[group]
+		if u == nil {
+			u = &cacheUsage{name: group}
+			usages[group] = u
+		}
+		u.files++
+		u.size += size
+		total.files++
+		total.size += size
+	}
+
+	sorted := make([]*cacheUsage, 0, len(usages))
+	for _, u := range usages {
+		sorted = append(sorted, u)
+	}
+	slices.SortFunc(sorted, func(a, b *cacheUsage) int {
+		if c := cmp.Compare(b.size, a.size); c != 0 {
+			return c
+		}
+		return strings.Compare(a.name, b.name)
+	})
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SIZE\tFILES\tMODULE")
+	for _, u := range append(sorted, total) {
+		fmt.Fprintf(tw, "%d\t%d\t%s\n", u.size, u.files, u.name)
+	}
+	return tw.Flush()
+}
+
+// cacheUsageGroup returns the name of the group that the cache name belongs to
+// in the report of [runCacheDU].
+func cacheUsageGroup(name string) string {
+	if rest, ok := strings.CutPrefix(name, "sumdb/"); ok {
+		sumdbName, _, _ := strings.Cut(rest, "/")
+		return "sumdb/" + sumdbName
+	}
+	if strings.HasPrefix(name, "quarantine/") {
+		return "quarantine"
+	}
+	if strings.HasPrefix(name, "direct-fetches/") {
+		return "direct-fetches"
+	}
+	escapedModulePath, _, ok := strings.Cut(name, "/@v/")
+	if !ok {
+		escapedModulePath, ok = strings.CutSuffix(name, "/@latest")
+	}
+	if ok {
+		if modulePath, err := module.UnescapePath(escapedModulePath); err == nil {
+			return modulePath
+		}
+	}
+	return "other"
+}
+
+// listCaches lists the names of all caches in the cacher that start with the
+// prefix.
+func listCaches(ctx context.Context, cacher goproxy.Cacher, prefix string) ([]string, error) {
+	cl, ok := cacher.(interface {
+		List(ctx context.Context, prefix string) (names []string, err error)
+	})
+	if !ok {
+		return nil, errors.New("cacher does not support listing")
+	}
+	return cl.List(ctx, prefix)
+}
+
+// parseModuleFileName parses the cache name of a module file.
+func parseModuleFileName(name string) (modulePath, moduleVersion, ext string, ok bool) {
+	escapedModulePath, file, ok := strings.Cut(name, "/@v/")
+	if !ok {
+		return "", "", "", false
+	}
+	ext = path.Ext(file)
+	var err error
+	if modulePath, err = module.UnescapePath(escapedModulePath); err != nil {
+		return "", "", "", false
+	}
+	if moduleVersion, err = module.UnescapeVersion(strings.TrimSuffix(file, ext)); err != nil {
+		return "", "", "", false
+	}
+	if module.Check(modulePath, moduleVersion) != nil || moduleVersion != module.CanonicalVersion(moduleVersion) {
+		return "", "", "", false
+	}
+	return modulePath, moduleVersion, ext, true
+}
+
+// cacheModTime returns the modification time of the cache for the name. It
+// returns the zero time if the cacher does not report it.
+func cacheModTime(ctx context.Context, cacher goproxy.Cacher, name string) (time.Time, error) {
+	rc, err := cacher.Get(ctx, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer rc.Close()
+	if lm, ok := rc.(interface{ LastModified() time.Time }); ok {
+		return lm.LastModified(), nil
+	}
+	if mt, ok := rc.(interface{ ModTime() time.Time }); ok {
+		return mt.ModTime(), nil
+	}
+	return time.Time{}, nil
+}
+
+// cacheSize returns the size of the cache for the name.
+func cacheSize(ctx context.Context, cacher goproxy.Cacher, name string) (int64, error) {
+	rc, err := cacher.Get(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	if s, ok := rc.(interface{ Size() int64 }); ok {
+		return s.Size(), nil
+	}
+	if s, ok := rc.(io.Seeker); ok {
+		return s.Seek(0, io.SeekEnd)
+	}
+	return io.Copy(io.Discard, rc)
+}
+
+// cacheTempFile copies the cache for the name to a temporary file in the
+// tempDir. The cleanup function removes the temporary file.
+func cacheTempFile(ctx context.Context, cacher goproxy.Cacher, name, tempDir string) (file string, cleanup func(), err error) {
+	rc, err := cacher.Get(ctx, name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer rc.Close()
+	f, err := os.CreateTemp(tempDir, "cache.*"+path.Ext(name))
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.Remove(f.Name()) }
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		cleanup()
+		return "", nil, err
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
@@ -0,0 +1,593 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goproxy/goproxy"
+	"github.com/goproxy/goproxy/azblobcacher"
+	"github.com/goproxy/goproxy/gcscacher"
+	"github.com/goproxy/goproxy/rediscacher"
+	"github.com/goproxy/goproxy/s3cacher"
+	"github.com/goproxy/goproxy/sqlcacher"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// makeModuleZip returns a module zip file with the files.
+func makeModuleZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return buf.Bytes()
+}
+
+// hashModuleZip returns the "h1:" hash of the module zip content.
+func hashModuleZip(t *testing.T, content []byte) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "module.zip")
+	if err := os.WriteFile(file, content, 0o644); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	hash, err := dirhash.HashZip(file, dirhash.DefaultHash)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return hash
+}
+
+func TestRunCacheVerify(t *testing.T) {
+	goodZip := makeModuleZip(t, map[string]string{"example.com/good@v1.0.0/go.mod": "module example.com/good"})
+	badZip := makeModuleZip(t, map[string]string{"example.com/bad@v1.0.0/go.mod": "module example.com/bad"})
+	tamperedZip := makeModuleZip(t, map[string]string{"example.com/tampered@v1.0.0/go.mod": "module example.com/tampered"})
+	privateZip := makeModuleZip(t, map[string]string{"example.com/private@v1.0.0/go.mod": "module example.com/private"})
+
+	skey, vkey, err := note.GenerateKey(rand.Reader, "sumdb.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sumdbServer := httptest.NewServer(sumdb.NewServer(sumdb.NewTestServer(skey, func(path, vers string) ([]byte, error) {
+		var hash string
+		switch path {
+		case "example.com/good":
+			hash = hashModuleZip(t, goodZip)
+		case "example.com/bad":
+			hash = hashModuleZip(t, badZip)
+		case "example.com/tampered":
+			hash = hashModuleZip(t, makeModuleZip(t, map[string]string{"example.com/tampered@v1.0.0/go.mod": "module example.com/tampered // original"}))
+		default:
+			return nil, fs.ErrNotExist
+		}
+		return fmt.Appendf(nil, "%s %s %s\n%s %s/go.mod h1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n", path, vers, hash, path, vers), nil
+	})))
+	t.Cleanup(sumdbServer.Close)
+
+	cacher := goproxy.DirCacher(t.TempDir())
+	for name, content := range map[string]string{
+		"example.com/good/@v/v1.0.0.zip":      string(goodZip),
+		"example.com/good/@v/v1.0.0.ziphash":  hashModuleZip(t, goodZip),
+		"example.com/good/@v/v1.0.0.info":     "{}",
+		"example.com/bad/@v/v1.0.0.zip":       string(badZip),
+		"example.com/bad/@v/v1.0.0.ziphash":   "h1:invalid",
+		"example.com/tampered/@v/v1.0.0.zip":  string(tamperedZip),
+		"example.com/private/@v/v1.0.0.zip":   string(privateZip),
+		"example.com/invalid/@v/v1.0.0.zip":   "invalid",
+		"sumdb/sum.golang.org/lookup/foo.zip": "",
+	} {
+		if err := cacher.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	t.Run("Normal", func(t *testing.T) {
+		lookupSumDB := newSumDBLookup([]string{
+			"GOPROXY=off",
+			"GOSUMDB=" + vkey + " " + sumdbServer.URL,
+			"GONOSUMDB=example.com/private",
+		}, http.DefaultTransport)
+		var buf bytes.Buffer
+		err := runCacheVerify(t.Context(), cacher, lookupSumDB, t.TempDir(), &buf)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if got, want := err.Error(), "found 3 mismatched module zip files"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		out := buf.String()
+		for _, want := range []string{
+			"example.com/bad/@v/v1.0.0.zip: hash mismatch: got " + hashModuleZip(t, badZip) + ", stored h1:invalid\n",
+			"example.com/invalid/@v/v1.0.0.zip: invalid module zip file: ",
+			"example.com/private/@v/v1.0.0.zip: not verified against checksum database: ",
+			"example.com/tampered/@v/v1.0.0.zip: checksum database mismatch: got " + hashModuleZip(t, tamperedZip) + "\n",
+			"verified 5 module zip files, 3 mismatched\n",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("output %q does not contain %q", out, want)
+			}
+		}
+		if strings.Contains(out, "example.com/good/") {
+			t.Errorf("output %q contains good module", out)
+		}
+	})
+
+	t.Run("SecurityError", func(t *testing.T) {
+		cacher := goproxy.DirCacher(t.TempDir())
+		if err := cacher.Put(t.Context(), "example.com/good/@v/v1.0.0.zip", bytes.NewReader(goodZip)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		lookupSumDB := func(modulePath, moduleVersion string) ([]string, error) {
+			return nil, fmt.Errorf("%w: inconsistent tree heads", sumdb.ErrSecurity)
+		}
+		var buf bytes.Buffer
+		if err := runCacheVerify(t.Context(), cacher, lookupSumDB, t.TempDir(), &buf); err == nil {
+			t.Fatal("expected error")
+		}
+		if got, want := buf.String(), "example.com/good/@v/v1.0.0.zip: checksum database security error: misbehaving server: inconsistent tree heads\n"; !strings.HasPrefix(got, want) {
+			t.Errorf("got %q, want prefix %q", got, want)
+		}
+	})
+
+	t.Run("NoSumDB", func(t *testing.T) {
+		cacher := goproxy.DirCacher(t.TempDir())
+		if err := cacher.Put(t.Context(), "example.com/private/@v/v1.0.0.zip", bytes.NewReader(privateZip)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		var buf bytes.Buffer
+		if err := runCacheVerify(t.Context(), cacher, nil, t.TempDir(), &buf); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := buf.String(), "verified 1 module zip files, 0 mismatched\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("NotListable", func(t *testing.T) {
+		err := runCacheVerify(t.Context(), struct{ goproxy.Cacher }{cacher}, nil, t.TempDir(), io.Discard)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if got, want := err.Error(), "cacher does not support listing"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestRunCacheGC(t *testing.T) {
+	newCacher := func(t *testing.T) goproxy.DirCacher {
+		cacher := goproxy.DirCacher(t.TempDir())
+		for _, name := range []string{
+			"example.com/a/@v/v1.0.0.info",
+			"example.com/a/@v/v1.0.0.mod",
+			"example.com/a/@v/v1.0.0.zip",
+			"example.com/a/@v/v1.0.0.ziphash",
+			"example.com/a/@v/v1.0.0.zip.sha256",
+			"example.com/b/@v/v1.0.0.info",
+			"example.com/b/@v/v1.0.0.ziphash",
+			"example.com/b/@v/v1.0.0.mod.sha256",
+			"example.com/c/@v/v1.0.0.info",
+			"direct-fetches/example.com/a/@v/v1.0.0.zip",
+			"direct-fetches/example.com/c/@v/list",
+		} {
+			if err := cacher.Put(t.Context(), name, strings.NewReader("")); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(string(cacher), "example.com", "a", "@v", ".v1.0.0.zip.tmp.0"), nil, 0o644); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		old := time.Now().Add(-2 * time.Hour)
+		for _, name := range []string{
+			"example.com/a/@v/v1.0.0.info",
+			"example.com/a/@v/.v1.0.0.zip.tmp.0",
+			"example.com/b/@v/v1.0.0.info",
+			"example.com/b/@v/v1.0.0.ziphash",
+			"example.com/b/@v/v1.0.0.mod.sha256",
+			"direct-fetches/example.com/a/@v/v1.0.0.zip",
+		} {
+			if err := os.Chtimes(filepath.Join(string(cacher), filepath.FromSlash(name)), old, old); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		return cacher
+	}
+	exists := func(t *testing.T, cacher goproxy.DirCacher, name string) bool {
+		_, err := os.Stat(filepath.Join(string(cacher), filepath.FromSlash(name)))
+		return err == nil
+	}
+
+	t.Run("Normal", func(t *testing.T) {
+		cacher := newCacher(t)
+		var buf bytes.Buffer
+		if err := runCacheGC(t.Context(), cacher, cacheGCOptions{minAge: time.Hour}, &buf); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for name, want := range map[string]bool{
+			"example.com/a/@v/v1.0.0.info":               true,
+			"example.com/a/@v/v1.0.0.zip":                true,
+			"example.com/a/@v/v1.0.0.zip.sha256":         true,
+			"example.com/a/@v/.v1.0.0.zip.tmp.0":         false,
+			"example.com/b/@v/v1.0.0.info":               true, // Cached alone.
+			"example.com/b/@v/v1.0.0.ziphash":            false,
+			"example.com/b/@v/v1.0.0.mod.sha256":         false,
+			"example.com/c/@v/v1.0.0.info":               true, // Too new.
+			"direct-fetches/example.com/a/@v/v1.0.0.zip": false,
+			"direct-fetches/example.com/c/@v/list":       true, // Too new.
+		} {
+			if got := exists(t, cacher, name); got != want {
+				t.Errorf("%s: got %t, want %t", name, got, want)
+			}
+		}
+		if got, want := buf.String(), "removed 4 caches\n"; !strings.HasSuffix(got, want) {
+			t.Errorf("got %q, want suffix %q", got, want)
+		}
+	})
+
+	t.Run("RemoveOrphanedInfo", func(t *testing.T) {
+		cacher := newCacher(t)
+		var buf bytes.Buffer
+		if err := runCacheGC(t.Context(), cacher, cacheGCOptions{minAge: time.Hour, removeOrphanedInfo: true}, &buf); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for name, want := range map[string]bool{
+			"example.com/a/@v/v1.0.0.info": true,
+			"example.com/b/@v/v1.0.0.info": false,
+			"example.com/c/@v/v1.0.0.info": true, // Too new.
+		} {
+			if got := exists(t, cacher, name); got != want {
+				t.Errorf("%s: got %t, want %t", name, got, want)
+			}
+		}
+		if got, want := buf.String(), "removed example.com/b/@v/v1.0.0.info (orphaned)\n"; !strings.Contains(got, want) {
+			t.Errorf("output %q does not contain %q", got, want)
+		}
+		if got, want := buf.String(), "removed 5 caches\n"; !strings.HasSuffix(got, want) {
+			t.Errorf("got %q, want suffix %q", got, want)
+		}
+	})
+
+	t.Run("Dedup", func(t *testing.T) {
+		dir := goproxy.DirCacher(t.TempDir())
+		cacher := &goproxy.CompressCacher{Cacher: &goproxy.DedupCacher{Cacher: dir}}
+		for _, content := range []string{"foo", "bar"} {
+			if err := cacher.Put(t.Context(), "example.com/@v/v1.0.0.zip", strings.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		old := time.Now().Add(-2 * time.Hour)
+		if err := filepath.WalkDir(string(dir), func(file string, de fs.DirEntry, err error) error {
+			if err != nil || de.IsDir() {
+				return err
+			}
+			return os.Chtimes(file, old, old)
+		}); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		var buf bytes.Buffer
+		if err := runCacheGC(t.Context(), cacher, cacheGCOptions{minAge: time.Hour}, &buf); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := strings.Count(buf.String(), " (unreferenced)\n"), 2; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := buf.String(), "removed 2 caches\n"; !strings.HasSuffix(got, want) {
+			t.Errorf("got %q, want suffix %q", got, want)
+		}
+		blobs, err := dir.List(t.Context(), "blobs/")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := len(blobs), 1; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		rc, err := cacher.Get(t.Context(), "example.com/@v/v1.0.0.zip")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer rc.Close()
+		if b, err := io.ReadAll(rc); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if got, want := string(b), "bar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Retention", func(t *testing.T) {
+		cacher := newCacher(t)
+		if err := runCacheGC(t.Context(), cacher, cacheGCOptions{minAge: time.Hour, retention: 90 * time.Minute}, io.Discard); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for _, name := range []string{"example.com/a/@v/v1.0.0.info", "example.com/b/@v/v1.0.0.info"} {
+			if exists(t, cacher, name) {
+				t.Errorf("%s: expired cache not removed", name)
+			}
+		}
+		if !exists(t, cacher, "example.com/a/@v/v1.0.0.zip") {
+			t.Error("unexpired cache removed")
+		}
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		cacher := newCacher(t)
+		var buf bytes.Buffer
+		if err := runCacheGC(t.Context(), struct{ goproxy.DirCacher }{cacher}, cacheGCOptions{minAge: time.Hour, dryRun: true}, &buf); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if !exists(t, cacher, "example.com/b/@v/v1.0.0.ziphash") {
+			t.Error("cache removed in dry run")
+		}
+		if got, want := buf.String(), "would remove example.com/b/@v/v1.0.0.ziphash (orphaned)\n"; !strings.Contains(got, want) {
+			t.Errorf("output %q does not contain %q", got, want)
+		}
+	})
+
+	t.Run("NotDeletable", func(t *testing.T) {
+		err := runCacheGC(t.Context(), struct{ goproxy.Cacher }{newCacher(t)}, cacheGCOptions{}, io.Discard)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if got, want := err.Error(), "cacher does not support deletion"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestRunCacheDU(t *testing.T) {
+	cacher := goproxy.DirCacher(t.TempDir())
+	for name, content := range map[string]string{
+		"example.com/!foo/@v/v1.0.0.info": "12",
+		"example.com/!foo/@v/v1.0.0.zip":  "1234",
+		"example.com/bar/@v/list":         "1",
+		"example.com/bar/@latest":         "12345678",
+		"sumdb/sum.golang.org/latest":     "123",
+		"quarantine/example.com/a":        "12",
+		"direct-fetches/example.com/a":    "1",
+	} {
+		if err := cacher.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := runCacheDU(t.Context(), cacher, &buf); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := "SIZE  FILES  MODULE\n" +
+		"9     2      example.com/bar\n" +
+		"6     2      example.com/Foo\n" +
+		"3     1      sumdb/sum.golang.org\n" +
+		"2     1      quarantine\n" +
+		"1     1      direct-fetches\n" +
+		"21    7      total\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseModuleFileName(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		wantModulePath    string
+		wantModuleVersion string
+		wantExt           string
+		wantOK            bool
+	}{
+		{"example.com/!foo/@v/v1.0.0.zip", "example.com/Foo", "v1.0.0", ".zip", true},
+		{"example.com/@v/v1.0.0.zip.sha256", "", "", "", false},
+		{"example.com/@v/v1.info", "", "", "", false},
+		{"example.com/@v/list", "", "", "", false},
+		{"sumdb/sum.golang.org/latest", "", "", "", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			modulePath, moduleVersion, ext, ok := parseModuleFileName(tt.name)
+			if got, want := ok, tt.wantOK; got != want {
+				t.Fatalf("got %t, want %t", got, want)
+			}
+			if got, want := modulePath, tt.wantModulePath; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := moduleVersion, tt.wantModuleVersion; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := ext, tt.wantExt; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCacherConfigNewCacher(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		cfg        cacherConfig
+		wantCacher goproxy.Cacher
+		wantErr    string
+	}{
+		{
+			name:       "Dir",
+			cfg:        cacherConfig{cacher: "dir", cacherDir: "caches"},
+			wantCacher: goproxy.DirCacher("caches"),
+		},
+		{
+			name:       "DirDedup",
+			cfg:        cacherConfig{cacher: "dir", cacherDir: "caches", dedup: true},
+			wantCacher: &goproxy.DedupCacher{Cacher: goproxy.DirCacher("caches")},
+		},
+		{
+			name:       "DirCompress",
+			cfg:        cacherConfig{cacher: "dir", cacherDir: "caches", compress: true},
+			wantCacher: &goproxy.CompressCacher{Cacher: goproxy.DirCacher("caches")},
+		},
+		{
+			name:       "DirCompressDedup",
+			cfg:        cacherConfig{cacher: "dir", cacherDir: "caches", compress: true, dedup: true},
+			wantCacher: &goproxy.CompressCacher{Cacher: &goproxy.DedupCacher{Cacher: goproxy.DirCacher("caches")}},
+		},
+		{
+			name:       "DirEncrypt",
+			cfg:        cacherConfig{cacher: "dir", cacherDir: "caches", encryptionKeyFile: "keys", encryptionModulePatterns: "example.com"},
+			wantCacher: &goproxy.EncryptCacher{Cacher: goproxy.DirCacher("caches"), KeyProvider: &goproxy.FileKeyProvider{File: "keys"}, ModulePatterns: "example.com"},
+		},
+		{
+			name: "S3",
+			cfg: cacherConfig{cacher: "s3", s3CacherOpts: s3CacherOptions{
+				endpoint:     "s3.amazonaws.com",
+				bucket:       "bucket",
+				keyPrefix:    "goproxy/",
+				storageClass: "STANDARD_IA",
+				partSize:     100 << 20,
+			}},
+			wantCacher: &s3cacher.Cacher{
+				Endpoint:     "s3.amazonaws.com",
+				Bucket:       "bucket",
+				KeyPrefix:    "goproxy/",
+				StorageClass: "STANDARD_IA",
+				PartSize:     100 << 20,
+				Transport:    http.DefaultTransport,
+			},
+		},
+		{
+			name: "GCS",
+			cfg: cacherConfig{cacher: "gcs", gcsCacherOpts: gcsCacherOptions{
+				endpoint:        "https://storage.googleapis.com",
+				bucket:          "bucket",
+				keyPrefix:       "goproxy/",
+				credentialsFile: "credentials.json",
+			}},
+			wantCacher: &gcscacher.Cacher{
+				Endpoint:        "https://storage.googleapis.com",
+				Bucket:          "bucket",
+				KeyPrefix:       "goproxy/",
+				CredentialsFile: "credentials.json",
+				Transport:       http.DefaultTransport,
+			},
+		},
+		{
+			name: "Azblob",
+			cfg: cacherConfig{cacher: "azblob", azblobCacherOpts: azblobCacherOptions{
+				accountName: "account",
+				accountKey:  "a2V5",
+				container:   "container",
+				keyPrefix:   "goproxy/",
+			}},
+			wantCacher: &azblobcacher.Cacher{
+				AccountName: "account",
+				AccountKey:  "a2V5",
+				Container:   "container",
+				KeyPrefix:   "goproxy/",
+				Transport:   http.DefaultTransport,
+			},
+		},
+		{
+			name: "DirRedis",
+			cfg: cacherConfig{cacher: "dir", cacherDir: "caches", redisCacherOpts: redisCacherOptions{
+				addr:      "localhost:6379",
+				keyPrefix: "goproxy:",
+				ttl:       time.Hour,
+				maxSize:   1 << 20,
+			}},
+			wantCacher: &rediscacher.Cacher{
+				Addr:      "localhost:6379",
+				KeyPrefix: "goproxy:",
+				TTL:       time.Hour,
+				MaxSize:   1 << 20,
+				Fallback:  goproxy.DirCacher("caches"),
+			},
+		},
+		{
+			name: "S3Dedup",
+			cfg:  cacherConfig{cacher: "s3", dedup: true, s3CacherOpts: s3CacherOptions{bucket: "bucket"}},
+			wantCacher: &goproxy.DedupCacher{Cacher: &s3cacher.Cacher{
+				Bucket:    "bucket",
+				Transport: http.DefaultTransport,
+			}},
+		},
+		{
+			name:    "Invalid",
+			cfg:     cacherConfig{cacher: "foo"},
+			wantErr: `invalid --cacher: "foo"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cacher, err := tt.cfg.newCacher(http.DefaultTransport)
+			if tt.wantErr != "" {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if got, want := err.Error(), tt.wantErr; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got, want := cacher, tt.wantCacher; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestCacherConfigNewCacherSQLite(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := cacherConfig{
+		cacher:        "sqlite",
+		sqliteFile:    filepath.Join(tempDir, "caches.db"),
+		sqliteBlobDir: filepath.Join(tempDir, "blobs"),
+	}
+	cacher, err := cfg.newCacher(http.DefaultTransport)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sc, ok := cacher.(*sqlcacher.Cacher)
+	if !ok {
+		t.Fatalf("got %T, want *sqlcacher.Cacher", cacher)
+	}
+	defer sc.DB.Close()
+	if got, want := sc.BlobCacher, goproxy.Cacher(goproxy.DirCacher(cfg.sqliteBlobDir)); got != want {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	if err := cacher.Put(t.Context(), "example.com/@v/v1.0.0.info", strings.NewReader("{}")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	rc, err := cacher.Get(t.Context(), "example.com/@v/v1.0.0.info")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer rc.Close()
+	if b, err := io.ReadAll(rc); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if got, want := string(b), "{}"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.sqliteBlobDir, "example.com", "@v", "v1.0.0.info")); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -18,5 +18,6 @@ A minimalist Go module proxy handler.
 	}
 	cmd.SetHelpCommand(&cobra.Command{Hidden: true})
 	cmd.AddCommand(newServerCmd())
+	cmd.AddCommand(newCacheCmd())
 	return cmd
 }
@@ -16,6 +16,8 @@ import (
 	"time"
 
 	"github.com/goproxy/goproxy"
+	"github.com/goproxy/goproxy/rediscacher"
+	"github.com/goproxy/goproxy/sqlcacher"
 	"github.com/spf13/cobra"
 )
 
@@ -49,16 +51,48 @@ type serverCmdConfig struct {
 	pathPrefix                 string
 	goBin                      string
 	maxConcurrentDirectFetches int
+	directFetchLocker          string
+	directFetchLockDir         string
 	proxiedSumDBs              []string
-	cacher                     string
-	cacherDir                  string
-	s3CacherOpts               s3CacherOptions
-	tempDir                    string
-	insecure                   bool
-	connectTimeout             time.Duration
-	fetchTimeout               time.Duration
-	shutdownTimeout            time.Duration
-	logFormat                  string
+	clusterSelf                string
+	clusterPeers               []string
+	clusterPeersDNS            string
+	clusterSecret              string
+	cacherConfig
+	tempDir            string
+	streamDownloads    bool
+	serveEnrichedList  bool
+	zipHashes          bool
+	verifyCachedZips   bool
+	cacheDigests       bool
+	redirectCachedZips bool
+	cacheScrubInterval time.Duration
+	hotModules         int
+	hotModulesInterval time.Duration
+	prefetchVersions   bool
+	insecure           bool
+	connectTimeout     time.Duration
+	fetchTimeout       time.Duration
+	retryMaxAttempts   int
+	retryBackoffBase   time.Duration
+	retryBackoffCap    time.Duration
+	retryJitter        bool
+	retryStatuses      []int
+	retryAfter         bool
+	attemptTimeout     time.Duration
+	breakerThreshold   int
+	breakerOpenTime    time.Duration
+	hedgeDelay         time.Duration
+	shutdownTimeout    time.Duration
+	logFormat          string
+	accessLog          bool
+	metadataAPI        bool
+	adminAPI           bool
+	webUI              bool
+	webUISumDBURL      string
+	webhookURLs        []string
+	webhookQueueDir    string
+	webhookMaxAttempts int
 }
 
 // newServerCmdConfig creates a new [serverCmdConfig].
@@ -71,23 +105,48 @@ func newServerCmdConfig(cmd *cobra.Command) *serverCmdConfig {
 	fs.StringVar(&cfg.pathPrefix, "path-prefix", "", "prefix for all request paths")
 	fs.StringVar(&cfg.goBin, "go-bin", "go", "path to the Go binary that is used to execute direct fetches")
 	fs.IntVar(&cfg.maxConcurrentDirectFetches, "max-concurrent-direct-fetches", 0, "maximum number (0 means no limit) of concurrent direct fetches")
+	fs.StringVar(&cfg.directFetchLocker, "direct-fetch-locker", "", "locker used to ensure that only one replica at a time performs the same direct fetch, with the others reading its result from the cacher (valid values: dir, redis, sqlite; empty means disabled)")
+	fs.StringVar(&cfg.directFetchLockDir, "direct-fetch-lock-dir", "direct-fetch-locks", "directory for the lock files of the dir direct fetch locker (may be on a file system shared by replicas)")
 	fs.StringSliceVar(&cfg.proxiedSumDBs, "proxied-sumdbs", nil, "list of proxied checksum databases")
-	fs.StringVar(&cfg.cacher, "cacher", "dir", "cacher to use (valid values: dir, s3)")
-	fs.StringVar(&cfg.cacherDir, "cacher-dir", "caches", "directory for the dir cacher")
-	fs.StringVar(&cfg.s3CacherOpts.accessKeyID, "cacher-s3-access-key-id", "", "access key ID for the S3 cacher")
-	fs.StringVar(&cfg.s3CacherOpts.secretAccessKey, "cacher-s3-secret-access-key", "", "secret access key for the S3 cacher")
-	fs.StringVar(&cfg.s3CacherOpts.endpoint, "cacher-s3-endpoint", "s3.amazonaws.com", "endpoint for the S3 cacher")
-	fs.BoolVar(&cfg.s3CacherOpts.disableTLS, "cacher-s3-disable-tls", false, "disable TLS for the S3 cacher")
-	fs.StringVar(&cfg.s3CacherOpts.region, "cacher-s3-region", "us-east-1", "region for the S3 cacher")
-	fs.StringVar(&cfg.s3CacherOpts.bucket, "cacher-s3-bucket", "", "bucket name for the S3 cacher")
-	fs.BoolVar(&cfg.s3CacherOpts.forcePathStyle, "cacher-s3-force-path-style", false, "force path-style addressing for the S3 cacher")
-	fs.Int64Var(&cfg.s3CacherOpts.partSize, "cacher-s3-part-size", 100<<20, "multipart upload part size for the S3 cacher")
+	fs.StringVar(&cfg.clusterSelf, "cluster-self", "", "base URL of this replica as seen by its peers in cluster mode (such as http://10.0.0.1:8080)")
+	fs.StringSliceVar(&cfg.clusterPeers, "cluster-peers", nil, "list of base URLs of the replicas to route fetches to in cluster mode")
+	fs.StringVar(&cfg.clusterPeersDNS, "cluster-peers-dns", "", "URL whose host is resolved to discover replicas in cluster mode (such as http://goproxy.default.svc:8080)")
+	fs.StringVar(&cfg.clusterSecret, "cluster-secret", "", "secret shared by the replicas in cluster mode to authenticate forwarded fetches (empty means forwarded fetches are accepted only from the IP addresses of known replicas)")
+	cfg.cacherConfig.bindFlags(fs)
 	fs.StringVar(&cfg.tempDir, "temp-dir", os.TempDir(), "directory for storing temporary files")
+	fs.BoolVar(&cfg.streamDownloads, "stream-downloads", false, "stream module zip files to clients while they are being fetched")
+	fs.BoolVar(&cfg.serveEnrichedList, "serve-enriched-list", false, "serve a JSON version list with cached pseudo-versions and retractions at <module>/@v/list.json")
+	fs.BoolVar(&cfg.zipHashes, "zip-hashes", false, "store the hash of each cached module zip file and serve go.sum lines at <module>/@v/<version>.sum")
+	fs.BoolVar(&cfg.verifyCachedZips, "verify-cached-zips", false, "verify cached module zip files against their stored hashes before serving them")
+	fs.BoolVar(&cfg.cacheDigests, "cache-digests", false, "store the digest of each cached content and quarantine cached content that does not match it")
+	fs.BoolVar(&cfg.redirectCachedZips, "redirect-cached-zips", false, "redirect requests for cached module zip files to the cacher (such as presigned URLs of the S3 cacher) instead of serving them")
+	fs.DurationVar(&cfg.cacheScrubInterval, "cache-scrub-interval", 0, "interval (0 means never) between background verifications of all cached content (requires --cache-digests)")
+	fs.IntVar(&cfg.hotModules, "hot-modules", 0, "number of most frequently requested modules whose version lists are refreshed in the background (0 means none)")
+	fs.DurationVar(&cfg.hotModulesInterval, "hot-modules-refresh-interval", 5*time.Minute, "interval between background refreshes of the version lists of hot modules")
+	fs.BoolVar(&cfg.prefetchVersions, "prefetch-new-versions", false, "fetch newly released versions of hot modules during background refreshes")
 	fs.BoolVar(&cfg.insecure, "insecure", false, "allow insecure TLS connections")
 	fs.DurationVar(&cfg.connectTimeout, "connect-timeout", 30*time.Second, "maximum amount of time (0 means no limit) will wait for an outgoing connection to establish")
 	fs.DurationVar(&cfg.fetchTimeout, "fetch-timeout", 10*time.Minute, "maximum amount of time (0 means no limit) will wait for a fetch to complete")
+	fs.IntVar(&cfg.retryMaxAttempts, "retry-max-attempts", 10, "maximum number of attempts of each outgoing HTTP request (1 means no retries)")
+	fs.DurationVar(&cfg.retryBackoffBase, "retry-backoff-base", 100*time.Millisecond, "base delay of the exponential backoff between attempts of outgoing HTTP requests")
+	fs.DurationVar(&cfg.retryBackoffCap, "retry-backoff-cap", time.Second, "maximum delay of the exponential backoff between attempts of outgoing HTTP requests")
+	fs.BoolVar(&cfg.retryJitter, "retry-jitter", true, "randomize the backoff delay between attempts of outgoing HTTP requests")
+	fs.IntSliceVar(&cfg.retryStatuses, "retry-statuses", []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}, "list of response statuses after which outgoing HTTP requests are retried")
+	fs.BoolVar(&cfg.retryAfter, "retry-honor-retry-after", false, "wait for the delay (up to 1m) specified by the Retry-After response header before retrying outgoing HTTP requests")
+	fs.DurationVar(&cfg.attemptTimeout, "retry-attempt-timeout", 0, "maximum amount of time (0 means no limit) each attempt of an outgoing HTTP request will wait for the response headers")
+	fs.IntVar(&cfg.breakerThreshold, "circuit-breaker-threshold", 0, "number of consecutive failures (0 means never) after which an upstream proxy followed by a \"|\" in GOPROXY is skipped")
+	fs.DurationVar(&cfg.breakerOpenTime, "circuit-breaker-open-duration", 30*time.Second, "amount of time a skipped upstream proxy stays skipped before being probed again")
+	fs.DurationVar(&cfg.hedgeDelay, "hedge-delay", 0, "delay (0 means never) after which a fetch from an upstream proxy followed by a \"|\" in GOPROXY is raced with the next one")
 	fs.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 10*time.Second, "maximum amount of time (0 means no limit) will wait for the server to shutdown")
 	fs.StringVar(&cfg.logFormat, "log-format", "text", "log format to use (valid values: text, json)")
+	fs.BoolVar(&cfg.accessLog, "access-log", false, "log an access record for each request in the format specified by --log-format")
+	fs.BoolVar(&cfg.metadataAPI, "metadata-api", false, "serve a read-only JSON API for the metadata of cached modules under /api/")
+	fs.BoolVar(&cfg.adminAPI, "admin-api", false, "serve the health of upstream proxies as JSON at /admin/upstreams and as Prometheus metrics at /admin/metrics")
+	fs.BoolVar(&cfg.webUI, "web-ui", false, "serve an HTML interface for browsing cached modules under /ui/")
+	fs.StringVar(&cfg.webUISumDBURL, "web-ui-sumdb-url", "https://sum.golang.org", "checksum database URL linked by the web UI (empty means no link)")
+	fs.StringSliceVar(&cfg.webhookURLs, "webhook-urls", nil, "list of URLs to notify when new module versions are cached")
+	fs.StringVar(&cfg.webhookQueueDir, "webhook-queue-dir", "webhook-queue", "directory for persisting pending webhook notifications")
+	fs.IntVar(&cfg.webhookMaxAttempts, "webhook-max-attempts", 10, "maximum number of attempts to deliver a webhook notification")
 	return cfg
 }
 
@@ -97,31 +156,56 @@ func runServerCmd(cmd *cobra.Command, args []string, cfg *serverCmdConfig) error
 	transport.DialContext = (&net.Dialer{Timeout: cfg.connectTimeout, KeepAlive: 30 * time.Second}).DialContext
 	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: cfg.insecure}
 	transport.RegisterProtocol("file", http.NewFileTransport(httpDirFS{}))
+	gf := &goproxy.GoFetcher{
+		GoBin:                      cfg.goBin,
+		MaxConcurrentDirectFetches: cfg.maxConcurrentDirectFetches,
+		TempDir:                    cfg.tempDir,
+		Transport:                  transport,
+		RetryPolicy:                cfg.retryPolicy(),
+		CircuitBreakerThreshold:    cfg.breakerThreshold,
+		CircuitBreakerOpenDuration: cfg.breakerOpenTime,
+		HedgeDelay:                 cfg.hedgeDelay,
+	}
 	g := &goproxy.Goproxy{
-		Fetcher: &goproxy.GoFetcher{
-			GoBin:                      cfg.goBin,
-			MaxConcurrentDirectFetches: cfg.maxConcurrentDirectFetches,
-			TempDir:                    cfg.tempDir,
-			Transport:                  transport,
-		},
-		ProxiedSumDBs: cfg.proxiedSumDBs,
-		TempDir:       cfg.tempDir,
-		Transport:     transport,
-	}
-
-	switch cfg.cacher {
-	case "dir":
-		g.Cacher = goproxy.DirCacher(cfg.cacherDir)
-	case "s3":
-		s3CacherOpts := cfg.s3CacherOpts
-		s3CacherOpts.transport = transport
-		s3c, err := newS3Cacher(s3CacherOpts)
-		if err != nil {
-			return err
+		Fetcher:             gf,
+		ProxiedSumDBs:       cfg.proxiedSumDBs,
+		TempDir:             cfg.tempDir,
+		Transport:           transport,
+		RetryPolicy:         gf.RetryPolicy,
+		StreamDownloads:     cfg.streamDownloads,
+		ServeEnrichedList:   cfg.serveEnrichedList,
+		ZipHashes:           cfg.zipHashes,
+		VerifyCachedZips:    cfg.verifyCachedZips,
+		CacheDigests:        cfg.cacheDigests,
+		RedirectCachedZips:  cfg.redirectCachedZips,
+		HotModules:          cfg.hotModules,
+		PrefetchNewVersions: cfg.prefetchVersions,
+	}
+	if cfg.clusterSelf != "" || len(cfg.clusterPeers) > 0 || cfg.clusterPeersDNS != "" {
+		g.Fetcher = &goproxy.ClusterFetcher{
+			Fetcher:   g.Fetcher,
+			Self:      cfg.clusterSelf,
+			Peers:     cfg.clusterPeers,
+			PeersDNS:  cfg.clusterPeersDNS,
+			Secret:    cfg.clusterSecret,
+			TempDir:   cfg.tempDir,
+			Transport: transport,
 		}
-		g.Cacher = s3c
-	default:
-		return fmt.Errorf("invalid --cacher: %q", cfg.cacher)
+	}
+
+	cacher, err := cfg.newCacher(transport)
+	if err != nil {
+		return err
+	}
+	g.Cacher = cacher
+
+	locker, err := cfg.newDirectFetchLocker()
+	if err != nil {
+		return err
+	}
+	if locker != nil {
+		gf.DirectFetchLocker = locker
+		gf.DirectFetchCacher = cacher
 	}
 
 	var logHandler slog.Handler
@@ -134,8 +218,85 @@ func runServerCmd(cmd *cobra.Command, args []string, cfg *serverCmdConfig) error
 		return fmt.Errorf("invalid --log-format: %q", cfg.logFormat)
 	}
 	g.Logger = slog.New(logHandler)
+	if cfg.accessLog {
+		g.AccessLogger = g.Logger
+	}
+
+	var onFetches []func(goproxy.FetchEvent)
+	mounts := map[string]http.Handler{}
+	if cfg.metadataAPI {
+		metadataAPI := &goproxy.MetadataAPI{Goproxy: g}
+		onFetches = append(onFetches, metadataAPI.RecordFetch)
+		mounts["/api"] = metadataAPI
+	}
+	if cfg.adminAPI {
+		mounts["/admin"] = &adminAPI{upstreamHealth: gf.UpstreamHealth}
+	}
+	if cfg.webUI {
+		mounts["/ui"] = &webUI{
+			cacher:   g.Cacher,
+			basePath: cfg.pathPrefix + "/ui",
+			sumdbURL: cfg.webUISumDBURL,
+			tempDir:  cfg.tempDir,
+			logger:   g.Logger,
+		}
+	}
+
+	if len(cfg.webhookURLs) > 0 {
+		wn, err := newWebhookNotifier(cfg.webhookURLs, cfg.webhookQueueDir, g.CachedZipHash, transport, cfg.webhookMaxAttempts, g.Logger)
+		if err != nil {
+			return err
+		}
+		onFetches = append(onFetches, wn.notify)
+		webhookCtx, cancelWebhook := context.WithCancel(cmd.Context())
+		defer cancelWebhook()
+		go wn.run(webhookCtx)
+	}
+	if cfg.cacheDigests && cfg.cacheScrubInterval > 0 {
+		scrubCtx, cancelScrub := context.WithCancel(cmd.Context())
+		defer cancelScrub()
+		go func() {
+			ticker := time.NewTicker(cfg.cacheScrubInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-scrubCtx.Done():
+					return
+				case <-ticker.C:
+				}
+				if err := g.ScrubCache(scrubCtx); err != nil && scrubCtx.Err() == nil {
+					g.Logger.Error("failed to scrub cache", "error", err)
+				}
+			}
+		}()
+	}
+	if cfg.hotModules > 0 && cfg.hotModulesInterval > 0 {
+		refreshCtx, cancelRefresh := context.WithCancel(cmd.Context())
+		defer cancelRefresh()
+		go func() {
+			ticker := time.NewTicker(cfg.hotModulesInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-refreshCtx.Done():
+					return
+				case <-ticker.C:
+				}
+				if err := g.RefreshHotModules(refreshCtx); err != nil && refreshCtx.Err() == nil {
+					g.Logger.Error("failed to refresh hot modules", "error", err)
+				}
+			}
+		}()
+	}
+	if len(onFetches) > 0 {
+		g.OnFetch = func(fe goproxy.FetchEvent) {
+			for _, onFetch := range onFetches {
+				onFetch(fe)
+			}
+		}
+	}
 
-	handler := newServerHandler(cfg, g)
+	handler := newServerHandler(cfg, g, mounts)
 
 	server := &http.Server{
 		Addr:        cfg.address,
@@ -176,11 +337,57 @@ func runServerCmd(cmd *cobra.Command, args []string, cfg *serverCmdConfig) error
 	return shutdownErr
 }
 
+// retryPolicy returns the [goproxy.RetryPolicy] for outgoing HTTP requests
+// based on the cfg.
+func (cfg *serverCmdConfig) retryPolicy() *goproxy.RetryPolicy {
+	return &goproxy.RetryPolicy{
+		MaxAttempts:       max(cfg.retryMaxAttempts, 1),
+		BackoffBase:       cfg.retryBackoffBase,
+		BackoffCap:        cfg.retryBackoffCap,
+		DisableJitter:     !cfg.retryJitter,
+		RetryableStatuses: append([]int{}, cfg.retryStatuses...),
+		HonorRetryAfter:   cfg.retryAfter,
+		AttemptTimeout:    cfg.attemptTimeout,
+	}
+}
+
+// newDirectFetchLocker creates a new [goproxy.Locker] for direct fetches based
+// on the cfg. It returns nil if direct fetches are not locked.
+func (cfg *serverCmdConfig) newDirectFetchLocker() (goproxy.Locker, error) {
+	switch cfg.directFetchLocker {
+	case "":
+		return nil, nil
+	case "dir":
+		return goproxy.DirLocker(cfg.directFetchLockDir), nil
+	case "redis":
+		if cfg.redisCacherOpts.addr == "" {
+			return nil, errors.New("--direct-fetch-locker redis requires --cacher-redis-addr")
+		}
+		locker := &rediscacher.Locker{Cacher: cfg.newRedisCacher(nil), KeyPrefix: "goproxy-lock:"}
+		if keyPrefix := cfg.redisCacherOpts.keyPrefix; strings.HasPrefix(keyPrefix, locker.KeyPrefix) || strings.HasPrefix(locker.KeyPrefix, keyPrefix) {
+			return nil, fmt.Errorf("--direct-fetch-locker redis requires a --cacher-redis-key-prefix that does not overlap with %q", locker.KeyPrefix)
+		}
+		return locker, nil
+	case "sqlite":
+		sc, err := cfg.newSQLiteCacher()
+		if err != nil {
+			return nil, err
+		}
+		return &sqlcacher.Locker{Cacher: sc}, nil
+	}
+	return nil, fmt.Errorf("invalid --direct-fetch-locker: %q", cfg.directFetchLocker)
+}
+
 // newServerHandler creates a new [http.Handler] used by the server command.
-func newServerHandler(cfg *serverCmdConfig, base http.Handler) http.Handler {
+// Each handler in the mounts is served under its path prefix, with the prefix
+// stripped.
+func newServerHandler(cfg *serverCmdConfig, base http.Handler, mounts map[string]http.Handler) http.Handler {
 	mux := http.NewServeMux()
 	mux.Handle("/", base)
 	mux.HandleFunc("GET /healthz", func(rw http.ResponseWriter, _ *http.Request) { rw.WriteHeader(http.StatusNoContent) })
+	for prefix, h := range mounts {
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, h))
+	}
 
 	handler := http.Handler(mux)
 	if cfg.pathPrefix != "" {
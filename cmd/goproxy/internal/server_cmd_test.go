@@ -1,10 +1,18 @@
 package internal
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
+
+	"github.com/goproxy/goproxy"
+	"github.com/goproxy/goproxy/rediscacher"
+	"github.com/goproxy/goproxy/sqlcacher"
+	"github.com/spf13/cobra"
 )
 
 func TestNewServerHandler(t *testing.T) {
@@ -12,6 +20,7 @@ func TestNewServerHandler(t *testing.T) {
 		name              string
 		cfg               serverCmdConfig
 		base              http.Handler
+		mounts            map[string]http.Handler
 		method            string
 		path              string
 		wantStatusCode    int
@@ -48,6 +57,22 @@ func TestNewServerHandler(t *testing.T) {
 			wantStatusCode:  http.StatusTeapot,
 			wantHandledPath: "/anything",
 		},
+		{
+			name: "Mount",
+			mounts: map[string]http.Handler{"/api": http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusAccepted)
+				rw.Write([]byte(req.URL.Path))
+			})},
+			path:              "/api/recent",
+			wantStatusCode:    http.StatusAccepted,
+			wantContentLength: int64(len("/recent")),
+		},
+		{
+			name:            "NoMount",
+			path:            "/api/recent",
+			wantStatusCode:  http.StatusTeapot,
+			wantHandledPath: "/api/recent",
+		},
 		{
 			name: "FetchTimeout",
 			cfg:  serverCmdConfig{fetchTimeout: 20 * time.Millisecond},
@@ -69,7 +94,7 @@ func TestNewServerHandler(t *testing.T) {
 				} else {
 					rw.WriteHeader(http.StatusTeapot)
 				}
-			}))
+			}), tt.mounts)
 
 			req := httptest.NewRequest(tt.method, "https://example.com"+tt.path, nil)
 			rec := httptest.NewRecorder()
@@ -87,3 +112,165 @@ func TestNewServerHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestServerCmdConfigNewDirectFetchLocker(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		cfg        serverCmdConfig
+		wantLocker func(t *testing.T, locker goproxy.Locker)
+		wantErr    error
+	}{
+		{
+			name: "Disabled",
+			wantLocker: func(t *testing.T, locker goproxy.Locker) {
+				if locker != nil {
+					t.Errorf("got %v, want nil", locker)
+				}
+			},
+		},
+		{
+			name: "Dir",
+			cfg:  serverCmdConfig{directFetchLocker: "dir", directFetchLockDir: "locks"},
+			wantLocker: func(t *testing.T, locker goproxy.Locker) {
+				if got, want := locker, goproxy.Locker(goproxy.DirLocker("locks")); got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			},
+		},
+		{
+			name: "Redis",
+			cfg: serverCmdConfig{directFetchLocker: "redis", cacherConfig: cacherConfig{redisCacherOpts: redisCacherOptions{
+				addr:      "localhost:6379",
+				password:  "secret",
+				keyPrefix: "goproxy:",
+			}}},
+			wantLocker: func(t *testing.T, locker goproxy.Locker) {
+				rl, ok := locker.(*rediscacher.Locker)
+				if !ok {
+					t.Fatalf("got %T, want *rediscacher.Locker", locker)
+				}
+				if got, want := rl.Cacher.Addr, "localhost:6379"; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+				if got, want := rl.Cacher.Password, "secret"; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+				if got, want := rl.KeyPrefix, "goproxy-lock:"; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			},
+		},
+		{
+			name:    "RedisEmptyKeyPrefix",
+			cfg:     serverCmdConfig{directFetchLocker: "redis", cacherConfig: cacherConfig{redisCacherOpts: redisCacherOptions{addr: "localhost:6379"}}},
+			wantErr: errors.New(`--direct-fetch-locker redis requires a --cacher-redis-key-prefix that does not overlap with "goproxy-lock:"`),
+		},
+		{
+			name:    "RedisOverlappingKeyPrefix",
+			cfg:     serverCmdConfig{directFetchLocker: "redis", cacherConfig: cacherConfig{redisCacherOpts: redisCacherOptions{addr: "localhost:6379", keyPrefix: "goproxy-lock:caches:"}}},
+			wantErr: errors.New(`--direct-fetch-locker redis requires a --cacher-redis-key-prefix that does not overlap with "goproxy-lock:"`),
+		},
+		{
+			name:    "RedisWithoutAddr",
+			cfg:     serverCmdConfig{directFetchLocker: "redis"},
+			wantErr: errors.New("--direct-fetch-locker redis requires --cacher-redis-addr"),
+		},
+		{
+			name: "SQLite",
+			cfg:  serverCmdConfig{directFetchLocker: "sqlite", cacherConfig: cacherConfig{sqliteFile: filepath.Join(t.TempDir(), "caches.db")}},
+			wantLocker: func(t *testing.T, locker goproxy.Locker) {
+				sl, ok := locker.(*sqlcacher.Locker)
+				if !ok {
+					t.Fatalf("got %T, want *sqlcacher.Locker", locker)
+				}
+				unlock, err := sl.Lock(t.Context(), "example.com/@v/list")
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				unlock()
+			},
+		},
+		{
+			name:    "Invalid",
+			cfg:     serverCmdConfig{directFetchLocker: "foobar"},
+			wantErr: errors.New(`invalid --direct-fetch-locker: "foobar"`),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			locker, err := tt.cfg.newDirectFetchLocker()
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if got, want := err.Error(), tt.wantErr.Error(); got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				tt.wantLocker(t, locker)
+			}
+		})
+	}
+}
+
+func TestServerCmdConfigRetryPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		name            string
+		args            []string
+		wantRetryPolicy *goproxy.RetryPolicy
+	}{
+		{
+			name: "Defaults",
+			wantRetryPolicy: &goproxy.RetryPolicy{
+				MaxAttempts:       10,
+				BackoffBase:       100 * time.Millisecond,
+				BackoffCap:        time.Second,
+				RetryableStatuses: []int{429, 500, 502, 503, 504},
+			},
+		},
+		{
+			name: "Custom",
+			args: []string{
+				"--retry-max-attempts", "3",
+				"--retry-backoff-base", "1s",
+				"--retry-backoff-cap", "10s",
+				"--retry-jitter=false",
+				"--retry-statuses", "503,504",
+				"--retry-honor-retry-after",
+				"--retry-attempt-timeout", "30s",
+			},
+			wantRetryPolicy: &goproxy.RetryPolicy{
+				MaxAttempts:       3,
+				BackoffBase:       time.Second,
+				BackoffCap:        10 * time.Second,
+				DisableJitter:     true,
+				RetryableStatuses: []int{503, 504},
+				HonorRetryAfter:   true,
+				AttemptTimeout:    30 * time.Second,
+			},
+		},
+		{
+			name: "NoRetries",
+			args: []string{"--retry-max-attempts", "0"},
+			wantRetryPolicy: &goproxy.RetryPolicy{
+				MaxAttempts:       1,
+				BackoffBase:       100 * time.Millisecond,
+				BackoffCap:        time.Second,
+				RetryableStatuses: []int{429, 500, 502, 503, 504},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			cfg := newServerCmdConfig(cmd)
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got, want := cfg.retryPolicy(), tt.wantRetryPolicy; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
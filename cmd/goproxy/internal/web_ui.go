@@ -0,0 +1,318 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/goproxy/goproxy"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// webUITemplatesFS is the file system of the templates used by [webUI].
+//
+//go:embed web_ui/*.html
+var webUITemplatesFS embed.FS
+
+// webUITemplates is the parsed [webUITemplatesFS].
+var webUITemplates = template.Must(template.ParseFS(webUITemplatesFS, "web_ui/*.html"))
+
+// webUI is an [http.Handler] that serves an HTML interface for browsing the
+// modules cached by a [github.com/goproxy/goproxy.Cacher].
+//
+// Listing modules and versions requires the cacher to implement the List
+// method documented in [github.com/goproxy/goproxy.Cacher].
+type webUI struct {
+	cacher   goproxy.Cacher
+	basePath string
+	sumdbURL string
+	tempDir  string
+	logger   *slog.Logger
+}
+
+// webUIModule is a module listed by [webUI].
+type webUIModule struct {
+	Path string
+	URL  string
+}
+
+// webUIVersion is a module version listed by [webUI].
+type webUIVersion struct {
+	Version string
+	URL     string
+	Cached  []string
+}
+
+// webUIZipFile is a file in a module zip file shown by [webUI].
+type webUIZipFile struct {
+	Name string
+	Size uint64
+}
+
+// ServeHTTP implements [http.Handler].
+func (ui *webUI) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.URL.Path == "/" {
+		ui.serveIndex(rw, req)
+		return
+	}
+	target, ok := strings.CutPrefix(req.URL.Path, "/modules/")
+	if !ok || path.Clean(req.URL.Path) != req.URL.Path {
+		http.NotFound(rw, req)
+		return
+	}
+	escapedModulePath, escapedModuleVersion, hasVersion := strings.Cut(target, "/@v/")
+	modulePath, err := module.UnescapePath(escapedModulePath)
+	if err != nil {
+		http.NotFound(rw, req)
+		return
+	}
+	if !hasVersion {
+		ui.serveModule(rw, req, modulePath)
+		return
+	}
+	moduleVersion, err := module.UnescapeVersion(escapedModuleVersion)
+	if err != nil || module.Check(modulePath, moduleVersion) != nil || moduleVersion != module.CanonicalVersion(moduleVersion) {
+		http.NotFound(rw, req)
+		return
+	}
+	ui.serveVersion(rw, req, modulePath, moduleVersion)
+}
+
+// serveIndex serves the list of cached modules.
+func (ui *webUI) serveIndex(rw http.ResponseWriter, req *http.Request) {
+	names, listable, err := ui.list(req.Context(), "")
+	if err != nil {
+		ui.logger.Error("failed to list caches", "error", err)
+		http.Error(rw, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	seen := map[string]bool{}
+	var modules []webUIModule
+	for _, name := range names {
+		if strings.HasPrefix(name, "sumdb/") || strings.HasPrefix(name, "quarantine/") || strings.HasPrefix(name, "direct-fetches/") {
+			continue
+		}
+		escapedModulePath, file, ok := strings.Cut(name, "/@v/")
+		if !ok || seen[escapedModulePath] {
+			continue
+		}
+		switch path.Ext(file) {
+		case ".info", ".mod", ".zip":
+		default:
+			continue
+		}
+		modulePath, err := module.UnescapePath(escapedModulePath)
+		if err != nil {
+			continue
+		}
+		seen[escapedModulePath] = true
+		modules = append(modules, webUIModule{Path: modulePath, URL: ui.basePath + "/modules/" + escapedModulePath})
+	}
+	slices.SortFunc(modules, func(a, b webUIModule) int { return strings.Compare(a.Path, b.Path) })
+	ui.render(rw, "index.html", map[string]any{
+		"BasePath": ui.basePath,
+		"Listable": listable,
+		"Modules":  modules,
+	})
+}
+
+// serveModule serves the list of cached versions of the module.
+func (ui *webUI) serveModule(rw http.ResponseWriter, req *http.Request, modulePath string) {
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		http.NotFound(rw, req)
+		return
+	}
+	prefix := escapedModulePath + "/@v/"
+	names, listable, err := ui.list(req.Context(), prefix)
+	if err != nil {
+		ui.logger.Error("failed to list caches", "error", err, "module_path", modulePath)
+		http.Error(rw, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	var versions []webUIVersion
+	for _, name := range names {
+		file := strings.TrimPrefix(name, prefix)
+		ext := path.Ext(file)
+		switch ext {
+		case ".info", ".mod", ".zip":
+		default:
+			continue
+		}
+		escapedModuleVersion := strings.TrimSuffix(file, ext)
+		moduleVersion, err := module.UnescapeVersion(escapedModuleVersion)
+		if err != nil || !semver.IsValid(moduleVersion) {
+			continue
+		}
+		if i := slices.IndexFunc(versions, func(v webUIVersion) bool { return v.Version == moduleVersion }); i >= 0 {
+			versions[i].Cached = append(versions[i].Cached, ext)
+			continue
+		}
+		versions = append(versions, webUIVersion{
+			Version: moduleVersion,
+			URL:     ui.basePath + "/modules/" + prefix + escapedModuleVersion,
+			Cached:  []string{ext},
+		})
+	}
+	if listable && len(versions) == 0 {
+		http.NotFound(rw, req)
+		return
+	}
+	slices.SortFunc(versions, func(a, b webUIVersion) int { return semver.Compare(b.Version, a.Version) })
+	ui.render(rw, "module.html", map[string]any{
+		"BasePath": ui.basePath,
+		"Listable": listable,
+		"Path":     modulePath,
+		"Versions": versions,
+	})
+}
+
+// serveVersion serves the details of the cached module version.
+func (ui *webUI) serveVersion(rw http.ResponseWriter, req *http.Request, modulePath, moduleVersion string) {
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		http.NotFound(rw, req)
+		return
+	}
+	escapedModuleVersion, err := module.EscapeVersion(moduleVersion)
+	if err != nil {
+		http.NotFound(rw, req)
+		return
+	}
+	nameWithoutExt := escapedModulePath + "/@v/" + escapedModuleVersion
+
+	var goMod string
+	hasGoMod := false
+	if rc, err := ui.cacher.Get(req.Context(), nameWithoutExt+".mod"); err == nil {
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			ui.logger.Error("failed to read cached mod file", "error", err, "module_path", modulePath, "module_version", moduleVersion)
+			http.Error(rw, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		goMod, hasGoMod = string(b), true
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		ui.logger.Error("failed to get cached mod file", "error", err, "module_path", modulePath, "module_version", moduleVersion)
+		http.Error(rw, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	zipFiles, hasZip, err := ui.zipFiles(req.Context(), nameWithoutExt+".zip")
+	if err != nil {
+		ui.logger.Error("failed to read cached zip file", "error", err, "module_path", modulePath, "module_version", moduleVersion)
+		http.Error(rw, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !hasGoMod && !hasZip {
+		http.NotFound(rw, req)
+		return
+	}
+
+	var sumdbLookupURL string
+	if ui.sumdbURL != "" {
+		sumdbLookupURL = strings.TrimSuffix(ui.sumdbURL, "/") + "/lookup/" + escapedModulePath + "@" + escapedModuleVersion
+	}
+	ui.render(rw, "version.html", map[string]any{
+		"BasePath":       ui.basePath,
+		"Path":           modulePath,
+		"ModuleURL":      ui.basePath + "/modules/" + escapedModulePath,
+		"Version":        moduleVersion,
+		"HasGoMod":       hasGoMod,
+		"GoMod":          goMod,
+		"HasZip":         hasZip,
+		"ZipFiles":       zipFiles,
+		"SumDBLookupURL": sumdbLookupURL,
+	})
+}
+
+// list lists the names of all caches that start with the prefix. It returns
+// false if the ui.cacher does not support listing.
+func (ui *webUI) list(ctx context.Context, prefix string) (names []string, listable bool, err error) {
+	cl, ok := ui.cacher.(interface {
+		List(ctx context.Context, prefix string) (names []string, err error)
+	})
+	if !ok {
+		return nil, false, nil
+	}
+	names, err = cl.List(ctx, prefix)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil, false, nil
+	}
+	return names, true, err
+}
+
+// zipFiles returns the files in the cached zip file for the name. It returns
+// false if the zip file is not cached.
+func (ui *webUI) zipFiles(ctx context.Context, name string) ([]webUIZipFile, bool, error) {
+	rc, err := ui.cacher.Get(ctx, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	defer rc.Close()
+
+	ra, ok := rc.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		f, err := os.CreateTemp(ui.tempDir, "zip.*")
+		if err != nil {
+			return nil, false, err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if _, err := io.Copy(f, rc); err != nil {
+			return nil, false, err
+		}
+		ra = f
+	}
+	size, err := ra.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, false, err
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, false, err
+	}
+	files := make([]webUIZipFile, 0, len(zr.File))
+	for _, f := range zr.File {
+		files = append(files, webUIZipFile{Name: f.Name, Size: f.UncompressedSize64})
+	}
+	return files, true, nil
+}
+
+// render renders the template with the name and the data.
+func (ui *webUI) render(rw http.ResponseWriter, name string, data any) {
+	var buf bytes.Buffer
+	if err := webUITemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		ui.logger.Error("failed to render template", "error", err, "template", name)
+		http.Error(rw, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "must-revalidate, no-cache, no-store")
+	buf.WriteTo(rw)
+}
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goproxy/goproxy"
+)
+
+func TestWebUI(t *testing.T) {
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	for name, content := range map[string]string{
+		"example.com/!foo@v1.0.0/go.mod":  "module example.com/Foo",
+		"example.com/!foo@v1.0.0/main.go": "package main",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	cacher := goproxy.DirCacher(t.TempDir())
+	for name, content := range map[string]string{
+		"example.com/!foo/@v/v1.0.0.info":              `{"Version":"v1.0.0"}`,
+		"example.com/!foo/@v/v1.0.0.mod":               "module example.com/Foo",
+		"example.com/!foo/@v/v1.0.0.zip":               zipBuf.String(),
+		"example.com/!foo/@v/v1.1.0.mod":               "module example.com/Foo",
+		"example.com/bar/@v/list":                      "v1.0.0",
+		"sumdb/sum.golang.org/latest":                  "",
+		"quarantine/example.com/baz/@v/v1.0.0.zip":     "",
+		"direct-fetches/example.com/qux/@v/v1.0.0.zip": "",
+	} {
+		if err := cacher.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name           string
+		cacher         goproxy.Cacher
+		method         string
+		path           string
+		wantStatusCode int
+		wantContains   []string
+		wantNotContain []string
+	}{
+		{
+			name:           "Index",
+			path:           "/",
+			wantStatusCode: http.StatusOK,
+			wantContains:   []string{`<a href="/ui/modules/example.com/!foo">example.com/Foo</a>`},
+			wantNotContain: []string{"example.com/bar", "sumdb", "quarantine", "direct-fetches"},
+		},
+		{
+			name:           "IndexNotListable",
+			cacher:         struct{ goproxy.Cacher }{cacher},
+			path:           "/",
+			wantStatusCode: http.StatusOK,
+			wantContains:   []string{"does not support listing"},
+		},
+		{
+			name:           "IndexWrappedNotListable",
+			cacher:         &goproxy.DedupCacher{Cacher: struct{ goproxy.Cacher }{cacher}},
+			path:           "/",
+			wantStatusCode: http.StatusOK,
+			wantContains:   []string{"does not support listing"},
+		},
+		{
+			name:           "Module",
+			path:           "/modules/example.com/!foo",
+			wantStatusCode: http.StatusOK,
+			wantContains: []string{
+				`<a href="/ui/modules/example.com/!foo/@v/v1.1.0">v1.1.0</a></td><td>.mod</td>`,
+				`<a href="/ui/modules/example.com/!foo/@v/v1.0.0">v1.0.0</a></td><td>.info, .mod, .zip</td>`,
+			},
+		},
+		{
+			name:           "ModuleNotFound",
+			path:           "/modules/example.com/bar",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Version",
+			path:           "/modules/example.com/!foo/@v/v1.0.0",
+			wantStatusCode: http.StatusOK,
+			wantContains: []string{
+				`<h1>example.com/Foo@v1.0.0</h1>`,
+				`<a href="https://sum.golang.org/lookup/example.com/!foo@v1.0.0">`,
+				`<pre>module example.com/Foo</pre>`,
+				`<td>example.com/!foo@v1.0.0/go.mod</td><td>22</td>`,
+				`<td>example.com/!foo@v1.0.0/main.go</td><td>12</td>`,
+			},
+		},
+		{
+			name:           "VersionWithoutZip",
+			path:           "/modules/example.com/!foo/@v/v1.1.0",
+			wantStatusCode: http.StatusOK,
+			wantContains:   []string{`<pre>module example.com/Foo</pre>`, "Not cached."},
+		},
+		{
+			name:           "VersionNotReaderAt",
+			cacher:         &readCloserCacher{Cacher: cacher},
+			path:           "/modules/example.com/!foo/@v/v1.0.0",
+			wantStatusCode: http.StatusOK,
+			wantContains:   []string{`<td>example.com/!foo@v1.0.0/main.go</td><td>12</td>`},
+		},
+		{
+			name:           "VersionNotFound",
+			path:           "/modules/example.com/!foo/@v/v1.2.0",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "NonCanonicalVersion",
+			path:           "/modules/example.com/!foo/@v/v1",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "InvalidModulePath",
+			path:           "/modules/Example.com",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "MethodNotAllowed",
+			method:         http.MethodPost,
+			path:           "/",
+			wantStatusCode: http.StatusMethodNotAllowed,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cacher == nil {
+				tt.cacher = cacher
+			}
+			ui := &webUI{
+				cacher:   tt.cacher,
+				basePath: "/ui",
+				sumdbURL: "https://sum.golang.org",
+				tempDir:  t.TempDir(),
+				logger:   slog.New(slog.DiscardHandler),
+			}
+
+			rec := httptest.NewRecorder()
+			ui.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if got, want := rec.Code, tt.wantStatusCode; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+			body := rec.Body.String()
+			for _, s := range tt.wantContains {
+				if !strings.Contains(body, s) {
+					t.Errorf("body does not contain %q", s)
+				}
+			}
+			for _, s := range tt.wantNotContain {
+				if strings.Contains(body, s) {
+					t.Errorf("body contains %q", s)
+				}
+			}
+		})
+	}
+}
+
+// readCloserCacher is a [goproxy.Cacher] whose Get returns only an
+// [io.ReadCloser].
+type readCloserCacher struct {
+	goproxy.Cacher
+}
+
+// Get implements [goproxy.Cacher].
+func (c *readCloserCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := c.Cacher.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ io.ReadCloser }{rc}, nil
+}
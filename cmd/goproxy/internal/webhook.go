@@ -0,0 +1,317 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aofei/backoff"
+	"github.com/goproxy/goproxy"
+)
+
+// errInvalidWebhookJob indicates that a webhook job file cannot be decoded.
+var errInvalidWebhookJob = errors.New("invalid webhook job")
+
+// webhookNotifier delivers webhook notifications for module versions whose zip
+// files have been newly put to the cacher by [goproxy.Goproxy].
+//
+// Notifications are persisted as files in the queue directory before they
+// are delivered, so pending ones survive restarts. Each notification is
+// delivered to all URLs by POST requests with a JSON payload, and is retried
+// with exponential backoff until all URLs have accepted it with a 2xx
+// response or the maximum number of attempts has been reached. Job files that
+// cannot be decoded are moved to the "dead" subdirectory of the queue
+// directory.
+type webhookNotifier struct {
+	urls        []string
+	queueDir    string
+	zipHash     func(ctx context.Context, name string) (string, error)
+	client      *http.Client
+	maxAttempts int
+	backoffBase time.Duration
+	backoffCap  time.Duration
+	pollPeriod  time.Duration
+	logger      *slog.Logger
+
+	wakeCh chan struct{}
+}
+
+// webhookPayload is the JSON payload delivered by [webhookNotifier].
+type webhookPayload struct {
+	ModulePath    string    `json:"module_path"`
+	ModuleVersion string    `json:"module_version"`
+	GoSumHash     string    `json:"go_sum_hash"`
+	User          string    `json:"user,omitempty"`
+	Client        string    `json:"client"`
+	Source        string    `json:"source,omitempty"`
+	Upstream      string    `json:"upstream,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// webhookJob is a queued notification of [webhookNotifier].
+type webhookJob struct {
+	Name        string
+	Payload     webhookPayload
+	PendingURLs []string
+	Attempts    int
+	NextAttempt time.Time
+}
+
+// newWebhookNotifier creates a new [webhookNotifier]. The zipHash returns the
+// "h1:" hash of the cached zip file for the name, typically
+// [goproxy.Goproxy.CachedZipHash].
+func newWebhookNotifier(urls []string, queueDir string, zipHash func(ctx context.Context, name string) (string, error), transport http.RoundTripper, maxAttempts int, logger *slog.Logger) (*webhookNotifier, error) {
+	if err := os.MkdirAll(queueDir, 0o755); err != nil {
+		return nil, err
+	}
+	return &webhookNotifier{
+		urls:        urls,
+		queueDir:    queueDir,
+		zipHash:     zipHash,
+		client:      &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		maxAttempts: maxAttempts,
+		backoffBase: time.Second,
+		backoffCap:  10 * time.Minute,
+		pollPeriod:  time.Minute,
+		logger:      logger,
+		wakeCh:      make(chan struct{}, 1),
+	}, nil
+}
+
+// notify queues notifications for the zip files cached during the fe. It can
+// be used as [goproxy.Goproxy.OnFetch].
+func (wn *webhookNotifier) notify(fe goproxy.FetchEvent) {
+	queued := false
+	for _, name := range fe.Cached {
+		if !strings.HasSuffix(name, ".zip") {
+			continue
+		}
+		job := &webhookJob{
+			Name: name,
+			Payload: webhookPayload{
+				ModulePath:    fe.ModulePath,
+				ModuleVersion: fe.ModuleVersion,
+				User:          fe.User,
+				Client:        fe.Client,
+				Source:        fe.Source,
+				Upstream:      fe.Upstream,
+				Time:          fe.Time,
+			},
+			PendingURLs: slices.Clone(wn.urls),
+		}
+		if err := wn.saveJob(wn.newJobFile(), job); err != nil {
+			wn.logger.Error("failed to queue webhook notification", "error", err, "name", name)
+			continue
+		}
+		queued = true
+	}
+	if queued {
+		select {
+		case wn.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run delivers the queued notifications until the ctx is done.
+func (wn *webhookNotifier) run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		wait := wn.pollPeriod
+		if next := wn.processQueue(ctx); !next.IsZero() {
+			wait = min(wait, max(time.Until(next), 0))
+		}
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-wn.wakeCh:
+		case <-timer.C:
+		}
+	}
+}
+
+// processQueue processes all due jobs in the queue. It returns the time of the
+// earliest next attempt of the remaining jobs, or the zero time if there is
+// none.
+func (wn *webhookNotifier) processQueue(ctx context.Context) (next time.Time) {
+	des, err := os.ReadDir(wn.queueDir)
+	if err != nil {
+		wn.logger.Error("failed to read webhook queue", "error", err)
+		return
+	}
+	for _, de := range des {
+		if ctx.Err() != nil {
+			return
+		}
+		if de.IsDir() || filepath.Ext(de.Name()) != ".json" {
+			continue
+		}
+		file := filepath.Join(wn.queueDir, de.Name())
+		job, err := wn.loadJob(file)
+		if err != nil {
+			if errors.Is(err, errInvalidWebhookJob) {
+				wn.deadLetterJob(file, err)
+			} else {
+				wn.logger.Error("failed to load webhook notification", "error", err, "file", file)
+			}
+			continue
+		}
+		if time.Now().Before(job.NextAttempt) {
+			if next.IsZero() || job.NextAttempt.Before(next) {
+				next = job.NextAttempt
+			}
+			continue
+		}
+		if !wn.processJob(ctx, file, job) {
+			if next.IsZero() || job.NextAttempt.Before(next) {
+				next = job.NextAttempt
+			}
+		}
+	}
+	return
+}
+
+// processJob makes an attempt to deliver the job stored in the file. It
+// returns true if the job is finished and has been removed from the queue.
+func (wn *webhookNotifier) processJob(ctx context.Context, file string, job *webhookJob) bool {
+	var lastErr error
+	if job.Payload.GoSumHash == "" {
+		hash, err := wn.zipHash(ctx, job.Name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				wn.logger.Warn("dropped webhook notification for missing cache", "name", job.Name)
+				wn.removeJob(file)
+				return true
+			}
+			lastErr = err
+		}
+		job.Payload.GoSumHash = hash
+	}
+	if job.Payload.GoSumHash != "" {
+		body, err := json.Marshal(job.Payload)
+		if err != nil {
+			wn.logger.Error("failed to marshal webhook payload", "error", err, "name", job.Name)
+			wn.removeJob(file)
+			return true
+		}
+		job.PendingURLs = slices.DeleteFunc(job.PendingURLs, func(url string) bool {
+			if err := wn.deliver(ctx, url, body); err != nil {
+				lastErr = err
+				return false
+			}
+			return true
+		})
+	}
+	if len(job.PendingURLs) == 0 {
+		wn.removeJob(file)
+		return true
+	}
+
+	job.Attempts++
+	if job.Attempts >= wn.maxAttempts {
+		wn.logger.Error("dropped webhook notification after too many attempts", "error", lastErr, "name", job.Name, "attempts", job.Attempts)
+		wn.removeJob(file)
+		return true
+	}
+	job.NextAttempt = time.Now().Add(backoff.Duration(wn.backoffBase, wn.backoffCap, job.Attempts))
+	if err := wn.saveJob(file, job); err != nil {
+		wn.logger.Error("failed to save webhook notification", "error", err, "file", file)
+	}
+	return false
+}
+
+// deliver POSTs the body to the url.
+func (wn *webhookNotifier) deliver(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := wn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST %s: %s", req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+// newJobFile returns a new job file path in the queue directory. Job files
+// sort in the order they are created.
+func (wn *webhookNotifier) newJobFile() string {
+	return filepath.Join(wn.queueDir, fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), rand.Text()))
+}
+
+// loadJob loads a job from the file.
+func (wn *webhookNotifier) loadJob(file string) (*webhookJob, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var job webhookJob
+	if err := json.Unmarshal(b, &job); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidWebhookJob, err)
+	}
+	return &job, nil
+}
+
+// saveJob atomically saves the job to the file.
+func (wn *webhookNotifier) saveJob(file string, job *webhookJob) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(wn.queueDir, ".job.tmp.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
+
+// deadLetterJob moves the job file that cannot be processed because of the
+// err to the "dead" subdirectory of the queue directory, so that it is kept for
+// inspection without being retried.
+func (wn *webhookNotifier) deadLetterJob(file string, err error) {
+	deadDir := filepath.Join(wn.queueDir, "dead")
+	if err := os.MkdirAll(deadDir, 0o755); err != nil {
+		wn.logger.Error("failed to create webhook dead letter directory", "error", err, "dir", deadDir)
+		return
+	}
+	if err := os.Rename(file, filepath.Join(deadDir, filepath.Base(file))); err != nil {
+		wn.logger.Error("failed to move webhook notification to dead letters", "error", err, "file", file)
+		return
+	}
+	wn.logger.Error("moved invalid webhook notification to dead letters", "error", err, "file", file)
+}
+
+// removeJob removes the job file.
+func (wn *webhookNotifier) removeJob(file string) {
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		wn.logger.Error("failed to remove webhook notification", "error", err, "file", file)
+	}
+}
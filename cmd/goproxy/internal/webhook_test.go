@@ -0,0 +1,283 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goproxy/goproxy"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	if w, err := zw.Create("example.com@v1.0.0/go.mod"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if _, err := io.WriteString(w, "module example.com"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	zipFile := t.TempDir() + "/v1.0.0.zip"
+	if err := os.WriteFile(zipFile, zipBuf.Bytes(), 0o644); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	zipHash, err := dirhash.HashZip(zipFile, dirhash.DefaultHash)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	cacher := goproxy.DirCacher(t.TempDir())
+	if err := cacher.Put(t.Context(), "example.com/@v/v1.0.0.zip", bytes.NewReader(zipBuf.Bytes())); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	fe := goproxy.FetchEvent{
+		Time:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Target:        "example.com/@v/v1.0.0.zip",
+		ModulePath:    "example.com",
+		ModuleVersion: "v1.0.0",
+		Source:        "proxy",
+		Upstream:      "https://proxy.golang.org",
+		Cached:        []string{"example.com/@v/v1.0.0.info", "example.com/@v/v1.0.0.mod", "example.com/@v/v1.0.0.zip"},
+		Client:        "192.0.2.1:1234",
+		User:          "gopher",
+	}
+
+	// newReceiver creates a new webhook receiver that fails the first
+	// failures requests.
+	newReceiver := func(t *testing.T, failures int) (url string, payloads func() []webhookPayload) {
+		var (
+			mu       sync.Mutex
+			requests int
+			received []webhookPayload
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			requests++
+			if requests <= failures {
+				rw.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if got, want := req.Method, http.MethodPost; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := req.Header.Get("Content-Type"), "application/json"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			var payload webhookPayload
+			if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			received = append(received, payload)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL, func() []webhookPayload {
+			mu.Lock()
+			defer mu.Unlock()
+			return received
+		}
+	}
+
+	newNotifier := func(t *testing.T, urls []string, queueDir string, maxAttempts int) *webhookNotifier {
+		g := &goproxy.Goproxy{Cacher: cacher, TempDir: t.TempDir(), Logger: slog.New(slog.DiscardHandler)}
+		wn, err := newWebhookNotifier(urls, queueDir, g.CachedZipHash, http.DefaultTransport, maxAttempts, slog.New(slog.DiscardHandler))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		wn.backoffBase = time.Millisecond
+		wn.backoffCap = time.Millisecond
+		return wn
+	}
+
+	queueLen := func(t *testing.T, queueDir string) int {
+		des, err := os.ReadDir(queueDir)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return len(des)
+	}
+
+	t.Run("Normal", func(t *testing.T) {
+		url1, payloads1 := newReceiver(t, 0)
+		url2, payloads2 := newReceiver(t, 0)
+		queueDir := t.TempDir()
+		wn := newNotifier(t, []string{url1, url2}, queueDir, 10)
+
+		wn.notify(fe)
+		if got, want := queueLen(t, queueDir), 1; got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		}
+		if next := wn.processQueue(t.Context()); !next.IsZero() {
+			t.Errorf("got %v, want zero time", next)
+		}
+		if got, want := queueLen(t, queueDir), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+
+		want := webhookPayload{
+			ModulePath:    "example.com",
+			ModuleVersion: "v1.0.0",
+			GoSumHash:     zipHash,
+			User:          "gopher",
+			Client:        "192.0.2.1:1234",
+			Source:        "proxy",
+			Upstream:      "https://proxy.golang.org",
+			Time:          fe.Time,
+		}
+		for _, payloads := range []func() []webhookPayload{payloads1, payloads2} {
+			if got, want := len(payloads()), 1; got != want {
+				t.Fatalf("got %d, want %d", got, want)
+			}
+			if got := payloads()[0]; got != want {
+				t.Errorf("got %#v, want %#v", got, want)
+			}
+		}
+	})
+
+	t.Run("NoZip", func(t *testing.T) {
+		queueDir := t.TempDir()
+		wn := newNotifier(t, []string{"http://127.0.0.1:0"}, queueDir, 10)
+
+		fe := fe
+		fe.Cached = []string{"example.com/@v/v1.0.0.mod"}
+		wn.notify(fe)
+		if got, want := queueLen(t, queueDir), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		url1, payloads1 := newReceiver(t, 0)
+		url2, payloads2 := newReceiver(t, 2)
+		queueDir := t.TempDir()
+		wn := newNotifier(t, []string{url1, url2}, queueDir, 10)
+
+		wn.notify(fe)
+		for range 2 {
+			if next := wn.processQueue(t.Context()); next.IsZero() {
+				t.Fatal("unexpected zero time")
+			}
+			time.Sleep(2 * time.Millisecond)
+		}
+		if next := wn.processQueue(t.Context()); !next.IsZero() {
+			t.Errorf("got %v, want zero time", next)
+		}
+		if got, want := len(payloads1()), 1; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := len(payloads2()), 1; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := queueLen(t, queueDir), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("MaxAttempts", func(t *testing.T) {
+		url, payloads := newReceiver(t, 3)
+		queueDir := t.TempDir()
+		wn := newNotifier(t, []string{url}, queueDir, 2)
+
+		wn.notify(fe)
+		wn.processQueue(t.Context())
+		time.Sleep(2 * time.Millisecond)
+		if next := wn.processQueue(t.Context()); !next.IsZero() {
+			t.Errorf("got %v, want zero time", next)
+		}
+		if got, want := len(payloads()), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := queueLen(t, queueDir), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("MissingCache", func(t *testing.T) {
+		url, payloads := newReceiver(t, 0)
+		queueDir := t.TempDir()
+		wn := newNotifier(t, []string{url}, queueDir, 10)
+
+		fe := fe
+		fe.Cached = []string{"example.com/@v/v2.0.0.zip"}
+		wn.notify(fe)
+		wn.processQueue(t.Context())
+		if got, want := len(payloads()), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := queueLen(t, queueDir), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("DeadLetter", func(t *testing.T) {
+		url, payloads := newReceiver(t, 0)
+		queueDir := t.TempDir()
+		wn := newNotifier(t, []string{url}, queueDir, 10)
+
+		if err := os.WriteFile(filepath.Join(queueDir, "invalid.json"), []byte("{"), 0o644); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for range 2 {
+			if next := wn.processQueue(t.Context()); !next.IsZero() {
+				t.Errorf("got %v, want zero time", next)
+			}
+		}
+		if got, want := len(payloads()), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if _, err := os.Stat(filepath.Join(queueDir, "invalid.json")); !os.IsNotExist(err) {
+			t.Errorf("got %v, want not exist", err)
+		}
+		if _, err := os.Stat(filepath.Join(queueDir, "dead", "invalid.json")); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+
+	t.Run("Persistent", func(t *testing.T) {
+		url, payloads := newReceiver(t, 0)
+		queueDir := t.TempDir()
+		newNotifier(t, []string{url}, queueDir, 10).notify(fe)
+
+		wn := newNotifier(t, []string{url}, queueDir, 10)
+		wn.processQueue(t.Context())
+		if got, want := len(payloads()), 1; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("Run", func(t *testing.T) {
+		url, payloads := newReceiver(t, 1)
+		queueDir := t.TempDir()
+		wn := newNotifier(t, []string{url}, queueDir, 10)
+
+		done := make(chan struct{})
+		go func() {
+			wn.run(t.Context())
+			close(done)
+		}()
+		t.Cleanup(func() { <-done })
+		wn.notify(fe)
+		deadline := time.Now().Add(5 * time.Second)
+		for len(payloads()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got, want := len(payloads()), 1; got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		}
+		if got, want := payloads()[0].GoSumHash, zipHash; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,234 @@
+package goproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+// compressedCacheSuffix is the name suffix of the caches compressed by
+// [CompressCacher] in its underlying [Cacher].
+const compressedCacheSuffix = ".gz"
+
+// CompressCacher implements [Cacher] by compressing content that compresses
+// well with gzip before putting it to another [Cacher]. Such content includes
+// module mod files, module version lists, and checksum database tiles. Other
+// content, such as module zip files, is put as is.
+//
+// Compressed content is stored under its name with a ".gz" suffix in the
+// underlying Cacher, and content previously stored uncompressed under its name
+// is still found by Get. The content returned by Get for compressed caches is
+// decompressed on first read, and implements the EncodedContent method
+// documented in [Cacher] so that [Goproxy] can serve the compressed bytes
+// directly to clients that accept gzip.
+//
+// The List and Delete methods documented in [Cacher] require the underlying
+// Cacher to implement them.
+type CompressCacher struct {
+	// Cacher is the underlying [Cacher].
+	Cacher Cacher
+
+	// Level is the gzip compression level.
+	//
+	// If Level is zero, [gzip.DefaultCompression] is used.
+	Level int
+}
+
+// Get implements [Cacher].
+func (cc *CompressCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if !compressibleCacheName(name) {
+		return cc.Cacher.Get(ctx, name)
+	}
+	rc, err := cc.Cacher.Get(ctx, name+compressedCacheSuffix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return cc.Cacher.Get(ctx, name)
+		}
+		return nil, err
+	}
+	defer rc.Close()
+	encoded, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	content := &compressedContent{encoded: encoded}
+	if lm, ok := rc.(interface{ LastModified() time.Time }); ok {
+		content.lastModified = lm.LastModified()
+	} else if mt, ok := rc.(interface{ ModTime() time.Time }); ok {
+		content.lastModified = mt.ModTime()
+	}
+	if et, ok := rc.(interface{ ETag() string }); ok {
+		content.etag = et.ETag()
+	}
+	return content, nil
+}
+
+// Put implements [Cacher].
+func (cc *CompressCacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	if !compressibleCacheName(name) {
+		return cc.Cacher.Put(ctx, name, content)
+	}
+	level := cc.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(zw, content); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return cc.Cacher.Put(ctx, name+compressedCacheSuffix, bytes.NewReader(buf.Bytes()))
+}
+
+// List lists the names of all caches that start with the prefix, in lexical
+// order. See [Cacher] for details.
+func (cc *CompressCacher) List(ctx context.Context, prefix string) ([]string, error) {
+	cl, ok := cc.Cacher.(interface {
+		List(ctx context.Context, prefix string) (names []string, err error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("underlying cacher does not support listing: %w", errors.ErrUnsupported)
+	}
+	names, err := cl.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range names {
+		if n, ok := strings.CutSuffix(name, compressedCacheSuffix); ok && compressibleCacheName(n) {
+			names[i] = n
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// Delete deletes the cache for the name, both compressed and uncompressed. See
+// [Cacher] for details.
+func (cc *CompressCacher) Delete(ctx context.Context, name string) error {
+	cd, ok := cc.Cacher.(interface {
+		Delete(ctx context.Context, name string) error
+	})
+	if !ok {
+		return fmt.Errorf("underlying cacher does not support deletion: %w", errors.ErrUnsupported)
+	}
+	if !compressibleCacheName(name) {
+		return cd.Delete(ctx, name)
+	}
+	compressedErr := cd.Delete(ctx, name+compressedCacheSuffix)
+	if compressedErr != nil && !errors.Is(compressedErr, fs.ErrNotExist) {
+		return compressedErr
+	}
+	if err := cd.Delete(ctx, name); err != nil && (compressedErr != nil || !errors.Is(err, fs.ErrNotExist)) {
+		return err
+	}
+	return nil
+}
+
+// compressibleCacheName reports whether the cache for the name is compressed
+// by [CompressCacher].
+func compressibleCacheName(name string) bool {
+	if strings.HasPrefix(name, "sumdb/") {
+		_, path, _ := strings.Cut(strings.TrimPrefix(name, "sumdb/"), "/")
+		return strings.HasPrefix(path, "tile/")
+	}
+	return strings.HasSuffix(name, ".mod") || strings.HasSuffix(name, "/@v/list")
+}
+
+// contentMetadata is the metadata of cached content.
+type contentMetadata struct {
+	lastModified time.Time
+	etag         string
+}
+
+// LastModified returns the last modification time of the content.
+func (cm contentMetadata) LastModified() time.Time {
+	return cm.lastModified
+}
+
+// ETag returns the entity tag of the content.
+func (cm contentMetadata) ETag() string {
+	return cm.etag
+}
+
+// compressedContent is the content of a cache compressed by [CompressCacher].
+// It is decompressed on first read.
+type compressedContent struct {
+	contentMetadata
+	encoded []byte
+	decoded *bytes.Reader
+}
+
+// decode decompresses the encoded content if it has not been decompressed.
+func (cc *compressedContent) decode() error {
+	if cc.decoded != nil {
+		return nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(cc.encoded))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		return err
+	}
+	cc.decoded = bytes.NewReader(b)
+	return nil
+}
+
+// Read implements [io.Reader].
+func (cc *compressedContent) Read(p []byte) (int, error) {
+	if err := cc.decode(); err != nil {
+		return 0, err
+	}
+	return cc.decoded.Read(p)
+}
+
+// Seek implements [io.Seeker].
+func (cc *compressedContent) Seek(offset int64, whence int) (int64, error) {
+	if err := cc.decode(); err != nil {
+		return 0, err
+	}
+	return cc.decoded.Seek(offset, whence)
+}
+
+// Close implements [io.Closer].
+func (cc *compressedContent) Close() error {
+	return nil
+}
+
+// EncodedContent returns the compressed content of the cc along with its
+// content coding. See [Cacher] for details.
+func (cc *compressedContent) EncodedContent() (io.Reader, string) {
+	return &struct {
+		*bytes.Reader
+		contentMetadata
+	}{bytes.NewReader(cc.encoded), contentMetadata{
+		lastModified: cc.lastModified,
+		etag:         encodedETag(cc.etag, "gzip"),
+	}}, "gzip"
+}
+
+// encodedETag returns the entity tag for the representation of the content
+// with the etag encoded with the contentCoding, so that the two
+// representations have different entity tags. It returns "" if the etag is
+// not a valid entity tag.
+func encodedETag(etag, contentCoding string) string {
+	if len(etag) < 2 || !strings.HasSuffix(etag, `"`) {
+		return ""
+	}
+	return etag[:len(etag)-1] + "-" + contentCoding + `"`
+}
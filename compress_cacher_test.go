@@ -0,0 +1,229 @@
+package goproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompressCacher(t *testing.T) {
+	get := func(t *testing.T, cc *CompressCacher, name string) io.ReadCloser {
+		rc, err := cc.Get(t.Context(), name)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		t.Cleanup(func() { rc.Close() })
+		return rc
+	}
+
+	t.Run("Normal", func(t *testing.T) {
+		dir := DirCacher(t.TempDir())
+		cc := &CompressCacher{Cacher: dir}
+		for _, name := range []string{
+			"example.com/@v/v1.0.0.mod",
+			"example.com/@v/v1.0.0.zip",
+			"example.com/@v/list",
+			"sumdb/sum.golang.org/tile/8/0/000",
+			"sumdb/sum.golang.org/latest",
+		} {
+			if err := cc.Put(t.Context(), name, strings.NewReader("foobar")); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if b, err := io.ReadAll(get(t, cc, name)); err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if got, want := string(b), "foobar"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		}
+
+		names, err := dir.List(t.Context(), "")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := strings.Join(names, ","), "example.com/@v/list.gz,example.com/@v/v1.0.0.mod.gz,example.com/@v/v1.0.0.zip,sumdb/sum.golang.org/latest,sumdb/sum.golang.org/tile/8/0/000.gz"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		names, err = cc.List(t.Context(), "example.com/")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := strings.Join(names, ","), "example.com/@v/list,example.com/@v/v1.0.0.mod,example.com/@v/v1.0.0.zip"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("EncodedContent", func(t *testing.T) {
+		cc := &CompressCacher{Cacher: DirCacher(t.TempDir()), Level: gzip.BestCompression}
+		if err := cc.Put(t.Context(), "example.com/@v/v1.0.0.mod", strings.NewReader("foobar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		ec, ok := get(t, cc, "example.com/@v/v1.0.0.mod").(interface {
+			EncodedContent() (content io.Reader, contentCoding string)
+		})
+		if !ok {
+			t.Fatal("expected EncodedContent to be implemented")
+		}
+		encoded, contentCoding := ec.EncodedContent()
+		if got, want := contentCoding, "gzip"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		zr, err := gzip.NewReader(encoded)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(zr); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "foobar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Uncompressed", func(t *testing.T) {
+		dir := DirCacher(t.TempDir())
+		if err := dir.Put(t.Context(), "example.com/@v/v1.0.0.mod", strings.NewReader("foobar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		cc := &CompressCacher{Cacher: dir}
+		if b, err := io.ReadAll(get(t, cc, "example.com/@v/v1.0.0.mod")); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "foobar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		cc := &CompressCacher{Cacher: DirCacher(cacheDir)}
+		if err := os.MkdirAll(filepath.Join(cacheDir, "example.com", "@v"), 0o755); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(cacheDir, "example.com", "@v", "v1.0.0.mod.gz"), []byte("not a gzip file"), 0o644); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := io.ReadAll(get(t, cc, "example.com/@v/v1.0.0.mod")); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err, gzip.ErrHeader; !compareErrors(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("GetNonExistent", func(t *testing.T) {
+		cc := &CompressCacher{Cacher: DirCacher(t.TempDir())}
+		for _, name := range []string{"example.com/@v/v1.0.0.mod", "example.com/@v/v1.0.0.zip"} {
+			if _, err := cc.Get(t.Context(), name); err == nil {
+				t.Fatal("expected error")
+			} else if got, want := err, fs.ErrNotExist; !compareErrors(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		dir := DirCacher(t.TempDir())
+		cc := &CompressCacher{Cacher: dir}
+		if err := dir.Put(t.Context(), "example.com/@v/v1.0.0.mod", strings.NewReader("foobar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := cc.Put(t.Context(), "example.com/@v/v1.0.0.mod", strings.NewReader("foobar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := cc.Delete(t.Context(), "example.com/@v/v1.0.0.mod"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := cc.Delete(t.Context(), "example.com/@v/v1.0.0.mod"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err, fs.ErrNotExist; !compareErrors(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		names, err := dir.List(t.Context(), "")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := len(names), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		cc := &CompressCacher{Cacher: struct{ Cacher }{DirCacher(t.TempDir())}}
+		if _, err := cc.List(t.Context(), ""); err == nil {
+			t.Fatal("expected error")
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("got %v, want %v", err, errors.ErrUnsupported)
+		}
+		if err := cc.Delete(t.Context(), "a"); err == nil {
+			t.Fatal("expected error")
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("got %v, want %v", err, errors.ErrUnsupported)
+		}
+	})
+
+	t.Run("InvalidLevel", func(t *testing.T) {
+		cc := &CompressCacher{Cacher: DirCacher(t.TempDir()), Level: 100}
+		if err := cc.Put(t.Context(), "example.com/@v/v1.0.0.mod", bytes.NewReader(nil)); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestGoproxyCompressCacher(t *testing.T) {
+	g := &Goproxy{
+		Cacher:       &CompressCacher{Cacher: DirCacher(t.TempDir())},
+		TempDir:      t.TempDir(),
+		CacheDigests: true,
+		Logger:       slog.New(slog.DiscardHandler),
+	}
+	g.initOnce.Do(g.init)
+	if err := g.putCache(t.Context(), "example.com/@v/v1.0.0.mod", strings.NewReader("module example.com")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for _, tt := range []struct {
+		n                   int
+		acceptEncoding      string
+		wantContentEncoding string
+	}{
+		{n: 1},
+		{n: 2, acceptEncoding: "gzip", wantContentEncoding: "gzip"},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.0.0.mod", nil)
+			req.Header.Set("Disable-Module-Fetch", "true")
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, req)
+			recr := rec.Result()
+			if got, want := recr.StatusCode, http.StatusOK; got != want {
+				t.Fatalf("got %d, want %d", got, want)
+			}
+			if got, want := recr.Header.Get("Content-Encoding"), tt.wantContentEncoding; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			body := io.Reader(recr.Body)
+			if tt.wantContentEncoding == "gzip" {
+				zr, err := gzip.NewReader(body)
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				body = zr
+			}
+			if b, err := io.ReadAll(body); err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if got, want := string(b), "module example.com"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
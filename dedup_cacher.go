@@ -0,0 +1,295 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DedupCacher implements [Cacher] by storing content-addressed blobs in
+// another [Cacher], so that identical content put for different names is
+// stored only once.
+//
+// The underlying Cacher holds the following caches:
+//   - "blobs/sha256/<xx>/<digest>": the content with the hex-encoded SHA-256
+//     digest, where <xx> is the first two characters of the digest.
+//   - "index/<name>": the digest of the content for the name.
+//   - "refs/<digest>/<name-digest>": a reference from the name (whose
+//     SHA-256 digest is the name-digest) to the blob, which is put again by
+//     every Put of the name.
+//
+// The List method documented in [Cacher] requires the underlying Cacher to
+// implement it, and so does the Delete method.
+//
+// Put and Delete never delete blobs, so that multiple DedupCacher values
+// (such as in different processes) can safely share the same underlying
+// Cacher without coordinating with each other. Blobs that are no longer
+// referenced are instead deleted by [DedupCacher.CollectGarbage].
+type DedupCacher struct {
+	// Cacher is the underlying [Cacher].
+	Cacher Cacher
+}
+
+// Get implements [Cacher].
+func (dc *DedupCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	digest, err := dc.digest(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return dc.Cacher.Get(ctx, dedupBlobName(digest))
+}
+
+// Put implements [Cacher].
+func (dc *DedupCacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	digest, err := sha256Digest(content)
+	if err != nil {
+		return err
+	}
+
+	// The reference must be put before checking the existence of the blob,
+	// so that [DedupCacher.CollectGarbage] will not delete the blob.
+	if err := dc.Cacher.Put(ctx, dedupRefName(digest, name), strings.NewReader(name)); err != nil {
+		return err
+	}
+	blobName := dedupBlobName(digest)
+	if rc, err := dc.Cacher.Get(ctx, blobName); err == nil {
+		rc.Close()
+	} else if errors.Is(err, fs.ErrNotExist) {
+		if err := dc.Cacher.Put(ctx, blobName, content); err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+	return dc.Cacher.Put(ctx, dedupIndexName(name), strings.NewReader(digest))
+}
+
+// List lists the names of all caches that start with the prefix, in lexical
+// order. See [Cacher] for details.
+func (dc *DedupCacher) List(ctx context.Context, prefix string) ([]string, error) {
+	cl, ok := dc.Cacher.(interface {
+		List(ctx context.Context, prefix string) (names []string, err error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("underlying cacher does not support listing: %w", errors.ErrUnsupported)
+	}
+	names, err := cl.List(ctx, dedupIndexName(prefix))
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range names {
+		names[i] = strings.TrimPrefix(name, dedupIndexName(""))
+	}
+	return names, nil
+}
+
+// Delete deletes the cache for the name, along with its reference to the
+// underlying blob. The blob itself is left for
+// [DedupCacher.CollectGarbage]. See [Cacher] for details.
+func (dc *DedupCacher) Delete(ctx context.Context, name string) error {
+	cd, ok := dc.Cacher.(interface {
+		Delete(ctx context.Context, name string) error
+	})
+	if !ok {
+		return fmt.Errorf("underlying cacher does not support deletion: %w", errors.ErrUnsupported)
+	}
+	digest, err := dc.digest(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := cd.Delete(ctx, dedupIndexName(name)); err != nil {
+		return err
+	}
+	if err := cd.Delete(ctx, dedupRefName(digest, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// CollectGarbage deletes the blobs that are no longer referenced by any cache
+// from the underlying Cacher, along with the stale references to them, and
+// returns their names in the underlying Cacher. If the dryRun is true,
+// nothing is deleted and the names that would be deleted are returned.
+//
+// A reference is stale if the index record for its name no longer holds the
+// digest of its blob. Blobs and stale references put within the minAge are
+// kept, since they may belong to a Put in progress, so the minAge should be
+// much longer than any Put takes. Blobs and references whose modification
+// times are unknown are always kept. In the rare event that a blob is deleted
+// while a concurrent Put is reusing it anyway, the cache for the name is
+// reported as not found and can simply be put again.
+//
+// CollectGarbage requires the underlying Cacher to implement the List and
+// Delete methods documented in [Cacher]. It is safe to call CollectGarbage
+// while other DedupCacher values are using the same underlying Cacher.
+func (dc *DedupCacher) CollectGarbage(ctx context.Context, minAge time.Duration, dryRun bool) ([]string, error) {
+	c, ok := dc.Cacher.(interface {
+		List(ctx context.Context, prefix string) (names []string, err error)
+		Delete(ctx context.Context, name string) error
+	})
+	if !ok {
+		return nil, fmt.Errorf("underlying cacher does not support listing and deletion: %w", errors.ErrUnsupported)
+	}
+	now := time.Now()
+	young := func(name string) (bool, error) {
+		modTime, err := dedupModTime(ctx, dc.Cacher, name)
+		if err != nil {
+			return false, err
+		}
+		return modTime.IsZero() || now.Sub(modTime) < minAge, nil
+	}
+
+	// Mark the blobs referenced by the index records.
+	indexNames, err := c.List(ctx, dedupIndexName(""))
+	if err != nil {
+		return nil, err
+	}
+	indexed := make(map[string]string, len(indexNames)) // Name digest to blob digest.
+	for _, indexName := range indexNames {
+		name := strings.TrimPrefix(indexName, dedupIndexName(""))
+		digest, err := dc.digest(ctx, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // Deleted concurrently.
+			}
+			return nil, err
+		}
+		nameDigest := sha256.Sum256([]byte(name))
+		indexed[hex.EncodeToString(nameDigest[:])] = digest
+	}
+
+	// live reports whether any reference to the blob with the digest is
+	// either indexed or young, deleting the stale ones otherwise.
+	var deleted []string
+	live := func(digest string) (bool, error) {
+		refNames, err := c.List(ctx, dedupRefName(digest, ""))
+		if err != nil {
+			return false, err
+		}
+		isLive := false
+		for _, refName := range refNames {
+			if indexed[path.Base(refName)] == digest {
+				isLive = true
+				continue
+			}
+			if ok, err := young(refName); errors.Is(err, fs.ErrNotExist) {
+				continue // Deleted concurrently.
+			} else if err != nil {
+				return false, err
+			} else if ok {
+				isLive = true
+				continue
+			}
+			if !dryRun {
+				if err := c.Delete(ctx, refName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return false, err
+				}
+			}
+			deleted = append(deleted, refName)
+		}
+		return isLive, nil
+	}
+
+	// Sweep the blobs that are neither marked nor young. References are
+	// listed again right before deleting each blob, so that blobs reused
+	// since the marking are kept.
+	blobNames, err := c.List(ctx, "blobs/sha256/")
+	if err != nil {
+		return nil, err
+	}
+	for _, blobName := range blobNames {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		digest := path.Base(blobName)
+		if blobName != dedupBlobName(digest) {
+			continue
+		}
+		if isLive, err := live(digest); err != nil {
+			return nil, err
+		} else if isLive {
+			continue
+		}
+		if ok, err := young(blobName); errors.Is(err, fs.ErrNotExist) {
+			continue // Deleted concurrently.
+		} else if err != nil {
+			return nil, err
+		} else if ok {
+			continue
+		}
+		if !dryRun {
+			if err := c.Delete(ctx, blobName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+		deleted = append(deleted, blobName)
+	}
+	slices.Sort(deleted)
+	return deleted, nil
+}
+
+// digest returns the digest of the content for the name.
+func (dc *DedupCacher) digest(ctx context.Context, name string) (string, error) {
+	rc, err := dc.Cacher.Get(ctx, dedupIndexName(name))
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return "", err
+	}
+	digest := strings.TrimSpace(string(b))
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != 2*sha256.Size {
+		return "", fmt.Errorf("invalid index record for %s", name)
+	}
+	return digest, nil
+}
+
+// dedupModTime returns the modification time of the cache for the name in the
+// c. It returns the zero time if unknown.
+func dedupModTime(ctx context.Context, c Cacher, name string) (time.Time, error) {
+	rc, err := c.Get(ctx, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer rc.Close()
+	if lm, ok := rc.(interface{ LastModified() time.Time }); ok {
+		return lm.LastModified(), nil
+	}
+	if mt, ok := rc.(interface{ ModTime() time.Time }); ok {
+		return mt.ModTime(), nil
+	}
+	return time.Time{}, nil
+}
+
+// dedupBlobName returns the name of the blob with the digest in the underlying
+// cacher of [DedupCacher].
+func dedupBlobName(digest string) string {
+	return "blobs/sha256/" + digest[:2] + "/" + digest
+}
+
+// dedupIndexName returns the name of the index record for the name in the
+// underlying cacher of [DedupCacher].
+func dedupIndexName(name string) string {
+	return "index/" + name
+}
+
+// dedupRefName returns the name of the reference from the name to the blob
+// with the digest in the underlying cacher of [DedupCacher]. If the name is
+// empty, it returns the prefix of all references to the blob.
+func dedupRefName(digest, name string) string {
+	if name == "" {
+		return "refs/" + digest + "/"
+	}
+	nameDigest := sha256.Sum256([]byte(name))
+	return "refs/" + digest + "/" + hex.EncodeToString(nameDigest[:])
+}
@@ -0,0 +1,301 @@
+package goproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDedupCacher(t *testing.T) {
+	digestOf := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	blobFiles := func(t *testing.T, dir DirCacher) []string {
+		names, err := dir.List(t.Context(), "blobs/")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return names
+	}
+	get := func(t *testing.T, dc *DedupCacher, name string) string {
+		rc, err := dc.Get(t.Context(), name)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer rc.Close()
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return string(b)
+	}
+
+	t.Run("Normal", func(t *testing.T) {
+		dir := DirCacher(t.TempDir())
+		dc := &DedupCacher{Cacher: dir}
+		for _, name := range []string{"a/@v/v1.0.0.zip", "b/@v/v1.0.0.zip"} {
+			if err := dc.Put(t.Context(), name, strings.NewReader("foobar")); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		if err := dc.Put(t.Context(), "c/@v/v1.0.0.zip", strings.NewReader("bazqux")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		if got, want := get(t, dc, "a/@v/v1.0.0.zip"), "foobar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := get(t, dc, "c/@v/v1.0.0.zip"), "bazqux"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := len(blobFiles(t, dir)), 2; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+
+		names, err := dc.List(t.Context(), "")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := strings.Join(names, ","), "a/@v/v1.0.0.zip,b/@v/v1.0.0.zip,c/@v/v1.0.0.zip"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("GetNonExistent", func(t *testing.T) {
+		dc := &DedupCacher{Cacher: DirCacher(t.TempDir())}
+		_, err := dc.Get(t.Context(), "a")
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if got, want := err, fs.ErrNotExist; !compareErrors(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("GetInvalidIndex", func(t *testing.T) {
+		dir := DirCacher(t.TempDir())
+		if err := dir.Put(t.Context(), "index/a", strings.NewReader("invalid")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		dc := &DedupCacher{Cacher: dir}
+		_, err := dc.Get(t.Context(), "a")
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if got, want := err.Error(), "invalid index record for a"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		dir := DirCacher(t.TempDir())
+		dc := &DedupCacher{Cacher: dir}
+		for _, name := range []string{"a", "b"} {
+			if err := dc.Put(t.Context(), name, strings.NewReader("foobar")); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+
+		if err := dc.Delete(t.Context(), "a"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := dc.Get(t.Context(), "a"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err, fs.ErrNotExist; !compareErrors(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := get(t, dc, "b"), "foobar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if err := dc.Delete(t.Context(), "b"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := len(blobFiles(t, dir)), 1; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		refs, err := dir.List(t.Context(), "refs/")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := len(refs), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+
+		if err := dc.Delete(t.Context(), "b"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err, fs.ErrNotExist; !compareErrors(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		dir := DirCacher(t.TempDir())
+		dc := &DedupCacher{Cacher: dir}
+		for _, content := range []string{"foo", "foo", "bar"} {
+			if err := dc.Put(t.Context(), "a", strings.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		if got, want := get(t, dc, "a"), "bar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := len(blobFiles(t, dir)), 2; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+
+		deleted, err := dc.CollectGarbage(t.Context(), 0, false)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := len(deleted), 2; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := strings.Join(blobFiles(t, dir), ","), "blobs/sha256/"+digestOf("bar")[:2]+"/"+digestOf("bar"); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := get(t, dc, "a"), "bar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("CollectGarbage", func(t *testing.T) {
+		dir := DirCacher(t.TempDir())
+		dc := &DedupCacher{Cacher: dir}
+		for name, content := range map[string]string{"a": "foo", "b": "bar", "c": "baz"} {
+			if err := dc.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		for _, name := range []string{"b", "c"} {
+			if err := dc.Delete(t.Context(), name); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		if err := dc.Put(t.Context(), "a", strings.NewReader("qux")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		old := time.Now().Add(-2 * time.Hour)
+		for _, name := range []string{
+			dedupBlobName(digestOf("foo")),
+			dedupRefName(digestOf("foo"), "a"),
+			dedupBlobName(digestOf("bar")),
+			dedupBlobName(digestOf("qux")),
+			dedupRefName(digestOf("qux"), "a"),
+		} {
+			if err := os.Chtimes(filepath.Join(string(dir), filepath.FromSlash(name)), old, old); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+
+		// Another DedupCacher sharing the same underlying cacher reuses
+		// the blob of "bar" after it has become unreferenced.
+		other := &DedupCacher{Cacher: dir}
+		if err := other.Put(t.Context(), "d", strings.NewReader("foo")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := os.Chtimes(filepath.Join(string(dir), filepath.FromSlash(dedupRefName(digestOf("foo"), "d"))), old, old); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := other.Put(t.Context(), "e", strings.NewReader("bar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := dir.Delete(t.Context(), dedupIndexName("e")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		wantDeleted := []string{dedupRefName(digestOf("foo"), "a")}
+		deleted, err := dc.CollectGarbage(t.Context(), time.Hour, true)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := strings.Join(deleted, ","), strings.Join(wantDeleted, ","); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := len(blobFiles(t, dir)), 4; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+
+		if err := dc.Delete(t.Context(), "d"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		wantDeleted = []string{dedupBlobName(digestOf("foo")), dedupRefName(digestOf("foo"), "a")}
+		slices.Sort(wantDeleted)
+		deleted, err = dc.CollectGarbage(t.Context(), time.Hour, false)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := strings.Join(deleted, ","), strings.Join(wantDeleted, ","); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := get(t, dc, "a"), "qux"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		for _, name := range []string{dedupBlobName(digestOf("bar")), dedupBlobName(digestOf("baz"))} {
+			if _, err := dir.Get(t.Context(), name); err != nil {
+				t.Errorf("%s: unexpected error %v", name, err)
+			}
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		dir := DirCacher(t.TempDir())
+		dc := &DedupCacher{Cacher: dir}
+		var wg sync.WaitGroup
+		for i := range 10 {
+			name := string(rune('a' + i))
+			wg.Go(func() {
+				if err := dc.Put(t.Context(), name, strings.NewReader("foobar")); err != nil {
+					t.Errorf("unexpected error %v", err)
+					return
+				}
+				if i%2 == 0 {
+					if err := dc.Delete(t.Context(), name); err != nil {
+						t.Errorf("unexpected error %v", err)
+					}
+				}
+			})
+		}
+		wg.Wait()
+		for i := 1; i < 10; i += 2 {
+			if got, want := get(t, dc, string(rune('a'+i))), "foobar"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		}
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		dc := &DedupCacher{Cacher: struct{ Cacher }{DirCacher(t.TempDir())}}
+		if err := dc.Put(t.Context(), "a", strings.NewReader("foo")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := dc.Put(t.Context(), "a", strings.NewReader("bar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := dc.CollectGarbage(t.Context(), 0, false); err == nil {
+			t.Fatal("expected error")
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("got %v, want %v", err, errors.ErrUnsupported)
+		}
+		if _, err := dc.List(t.Context(), ""); err == nil {
+			t.Fatal("expected error")
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("got %v, want %v", err, errors.ErrUnsupported)
+		}
+		if err := dc.Delete(t.Context(), "a"); err == nil {
+			t.Fatal("expected error")
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("got %v, want %v", err, errors.ErrUnsupported)
+		}
+	})
+}
@@ -0,0 +1,581 @@
+package goproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+const (
+	// encryptedCacheMagic is the magic number at the start of the caches
+	// encrypted by [EncryptCacher].
+	encryptedCacheMagic = "GPXENC01"
+
+	// encryptedCacheChunkSize is the size of the plaintext chunks of the
+	// caches encrypted by [EncryptCacher].
+	encryptedCacheChunkSize = 64 << 10
+)
+
+// EncryptCacher implements [Cacher] by encrypting content with AES-256-GCM
+// before putting it to another [Cacher], so that the content is encrypted at
+// rest.
+//
+// Each cache is encrypted with its own randomly generated data key, which is
+// wrapped by the KeyProvider and stored alongside the encrypted content. The
+// content is encrypted in fixed-size chunks that are authenticated separately,
+// so that it can be read and decrypted as a stream, and the content returned
+// by Get implements [io.Seeker] if the content returned by the underlying
+// Cacher does.
+//
+// Content stored unencrypted in the underlying Cacher is returned by Get as is
+// only for names whose content is not encrypted, or when AllowPlaintext is
+// true. Otherwise, Get returns an error for it.
+//
+// The List and Delete methods documented in [Cacher] are passed through to the
+// underlying Cacher if it implements them.
+type EncryptCacher struct {
+	// Cacher is the underlying [Cacher].
+	Cacher Cacher
+
+	// KeyProvider is the [KeyProvider] used to wrap and unwrap data keys.
+	KeyProvider KeyProvider
+
+	// ModulePatterns is a comma-separated list of glob patterns (in the
+	// syntax of Go's path.Match) of module path prefixes, in the same form
+	// as the GOPRIVATE environment variable. Only the content of modules
+	// that match them is encrypted.
+	//
+	// If ModulePatterns is empty, all content is encrypted, including
+	// content that does not belong to any module, such as checksum database
+	// tiles.
+	ModulePatterns string
+
+	// AllowPlaintext indicates whether Get returns content stored
+	// unencrypted in the underlying Cacher, such as content put before the
+	// EncryptCacher is used, for names whose content is encrypted. It is
+	// intended for migrating existing caches.
+	//
+	// If AllowPlaintext is false, such content is rejected, so that
+	// content written directly to the underlying Cacher cannot be served
+	// in place of encrypted content.
+	AllowPlaintext bool
+}
+
+// Get implements [Cacher].
+func (ec *EncryptCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := ec.Cacher.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(rc)
+	if magic, err := br.Peek(len(encryptedCacheMagic)); err != nil || string(magic) != encryptedCacheMagic {
+		if ec.encrypts(name) && !ec.AllowPlaintext {
+			rc.Close()
+			return nil, fmt.Errorf("unencrypted cache for %s", name)
+		}
+		if s, ok := rc.(io.Seeker); ok {
+			if _, err := s.Seek(0, io.SeekStart); err != nil {
+				rc.Close()
+				return nil, err
+			}
+			return rc, nil
+		}
+		return &struct {
+			io.Reader
+			io.Closer
+		}{br, rc}, nil
+	}
+
+	header, err := readEncryptedCacheHeader(br)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("invalid encrypted cache for %s: %w", name, err)
+	}
+	dataKey, err := ec.KeyProvider.UnwrapKey(ctx, header.keyID, header.wrappedKey)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	aead, err := newEncryptedCacheAEAD(dataKey)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	content := &encryptedContent{
+		header:      header,
+		aead:        aead,
+		ciphertext:  rc,
+		chunkReader: br,
+		bufIndex:    -1,
+	}
+	if lm, ok := rc.(interface{ LastModified() time.Time }); ok {
+		content.lastModified = lm.LastModified()
+	} else if mt, ok := rc.(interface{ ModTime() time.Time }); ok {
+		content.lastModified = mt.ModTime()
+	}
+	if et, ok := rc.(interface{ ETag() string }); ok {
+		content.etag = et.ETag()
+	}
+	if _, ok := rc.(io.Seeker); ok {
+		return &seekableEncryptedContent{content}, nil
+	}
+	return content, nil
+}
+
+// Put implements [Cacher].
+func (ec *EncryptCacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	if !ec.encrypts(name) {
+		return ec.Cacher.Put(ctx, name, content)
+	}
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	keyID, wrappedKey, err := ec.KeyProvider.WrapKey(ctx, dataKey)
+	if err != nil {
+		return err
+	}
+	header, err := newEncryptedCacheHeader(keyID, wrappedKey, size)
+	if err != nil {
+		return err
+	}
+	aead, err := newEncryptedCacheAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	return ec.Cacher.Put(ctx, name, &encryptingReader{
+		header:    header,
+		aead:      aead,
+		plaintext: content,
+		bufIndex:  -1,
+	})
+}
+
+// List lists the names of all caches that start with the prefix, in lexical
+// order. See [Cacher] for details.
+func (ec *EncryptCacher) List(ctx context.Context, prefix string) ([]string, error) {
+	cl, ok := ec.Cacher.(interface {
+		List(ctx context.Context, prefix string) (names []string, err error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("underlying cacher does not support listing: %w", errors.ErrUnsupported)
+	}
+	return cl.List(ctx, prefix)
+}
+
+// Delete deletes the cache for the name. See [Cacher] for details.
+func (ec *EncryptCacher) Delete(ctx context.Context, name string) error {
+	cd, ok := ec.Cacher.(interface {
+		Delete(ctx context.Context, name string) error
+	})
+	if !ok {
+		return fmt.Errorf("underlying cacher does not support deletion: %w", errors.ErrUnsupported)
+	}
+	return cd.Delete(ctx, name)
+}
+
+// encrypts reports whether the cache for the name should be encrypted.
+func (ec *EncryptCacher) encrypts(name string) bool {
+	if ec.ModulePatterns == "" {
+		return true
+	}
+	name = strings.TrimPrefix(name, quarantineCachePrefix)
+	name = strings.TrimPrefix(name, directFetchResultPrefix)
+	escapedModulePath, _, ok := strings.Cut(name, "/@")
+	if !ok {
+		return false
+	}
+	modulePath, err := module.UnescapePath(escapedModulePath)
+	if err != nil {
+		return false
+	}
+	return module.MatchPrefixPatterns(ec.ModulePatterns, modulePath)
+}
+
+// KeyProvider provides key-encryption keys for [EncryptCacher], which uses
+// them to wrap and unwrap the data keys of encrypted caches.
+//
+// A KeyProvider may be backed by a key management service, so that key
+// material never leaves it.
+type KeyProvider interface {
+	// WrapKey encrypts the dataKey with the current key-encryption key. It
+	// returns the ID of the key-encryption key, which is passed to
+	// UnwrapKey along with the wrappedKey.
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrappedKey []byte, err error)
+
+	// UnwrapKey decrypts the wrappedKey with the key-encryption key
+	// identified by the keyID.
+	UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) (dataKey []byte, err error)
+}
+
+// FileKeyProvider implements [KeyProvider] using AES-256 key-encryption keys
+// read from a local key file.
+//
+// The key file contains one base64-encoded 32-byte key per line. Empty lines
+// and lines starting with "#" are ignored. The first key is used to wrap new
+// data keys, and all keys are used to unwrap existing ones, so keys can be
+// rotated by adding a new key as the first line. The ID of a key is the first
+// 16 hex characters of its SHA-256 digest.
+//
+// The key file is read only once. A FileKeyProvider must not be copied after
+// first use.
+type FileKeyProvider struct {
+	// File is the path of the key file.
+	File string
+
+	loadOnce sync.Once
+	keyIDs   []string
+	keys     map[string]cipher.AEAD
+	loadErr  error
+}
+
+// load loads the key file of the fkp.
+func (fkp *FileKeyProvider) load() {
+	b, err := os.ReadFile(fkp.File)
+	if err != nil {
+		fkp.loadErr = err
+		return
+	}
+	fkp.keys = map[string]cipher.AEAD{}
+	for line := range strings.Lines(string(b)) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(key) != 32 {
+			fkp.loadErr = fmt.Errorf("invalid key in key file %s", fkp.File)
+			return
+		}
+		aead, err := newEncryptedCacheAEAD(key)
+		if err != nil {
+			fkp.loadErr = err
+			return
+		}
+		digest := sha256.Sum256(key)
+		keyID := hex.EncodeToString(digest[:8])
+		fkp.keyIDs = append(fkp.keyIDs, keyID)
+		fkp.keys[keyID] = aead
+	}
+	if len(fkp.keyIDs) == 0 {
+		fkp.loadErr = fmt.Errorf("no keys in key file %s", fkp.File)
+	}
+}
+
+// WrapKey implements [KeyProvider].
+func (fkp *FileKeyProvider) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	fkp.loadOnce.Do(fkp.load)
+	if fkp.loadErr != nil {
+		return "", nil, fkp.loadErr
+	}
+	keyID := fkp.keyIDs[0]
+	aead := fkp.keys[keyID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return keyID, aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+// UnwrapKey implements [KeyProvider].
+func (fkp *FileKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error) {
+	fkp.loadOnce.Do(fkp.load)
+	if fkp.loadErr != nil {
+		return nil, fkp.loadErr
+	}
+	aead, ok := fkp.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", keyID)
+	}
+	if len(wrappedKey) < aead.NonceSize() {
+		return nil, errors.New("invalid wrapped key")
+	}
+	nonce, ciphertext := wrappedKey[:aead.NonceSize()], wrappedKey[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, errors.New("invalid wrapped key")
+	}
+	return dataKey, nil
+}
+
+// newEncryptedCacheAEAD returns a new AES-GCM [cipher.AEAD] with the key.
+func newEncryptedCacheAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptedCacheHeader is the header of a cache encrypted by [EncryptCacher].
+//
+// The header is laid out as the magic number, the big-endian uint32 chunk
+// size, the big-endian uint64 plaintext size, and the key ID and the wrapped
+// key, each prefixed with its big-endian uint16 length. It is followed by the
+// encrypted chunks, each of which is sealed with the chunk index as the nonce
+// and the header as the additional data.
+type encryptedCacheHeader struct {
+	raw        []byte
+	chunkSize  int64
+	size       int64
+	keyID      string
+	wrappedKey []byte
+}
+
+// newEncryptedCacheHeader returns a new [encryptedCacheHeader].
+func newEncryptedCacheHeader(keyID string, wrappedKey []byte, size int64) (*encryptedCacheHeader, error) {
+	if len(keyID) > 0xffff || len(wrappedKey) > 0xffff {
+		return nil, errors.New("key ID or wrapped key is too long")
+	}
+	raw := []byte(encryptedCacheMagic)
+	raw = binary.BigEndian.AppendUint32(raw, encryptedCacheChunkSize)
+	raw = binary.BigEndian.AppendUint64(raw, uint64(size))
+	raw = binary.BigEndian.AppendUint16(raw, uint16(len(keyID)))
+	raw = append(raw, keyID...)
+	raw = binary.BigEndian.AppendUint16(raw, uint16(len(wrappedKey)))
+	raw = append(raw, wrappedKey...)
+	return &encryptedCacheHeader{
+		raw:        raw,
+		chunkSize:  encryptedCacheChunkSize,
+		size:       size,
+		keyID:      keyID,
+		wrappedKey: wrappedKey,
+	}, nil
+}
+
+// readEncryptedCacheHeader reads an [encryptedCacheHeader] from the r.
+func readEncryptedCacheHeader(r io.Reader) (*encryptedCacheHeader, error) {
+	var raw bytes.Buffer
+	r = io.TeeReader(r, &raw)
+	fixed := make([]byte, len(encryptedCacheMagic)+4+8)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	chunkSize := int64(binary.BigEndian.Uint32(fixed[len(encryptedCacheMagic):]))
+	size := binary.BigEndian.Uint64(fixed[len(encryptedCacheMagic)+4:])
+	if chunkSize == 0 || chunkSize > 16<<20 || size > 1<<62 {
+		return nil, errors.New("invalid header")
+	}
+	readBytes := func() ([]byte, error) {
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	}
+	keyID, err := readBytes()
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey, err := readBytes()
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedCacheHeader{
+		raw:        raw.Bytes(),
+		chunkSize:  chunkSize,
+		size:       int64(size),
+		keyID:      string(keyID),
+		wrappedKey: wrappedKey,
+	}, nil
+}
+
+// chunkCount returns the number of chunks. There is always at least one chunk,
+// so that the header of empty content is also authenticated.
+func (h *encryptedCacheHeader) chunkCount() int64 {
+	return max(1, (h.size+h.chunkSize-1)/h.chunkSize)
+}
+
+// plaintextChunkSize returns the plaintext size of the chunk at the index.
+func (h *encryptedCacheHeader) plaintextChunkSize(index int64) int64 {
+	return min(h.chunkSize, h.size-index*h.chunkSize)
+}
+
+// ciphertextChunkOffset returns the ciphertext offset of the chunk at the
+// index, including the header.
+func (h *encryptedCacheHeader) ciphertextChunkOffset(index int64) int64 {
+	return int64(len(h.raw)) + index*(h.chunkSize+16)
+}
+
+// ciphertextSize returns the ciphertext size, including the header.
+func (h *encryptedCacheHeader) ciphertextSize() int64 {
+	return int64(len(h.raw)) + h.size + h.chunkCount()*16
+}
+
+// nonce returns the nonce of the chunk at the index.
+func (h *encryptedCacheHeader) nonce(index int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(index))
+	return nonce
+}
+
+// encryptingReader is an [io.ReadSeeker] that reads the ciphertext of the
+// plaintext, encrypting the chunks on demand.
+type encryptingReader struct {
+	header    *encryptedCacheHeader
+	aead      cipher.AEAD
+	plaintext io.ReadSeeker
+	offset    int64
+	buf       []byte
+	bufIndex  int64
+}
+
+// Read implements [io.Reader].
+func (er *encryptingReader) Read(p []byte) (int, error) {
+	if headerSize := int64(len(er.header.raw)); er.offset < headerSize {
+		n := copy(p, er.header.raw[er.offset:])
+		er.offset += int64(n)
+		return n, nil
+	}
+	if er.offset >= er.header.ciphertextSize() {
+		return 0, io.EOF
+	}
+	index := (er.offset - int64(len(er.header.raw))) / (er.header.chunkSize + 16)
+	if index != er.bufIndex {
+		if _, err := er.plaintext.Seek(index*er.header.chunkSize, io.SeekStart); err != nil {
+			return 0, err
+		}
+		plaintext := make([]byte, er.header.plaintextChunkSize(index))
+		if _, err := io.ReadFull(er.plaintext, plaintext); err != nil {
+			return 0, err
+		}
+		er.buf = er.aead.Seal(plaintext[:0], er.header.nonce(index), plaintext, er.header.raw)
+		er.bufIndex = index
+	}
+	n := copy(p, er.buf[er.offset-er.header.ciphertextChunkOffset(index):])
+	er.offset += int64(n)
+	return n, nil
+}
+
+// Seek implements [io.Seeker].
+func (er *encryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += er.offset
+	case io.SeekEnd:
+		offset += er.header.ciphertextSize()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	er.offset = offset
+	return offset, nil
+}
+
+// encryptedContent is the content of a cache encrypted by [EncryptCacher]. It
+// decrypts the chunks on demand.
+type encryptedContent struct {
+	contentMetadata
+	header      *encryptedCacheHeader
+	aead        cipher.AEAD
+	ciphertext  io.ReadCloser
+	chunkReader io.Reader
+	nextIndex   int64
+	offset      int64
+	buf         []byte
+	bufIndex    int64
+}
+
+// Read implements [io.Reader].
+func (ec *encryptedContent) Read(p []byte) (int, error) {
+	// The last chunk is always decrypted before returning io.EOF, so that
+	// truncated content is detected.
+	lastIndex := ec.header.chunkCount() - 1
+	index := min(ec.offset/ec.header.chunkSize, lastIndex)
+	if index != ec.bufIndex {
+		if index != ec.nextIndex {
+			s, ok := ec.ciphertext.(io.Seeker)
+			if !ok {
+				return 0, errors.New("underlying content is not seekable")
+			}
+			if _, err := s.Seek(ec.header.ciphertextChunkOffset(index), io.SeekStart); err != nil {
+				return 0, err
+			}
+			ec.chunkReader = ec.ciphertext
+		}
+		ciphertext := make([]byte, ec.header.plaintextChunkSize(index)+16)
+		if _, err := io.ReadFull(ec.chunkReader, ciphertext); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		plaintext, err := ec.aead.Open(ciphertext[:0], ec.header.nonce(index), ciphertext, ec.header.raw)
+		if err != nil {
+			return 0, errors.New("encrypted content has been tampered with")
+		}
+		ec.buf = plaintext
+		ec.bufIndex = index
+		ec.nextIndex = index + 1
+	}
+	if ec.offset >= ec.header.size {
+		return 0, io.EOF
+	}
+	n := copy(p, ec.buf[ec.offset-index*ec.header.chunkSize:])
+	ec.offset += int64(n)
+	return n, nil
+}
+
+// Close implements [io.Closer].
+func (ec *encryptedContent) Close() error {
+	return ec.ciphertext.Close()
+}
+
+// Size returns the plaintext size of the ec.
+func (ec *encryptedContent) Size() int64 {
+	return ec.header.size
+}
+
+// seekableEncryptedContent is an [encryptedContent] whose underlying content
+// implements [io.Seeker].
+type seekableEncryptedContent struct {
+	*encryptedContent
+}
+
+// Seek implements [io.Seeker].
+func (sec *seekableEncryptedContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += sec.offset
+	case io.SeekEnd:
+		offset += sec.header.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	sec.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,341 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// nonSeekableCacher is a [Cacher] whose returned content does not implement
+// [io.Seeker].
+type nonSeekableCacher struct {
+	Cacher
+}
+
+func (nsc nonSeekableCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := nsc.Cacher.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ io.ReadCloser }{rc}, nil
+}
+
+// writeKeyFile writes a key file with n random keys and returns its path.
+func writeKeyFile(t *testing.T, keys ...[]byte) string {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteString("# keys\n\n")
+	for _, key := range keys {
+		buf.WriteString(base64.StdEncoding.EncodeToString(key) + "\n")
+	}
+	file := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(file, buf.Bytes(), 0o600); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return file
+}
+
+func TestEncryptCacher(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	keyFile := writeKeyFile(t, key)
+
+	t.Run("Normal", func(t *testing.T) {
+		for _, size := range []int{0, 1, encryptedCacheChunkSize, encryptedCacheChunkSize + 1, 3*encryptedCacheChunkSize + 5} {
+			t.Run(strconv.Itoa(size), func(t *testing.T) {
+				content := make([]byte, size)
+				rand.Read(content)
+				cacheDir := t.TempDir()
+				for _, cacher := range []Cacher{DirCacher(cacheDir), nonSeekableCacher{DirCacher(cacheDir)}} {
+					ec := &EncryptCacher{Cacher: cacher, KeyProvider: &FileKeyProvider{File: keyFile}}
+					if err := ec.Put(t.Context(), "example.com/@v/v1.0.0.zip", bytes.NewReader(content)); err != nil {
+						t.Fatalf("unexpected error %v", err)
+					}
+					stored, err := os.ReadFile(filepath.Join(cacheDir, "example.com", "@v", "v1.0.0.zip"))
+					if err != nil {
+						t.Fatalf("unexpected error %v", err)
+					}
+					if !bytes.HasPrefix(stored, []byte(encryptedCacheMagic)) {
+						t.Errorf("stored content %q is not encrypted", stored)
+					}
+					if size >= 16 && bytes.Contains(stored, content) {
+						t.Error("stored content contains plaintext")
+					}
+
+					rc, err := ec.Get(t.Context(), "example.com/@v/v1.0.0.zip")
+					if err != nil {
+						t.Fatalf("unexpected error %v", err)
+					}
+					if got, want := rc.(interface{ Size() int64 }).Size(), int64(size); got != want {
+						t.Errorf("got %d, want %d", got, want)
+					}
+					if b, err := io.ReadAll(rc); err != nil {
+						t.Errorf("unexpected error %v", err)
+					} else if !bytes.Equal(b, content) {
+						t.Error("decrypted content does not match")
+					}
+					rc.Close()
+				}
+			})
+		}
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		content := make([]byte, 3*encryptedCacheChunkSize+5)
+		rand.Read(content)
+		ec := &EncryptCacher{Cacher: DirCacher(t.TempDir()), KeyProvider: &FileKeyProvider{File: keyFile}}
+		if err := ec.Put(t.Context(), "example.com/@v/v1.0.0.zip", bytes.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		rc, err := ec.Get(t.Context(), "example.com/@v/v1.0.0.zip")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer rc.Close()
+		rs, ok := rc.(io.ReadSeeker)
+		if !ok {
+			t.Fatal("expected io.Seeker to be implemented")
+		}
+		for _, tt := range []struct {
+			n      int
+			offset int64
+			length int
+		}{
+			{n: 1, offset: 2*encryptedCacheChunkSize + 10, length: 100},
+			{n: 2, offset: 5, length: encryptedCacheChunkSize},
+			{n: 3, offset: encryptedCacheChunkSize - 1, length: 2},
+			{n: 4, offset: int64(len(content)) - 5, length: 5},
+		} {
+			t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+				if _, err := rs.Seek(tt.offset, io.SeekStart); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				b := make([]byte, tt.length)
+				if _, err := io.ReadFull(rs, b); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if !bytes.Equal(b, content[tt.offset:tt.offset+int64(tt.length)]) {
+					t.Error("decrypted content does not match")
+				}
+			})
+		}
+
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Range", "bytes=70000-70009")
+		rec := httptest.NewRecorder()
+		responseSuccess(rec, req, rc, "application/zip", 60)
+		recr := rec.Result()
+		if got, want := recr.StatusCode, http.StatusPartialContent; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if b, err := io.ReadAll(recr.Body); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if !bytes.Equal(b, content[70000:70010]) {
+			t.Error("decrypted content does not match")
+		}
+	})
+
+	t.Run("ModulePatterns", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		ec := &EncryptCacher{
+			Cacher:         DirCacher(cacheDir),
+			KeyProvider:    &FileKeyProvider{File: keyFile},
+			ModulePatterns: "example.com/private,*.corp.example.com",
+		}
+		for name, wantEncrypted := range map[string]bool{
+			"example.com/private/@v/v1.0.0.mod":            true,
+			"example.com/private/sub/@v/list":              true,
+			"git.corp.example.com/foo/@latest":             true,
+			"quarantine/example.com/private/@v/v1.0.0.mod": true,
+			"direct-fetches/example.com/private/@v/list":   true,
+			"example.com/public/@v/v1.0.0.mod":             false,
+			"sumdb/sum.golang.org/latest":                  false,
+		} {
+			if err := ec.Put(t.Context(), name, strings.NewReader("foobar")); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			stored, err := os.ReadFile(filepath.Join(cacheDir, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got, want := bytes.HasPrefix(stored, []byte(encryptedCacheMagic)), wantEncrypted; got != want {
+				t.Errorf("%s: got %t, want %t", name, got, want)
+			}
+			rc, err := ec.Get(t.Context(), name)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if b, err := io.ReadAll(rc); err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if got, want := string(b), "foobar"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			rc.Close()
+		}
+	})
+
+	t.Run("Unencrypted", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		for _, cacher := range []Cacher{DirCacher(cacheDir), nonSeekableCacher{DirCacher(cacheDir)}} {
+			for _, content := range []string{"", "foo", "foobarbazqux"} {
+				if err := DirCacher(cacheDir).Put(t.Context(), "a", strings.NewReader(content)); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				ec := &EncryptCacher{Cacher: cacher, KeyProvider: &FileKeyProvider{File: keyFile}}
+				if _, err := ec.Get(t.Context(), "a"); err == nil {
+					t.Fatal("expected error")
+				} else if got, want := err.Error(), "unencrypted cache for a"; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+
+				for _, ec := range []*EncryptCacher{
+					{Cacher: cacher, KeyProvider: &FileKeyProvider{File: keyFile}, AllowPlaintext: true},
+					{Cacher: cacher, KeyProvider: &FileKeyProvider{File: keyFile}, ModulePatterns: "example.com"},
+				} {
+					rc, err := ec.Get(t.Context(), "a")
+					if err != nil {
+						t.Fatalf("unexpected error %v", err)
+					}
+					if b, err := io.ReadAll(rc); err != nil {
+						t.Errorf("unexpected error %v", err)
+					} else if got, want := string(b), content; got != want {
+						t.Errorf("got %q, want %q", got, want)
+					}
+					rc.Close()
+				}
+			}
+		}
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		for _, tamper := range []func([]byte) []byte{
+			func(b []byte) []byte { b[len(b)-1] ^= 1; return b },
+			func(b []byte) []byte { return b[:len(b)-1] },
+			func(b []byte) []byte { return b[:len(b)-encryptedCacheChunkSize-16] },
+			func(b []byte) []byte { b[len(encryptedCacheMagic)+4+7]--; return b }, // Plaintext size.
+		} {
+			cacheDir := t.TempDir()
+			ec := &EncryptCacher{Cacher: DirCacher(cacheDir), KeyProvider: &FileKeyProvider{File: keyFile}}
+			if err := ec.Put(t.Context(), "a", bytes.NewReader(make([]byte, 2*encryptedCacheChunkSize+1))); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			file := filepath.Join(cacheDir, "a")
+			b, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if err := os.WriteFile(file, tamper(b), 0o644); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			rc, err := ec.Get(t.Context(), "a")
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if _, err := io.ReadAll(rc); err == nil {
+				t.Error("expected error")
+			}
+			rc.Close()
+		}
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		ec := &EncryptCacher{Cacher: DirCacher(cacheDir), KeyProvider: &FileKeyProvider{File: keyFile}}
+		if err := ec.Put(t.Context(), "a", strings.NewReader("foobar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		otherKey := make([]byte, 32)
+		rand.Read(otherKey)
+		ec = &EncryptCacher{Cacher: DirCacher(cacheDir), KeyProvider: &FileKeyProvider{File: writeKeyFile(t, otherKey)}}
+		if _, err := ec.Get(t.Context(), "a"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), "unknown key ID "; !strings.HasPrefix(got, want) {
+			t.Errorf("got %q, want prefix %q", got, want)
+		}
+	})
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	oldKey := make([]byte, 32)
+	rand.Read(oldKey)
+	newKey := make([]byte, 32)
+	rand.Read(newKey)
+	dataKey := make([]byte, 32)
+	rand.Read(dataKey)
+
+	t.Run("Rotation", func(t *testing.T) {
+		oldKeyID, wrappedKey, err := (&FileKeyProvider{File: writeKeyFile(t, oldKey)}).WrapKey(t.Context(), dataKey)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		fkp := &FileKeyProvider{File: writeKeyFile(t, newKey, oldKey)}
+		newKeyID, _, err := fkp.WrapKey(t.Context(), dataKey)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if oldKeyID == newKeyID {
+			t.Errorf("got the same key ID %q for different keys", newKeyID)
+		}
+		got, err := fkp.UnwrapKey(t.Context(), oldKeyID, wrappedKey)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if !bytes.Equal(got, dataKey) {
+			t.Error("unwrapped key does not match")
+		}
+
+		wrappedKey[len(wrappedKey)-1] ^= 1
+		if _, err := fkp.UnwrapKey(t.Context(), oldKeyID, wrappedKey); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), "invalid wrapped key"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("InvalidKeyFile", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "keys")
+		for _, tt := range []struct {
+			n       int
+			content string
+			wantErr string
+		}{
+			{n: 1, content: "# no keys\n", wantErr: "no keys in key file " + file},
+			{n: 2, content: "invalid\n", wantErr: "invalid key in key file " + file},
+			{n: 3, content: base64.StdEncoding.EncodeToString(make([]byte, 16)), wantErr: "invalid key in key file " + file},
+		} {
+			t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+				if err := os.WriteFile(file, []byte(tt.content), 0o600); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				_, _, err := (&FileKeyProvider{File: file}).WrapKey(t.Context(), dataKey)
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if got, want := err.Error(), tt.wantErr; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			})
+		}
+	})
+
+	t.Run("NonExistentKeyFile", func(t *testing.T) {
+		fkp := &FileKeyProvider{File: filepath.Join(t.TempDir(), "keys")}
+		if _, _, err := fkp.WrapKey(t.Context(), dataKey); err == nil {
+			t.Fatal("expected error")
+		} else if !os.IsNotExist(err) {
+			t.Errorf("got %v, want %v", err, os.ErrNotExist)
+		}
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"slices"
 	"strings"
 	"sync"
@@ -32,6 +33,25 @@ import (
 //
 // Note that any error returned by Fetcher that matches [fs.ErrNotExist]
 // indicates that the module cannot be fetched.
+//
+// A Fetcher may optionally implement the following interfaces:
+//  1. interface{ DownloadStream(ctx context.Context, path, version string, zipDst io.Writer) (info, mod, zip io.ReadSeekCloser, err error) },
+//     which is like Download but also writes the content of the zip file
+//     to the zipDst as it is received, before the module files have been
+//     verified. If a non-nil error is returned, anything that has already
+//     been written to the zipDst must be considered invalid. It is used by
+//     [Goproxy] when [Goproxy.StreamDownloads] is true.
+//  2. interface{ DownloadInfo(ctx context.Context, path, version string) (info io.ReadSeekCloser, err error) },
+//     which is like Download but downloads only the info file. It is
+//     preferred by [Goproxy] over Download when serving info files.
+//  3. interface{ DownloadMod(ctx context.Context, path, version string) (mod io.ReadSeekCloser, err error) },
+//     which is like Download but downloads only the mod file. It is
+//     preferred by [Goproxy] over Download when serving mod files, so that
+//     operations that only need mod files (such as "go mod graph") do not
+//     have to wait for the zip files.
+//  4. interface{ DownloadZip(ctx context.Context, path, version string) (zip io.ReadSeekCloser, err error) },
+//     which is like Download but downloads only the zip file. It is
+//     preferred by [Goproxy] over Download when serving zip files.
 type Fetcher interface {
 	// Query performs the version query for the given module path.
 	//
@@ -113,6 +133,26 @@ type GoFetcher struct {
 	// If MaxConcurrentDirectFetches is zero, there is no limit.
 	MaxConcurrentDirectFetches int
 
+	// DirectFetchLocker is used to lock each direct fetch of a module
+	// version, version query, or version list, so that only one host at a
+	// time performs it. This is useful when multiple hosts share the same
+	// [Cacher], since the lock file in GOMODCACHE only protects a single
+	// host.
+	//
+	// If DirectFetchLocker is nil, direct fetches are not locked.
+	DirectFetchLocker Locker
+
+	// DirectFetchCacher is the [Cacher] used to share the results of direct
+	// fetches between hosts that use the same DirectFetchLocker, typically
+	// the same as [Goproxy.Cacher]. The results are put to it under the
+	// "direct-fetches/" prefix before the locks are released, and a host
+	// that has waited for a lock reads them from it instead of fetching
+	// again. The prefix keeps the results apart from the caches of
+	// [Goproxy], which must only be put by Goproxy itself.
+	//
+	// If DirectFetchCacher is nil, the results are not shared.
+	DirectFetchCacher Cacher
+
 	// TempDir is the directory for storing temporary files.
 	//
 	// If TempDir is empty, [os.TempDir] is used.
@@ -124,6 +164,61 @@ type GoFetcher struct {
 	// If Transport is nil, [http.DefaultTransport] is used.
 	Transport http.RoundTripper
 
+	// RetryPolicy is the policy for retrying failed outgoing HTTP requests,
+	// excluding direct fetches initiated by the local Go binary.
+	//
+	// If RetryPolicy is nil, the zero value of [RetryPolicy] is used.
+	RetryPolicy *RetryPolicy
+
+	// CircuitBreakerThreshold is the number of consecutive failures of an
+	// upstream proxy in the GOPROXY list after which its circuit breaker
+	// trips. While the circuit breaker is open, the proxy is skipped
+	// immediately if it is followed by a "|" in the GOPROXY list, rather
+	// than paying the full retry budget on every fetch. After
+	// CircuitBreakerOpenDuration, a single fetch is let through as a probe,
+	// whose success closes the circuit breaker again. Proxies followed by a
+	// "," are never skipped, since falling back on errors is not allowed
+	// for them.
+	//
+	// The health of each upstream proxy is tracked regardless (see
+	// [GoFetcher.UpstreamHealth]).
+	//
+	// If CircuitBreakerThreshold is zero, circuit breakers never trip.
+	CircuitBreakerThreshold int
+
+	// CircuitBreakerOpenDuration is the duration for which a tripped
+	// circuit breaker stays open before a probe is let through.
+	//
+	// If CircuitBreakerOpenDuration is zero, 30 seconds is used.
+	CircuitBreakerOpenDuration time.Duration
+
+	// HedgeDelay is the delay after which a fetch from the first eligible
+	// upstream proxy in the GOPROXY list is hedged by also fetching from
+	// the next one, with whichever returns a valid response first being
+	// used and the other being canceled. This reduces tail latency at the
+	// cost of extra upstream requests. Only a proxy followed by a "|" is
+	// eligible, since falling back on any error is already allowed for it,
+	// and the next proxy is skipped if its circuit breaker is not closed.
+	// Responses of hedged downloads are verified (including against the
+	// checksum database) before they can win, so that an invalid response
+	// from the faster proxy does not beat a valid one from the slower. As
+	// a result, an invalid response from any proxy followed by a "|" also
+	// falls back to the next entry while hedging is enabled.
+	//
+	// Hedging does not apply to [GoFetcher.DownloadStream] when its zipDst
+	// is not nil, since streamed content cannot be taken back.
+	//
+	// If HedgeDelay is zero, fetches are never hedged.
+	HedgeDelay time.Duration
+
+	// OnSumDBSecurityError is called with a detailed message whenever the
+	// checksum database is found to be misbehaving, such as by presenting
+	// inconsistent signed tree heads. The verification that triggered it
+	// fails regardless.
+	//
+	// If OnSumDBSecurityError is nil, such messages are discarded.
+	OnSumDBSecurityError func(msg string)
+
 	initOnce              sync.Once
 	initErr               error
 	env                   []string
@@ -132,6 +227,7 @@ type GoFetcher struct {
 	directFetchWorkerPool chan struct{}
 	httpClient            *http.Client
 	sumdbClient           *sumdb.Client
+	upstreamHealth        *upstreamHealthTracker
 }
 
 // init initializes the f.
@@ -183,22 +279,44 @@ func (gf *GoFetcher) init() {
 		"GOPRIVATE=",
 	)
 
+	circuitBreakerOpenDuration := gf.CircuitBreakerOpenDuration
+	if circuitBreakerOpenDuration == 0 {
+		circuitBreakerOpenDuration = 30 * time.Second
+	}
+	gf.upstreamHealth = newUpstreamHealthTracker(gf.envGOPROXY, gf.CircuitBreakerThreshold, circuitBreakerOpenDuration)
+
 	if gf.MaxConcurrentDirectFetches > 0 {
 		gf.directFetchWorkerPool = make(chan struct{}, gf.MaxConcurrentDirectFetches)
 	}
 
 	gf.httpClient = &http.Client{Transport: gf.Transport}
 	if envGOSUMDB != "off" {
-		sco, err := newSumdbClientOps(gf.envGOPROXY, envGOSUMDB, gf.httpClient)
+		sco, err := newSumdbClientOps(gf.envGOPROXY, envGOSUMDB, gf.httpClient, gf.RetryPolicy)
 		if err != nil {
 			gf.initErr = err
 			return
 		}
+		sco.securityError = gf.OnSumDBSecurityError
 		gf.sumdbClient = sumdb.NewClient(sco)
 		gf.sumdbClient.SetGONOSUMDB(envGONOSUMDB)
 	}
 }
 
+// LookupSumDB looks up the go.sum lines for the given module path and version
+// in the checksum database specified by GOSUMDB, which is connected to through
+// the proxies in GOPROXY that support proxying it, as the go command does. The
+// returned error matches [sumdb.ErrGONOSUMDB] if the module path matches
+// GONOSUMDB (or GOPRIVATE), or if GOSUMDB is "off".
+func (gf *GoFetcher) LookupSumDB(path, version string) (lines []string, err error) {
+	if gf.initOnce.Do(gf.init); gf.initErr != nil {
+		return nil, gf.initErr
+	}
+	if gf.sumdbClient == nil {
+		return nil, sumdb.ErrGONOSUMDB
+	}
+	return gf.sumdbClient.Lookup(path, version)
+}
+
 // skipProxy reports whether the module path should be fetched directly rather
 // than using a proxy.
 func (gf *GoFetcher) skipProxy(path string) bool {
@@ -211,20 +329,42 @@ func (gf *GoFetcher) Query(ctx context.Context, path, query string) (version str
 		err = gf.initErr
 		return
 	}
+	ft := fetchTraceFromContext(ctx)
 	if gf.skipProxy(path) {
 		version, time, err = gf.directQuery(ctx, path, query)
+		if err == nil {
+			ft.setSource(fetchSourceDirect, "")
+		}
 	} else {
-		err = walkEnvGOPROXY(gf.envGOPROXY, func(proxy *url.URL) error {
-			version, time, err = gf.proxyQuery(ctx, path, query, proxy)
-			return err
-		}, func() error {
-			version, time, err = gf.directQuery(ctx, path, query)
-			return err
+		var (
+			qr    queryResult
+			proxy *url.URL
+		)
+		qr, proxy, err = walkEnvGOPROXYHedged(ctx, gf.envGOPROXY, gf.upstreamHealth, gf.HedgeDelay, func(ctx context.Context, proxy *url.URL) (qr queryResult, err error) {
+			qr.version, qr.time, err = gf.proxyQuery(ctx, path, query, proxy)
+			return
+		}, nil, func() (qr queryResult, err error) {
+			qr.version, qr.time, err = gf.directQuery(ctx, path, query)
+			return
 		})
+		if err == nil {
+			version, time = qr.version, qr.time
+			if proxy != nil {
+				ft.setSource(fetchSourceProxy, proxy.Redacted())
+			} else {
+				ft.setSource(fetchSourceDirect, "")
+			}
+		}
 	}
 	return
 }
 
+// queryResult is the result of a version query.
+type queryResult struct {
+	version string
+	time    time.Time
+}
+
 // proxyQuery performs the version query for the given module path using the
 // given proxy.
 func (gf *GoFetcher) proxyQuery(ctx context.Context, path, query string, proxy *url.URL) (version string, time time.Time, err error) {
@@ -243,7 +383,7 @@ func (gf *GoFetcher) proxyQuery(ctx context.Context, path, query string, proxy *
 		u = proxy.JoinPath(escapedPath + "/@v/" + escapedQuery + ".info")
 	}
 	var info bytes.Buffer
-	err = httpGet(ctx, gf.httpClient, u.String(), &info)
+	err = httpGet(ctx, gf.httpClient, gf.RetryPolicy, u.String(), &info)
 	if err != nil {
 		return
 	}
@@ -258,6 +398,28 @@ func (gf *GoFetcher) proxyQuery(ctx context.Context, path, query string, proxy *
 // directQuery performs the version query for the given module path using the
 // local Go binary.
 func (gf *GoFetcher) directQuery(ctx context.Context, path, query string) (version string, t time.Time, err error) {
+	var name string
+	if escapedPath, err := module.EscapePath(path); err == nil {
+		if escapedQuery, err := module.EscapeVersion(query); err == nil {
+			if escapedQuery == "latest" {
+				name = escapedPath + "/@latest"
+			} else {
+				name = escapedPath + "/@v/" + escapedQuery + ".info"
+			}
+		}
+	}
+	lockTime := time.Now()
+	unlock, err := gf.lockDirectFetch(ctx, name)
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if info, err := gf.sharedDirectFetchResult(ctx, name, lockTime); err == nil {
+		if version, t, err := unmarshalInfo(string(info)); err == nil {
+			return version, t, nil
+		}
+	}
+
 	output, err := gf.execGo(ctx, "list", "-json", "-m", path+"@"+query)
 	if err != nil {
 		return
@@ -266,7 +428,11 @@ func (gf *GoFetcher) directQuery(ctx context.Context, path, query string) (versi
 		Version string
 		Time    time.Time
 	}
-	return info.Version, info.Time, json.Unmarshal(output, &info)
+	if err = json.Unmarshal(output, &info); err != nil {
+		return
+	}
+	gf.shareDirectFetchResult(ctx, name, strings.NewReader(marshalInfo(info.Version, info.Time)))
+	return info.Version, info.Time, nil
 }
 
 // List implements [Fetcher].
@@ -276,16 +442,26 @@ func (gf *GoFetcher) List(ctx context.Context, path string) (versions []string,
 		return
 	}
 
+	ft := fetchTraceFromContext(ctx)
 	if gf.skipProxy(path) {
 		versions, err = gf.directList(ctx, path)
+		if err == nil {
+			ft.setSource(fetchSourceDirect, "")
+		}
 	} else {
-		err = walkEnvGOPROXY(gf.envGOPROXY, func(proxy *url.URL) error {
-			versions, err = gf.proxyList(ctx, path, proxy)
-			return err
-		}, func() error {
-			versions, err = gf.directList(ctx, path)
-			return err
+		var proxy *url.URL
+		versions, proxy, err = walkEnvGOPROXYHedged(ctx, gf.envGOPROXY, gf.upstreamHealth, gf.HedgeDelay, func(ctx context.Context, proxy *url.URL) ([]string, error) {
+			return gf.proxyList(ctx, path, proxy)
+		}, nil, func() ([]string, error) {
+			return gf.directList(ctx, path)
 		})
+		if err == nil {
+			if proxy != nil {
+				ft.setSource(fetchSourceProxy, proxy.Redacted())
+			} else {
+				ft.setSource(fetchSourceDirect, "")
+			}
+		}
 	}
 	if err != nil {
 		return
@@ -314,7 +490,7 @@ func (gf *GoFetcher) proxyList(ctx context.Context, path string, proxy *url.URL)
 		return
 	}
 	var list bytes.Buffer
-	err = httpGet(ctx, gf.httpClient, proxy.JoinPath(escapedPath+"/@v/list").String(), &list)
+	err = httpGet(ctx, gf.httpClient, gf.RetryPolicy, proxy.JoinPath(escapedPath+"/@v/list").String(), &list)
 	if err != nil {
 		return
 	}
@@ -325,16 +501,55 @@ func (gf *GoFetcher) proxyList(ctx context.Context, path string, proxy *url.URL)
 // directList lists the available versions for the given module path using the
 // local Go binary.
 func (gf *GoFetcher) directList(ctx context.Context, path string) (versions []string, err error) {
+	var name string
+	if escapedPath, err := module.EscapePath(path); err == nil {
+		name = escapedPath + "/@v/list"
+	}
+	lockTime := time.Now()
+	unlock, err := gf.lockDirectFetch(ctx, name)
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if list, err := gf.sharedDirectFetchResult(ctx, name, lockTime); err == nil {
+		return strings.Fields(string(list)), nil
+	}
+
 	output, err := gf.execGo(ctx, "list", "-json", "-m", "-versions", path+"@latest")
 	if err != nil {
 		return
 	}
 	var list struct{ Versions []string }
-	return list.Versions, json.Unmarshal(output, &list)
+	if err = json.Unmarshal(output, &list); err != nil {
+		return
+	}
+	gf.shareDirectFetchResult(ctx, name, strings.NewReader(strings.Join(list.Versions, "\n")))
+	return list.Versions, nil
 }
 
 // Download implements [Fetcher].
 func (gf *GoFetcher) Download(ctx context.Context, path, version string) (info, mod, zip io.ReadSeekCloser, err error) {
+	return gf.download(ctx, path, version, nil)
+}
+
+// DownloadStream is like [GoFetcher.Download] but also writes the content of
+// the zip file to the zipDst as it is received. It implements the optional
+// interface documented in [Fetcher].
+//
+// For proxy downloads, the zip file is streamed from the upstream proxy as it
+// is downloaded. For direct downloads, the zip file is written to the zipDst
+// only after it has been downloaded and verified by the local Go binary.
+//
+// Once anything has been written to the zipDst, GoFetcher no longer falls
+// back to other entries in GOPROXY, since the content already written cannot
+// be taken back.
+func (gf *GoFetcher) DownloadStream(ctx context.Context, path, version string, zipDst io.Writer) (info, mod, zip io.ReadSeekCloser, err error) {
+	return gf.download(ctx, path, version, zipDst)
+}
+
+// download implements [GoFetcher.Download] and [GoFetcher.DownloadStream]. The
+// zipDst is optional.
+func (gf *GoFetcher) download(ctx context.Context, path, version string, zipDst io.Writer) (info, mod, zip io.ReadSeekCloser, err error) {
 	if gf.initOnce.Do(gf.init); gf.initErr != nil {
 		err = gf.initErr
 		return
@@ -344,34 +559,87 @@ func (gf *GoFetcher) Download(ctx context.Context, path, version string) (info,
 		return
 	}
 
-	var (
+	type downloadResult struct {
 		infoFile, modFile, zipFile string
 
-		// fromProxy indicates whether the module files were fetched
-		// from an upstream proxy rather than directly using the local
-		// Go binary.
-		fromProxy bool
-
 		// cleanup is the cleanup function that will be called when the
 		// infoFile, modFile, and zipFile are no longer needed, or when
 		// an error occurs.
 		cleanup func()
+
+		// verified reports whether the module files have already been
+		// verified, in which case the infoVersion and infoTime are set.
+		verified    bool
+		infoVersion string
+		infoTime    time.Time
+	}
+	var (
+		dr downloadResult
+
+		// fromProxy is the redacted URL of the upstream proxy that
+		// the module files were fetched from. It is empty if they were
+		// fetched directly using the local Go binary.
+		fromProxy string
+	)
+	var (
+		zipStream *countingWriter
+
+		// zipStreamErr is the error that interrupted the zipStream
+		// after something has been written to it.
+		zipStreamErr error
 	)
+	hedgeDelay := gf.HedgeDelay
+	if zipDst != nil {
+		zipStream = &countingWriter{w: zipDst}
+		hedgeDelay = 0 // Streamed content cannot be taken back.
+	}
 	if gf.skipProxy(path) {
-		infoFile, modFile, zipFile, err = gf.directDownload(ctx, path, version)
+		dr.infoFile, dr.modFile, dr.zipFile, dr.cleanup, err = gf.directDownload(ctx, path, version)
 	} else {
-		err = walkEnvGOPROXY(gf.envGOPROXY, func(proxy *url.URL) error {
-			infoFile, modFile, zipFile, cleanup, err = gf.proxyDownload(ctx, path, version, proxy)
-			fromProxy = err == nil
-			return err
-		}, func() error {
-			infoFile, modFile, zipFile, err = gf.directDownload(ctx, path, version)
-			return err
+		var proxy *url.URL
+		dr, proxy, err = walkEnvGOPROXYHedged(ctx, gf.envGOPROXY, gf.upstreamHealth, hedgeDelay, func(ctx context.Context, proxy *url.URL) (dr downloadResult, err error) {
+			if zipStreamErr != nil {
+				return dr, zipStreamErr
+			}
+			var zipTee io.Writer
+			if zipStream != nil {
+				zipTee = zipStream
+			}
+			dr.infoFile, dr.modFile, dr.zipFile, dr.cleanup, err = gf.proxyDownload(ctx, path, version, proxy, zipTee)
+			if err != nil {
+				if zipStream != nil && zipStream.n > 0 {
+					zipStreamErr = err
+				}
+				return
+			}
+			if hedgeDelay > 0 {
+				// Verify before returning so that an invalid
+				// response cannot win a hedged race.
+				dr.infoVersion, dr.infoTime, err = gf.verifyDownload(path, version, dr.infoFile, dr.modFile, dr.zipFile, true)
+				if err != nil {
+					dr.cleanup()
+					return downloadResult{}, err
+				}
+				dr.verified = true
+			}
+			return
+		}, func(dr downloadResult) {
+			dr.cleanup()
+		}, func() (dr downloadResult, err error) {
+			if zipStreamErr != nil {
+				return dr, zipStreamErr
+			}
+			dr.infoFile, dr.modFile, dr.zipFile, dr.cleanup, err = gf.directDownload(ctx, path, version)
+			return
 		})
+		if err == nil && proxy != nil {
+			fromProxy = proxy.Redacted()
+		}
 	}
 	if err != nil {
 		return
 	}
+	cleanup := dr.cleanup
 	if cleanup != nil {
 		defer func() {
 			if err != nil {
@@ -382,42 +650,42 @@ func (gf *GoFetcher) Download(ctx context.Context, path, version string) (info,
 		cleanup = func() {} // Avoid nil cleanup.
 	}
 
-	infoVersion, infoTime, err := unmarshalInfoFile(infoFile)
-	if err != nil {
-		return
-	}
-	err = checkModFile(modFile)
-	if err != nil {
-		return
-	}
-	err = checkZipFile(zipFile, path, version)
-	if err != nil {
-		return
-	}
-
-	// Verify against the checksum database only for proxy downloads. Direct
-	// downloads are verified by the local Go binary itself.
-	if gf.sumdbClient != nil && fromProxy {
-		err = verifyModFile(gf.sumdbClient, modFile, path, version)
-		if err != nil {
-			return
-		}
-		err = verifyZipFile(gf.sumdbClient, zipFile, path, version)
+	infoVersion, infoTime := dr.infoVersion, dr.infoTime
+	if !dr.verified {
+		infoVersion, infoTime, err = gf.verifyDownload(path, version, dr.infoFile, dr.modFile, dr.zipFile, fromProxy != "")
 		if err != nil {
 			return
 		}
 	}
 
+	if fromProxy != "" {
+		fetchTraceFromContext(ctx).setSource(fetchSourceProxy, fromProxy)
+	} else {
+		fetchTraceFromContext(ctx).setSource(fetchSourceDirect, "")
+	}
+
 	infoContent := strings.NewReader(marshalInfo(infoVersion, infoTime))
-	modContent, err := os.Open(modFile)
+	modContent, err := os.Open(dr.modFile)
 	if err != nil {
 		return
 	}
-	zipContent, err := os.Open(zipFile)
+	zipContent, err := os.Open(dr.zipFile)
 	if err != nil {
 		modContent.Close()
 		return
 	}
+	if zipStream != nil && fromProxy == "" {
+		// Direct downloads cannot be streamed, so write the zip file
+		// only after it has been verified.
+		if _, err = io.Copy(zipStream, zipContent); err == nil {
+			_, err = zipContent.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			modContent.Close()
+			zipContent.Close()
+			return
+		}
+	}
 
 	var (
 		closers int32 = 3
@@ -454,9 +722,58 @@ func (gf *GoFetcher) Download(ctx context.Context, path, version string) (info,
 	return
 }
 
+// verifyDownload verifies the module files for the given module path and
+// version, and returns the version and time from the info file. The mod and
+// zip files are also verified against the checksum database if they were
+// fetched from a proxy. Direct downloads are verified by the local Go binary
+// itself.
+func (gf *GoFetcher) verifyDownload(path, version, infoFile, modFile, zipFile string, fromProxy bool) (infoVersion string, infoTime time.Time, err error) {
+	infoVersion, infoTime, err = unmarshalInfoFile(infoFile)
+	if err != nil {
+		return
+	}
+	if err = gf.verifyDownloadFile(path, version, ".mod", modFile, fromProxy); err != nil {
+		return
+	}
+	err = gf.verifyDownloadFile(path, version, ".zip", zipFile, fromProxy)
+	return
+}
+
+// verifyDownloadFile is like [GoFetcher.verifyDownload] but verifies only the
+// module file with the ext (".info", ".mod", or ".zip").
+func (gf *GoFetcher) verifyDownloadFile(path, version, ext, file string, fromProxy bool) error {
+	switch ext {
+	case ".info":
+		_, _, err := unmarshalInfoFile(file)
+		return err
+	case ".mod":
+		if err := checkModFile(file); err != nil {
+			return err
+		}
+		if gf.sumdbClient != nil && fromProxy {
+			return verifyModFile(gf.sumdbClient, file, path, version)
+		}
+	case ".zip":
+		if err := checkZipFile(file, path, version); err != nil {
+			return err
+		}
+		if gf.sumdbClient != nil && fromProxy {
+			return verifyZipFile(gf.sumdbClient, file, path, version)
+		}
+	}
+	return nil
+}
+
 // proxyDownload downloads the module files for the given module path and
-// version using the given proxy.
-func (gf *GoFetcher) proxyDownload(ctx context.Context, path, version string, proxy *url.URL) (infoFile, modFile, zipFile string, cleanup func(), err error) {
+// version using the given proxy. The content of the zip file is also written
+// to the zipTee as it is received, unless the zipTee is nil.
+//
+// The module files are downloaded concurrently. Once any of them fails, the
+// others are canceled, since the download from the proxy as a whole has
+// failed. When the zipTee is not nil, the zip file is downloaded only after
+// the info and mod files have been downloaded, so that nothing is written to
+// the zipTee if the proxy cannot serve them.
+func (gf *GoFetcher) proxyDownload(ctx context.Context, path, version string, proxy *url.URL, zipTee io.Writer) (infoFile, modFile, zipFile string, cleanup func(), err error) {
 	escapedPath, err := module.EscapePath(path)
 	if err != nil {
 		return
@@ -477,16 +794,205 @@ func (gf *GoFetcher) proxyDownload(ctx context.Context, path, version string, pr
 		}
 	}()
 
-	infoFile, err = httpGetTemp(ctx, gf.httpClient, urlWithoutExt+".info", tempDir)
+	getCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	get := func(ext string, tee io.Writer) string {
+		file, err := httpGetTempTee(getCtx, gf.httpClient, gf.RetryPolicy, urlWithoutExt+ext, tempDir, tee)
+		if err != nil {
+			cancel(err) // Only the first error is kept as the cause.
+			return ""
+		}
+		return file
+	}
+	var wg sync.WaitGroup
+	wg.Go(func() { infoFile = get(".info", nil) })
+	wg.Go(func() { modFile = get(".mod", nil) })
+	if zipTee == nil {
+		wg.Go(func() { zipFile = get(".zip", nil) })
+	}
+	wg.Wait()
+	if zipTee != nil && getCtx.Err() == nil {
+		zipFile = get(".zip", zipTee)
+	}
+	if err = context.Cause(getCtx); err != nil {
+		return
+	}
+	cleanup = func() { os.RemoveAll(tempDir) }
+	return
+}
+
+// DownloadInfo is like [GoFetcher.Download] but downloads only the info file.
+// It implements the optional interface documented in [Fetcher].
+func (gf *GoFetcher) DownloadInfo(ctx context.Context, path, version string) (info io.ReadSeekCloser, err error) {
+	return gf.downloadFile(ctx, path, version, ".info")
+}
+
+// DownloadMod is like [GoFetcher.Download] but downloads only the mod file. It
+// implements the optional interface documented in [Fetcher].
+func (gf *GoFetcher) DownloadMod(ctx context.Context, path, version string) (mod io.ReadSeekCloser, err error) {
+	return gf.downloadFile(ctx, path, version, ".mod")
+}
+
+// DownloadZip is like [GoFetcher.Download] but downloads only the zip file. It
+// implements the optional interface documented in [Fetcher].
+func (gf *GoFetcher) DownloadZip(ctx context.Context, path, version string) (zip io.ReadSeekCloser, err error) {
+	return gf.downloadFile(ctx, path, version, ".zip")
+}
+
+// downloadFile downloads the module file with the ext (".info", ".mod", or
+// ".zip") for the given module path and version.
+//
+// Only proxy downloads are done per file. Direct downloads still download all
+// three kinds of module files using the local Go binary.
+func (gf *GoFetcher) downloadFile(ctx context.Context, path, version, ext string) (content io.ReadSeekCloser, err error) {
+	if gf.initOnce.Do(gf.init); gf.initErr != nil {
+		err = gf.initErr
+		return
+	}
+
+	if err = checkCanonicalVersion(path, version); err != nil {
+		return
+	}
+
+	var (
+		file string
+
+		// fromProxy is the redacted URL of the upstream proxy that
+		// the module file was fetched from. It is empty if it was
+		// fetched directly using the local Go binary.
+		fromProxy string
+
+		// cleanup is the cleanup function that will be called when the
+		// file is no longer needed, or when an error occurs.
+		cleanup = func() {}
+	)
+	directDownloadFile := func() error {
+		infoFile, modFile, zipFile, directCleanup, err := gf.directDownload(ctx, path, version)
+		if directCleanup != nil {
+			cleanup = directCleanup
+		}
+		switch ext {
+		case ".info":
+			file = infoFile
+		case ".mod":
+			file = modFile
+		case ".zip":
+			file = zipFile
+		}
+		return err
+	}
+	// verified reports whether the file has already been verified.
+	var verified bool
+	if gf.skipProxy(path) {
+		err = directDownloadFile()
+	} else {
+		type downloadFileResult struct {
+			file    string
+			cleanup func()
+		}
+		var (
+			dfr   downloadFileResult
+			proxy *url.URL
+		)
+		dfr, proxy, err = walkEnvGOPROXYHedged(ctx, gf.envGOPROXY, gf.upstreamHealth, gf.HedgeDelay, func(ctx context.Context, proxy *url.URL) (dfr downloadFileResult, err error) {
+			dfr.file, dfr.cleanup, err = gf.proxyDownloadFile(ctx, path, version, ext, proxy)
+			if err != nil {
+				return
+			}
+			if gf.HedgeDelay > 0 {
+				// Verify before returning so that an invalid
+				// response cannot win a hedged race.
+				if err = gf.verifyDownloadFile(path, version, ext, dfr.file, true); err != nil {
+					dfr.cleanup()
+					return downloadFileResult{}, err
+				}
+			}
+			return
+		}, func(dfr downloadFileResult) {
+			dfr.cleanup()
+		}, func() (dfr downloadFileResult, err error) {
+			err = directDownloadFile()
+			return
+		})
+		if err == nil && proxy != nil {
+			file, cleanup, fromProxy = dfr.file, dfr.cleanup, proxy.Redacted()
+			verified = gf.HedgeDelay > 0
+		}
+	}
 	if err != nil {
 		return
 	}
-	modFile, err = httpGetTemp(ctx, gf.httpClient, urlWithoutExt+".mod", tempDir)
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
+	}()
+
+	if !verified {
+		if err = gf.verifyDownloadFile(path, version, ext, file, fromProxy != ""); err != nil {
+			return
+		}
+	}
+	if ext == ".info" {
+		var (
+			infoVersion string
+			infoTime    time.Time
+		)
+		infoVersion, infoTime, err = unmarshalInfoFile(file)
+		if err != nil {
+			return
+		}
+		cleanup()
+		cleanup = func() {}
+		content = struct {
+			io.ReadSeeker
+			io.Closer
+		}{strings.NewReader(marshalInfo(infoVersion, infoTime)), closerFunc(func() error { return nil })}
+	}
+
+	if fromProxy != "" {
+		fetchTraceFromContext(ctx).setSource(fetchSourceProxy, fromProxy)
+	} else {
+		fetchTraceFromContext(ctx).setSource(fetchSourceDirect, "")
+	}
+
+	if content != nil {
+		return
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	closeOnce := sync.OnceValue(func() error {
+		defer cleanup()
+		return f.Close()
+	})
+	content = struct {
+		io.ReadSeeker
+		io.Closer
+	}{f, closerFunc(closeOnce)}
+	return
+}
+
+// proxyDownloadFile downloads the module file with the ext for the given
+// module path and version using the given proxy.
+func (gf *GoFetcher) proxyDownloadFile(ctx context.Context, path, version, ext string, proxy *url.URL) (file string, cleanup func(), err error) {
+	escapedPath, err := module.EscapePath(path)
+	if err != nil {
+		return
+	}
+	escapedVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return
+	}
+
+	tempDir, err := os.MkdirTemp(gf.TempDir, tempDirPattern)
 	if err != nil {
 		return
 	}
-	zipFile, err = httpGetTemp(ctx, gf.httpClient, urlWithoutExt+".zip", tempDir)
+	file, err = httpGetTemp(ctx, gf.httpClient, gf.RetryPolicy, proxy.JoinPath(escapedPath+"/@v/"+escapedVersion+ext).String(), tempDir)
 	if err != nil {
+		os.RemoveAll(tempDir)
 		return
 	}
 	cleanup = func() { os.RemoveAll(tempDir) }
@@ -494,14 +1000,135 @@ func (gf *GoFetcher) proxyDownload(ctx context.Context, path, version string, pr
 }
 
 // directDownload downloads the module files for the given module path and
-// version using the local Go binary.
-func (gf *GoFetcher) directDownload(ctx context.Context, path, version string) (infoFile, modFile, zipFile string, err error) {
+// version using the local Go binary. The cleanup is nil unless the module
+// files are temporary files.
+func (gf *GoFetcher) directDownload(ctx context.Context, path, version string) (infoFile, modFile, zipFile string, cleanup func(), err error) {
+	var nameWithoutExt string
+	if escapedPath, err := module.EscapePath(path); err == nil {
+		if escapedVersion, err := module.EscapeVersion(version); err == nil {
+			nameWithoutExt = escapedPath + "/@v/" + escapedVersion
+		}
+	}
+	unlock, err := gf.lockDirectFetch(ctx, nameWithoutExt)
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if infoFile, modFile, zipFile, cleanup, err := gf.sharedDirectDownload(ctx, nameWithoutExt); err == nil {
+		return infoFile, modFile, zipFile, cleanup, nil
+	}
+
 	output, err := gf.execGo(ctx, "mod", "download", "-json", path+"@"+version)
 	if err != nil {
 		return
 	}
 	var download struct{ Info, GoMod, Zip string }
-	return download.Info, download.GoMod, download.Zip, json.Unmarshal(output, &download)
+	if err = json.Unmarshal(output, &download); err != nil {
+		return
+	}
+	if nameWithoutExt != "" {
+		for ext, file := range map[string]string{".info": download.Info, ".mod": download.GoMod, ".zip": download.Zip} {
+			if f, err := os.Open(file); err == nil {
+				gf.shareDirectFetchResult(ctx, nameWithoutExt+ext, f)
+				f.Close()
+			}
+		}
+	}
+	return download.Info, download.GoMod, download.Zip, nil, nil
+}
+
+// directFetchResultPrefix is the name prefix of the results of direct fetches
+// shared through [GoFetcher.DirectFetchCacher].
+const directFetchResultPrefix = "direct-fetches/"
+
+// lockDirectFetch acquires the lock for the direct fetch whose result is shared
+// for the name using the gf.DirectFetchLocker. The name is empty if the direct
+// fetch cannot be locked.
+func (gf *GoFetcher) lockDirectFetch(ctx context.Context, name string) (unlock func(), err error) {
+	if gf.DirectFetchLocker == nil || name == "" {
+		return func() {}, nil
+	}
+	return gf.DirectFetchLocker.Lock(ctx, name)
+}
+
+// sharedDirectFetchResult returns the result of a direct fetch shared through
+// the gf.DirectFetchCacher for the name. It returns [fs.ErrNotExist] if not
+// found, or if the result was shared before the notBefore.
+func (gf *GoFetcher) sharedDirectFetchResult(ctx context.Context, name string, notBefore time.Time) ([]byte, error) {
+	if gf.DirectFetchCacher == nil || name == "" {
+		return nil, fs.ErrNotExist
+	}
+	content, err := gf.DirectFetchCacher.Get(ctx, directFetchResultPrefix+name)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	var modTime time.Time
+	if lm, ok := content.(interface{ LastModified() time.Time }); ok {
+		modTime = lm.LastModified()
+	} else if mt, ok := content.(interface{ ModTime() time.Time }); ok {
+		modTime = mt.ModTime()
+	}
+
+	// Some Cachers only keep the modification time to the second.
+	if modTime.Before(notBefore.Truncate(time.Second)) {
+		return nil, fs.ErrNotExist
+	}
+
+	return io.ReadAll(content)
+}
+
+// sharedDirectDownload returns the module files of a direct download shared
+// through the gf.DirectFetchCacher for the nameWithoutExt, which are copied to
+// temporary files. It returns [fs.ErrNotExist] if not found.
+func (gf *GoFetcher) sharedDirectDownload(ctx context.Context, nameWithoutExt string) (infoFile, modFile, zipFile string, cleanup func(), err error) {
+	if gf.DirectFetchCacher == nil || nameWithoutExt == "" {
+		err = fs.ErrNotExist
+		return
+	}
+	tempDir, err := os.MkdirTemp(gf.TempDir, tempDirPattern)
+	if err != nil {
+		return
+	}
+	files := make([]string, 0, 3)
+	for _, ext := range []string{".info", ".mod", ".zip"} {
+		file := filepath.Join(tempDir, "download"+ext)
+		if err = gf.copySharedDirectFetchResult(ctx, nameWithoutExt+ext, file); err != nil {
+			os.RemoveAll(tempDir)
+			return
+		}
+		files = append(files, file)
+	}
+	return files[0], files[1], files[2], func() { os.RemoveAll(tempDir) }, nil
+}
+
+// copySharedDirectFetchResult copies the result of a direct fetch shared
+// through the gf.DirectFetchCacher for the name to the file.
+func (gf *GoFetcher) copySharedDirectFetchResult(ctx context.Context, name, file string) error {
+	content, err := gf.DirectFetchCacher.Get(ctx, directFetchResultPrefix+name)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// shareDirectFetchResult shares the result of a direct fetch through the
+// gf.DirectFetchCacher for the name. Failures are ignored, since sharing is
+// only an optimization.
+func (gf *GoFetcher) shareDirectFetchResult(ctx context.Context, name string, content io.ReadSeeker) {
+	if gf.DirectFetchCacher == nil || name == "" {
+		return
+	}
+	gf.DirectFetchCacher.Put(ctx, directFetchResultPrefix+name, content)
 }
 
 // execGo executes the local Go binary with the given args and returns the output.
@@ -595,45 +1222,155 @@ func cleanEnvGOPROXY(envGOPROXY string) (string, error) {
 	return cleaned, nil
 }
 
-// walkEnvGOPROXY walks through the proxy list parsed from the envGOPROXY.
-func walkEnvGOPROXY(envGOPROXY string, onProxy func(proxy *url.URL) error, onDirect func() error) error {
+// walkEnvGOPROXY walks through the proxy list parsed from the envGOPROXY. If
+// the uht is not nil, the results of the onProxy calls are recorded to it, and
+// proxies whose circuit breakers are open are skipped if falling back to the
+// next entry on errors is allowed.
+func walkEnvGOPROXY(envGOPROXY string, uht *upstreamHealthTracker, onProxy func(proxy *url.URL) error, onDirect func() error) error {
+	_, _, err := walkEnvGOPROXYHedged(context.Background(), envGOPROXY, uht, 0, func(_ context.Context, proxy *url.URL) (struct{}, error) {
+		return struct{}{}, onProxy(proxy)
+	}, nil, func() (struct{}, error) {
+		return struct{}{}, onDirect()
+	})
+	return err
+}
+
+// walkEnvGOPROXYHedged is like [walkEnvGOPROXY] but returns the result of the
+// successful onProxy or onDirect call, along with the proxy it was called with
+// (nil for the onDirect).
+//
+// If the hedgeDelay is greater than zero, the first proxy that is followed by
+// a "|" is raced with the next proxy in the list using [hedge], provided that
+// the circuit breaker of the latter is closed. Results of onProxy calls that
+// lose the race are passed to the discard (if not nil).
+func walkEnvGOPROXYHedged[T any](ctx context.Context, envGOPROXY string, uht *upstreamHealthTracker, hedgeDelay time.Duration, onProxy func(ctx context.Context, proxy *url.URL) (T, error), discard func(T), onDirect func() (T, error)) (T, *url.URL, error) {
+	var zero T
 	if envGOPROXY == "" {
-		return errors.New("missing GOPROXY")
+		return zero, nil, errors.New("missing GOPROXY")
 	}
 	var lastErr error
 	for envGOPROXY != "" {
 		var (
-			proxy           string
+			entry           string
 			fallBackOnError bool
 		)
-		if i := strings.IndexAny(envGOPROXY, ",|"); i >= 0 {
-			proxy = envGOPROXY[:i]
-			fallBackOnError = envGOPROXY[i] == '|'
-			envGOPROXY = envGOPROXY[i+1:]
-		} else {
-			proxy = envGOPROXY
-			envGOPROXY = ""
-		}
-		switch proxy {
+		entry, fallBackOnError, envGOPROXY = cutEnvGOPROXY(envGOPROXY)
+		switch entry {
 		case "direct":
-			return onDirect()
+			result, err := onDirect()
+			return result, nil, err
 		case "off":
-			return notExistErrorf("module lookup disabled by GOPROXY=off")
+			return zero, nil, notExistErrorf("module lookup disabled by GOPROXY=off")
 		}
-		u, err := url.Parse(proxy)
+		proxy, err := url.Parse(entry)
 		if err != nil {
-			return err
+			return zero, nil, err
 		}
-		if err := onProxy(u); err != nil {
+		if fallBackOnError && !uht.allow(proxy) {
+			lastErr = fmt.Errorf("%w: circuit breaker of %s is open", errBadUpstream, proxy.Redacted())
+			continue
+		}
+
+		var (
+			result T
+			hedged bool
+		)
+		if fallBackOnError && hedgeDelay > 0 {
+			next, nextFallBackOnError, rest := cutEnvGOPROXY(envGOPROXY)
+			if next != "direct" && next != "off" {
+				if nextProxy, parseErr := url.Parse(next); parseErr == nil && uht.healthy(nextProxy) {
+					result, proxy, err = hedge(ctx, hedgeDelay, uht, proxy, nextProxy, onProxy, discard)
+					envGOPROXY, fallBackOnError, hedged = rest, nextFallBackOnError, true
+					hedgeDelay = 0 // Only the first two eligible proxies are raced.
+				}
+			}
+		}
+		if !hedged {
+			result, err = onProxy(ctx, proxy)
+			uht.record(ctx, proxy, err)
+		}
+		if err != nil {
 			if fallBackOnError || errors.Is(err, fs.ErrNotExist) {
 				lastErr = err
 				continue
 			}
-			return err
+			return zero, nil, err
 		}
-		return nil
+		return result, proxy, nil
+	}
+	return zero, nil, lastErr
+}
+
+// cutEnvGOPROXY cuts the first entry from the envGOPROXY, and reports whether
+// falling back to the next entry on any error is allowed for it.
+func cutEnvGOPROXY(envGOPROXY string) (entry string, fallBackOnError bool, rest string) {
+	if i := strings.IndexAny(envGOPROXY, ",|"); i >= 0 {
+		return envGOPROXY[:i], envGOPROXY[i] == '|', envGOPROXY[i+1:]
 	}
-	return lastErr
+	return envGOPROXY, false, ""
+}
+
+// hedge calls the fetch with the first proxy, and also with the second proxy
+// if the former has not finished within the delay, or as soon as it fails. It
+// returns the result of whichever call succeeds first, along with its proxy,
+// and cancels the other call, whose result is passed to the discard (if not
+// nil) if it succeeds anyway. If both calls fail, the error of the call with
+// the second proxy is returned, as if falling back from the first proxy to
+// the second one. The results of both calls are recorded to the uht.
+func hedge[T any](ctx context.Context, delay time.Duration, uht *upstreamHealthTracker, first, second *url.URL, fetch func(ctx context.Context, proxy *url.URL) (T, error), discard func(T)) (T, *url.URL, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type hedgeResult struct {
+		proxy  *url.URL
+		result T
+		err    error
+	}
+	results := make(chan hedgeResult, 2)
+	start := func(proxy *url.URL) {
+		go func() {
+			result, err := fetch(ctx, proxy)
+			uht.record(ctx, proxy, err)
+			results <- hedgeResult{proxy: proxy, result: result, err: err}
+		}()
+	}
+	start(first)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var (
+		zero              T
+		started, finished = 1, 0
+		secondErr         error
+	)
+	for finished < started {
+		select {
+		case <-timer.C:
+			start(second)
+			started++
+		case r := <-results:
+			finished++
+			if r.err == nil {
+				if finished < started {
+					go func() {
+						if r := <-results; r.err == nil && discard != nil {
+							discard(r.result)
+						}
+					}()
+				}
+				return r.result, r.proxy, nil
+			}
+			if r.proxy == second {
+				secondErr = r.err
+			}
+			if started == 1 {
+				timer.Stop()
+				start(second)
+				started++
+			}
+		}
+	}
+	return zero, nil, secondErr
 }
 
 const defaultEnvGOSUMDB = "sum.golang.org"
@@ -822,6 +1559,20 @@ func verifyZipFile(sumdbClient *sumdb.Client, name, modulePath, moduleVersion st
 	return nil
 }
 
+// countingWriter is an [io.Writer] that counts the number of bytes written to
+// the underlying [io.Writer].
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements [io.Writer].
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
 // closerFunc is an adapter to allow the use of an ordinary function as an [io.Closer].
 type closerFunc func() error
 
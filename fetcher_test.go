@@ -8,11 +8,16 @@ import (
 	"io"
 	"io/fs"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -988,6 +993,177 @@ func TestGoFetcherDownload(t *testing.T) {
 	}
 }
 
+func TestGoFetcherDownloadStream(t *testing.T) {
+	t.Setenv("GOMODCACHE", t.TempDir())
+	t.Setenv("GOFLAGS", "-modcacherw")
+
+	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	mod := "module example.com"
+	zip, err := makeZip(map[string][]byte{"example.com@v1.0.0/go.mod": []byte(mod)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	proxyHandler := func(rw http.ResponseWriter, req *http.Request) {
+		switch strings.TrimPrefix(req.URL.Path, "/direct") {
+		case "/example.com/@v/v1.0.0.info":
+			responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.mod":
+			responseSuccess(rw, req, strings.NewReader(mod), "text/plain; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.zip":
+			responseSuccess(rw, req, bytes.NewReader(zip), "application/zip", -2)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}
+	truncatedZipProxyHandler := func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/example.com/@v/v1.0.0.zip" {
+			rw.Header().Set("Content-Length", strconv.Itoa(len(zip)))
+			rw.Write(zip[:len(zip)/2])
+			return
+		}
+		proxyHandler(rw, req)
+	}
+
+	zipFile, err := makeTempFile(t, zip)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	dirHash, err := dirhash.HashZip(zipFile, dirhash.DefaultHash)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	modHash, err := dirhash.DefaultHash([]string{"go.mod"}, func(string) (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(mod)), nil })
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	skey, vkey, err := note.GenerateKey(nil, "stream.sumdb.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for _, tt := range []struct {
+		n                   int
+		proxyHandler        http.HandlerFunc
+		fallbackZipRequests int
+		zipHash             string
+		env                 func(proxyServerURL, fallbackProxyServerURL, sumdbServerURL string) []string
+		wantZipDst          string
+		wantErr             error
+	}{
+		{
+			n: 1,
+			env: func(proxyServerURL, _, sumdbServerURL string) []string {
+				return []string{"GOPROXY=" + proxyServerURL, "GOSUMDB=" + vkey + " " + sumdbServerURL}
+			},
+			wantZipDst: string(zip),
+		},
+		{
+			n: 2,
+			env: func(proxyServerURL, _, sumdbServerURL string) []string {
+				return append(
+					os.Environ(),
+					"GOPROXY="+proxyServerURL,
+					"GONOPROXY=example.com",
+					"GOSUMDB="+vkey+" "+sumdbServerURL,
+				)
+			},
+			wantZipDst: string(zip),
+		},
+		{
+			n:       3,
+			zipHash: "h1:invalid",
+			env: func(proxyServerURL, _, sumdbServerURL string) []string {
+				return []string{"GOPROXY=" + proxyServerURL, "GOSUMDB=" + vkey + " " + sumdbServerURL}
+			},
+			wantZipDst: string(zip),
+			wantErr:    notExistErrorf("example.com@v1.0.0: invalid version: untrusted revision v1.0.0"),
+		},
+		{
+			n:            4,
+			proxyHandler: truncatedZipProxyHandler,
+			env: func(proxyServerURL, fallbackProxyServerURL, _ string) []string {
+				return []string{"GOPROXY=" + proxyServerURL + "|" + fallbackProxyServerURL, "GOSUMDB=off"}
+			},
+			wantZipDst: string(zip[:len(zip)/2]),
+			wantErr:    io.ErrUnexpectedEOF,
+		},
+		{
+			n: 5,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				if req.URL.Path == "/example.com/@v/v1.0.0.zip" {
+					responseNotFound(rw, req, -2)
+					return
+				}
+				proxyHandler(rw, req)
+			},
+			fallbackZipRequests: 1,
+			env: func(proxyServerURL, fallbackProxyServerURL, _ string) []string {
+				return []string{"GOPROXY=" + proxyServerURL + "," + fallbackProxyServerURL, "GOSUMDB=off"}
+			},
+			wantZipDst: string(zip),
+		},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			if tt.proxyHandler == nil {
+				tt.proxyHandler = proxyHandler
+			}
+			proxyServer := newHTTPTestServer(t, tt.proxyHandler)
+
+			var fallbackZipRequests int
+			fallbackProxyServer := newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if path.Ext(req.URL.Path) == ".zip" {
+					fallbackZipRequests++
+				}
+				proxyHandler(rw, req)
+			}))
+
+			zipHash := dirHash
+			if tt.zipHash != "" {
+				zipHash = tt.zipHash
+			}
+			sumdbServer := newHTTPTestServer(t, sumdb.NewServer(sumdb.NewTestServer(skey, func(modulePath, moduleVersion string) ([]byte, error) {
+				gosum := fmt.Sprintf("%s %s %s\n", modulePath, moduleVersion, zipHash)
+				gosum += fmt.Sprintf("%s %s/go.mod %s\n", modulePath, moduleVersion, modHash)
+				return []byte(gosum), nil
+			})))
+
+			gf := &GoFetcher{Env: tt.env(proxyServer.URL, fallbackProxyServer.URL, sumdbServer.URL), TempDir: t.TempDir()}
+			gf.initOnce.Do(gf.init)
+			gf.env = append(gf.env, "GOPROXY="+proxyServer.URL+"/direct/")
+
+			var zipDst bytes.Buffer
+			info, mod, zip, err := gf.DownloadStream(t.Context(), "example.com", "v1.0.0", &zipDst)
+			if got, want := zipDst.String(), tt.wantZipDst; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := fallbackZipRequests, tt.fallbackZipRequests; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if got, want := err, tt.wantErr; !compareErrors(got, want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				info.Close()
+				mod.Close()
+				if b, err := io.ReadAll(zip); err != nil {
+					t.Errorf("unexpected error %v", err)
+				} else if err := zip.Close(); err != nil {
+					t.Errorf("unexpected error %v", err)
+				} else if got, want := string(b), tt.wantZipDst; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
 func TestGoFetcherProxyDownload(t *testing.T) {
 	infoVersion := "v1.0.0"
 	info := marshalInfo(infoVersion, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
@@ -1097,7 +1273,7 @@ func TestGoFetcherProxyDownload(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected error %v", err)
 			}
-			infoFile, modFile, zipFile, cleanup, err := gf.proxyDownload(t.Context(), tt.path, tt.version, proxy)
+			infoFile, modFile, zipFile, cleanup, err := gf.proxyDownload(t.Context(), tt.path, tt.version, proxy, nil)
 			if tt.wantErr != nil {
 				if err == nil {
 					t.Fatal("expected error")
@@ -1146,6 +1322,346 @@ func TestGoFetcherProxyDownload(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var arrivals sync.WaitGroup
+		arrivals.Add(3)
+		allArrived := make(chan struct{})
+		go func() {
+			arrivals.Wait()
+			close(allArrived)
+		}()
+		proxyServer := newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			arrivals.Done()
+			select {
+			case <-allArrived:
+			case <-time.After(5 * time.Second):
+				responseNotFound(rw, req, -2, "module files were not requested concurrently")
+				return
+			}
+			proxyHandler(rw, req)
+		}))
+
+		gf := &GoFetcher{TempDir: t.TempDir()}
+		gf.initOnce.Do(gf.init)
+		proxy, err := url.Parse(proxyServer.URL)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		_, _, _, cleanup, err := gf.proxyDownload(t.Context(), "example.com", infoVersion, proxy, nil)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		cleanup()
+	})
+
+	t.Run("CancelSiblings", func(t *testing.T) {
+		zipStarted := make(chan struct{})
+		zipCanceled := make(chan struct{})
+		proxyServer := newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			switch path.Ext(req.URL.Path) {
+			case ".info":
+				<-zipStarted // Fail only once the zip download is in flight.
+				responseNotFound(rw, req, -2)
+			case ".zip":
+				close(zipStarted)
+				select {
+				case <-req.Context().Done():
+					close(zipCanceled)
+				case <-time.After(5 * time.Second):
+					proxyHandler(rw, req)
+				}
+			default:
+				proxyHandler(rw, req)
+			}
+		}))
+
+		gf := &GoFetcher{TempDir: t.TempDir()}
+		gf.initOnce.Do(gf.init)
+		proxy, err := url.Parse(proxyServer.URL)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		_, _, _, _, err = gf.proxyDownload(t.Context(), "example.com", infoVersion, proxy, nil)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if got, want := err, notExistErrorf("not found"); !compareErrors(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		select {
+		case <-zipCanceled:
+		case <-time.After(5 * time.Second):
+			t.Error("zip download was not canceled")
+		}
+	})
+}
+
+func TestGoFetcherDownloadFile(t *testing.T) {
+	t.Setenv("GOMODCACHE", t.TempDir())
+	t.Setenv("GOFLAGS", "-modcacherw")
+
+	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	mod := "module example.com"
+	zip, err := makeZip(map[string][]byte{"example.com@v1.0.0/go.mod": []byte(mod)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	proxyHandler := func(rw http.ResponseWriter, req *http.Request) {
+		switch strings.TrimPrefix(req.URL.Path, "/direct") {
+		case "/example.com/@v/v1.0.0.info":
+			responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.mod":
+			responseSuccess(rw, req, strings.NewReader(mod), "text/plain; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.zip":
+			responseSuccess(rw, req, bytes.NewReader(zip), "application/zip", -2)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}
+
+	zipFile, err := makeTempFile(t, zip)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	zipHash, err := dirhash.HashZip(zipFile, dirhash.DefaultHash)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	modHash, err := dirhash.DefaultHash([]string{"go.mod"}, func(string) (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(mod)), nil })
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	skey, vkey, err := note.GenerateKey(nil, "file.sumdb.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sumdbHandler := sumdb.NewServer(sumdb.NewTestServer(skey, func(modulePath, moduleVersion string) ([]byte, error) {
+		gosum := fmt.Sprintf("%s %s %s\n", modulePath, moduleVersion, zipHash)
+		gosum += fmt.Sprintf("%s %s/go.mod %s\n", modulePath, moduleVersion, modHash)
+		return []byte(gosum), nil
+	}))
+	untrustedSumdbHandler := sumdb.NewServer(sumdb.NewTestServer(skey, func(modulePath, moduleVersion string) ([]byte, error) {
+		gosum := fmt.Sprintf("%s %s %s\n", modulePath, moduleVersion, "h1:invalid")
+		gosum += fmt.Sprintf("%s %s/go.mod %s\n", modulePath, moduleVersion, "h1:invalid")
+		return []byte(gosum), nil
+	}))
+
+	for _, tt := range []struct {
+		n            int
+		proxyHandler http.HandlerFunc
+		sumdbHandler http.Handler
+		direct       bool
+		version      string
+		ext          string
+		wantContent  string
+		wantRequests []string
+		wantErr      error
+	}{
+		{
+			n:            1,
+			ext:          ".info",
+			wantContent:  info,
+			wantRequests: []string{"/example.com/@v/v1.0.0.info"},
+		},
+		{
+			n:            2,
+			ext:          ".mod",
+			wantContent:  mod,
+			wantRequests: []string{"/example.com/@v/v1.0.0.mod"},
+		},
+		{
+			n:            3,
+			ext:          ".zip",
+			wantContent:  string(zip),
+			wantRequests: []string{"/example.com/@v/v1.0.0.zip"},
+		},
+		{
+			n:           4,
+			direct:      true,
+			ext:         ".info",
+			wantContent: info,
+		},
+		{
+			n:           5,
+			direct:      true,
+			ext:         ".mod",
+			wantContent: mod,
+		},
+		{
+			n:           6,
+			direct:      true,
+			ext:         ".zip",
+			wantContent: string(zip),
+		},
+		{
+			n: 7,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				responseSuccess(rw, req, strings.NewReader(""), "text/plain; charset=utf-8", -2)
+			},
+			ext:     ".info",
+			wantErr: notExistErrorf("invalid info file: unexpected end of JSON input"),
+		},
+		{
+			n: 8,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				responseSuccess(rw, req, strings.NewReader(""), "text/plain; charset=utf-8", -2)
+			},
+			ext:     ".mod",
+			wantErr: notExistErrorf("invalid mod file: missing module directive"),
+		},
+		{
+			n: 9,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				responseSuccess(rw, req, strings.NewReader(""), "application/zip", -2)
+			},
+			ext:     ".zip",
+			wantErr: notExistErrorf("invalid zip file: zip: not a valid zip file"),
+		},
+		{
+			n:            10,
+			sumdbHandler: untrustedSumdbHandler,
+			ext:          ".mod",
+			wantErr:      notExistErrorf("example.com@v1.0.0: invalid version: untrusted revision v1.0.0"),
+		},
+		{
+			n:            11,
+			sumdbHandler: untrustedSumdbHandler,
+			ext:          ".zip",
+			wantErr:      notExistErrorf("example.com@v1.0.0: invalid version: untrusted revision v1.0.0"),
+		},
+		{
+			n:       12,
+			version: "v1",
+			ext:     ".mod",
+			wantErr: errors.New("example.com@v1: invalid version: not a canonical version"),
+		},
+		{
+			n:            13,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, -2) },
+			ext:          ".zip",
+			wantErr:      notExistErrorf("not found"),
+		},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			if tt.proxyHandler == nil {
+				tt.proxyHandler = proxyHandler
+			}
+			var requests []string
+			proxyServer := newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if !strings.HasPrefix(req.URL.Path, "/direct/") {
+					requests = append(requests, req.URL.Path)
+				}
+				tt.proxyHandler(rw, req)
+			}))
+			if tt.sumdbHandler == nil {
+				tt.sumdbHandler = sumdbHandler
+			}
+			sumdbServer := newHTTPTestServer(t, tt.sumdbHandler)
+			if tt.version == "" {
+				tt.version = "v1.0.0"
+			}
+
+			env := append(os.Environ(), "GOPROXY="+proxyServer.URL, "GOSUMDB="+vkey+" "+sumdbServer.URL)
+			if tt.direct {
+				env = append(env, "GONOPROXY=example.com")
+			}
+			gf := &GoFetcher{Env: env, TempDir: t.TempDir()}
+			gf.initOnce.Do(gf.init)
+			gf.env = append(gf.env, "GOPROXY="+proxyServer.URL+"/direct/")
+
+			var downloadFile func(ctx context.Context, path, version string) (io.ReadSeekCloser, error)
+			switch tt.ext {
+			case ".info":
+				downloadFile = gf.DownloadInfo
+			case ".mod":
+				downloadFile = gf.DownloadMod
+			case ".zip":
+				downloadFile = gf.DownloadZip
+			}
+			content, err := downloadFile(t.Context(), "example.com", tt.version)
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if got, want := err, tt.wantErr; !compareErrors(got, want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if b, err := io.ReadAll(content); err != nil {
+					t.Errorf("unexpected error %v", err)
+				} else if err := content.Close(); err != nil {
+					t.Errorf("unexpected error %v", err)
+				} else if got, want := string(b), tt.wantContent; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+				if got, want := strings.Join(requests, ","), strings.Join(tt.wantRequests, ","); got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+			if des, err := os.ReadDir(gf.TempDir); err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if got, want := len(des), 0; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestGoFetcherProxyDownloadFile(t *testing.T) {
+	proxyServer := newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/example.com/!foo/@v/v1.0.0.mod" {
+			responseSuccess(rw, req, strings.NewReader("module example.com/Foo"), "text/plain; charset=utf-8", -2)
+			return
+		}
+		responseNotFound(rw, req, -2)
+	}))
+	proxy, err := url.Parse(proxyServer.URL)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	t.Run("Normal", func(t *testing.T) {
+		gf := &GoFetcher{TempDir: t.TempDir()}
+		gf.initOnce.Do(gf.init)
+
+		file, cleanup, err := gf.proxyDownloadFile(t.Context(), "example.com/Foo", "v1.0.0", ".mod", proxy)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := os.ReadFile(file); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "module example.com/Foo"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		cleanup()
+		if des, err := os.ReadDir(gf.TempDir); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := len(des), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		gf := &GoFetcher{TempDir: t.TempDir()}
+		gf.initOnce.Do(gf.init)
+
+		_, _, err := gf.proxyDownloadFile(t.Context(), "example.com/Foo", "v1.0.0", ".zip", proxy)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if got, want := err, notExistErrorf("not found"); !compareErrors(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if des, err := os.ReadDir(gf.TempDir); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := len(des), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
 }
 
 func TestGoFetcherDirectDownload(t *testing.T) {
@@ -1201,7 +1717,7 @@ func TestGoFetcherDirectDownload(t *testing.T) {
 			}
 			gf.env = append(gf.env, "GOPROXY="+proxyServer.URL)
 
-			infoFile, modFile, zipFile, err := gf.directDownload(t.Context(), tt.path, infoVersion)
+			infoFile, modFile, zipFile, _, err := gf.directDownload(t.Context(), tt.path, infoVersion)
 			if tt.wantErr != nil {
 				if err == nil {
 					t.Fatal("expected error")
@@ -1233,6 +1749,203 @@ func TestGoFetcherDirectDownload(t *testing.T) {
 	}
 }
 
+func TestGoFetcherLockedDirectFetch(t *testing.T) {
+	t.Setenv("GOMODCACHE", t.TempDir())
+	t.Setenv("GOFLAGS", "-modcacherw")
+
+	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	mod := "module example.com"
+	zip, err := makeZip(map[string][]byte{"example.com@v1.0.0/go.mod": []byte("module example.com")})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var (
+		proxyRequestsMu sync.Mutex
+		proxyRequests   int
+	)
+	proxyServer := newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		proxyRequestsMu.Lock()
+		proxyRequests++
+		proxyRequestsMu.Unlock()
+		switch req.URL.Path {
+		case "/example.com/@v/list":
+			responseSuccess(rw, req, strings.NewReader("v1.0.0"), "text/plain; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.info":
+			responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.mod":
+			responseSuccess(rw, req, strings.NewReader(mod), "text/plain; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.zip":
+			responseSuccess(rw, req, bytes.NewReader(zip), "application/zip", -2)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}))
+	getProxyRequests := func() int {
+		proxyRequestsMu.Lock()
+		defer proxyRequestsMu.Unlock()
+		return proxyRequests
+	}
+	newGoFetcher := func(t *testing.T, locker Locker, cacher Cacher) *GoFetcher {
+		gf := &GoFetcher{
+			Env:               append(os.Environ(), "GOSUMDB=off"),
+			DirectFetchLocker: locker,
+			DirectFetchCacher: cacher,
+			TempDir:           t.TempDir(),
+		}
+		gf.initOnce.Do(gf.init)
+		if gf.initErr != nil {
+			t.Fatalf("unexpected error %v", gf.initErr)
+		}
+		gf.env = append(gf.env, "GOPROXY="+proxyServer.URL)
+		return gf
+	}
+
+	t.Run("Share", func(t *testing.T) {
+		cacher := DirCacher(t.TempDir())
+		gf := newGoFetcher(t, DirLocker(t.TempDir()), cacher)
+		if _, _, _, _, err := gf.directDownload(t.Context(), "example.com", "v1.0.0"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for name, want := range map[string]string{
+			"direct-fetches/example.com/@v/v1.0.0.mod": mod,
+			"direct-fetches/example.com/@v/v1.0.0.zip": string(zip),
+		} {
+			if rc, err := cacher.Get(t.Context(), name); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			} else if b, err := io.ReadAll(rc); err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if got := string(b); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			} else {
+				rc.Close()
+			}
+		}
+		if _, err := gf.directList(t.Context(), "example.com"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if rc, err := cacher.Get(t.Context(), "direct-fetches/example.com/@v/list"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if b, err := io.ReadAll(rc); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "v1.0.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		} else {
+			rc.Close()
+		}
+		for _, name := range []string{"example.com/@v/v1.0.0.zip", "example.com/@v/list"} {
+			if _, err := cacher.Get(t.Context(), name); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+			}
+		}
+	})
+
+	t.Run("EmptyShared", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		cacher := DirCacher(cacheDir)
+		gf := newGoFetcher(t, DirLocker(t.TempDir()), cacher)
+		if err := cacher.Put(t.Context(), "direct-fetches/example.com/@v/list", strings.NewReader("")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		modTime := time.Now().Add(time.Hour)
+		if err := os.Chtimes(filepath.Join(cacheDir, "direct-fetches", "example.com", "@v", "list"), modTime, modTime); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		versions, err := gf.directList(t.Context(), "example.com")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := len(versions), 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("WaitAndReadShared", func(t *testing.T) {
+		locker := DirLocker(t.TempDir())
+		cacher := DirCacher(t.TempDir())
+		gf := newGoFetcher(t, locker, cacher)
+
+		unlock, err := locker.Lock(t.Context(), "example.com/@v/v1.0.0")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		type result struct {
+			modFile string
+			cleanup func()
+			err     error
+		}
+		resultCh := make(chan result, 1)
+		go func() {
+			_, modFile, _, cleanup, err := gf.directDownload(t.Context(), "example.com", "v1.0.0")
+			resultCh <- result{modFile, cleanup, err}
+		}()
+		time.Sleep(2 * dirLockerPollInterval)
+		select {
+		case <-resultCh:
+			t.Fatal("expected direct download to wait for the lock")
+		default:
+		}
+		for ext, content := range map[string]string{".info": info, ".mod": mod, ".zip": string(zip)} {
+			if err := cacher.Put(t.Context(), "direct-fetches/example.com/@v/v1.0.0"+ext, strings.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		proxyRequestsBefore := getProxyRequests()
+		unlock()
+
+		r := <-resultCh
+		if r.err != nil {
+			t.Fatalf("unexpected error %v", r.err)
+		}
+		if r.cleanup == nil {
+			t.Fatal("expected cleanup")
+		}
+		defer r.cleanup()
+		if b, err := os.ReadFile(r.modFile); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), mod; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := getProxyRequests(), proxyRequestsBefore; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("IgnoreStaleShared", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		cacher := DirCacher(cacheDir)
+		gf := newGoFetcher(t, DirLocker(t.TempDir()), cacher)
+		if err := cacher.Put(t.Context(), "direct-fetches/example.com/@latest", strings.NewReader(marshalInfo("v0.1.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		modTime := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(filepath.Join(cacheDir, "direct-fetches", "example.com", "@latest"), modTime, modTime); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		version, _, err := gf.directQuery(t.Context(), "example.com", "latest")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := version, "v1.0.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("LockCanceled", func(t *testing.T) {
+		locker := DirLocker(t.TempDir())
+		gf := newGoFetcher(t, locker, nil)
+		unlock, err := locker.Lock(t.Context(), "example.com/@v/list")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer unlock()
+		ctx, cancel := context.WithTimeout(t.Context(), 2*dirLockerPollInterval)
+		defer cancel()
+		if _, err := gf.directList(ctx, "example.com"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
 type misbehavingDoneContext struct{}
 
 func (misbehavingDoneContext) Deadline() (deadline time.Time, ok bool) { return time.Time{}, false }
@@ -1498,7 +2211,7 @@ func TestWalkEnvGOPROXY(t *testing.T) {
 				onProxy  string
 				onDirect bool
 			)
-			err := walkEnvGOPROXY(tt.envGOPROXY, func(proxy *url.URL) error {
+			err := walkEnvGOPROXY(tt.envGOPROXY, nil, func(proxy *url.URL) error {
 				onProxy = proxy.String()
 				return tt.onProxy(proxy)
 			}, func() error {
@@ -1527,6 +2240,284 @@ func TestWalkEnvGOPROXY(t *testing.T) {
 	}
 }
 
+func TestWalkEnvGOPROXYHedged(t *testing.T) {
+	// block blocks until the ctx is done, and returns the cause.
+	block := func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", context.Cause(ctx)
+	}
+
+	t.Run("SlowFirst", func(t *testing.T) {
+		var firstErr atomic.Value
+		result, proxy, err := walkEnvGOPROXYHedged(t.Context(), "https://a.example.com|https://b.example.com", nil, time.Millisecond, func(ctx context.Context, proxy *url.URL) (string, error) {
+			if proxy.Host == "a.example.com" {
+				result, err := block(ctx)
+				firstErr.Store(err)
+				return result, err
+			}
+			return proxy.Host, nil
+		}, nil, func() (string, error) { return "", errors.New("unexpected direct") })
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := result, "b.example.com"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := proxy.Host, "b.example.com"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		deadline := time.Now().Add(time.Second)
+		for firstErr.Load() == nil && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got, want := firstErr.Load(), context.Canceled; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("FastFirst", func(t *testing.T) {
+		var calls []string
+		result, _, err := walkEnvGOPROXYHedged(t.Context(), "https://a.example.com|https://b.example.com", nil, time.Hour, func(ctx context.Context, proxy *url.URL) (string, error) {
+			calls = append(calls, proxy.Host)
+			return proxy.Host, nil
+		}, nil, func() (string, error) { return "", errors.New("unexpected direct") })
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := result, "a.example.com"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := calls, []string{"a.example.com"}; !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("FailingFirst", func(t *testing.T) {
+		result, _, err := walkEnvGOPROXYHedged(t.Context(), "https://a.example.com|https://b.example.com", nil, time.Hour, func(ctx context.Context, proxy *url.URL) (string, error) {
+			if proxy.Host == "a.example.com" {
+				return "", errBadUpstream
+			}
+			return proxy.Host, nil
+		}, nil, func() (string, error) { return "", errors.New("unexpected direct") })
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := result, "b.example.com"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("BothFailing", func(t *testing.T) {
+		var calls atomic.Int64
+		result, proxy, err := walkEnvGOPROXYHedged(t.Context(), "https://a.example.com|https://b.example.com|direct", nil, time.Millisecond, func(ctx context.Context, proxy *url.URL) (string, error) {
+			calls.Add(1)
+			return "", errBadUpstream
+		}, nil, func() (string, error) { return "direct", nil })
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := result, "direct"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if proxy != nil {
+			t.Errorf("got %v, want nil", proxy)
+		}
+		if got, want := calls.Load(), int64(2); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("Discard", func(t *testing.T) {
+		discarded := make(chan string, 1)
+		result, _, err := walkEnvGOPROXYHedged(t.Context(), "https://a.example.com|https://b.example.com", nil, time.Millisecond, func(ctx context.Context, proxy *url.URL) (string, error) {
+			if proxy.Host == "a.example.com" {
+				block(ctx)
+				return proxy.Host, nil // Succeed anyway.
+			}
+			return proxy.Host, nil
+		}, func(result string) { discarded <- result }, func() (string, error) { return "", errors.New("unexpected direct") })
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := result, "b.example.com"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		select {
+		case got := <-discarded:
+			if want := "a.example.com"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Error("expected result to be discarded")
+		}
+	})
+
+	for _, tt := range []struct {
+		name       string
+		envGOPROXY string
+		uht        *upstreamHealthTracker
+	}{
+		{"CommaSeparated", "https://a.example.com,https://b.example.com", nil},
+		{"NextDirect", "https://a.example.com|direct", nil},
+		{"NextCircuitBreakerOpen", "https://a.example.com|https://b.example.com", func() *upstreamHealthTracker {
+			uht := newUpstreamHealthTracker("https://a.example.com|https://b.example.com", 1, time.Hour)
+			uht.record(t.Context(), &url.URL{Scheme: "https", Host: "b.example.com"}, errBadUpstream)
+			return uht
+		}()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := walkEnvGOPROXYHedged(t.Context(), tt.envGOPROXY, tt.uht, time.Millisecond, func(ctx context.Context, proxy *url.URL) (string, error) {
+				if proxy.Host != "a.example.com" {
+					return "", errors.New("unexpected hedged request")
+				}
+				time.Sleep(10 * time.Millisecond)
+				return proxy.Host, nil
+			}, nil, func() (string, error) { return "", errors.New("unexpected direct") })
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestGoFetcherHedge(t *testing.T) {
+	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	mod := "module example.com"
+	zip, err := makeZip(map[string][]byte{"example.com@v1.0.0/go.mod": []byte(mod)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	newProxyServer := func(mod string, delay time.Duration, canceled *atomic.Int64) *httptest.Server {
+		return newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			select {
+			case <-time.After(delay):
+			case <-req.Context().Done():
+				canceled.Add(1)
+				return
+			}
+			switch req.URL.Path {
+			case "/example.com/@v/list":
+				responseSuccess(rw, req, strings.NewReader("v1.0.0"), "text/plain; charset=utf-8", -2)
+			case "/example.com/@latest", "/example.com/@v/v1.0.0.info":
+				responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+			case "/example.com/@v/v1.0.0.mod":
+				responseSuccess(rw, req, strings.NewReader(mod), "text/plain; charset=utf-8", -2)
+			case "/example.com/@v/v1.0.0.zip":
+				responseSuccess(rw, req, bytes.NewReader(zip), "application/zip", -2)
+			default:
+				responseNotFound(rw, req, -2)
+			}
+		}))
+	}
+
+	t.Run("SlowFirst", func(t *testing.T) {
+		var slowCanceled, fastCanceled atomic.Int64
+		slowServer := newProxyServer(mod, time.Minute, &slowCanceled)
+		fastServer := newProxyServer(mod, 0, &fastCanceled)
+		gf := &GoFetcher{
+			Env:        []string{"GOPROXY=" + slowServer.URL + "|" + fastServer.URL, "GOSUMDB=off"},
+			TempDir:    t.TempDir(),
+			HedgeDelay: 10 * time.Millisecond,
+		}
+
+		ft := &fetchTrace{}
+		ctx := withFetchTrace(t.Context(), ft)
+		version, _, err := gf.Query(ctx, "example.com", "latest")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := version, "v1.0.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := ft.upstream, fastServer.URL; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		versions, err := gf.List(t.Context(), "example.com")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := versions, []string{"v1.0.0"}; !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		infoRSC, modRSC, zipRSC, err := gf.Download(t.Context(), "example.com", "v1.0.0")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		infoRSC.Close()
+		modRSC.Close()
+		zipRSC.Close()
+
+		modRSC, err = gf.DownloadMod(t.Context(), "example.com", "v1.0.0")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(modRSC); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if got, want := string(b), mod; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		modRSC.Close()
+
+		deadline := time.Now().Add(time.Second)
+		for slowCanceled.Load() < 6 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got, want := slowCanceled.Load(), int64(6); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := fastCanceled.Load(), int64(0); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("InvalidFast", func(t *testing.T) {
+		var slowCanceled, fastCanceled atomic.Int64
+		slowServer := newProxyServer(mod, 50*time.Millisecond, &slowCanceled)
+		fastServer := newProxyServer("invalid mod", 0, &fastCanceled)
+		gf := &GoFetcher{
+			Env:        []string{"GOPROXY=" + slowServer.URL + "|" + fastServer.URL, "GOSUMDB=off"},
+			TempDir:    t.TempDir(),
+			HedgeDelay: time.Millisecond,
+		}
+		ft := &fetchTrace{}
+		ctx := withFetchTrace(t.Context(), ft)
+		modRSC, err := gf.DownloadMod(ctx, "example.com", "v1.0.0")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer modRSC.Close()
+		if b, err := io.ReadAll(modRSC); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if got, want := string(b), mod; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := ft.upstream, slowServer.URL; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("CommaSeparated", func(t *testing.T) {
+		var slowCanceled, fastCanceled atomic.Int64
+		slowServer := newProxyServer(mod, 50*time.Millisecond, &slowCanceled)
+		fastServer := newProxyServer(mod, 0, &fastCanceled)
+		gf := &GoFetcher{
+			Env:        []string{"GOPROXY=" + slowServer.URL + "," + fastServer.URL, "GOSUMDB=off"},
+			TempDir:    t.TempDir(),
+			HedgeDelay: time.Millisecond,
+		}
+		ft := &fetchTrace{}
+		ctx := withFetchTrace(t.Context(), ft)
+		if _, err := gf.List(ctx, "example.com"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := ft.upstream, slowServer.URL; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
 func TestCleanEnvGOSUMDB(t *testing.T) {
 	for _, tt := range []struct {
 		n              int
@@ -0,0 +1,373 @@
+/*
+Package gcscacher implements [github.com/goproxy/goproxy.Cacher] using Google
+Cloud Storage.
+*/
+package gcscacher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goproxy/goproxy/internal/cachecontent"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// Cacher implements [github.com/goproxy/goproxy.Cacher] using Google Cloud
+// Storage.
+//
+// In addition to Get and Put, Cacher also implements the List and Delete
+// methods documented in [github.com/goproxy/goproxy.Cacher].
+//
+// The content returned by Get implements [io.Seeker] if the size of the
+// object is known, so that it can be verified before being served. Reading
+// after seeking sends a range request for the same generation of the object.
+//
+// Make sure to set all fields before calling any methods. A Cacher must not
+// be copied after first use.
+type Cacher struct {
+	// Endpoint is the endpoint of the Google Cloud Storage JSON API, such as
+	// the URL of an emulator.
+	//
+	// If Endpoint is empty, "https://storage.googleapis.com" is used.
+	Endpoint string
+
+	// Bucket is the name of the bucket.
+	Bucket string
+
+	// KeyPrefix is the prefix prepended to cache names to form object
+	// names, such as "goproxy/".
+	KeyPrefix string
+
+	// CredentialsFile is the service account key file used to authenticate
+	// requests.
+	//
+	// If CredentialsFile is empty, the Application Default Credentials are
+	// used, which are retrieved from the first available source of the
+	// following: the file named by the GOOGLE_APPLICATION_CREDENTIALS
+	// environment variable, the well-known file created by "gcloud auth
+	// application-default login", and the metadata server of the Google
+	// Cloud environment.
+	CredentialsFile string
+
+	// Anonymous indicates whether to send requests anonymously, such as to
+	// an emulator or a public bucket. CredentialsFile is ignored if
+	// Anonymous is true.
+	Anonymous bool
+
+	// Transport is used to perform HTTP requests.
+	//
+	// If Transport is nil, [http.DefaultTransport] is used.
+	Transport http.RoundTripper
+
+	initOnce sync.Once
+	initErr  error
+	endpoint string
+	client   *http.Client
+}
+
+// init initializes the c.
+func (c *Cacher) init() {
+	c.endpoint = strings.TrimSuffix(c.Endpoint, "/")
+	if c.endpoint == "" {
+		c.endpoint = "https://storage.googleapis.com"
+	}
+
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if c.Anonymous {
+		c.client = &http.Client{Transport: transport}
+		return
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: transport})
+	const scope = "https://www.googleapis.com/auth/devstorage.read_write"
+	var creds *google.Credentials
+	if c.CredentialsFile != "" {
+		b, err := os.ReadFile(c.CredentialsFile)
+		if err != nil {
+			c.initErr = err
+			return
+		}
+		creds, c.initErr = google.CredentialsFromJSONWithType(ctx, b, google.ServiceAccount, scope)
+	} else {
+		creds, c.initErr = google.FindDefaultCredentials(ctx, scope)
+	}
+	if c.initErr != nil {
+		return
+	}
+	c.client = &http.Client{Transport: &oauth2.Transport{Source: creds.TokenSource, Base: transport}}
+}
+
+// objectURL returns the JSON API URL of the object for the name.
+func (c *Cacher) objectURL(name string) string {
+	return c.endpoint + "/storage/v1/b/" + url.PathEscape(c.Bucket) + "/o/" + url.PathEscape(c.KeyPrefix+name)
+}
+
+// do sends the req and returns its response. It returns [fs.ErrNotExist] if
+// the response status code is 404.
+func (c *Cacher) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fs.ErrNotExist
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if err != nil {
+		return nil, err
+	}
+	var errorResponse struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	msg := strings.TrimSpace(string(b))
+	if json.Unmarshal(b, &errorResponse) == nil && errorResponse.Error.Message != "" {
+		msg = errorResponse.Error.Message
+	}
+	return nil, fmt.Errorf("unexpected response status %s: %s", resp.Status, msg)
+}
+
+// Get implements [github.com/goproxy/goproxy.Cacher].
+func (c *Cacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return nil, c.initErr
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.objectURL(name)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	cache := &cache{ReadCloser: resp.Body, size: resp.ContentLength}
+	cache.lastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		if !strings.HasPrefix(etag, `"`) {
+			etag = strconv.Quote(etag)
+		}
+		cache.etag = etag
+	}
+	if cache.size < 0 {
+		return cache, nil
+	}
+
+	// Pin the generation, so that reading after seeking never mixes the
+	// content of different generations of the object.
+	mediaURL := c.objectURL(name) + "?alt=media"
+	if generation := resp.Header.Get("X-Goog-Generation"); generation != "" {
+		mediaURL += "&generation=" + url.QueryEscape(generation)
+	}
+	return &seekableCache{cache: cache, ctx: ctx, c: c, mediaURL: mediaURL}, nil
+}
+
+// Put implements [github.com/goproxy/goproxy.Cacher].
+func (c *Cacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return c.initErr
+	}
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	uploadURL := c.endpoint + "/upload/storage/v1/b/" + url.PathEscape(c.Bucket) + "/o?" + url.Values{
+		"uploadType": {"media"},
+		"name":       {c.KeyPrefix + name},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, io.NopCloser(content))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Content-Type", cachecontent.Type(name))
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// List lists the names of all caches that start with the prefix, in lexical
+// order. See [github.com/goproxy/goproxy.Cacher] for details.
+func (c *Cacher) List(ctx context.Context, prefix string) ([]string, error) {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return nil, c.initErr
+	}
+	var (
+		names     []string
+		pageToken string
+	)
+	for {
+		query := url.Values{
+			"prefix": {c.KeyPrefix + prefix},
+			"fields": {"items(name),nextPageToken"},
+		}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint+"/storage/v1/b/"+url.PathEscape(c.Bucket)+"/o?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var objects struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&objects)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range objects.Items {
+			names = append(names, strings.TrimPrefix(item.Name, c.KeyPrefix))
+		}
+		if objects.NextPageToken == "" {
+			return names, nil
+		}
+		pageToken = objects.NextPageToken
+	}
+}
+
+// Delete deletes the cache for the name. It returns [fs.ErrNotExist] if not
+// found. See [github.com/goproxy/goproxy.Cacher] for details.
+func (c *Cacher) Delete(ctx context.Context, name string) error {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return c.initErr
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// cache is the cache returned by [Cacher.Get].
+type cache struct {
+	io.ReadCloser
+	size         int64
+	lastModified time.Time
+	etag         string
+}
+
+// Size implements [github.com/goproxy/goproxy.Cacher.Get].
+func (c *cache) Size() int64 {
+	return c.size
+}
+
+// LastModified implements [github.com/goproxy/goproxy.Cacher.Get].
+func (c *cache) LastModified() time.Time {
+	return c.lastModified
+}
+
+// ETag implements [github.com/goproxy/goproxy.Cacher.Get].
+func (c *cache) ETag() string {
+	return c.etag
+}
+
+// seekableCache is the cache returned by [Cacher.Get] when the size of the
+// object is known. It implements [io.Seeker] by reading the object with range
+// requests after seeking, so that the content can be verified before being
+// served.
+type seekableCache struct {
+	*cache
+	ctx      context.Context
+	c        *Cacher
+	mediaURL string
+	offset   int64
+}
+
+// Read implements [io.Reader].
+func (sc *seekableCache) Read(p []byte) (int, error) {
+	if sc.ReadCloser == nil {
+		if sc.offset >= sc.size {
+			return 0, io.EOF
+		}
+		req, err := http.NewRequestWithContext(sc.ctx, http.MethodGet, sc.mediaURL, nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", sc.offset))
+		resp, err := sc.c.do(req)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return 0, fmt.Errorf("unexpected response status %s for range request", resp.Status)
+		}
+		sc.ReadCloser = resp.Body
+	}
+	n, err := sc.ReadCloser.Read(p)
+	sc.offset += int64(n)
+	return n, err
+}
+
+// Seek implements [io.Seeker].
+func (sc *seekableCache) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += sc.offset
+	case io.SeekEnd:
+		offset += sc.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != sc.offset && sc.ReadCloser != nil {
+		sc.ReadCloser.Close()
+		sc.ReadCloser = nil
+	}
+	sc.offset = offset
+	return offset, nil
+}
+
+// Close implements [io.Closer].
+func (sc *seekableCache) Close() error {
+	if sc.ReadCloser == nil {
+		return nil
+	}
+	return sc.ReadCloser.Close()
+}
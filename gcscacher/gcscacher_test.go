@@ -0,0 +1,347 @@
+package gcscacher
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGCSObject is an object stored by [fakeGCS].
+type fakeGCSObject struct {
+	content     []byte
+	contentType string
+	generation  int
+	modTime     time.Time
+}
+
+// fakeGCS is an in-process fake of the Google Cloud Storage JSON API that
+// supports a single bucket.
+type fakeGCS struct {
+	bucket     string
+	pageSize   int
+	token      string
+	mu         sync.Mutex
+	generation int
+	objects    map[string]fakeGCSObject
+}
+
+// ServeHTTP implements [http.Handler].
+func (fg *fakeGCS) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		rw.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(rw, `{"access_token":%q,"token_type":"Bearer","expires_in":3600}`, fg.token)
+		return
+	}
+	if fg.token != "" && req.Header.Get("Authorization") != "Bearer "+fg.token {
+		fg.responseError(rw, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	switch {
+	case req.Method == http.MethodPost && req.URL.Path == "/upload/storage/v1/b/"+fg.bucket+"/o":
+		if req.URL.Query().Get("uploadType") != "media" {
+			fg.responseError(rw, http.StatusBadRequest, "Invalid upload type")
+			return
+		}
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			fg.responseError(rw, http.StatusBadRequest, err.Error())
+			return
+		}
+		fg.generation++
+		fg.objects[req.URL.Query().Get("name")] = fakeGCSObject{
+			content:     b,
+			contentType: req.Header.Get("Content-Type"),
+			generation:  fg.generation,
+			modTime:     time.Now().UTC().Truncate(time.Second),
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(rw, `{"name":%q}`, req.URL.Query().Get("name"))
+	case req.Method == http.MethodGet && req.URL.Path == "/storage/v1/b/"+fg.bucket+"/o":
+		prefix := req.URL.Query().Get("prefix")
+		var names []string
+		for name := range fg.objects {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		start := 0
+		if pageToken := req.URL.Query().Get("pageToken"); pageToken != "" {
+			start, _ = strconv.Atoi(pageToken)
+		}
+		end := min(start+fg.pageSize, len(names))
+		type item struct {
+			Name string `json:"name"`
+		}
+		var result struct {
+			Items         []item `json:"items,omitempty"`
+			NextPageToken string `json:"nextPageToken,omitempty"`
+		}
+		for _, name := range names[start:end] {
+			result.Items = append(result.Items, item{name})
+		}
+		if end < len(names) {
+			result.NextPageToken = strconv.Itoa(end)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(result)
+	case strings.HasPrefix(req.URL.Path, "/storage/v1/b/"+fg.bucket+"/o/"):
+		name := strings.TrimPrefix(req.URL.Path, "/storage/v1/b/"+fg.bucket+"/o/")
+		if !strings.Contains(req.URL.RawPath, "%2F") && strings.Contains(name, "/") {
+			fg.responseError(rw, http.StatusBadRequest, "Object name must be escaped")
+			return
+		}
+		o, ok := fg.objects[name]
+		if !ok {
+			fg.responseError(rw, http.StatusNotFound, "No such object")
+			return
+		}
+		switch req.Method {
+		case http.MethodGet:
+			if req.URL.Query().Get("alt") != "media" {
+				fg.responseError(rw, http.StatusBadRequest, "Only media downloads are supported")
+				return
+			}
+			if generation := req.URL.Query().Get("generation"); generation != "" && generation != strconv.Itoa(o.generation) {
+				fg.responseError(rw, http.StatusNotFound, "No such object")
+				return
+			}
+			rw.Header().Set("Content-Type", o.contentType)
+			rw.Header().Set("ETag", fmt.Sprintf("CAE=%d", o.generation))
+			rw.Header().Set("X-Goog-Generation", strconv.Itoa(o.generation))
+			http.ServeContent(rw, req, "", o.modTime, bytes.NewReader(o.content))
+		case http.MethodDelete:
+			delete(fg.objects, name)
+			rw.WriteHeader(http.StatusNoContent)
+		default:
+			fg.responseError(rw, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	default:
+		fg.responseError(rw, http.StatusNotFound, "Not found")
+	}
+}
+
+// responseError responses a Google Cloud Storage JSON API error to the
+// client.
+func (fg *fakeGCS) responseError(rw http.ResponseWriter, statusCode int, message string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(statusCode)
+	fmt.Fprintf(rw, `{"error":{"code":%d,"message":%q}}`, statusCode, message)
+}
+
+// newFakeGCSCacher returns a new [Cacher] backed by a new [fakeGCS].
+func newFakeGCSCacher(t *testing.T) (*Cacher, *fakeGCS) {
+	fg := &fakeGCS{bucket: "test", pageSize: 2, objects: map[string]fakeGCSObject{}}
+	server := httptest.NewServer(fg)
+	t.Cleanup(server.Close)
+	c := &Cacher{
+		Endpoint:  server.URL,
+		Bucket:    fg.bucket,
+		Anonymous: true,
+		Transport: server.Client().Transport,
+	}
+	return c, fg
+}
+
+func TestCacher(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		c, fg := newFakeGCSCacher(t)
+		c.KeyPrefix = "goproxy/"
+		for name, content := range map[string]string{
+			"example.com/@v/v1.0.0.info": "{}",
+			"example.com/@v/v1.0.0.mod":  "module example.com",
+			"example.com/@v/v1.0.0.zip":  "zip",
+			"example.com/@v/list":        "",
+		} {
+			if err := c.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+
+		for name, want := range map[string]string{
+			"goproxy/example.com/@v/v1.0.0.info": "application/json; charset=utf-8",
+			"goproxy/example.com/@v/v1.0.0.mod":  "text/plain; charset=utf-8",
+			"goproxy/example.com/@v/v1.0.0.zip":  "application/zip",
+		} {
+			o, ok := fg.objects[name]
+			if !ok {
+				t.Fatalf("expected object %s to exist", name)
+			}
+			if got := o.contentType; got != want {
+				t.Errorf("%s: got %q, want %q", name, got, want)
+			}
+		}
+
+		rc, err := c.Get(t.Context(), "example.com/@v/v1.0.0.mod")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(rc); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "module example.com"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := rc.(interface{ Size() int64 }).Size(), int64(len("module example.com")); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := rc.(interface{ ETag() string }).ETag(), fmt.Sprintf(`"CAE=%d"`, fg.objects["goproxy/example.com/@v/v1.0.0.mod"].generation); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := rc.(interface{ LastModified() time.Time }).LastModified(), fg.objects["goproxy/example.com/@v/v1.0.0.mod"].modTime; !got.Equal(want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		rc.Close()
+
+		rc, err = c.Get(t.Context(), "example.com/@v/list")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(rc); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if len(b) != 0 {
+			t.Errorf("got %q, want empty", b)
+		}
+		rc.Close()
+
+		names, err := c.List(t.Context(), "example.com/@v/")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := names, []string{
+			"example.com/@v/list",
+			"example.com/@v/v1.0.0.info",
+			"example.com/@v/v1.0.0.mod",
+			"example.com/@v/v1.0.0.zip",
+		}; !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if err := c.Delete(t.Context(), "example.com/@v/v1.0.0.zip"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := c.Get(t.Context(), "example.com/@v/v1.0.0.zip"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+		if err := c.Delete(t.Context(), "example.com/@v/v1.0.0.zip"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		c, _ := newFakeGCSCacher(t)
+		if err := c.Put(t.Context(), "example.com/@v/v1.0.0.zip", strings.NewReader("foobar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		rc, err := c.Get(t.Context(), "example.com/@v/v1.0.0.zip")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer rc.Close()
+		rs, ok := rc.(io.ReadSeeker)
+		if !ok {
+			t.Fatal("expected io.ReadSeeker")
+		}
+		if b, err := io.ReadAll(rs); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "foobar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if size, err := rs.Seek(0, io.SeekEnd); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := size, int64(6); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if _, err := rs.Seek(3, io.SeekStart); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(rs); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "bar"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if err := c.Put(t.Context(), "example.com/@v/v1.0.0.zip", strings.NewReader("bazqux")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := io.ReadAll(rs); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("CredentialsFile", func(t *testing.T) {
+		c, fg := newFakeGCSCacher(t)
+		fg.token = "test-token"
+
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		credentials, err := json.Marshal(map[string]string{
+			"type":         "service_account",
+			"client_email": "goproxy@example.iam.gserviceaccount.com",
+			"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+			"token_uri":    c.Endpoint + "/token",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		c.CredentialsFile = filepath.Join(t.TempDir(), "credentials.json")
+		if err := os.WriteFile(c.CredentialsFile, credentials, 0o600); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		c.Anonymous = false
+		if err := c.Put(t.Context(), "example.com/@v/v1.0.0.info", strings.NewReader("{}")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, ok := fg.objects["example.com/@v/v1.0.0.info"]; !ok {
+			t.Error("expected object to exist")
+		}
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		c, fg := newFakeGCSCacher(t)
+		fg.token = "test-token"
+		if _, err := c.Get(t.Context(), "example.com/@v/v1.0.0.info"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), "unexpected response status 401 Unauthorized: Unauthorized"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("InvalidCredentialsFile", func(t *testing.T) {
+		c, _ := newFakeGCSCacher(t)
+		c.Anonymous = false
+		c.CredentialsFile = filepath.Join(t.TempDir(), "credentials.json")
+		if _, err := c.Get(t.Context(), "example.com/@v/v1.0.0.info"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+}
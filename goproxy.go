@@ -4,7 +4,9 @@ Package goproxy implements a minimalist Go module proxy handler.
 package goproxy
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -14,11 +16,15 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"golang.org/x/mod/modfile"
 	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
 )
 
 // tempDirPattern is the pattern for creating temporary directories.
@@ -71,24 +77,147 @@ type Goproxy struct {
 	// the local Go binary.
 	Transport http.RoundTripper
 
+	// RetryPolicy is the policy for retrying failed outgoing HTTP requests,
+	// such as those for proxied checksum databases.
+	//
+	// If RetryPolicy is nil, the zero value of [RetryPolicy] is used.
+	//
+	// If Fetcher is nil, the default [GoFetcher] also uses RetryPolicy.
+	RetryPolicy *RetryPolicy
+
+	// StreamDownloads indicates whether to stream module zip files to the
+	// client as they are received from upstream, rather than only after
+	// they have been fully downloaded and verified. The module files are
+	// still put to the Cacher only after the verification succeeds, and the
+	// client connection is aborted if the verification fails after the
+	// streaming has started.
+	//
+	// StreamDownloads takes effect only for cache misses of GET requests
+	// without the Range header, and only if Fetcher implements the
+	// DownloadStream method documented in [Fetcher], as [GoFetcher] does.
+	StreamDownloads bool
+
+	// ServeEnrichedList indicates whether to serve a non-standard JSON
+	// variant of the version list at "<module>/@v/list.json", in addition
+	// to the standard "<module>/@v/list". Each entry of the JSON array has
+	// the following fields:
+	//   - Version: the version.
+	//   - Pseudo: whether the version is a pseudo-version. Pseudo-versions
+	//     are listed only if they are cached and the Cacher implements the
+	//     List method documented in [Cacher].
+	//   - Retracted: whether the version is covered by a "retract"
+	//     directive in the "go.mod" file from the latest version of the
+	//     same module.
+	//   - Rationale: the rationale of the "retract" directive, if any.
+	ServeEnrichedList bool
+
+	// ZipHashes indicates whether to store the "h1:" hash of each module
+	// zip file put to the Cacher alongside it as
+	// "<module>/@v/<version>.ziphash", in the same way as the module cache
+	// of the go command does. It also enables a non-standard endpoint at
+	// "<module>/@v/<version>.sum" that serves the go.sum lines of the module
+	// version (for both the zip file and the "go.mod" file) without the
+	// client having to download the zip file.
+	ZipHashes bool
+
+	// VerifyCachedZips indicates whether to verify each module zip file
+	// got from the Cacher against its stored "h1:" hash (see
+	// [Goproxy.ZipHashes]). A cached zip file that fails the verification is
+	// treated as missing, so it will be fetched again.
+	//
+	// VerifyCachedZips takes effect only for cached zip files that have a
+	// stored hash and whose content implements [io.Seeker].
+	VerifyCachedZips bool
+
+	// CacheDigests indicates whether to store the SHA-256 digest of each
+	// content put to the Cacher alongside it as "<name>.sha256", and to
+	// verify the content got from the Cacher against its stored digest. A
+	// cached content that fails the verification is quarantined by copying
+	// it to "quarantine/<name>" (and deleting the original if the Cacher
+	// implements the Delete method documented in [Cacher]), and is then
+	// treated as missing, so it will be fetched again.
+	//
+	// Only immutable content is covered, namely module files of canonical
+	// versions (including their "<name>.ziphash") and checksum database
+	// lookups and tiles. Mutable content such as "@v/list", "@latest", and
+	// checksum database "latest" is rewritten all the time, so it could be
+	// read between the puts of its content and its digest.
+	//
+	// The verification takes effect only for cached content that has a
+	// stored digest and implements [io.Seeker]. See [Goproxy.ScrubCache]
+	// for verifying all cached content in the background.
+	CacheDigests bool
+
+	// RedirectCachedZips indicates whether to answer requests for cached
+	// module zip files with a 302 redirect to the URL returned by the
+	// RedirectURL method documented in [Cacher], instead of serving their
+	// content through g. This saves the bandwidth and CPU of g, but
+	// requires clients to be able to follow redirects.
+	//
+	// RedirectCachedZips takes effect only if the Cacher implements the
+	// RedirectURL method. Note that redirected zip files are not verified
+	// by g (see [Goproxy.CacheDigests] and [Goproxy.VerifyCachedZips]).
+	RedirectCachedZips bool
+
+	// HotModules is the number of most frequently requested modules whose
+	// "@v/list" and "@latest" are refreshed by [Goproxy.RefreshHotModules].
+	// Request frequencies are tracked only for requests that are allowed to
+	// fetch (see the "Disable-Module-Fetch" header).
+	//
+	// If HotModules is zero, request frequencies are not tracked.
+	HotModules int
+
+	// PrefetchNewVersions indicates whether [Goproxy.RefreshHotModules]
+	// also fetches the module files of versions newly appearing in the
+	// "@v/list" of hot modules, so that the first request for a newly
+	// released version can be served from the cache.
+	PrefetchNewVersions bool
+
 	// Logger is used to log messages that occur during proxying. It is
 	// currently used only for error messages.
 	//
 	// If Logger is nil, [slog.Default] with group name "goproxy" is used.
 	Logger *slog.Logger
 
+	// AccessLogger is used to log an access record for each request. Each
+	// record includes the request method and target, the module path and
+	// version (if any), the response status, the number of bytes written,
+	// the duration, where the content came from (the cache, an upstream
+	// proxy from the GOPROXY list, or a direct fetch), and the client
+	// identity.
+	//
+	// Note that the content source is only known for the default
+	// [GoFetcher] and the cache.
+	//
+	// If AccessLogger is nil, access logging is disabled.
+	AccessLogger *slog.Logger
+
+	// OnFetch is called after a request has been served using content
+	// fetched from the Fetcher, typically on a cache miss. It is not
+	// called for requests served entirely from the Cacher. It is also
+	// called after [Goproxy.RefreshHotModules] prefetches a new version,
+	// so that versions cached ahead of requests are reported as well. It
+	// is called synchronously in the request goroutine (or the goroutine
+	// calling RefreshHotModules), so it should return quickly.
+	//
+	// See [MetadataAPI.RecordFetch] for an example of usage.
+	//
+	// If OnFetch is nil, fetch events are not reported.
+	OnFetch func(FetchEvent)
+
 	initOnce      sync.Once
 	fetcher       Fetcher
 	proxiedSumDBs map[string]*url.URL
 	httpClient    *http.Client
 	logger        *slog.Logger
+	hotModules    hotModuleTracker
 }
 
 // init initializes the g.
 func (g *Goproxy) init() {
 	g.fetcher = g.Fetcher
 	if g.fetcher == nil {
-		g.fetcher = &GoFetcher{TempDir: g.TempDir, Transport: g.Transport}
+		g.fetcher = &GoFetcher{TempDir: g.TempDir, Transport: g.Transport, RetryPolicy: g.RetryPolicy}
 	}
 
 	g.proxiedSumDBs = make(map[string]*url.URL)
@@ -121,6 +250,31 @@ func (g *Goproxy) init() {
 func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	g.initOnce.Do(g.init)
 
+	if cf, ok := g.Fetcher.(*ClusterFetcher); ok && cf.forwarded(req) {
+		req = req.WithContext(withClusterForwarded(req.Context()))
+	}
+
+	if g.AccessLogger != nil || g.OnFetch != nil {
+		startTime := time.Now()
+		ft := &fetchTrace{target: strings.TrimPrefix(req.URL.Path, "/")}
+		req = req.WithContext(withFetchTrace(req.Context(), ft))
+		var alrw *accessLogResponseWriter
+		if g.AccessLogger != nil {
+			alrw = &accessLogResponseWriter{ResponseWriter: rw}
+			rw = alrw
+		}
+		defer func() {
+			if alrw != nil {
+				g.logAccess(req, ft, alrw, time.Since(startTime))
+			}
+			if g.OnFetch != nil {
+				if fe, ok := ft.fetchEvent(req, startTime); ok {
+					g.OnFetch(fe)
+				}
+			}
+		}()
+	}
+
 	switch req.Method {
 	case http.MethodGet, http.MethodHead:
 	default:
@@ -134,6 +288,7 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 	target := path[1:] // Remove the leading slash.
+	fetchTraceFromContext(req.Context()).setTarget(target)
 
 	if strings.HasPrefix(target, "sumdb/") {
 		g.serveSumDB(rw, req, target)
@@ -156,6 +311,9 @@ func (g *Goproxy) serveFetch(rw http.ResponseWriter, req *http.Request, target s
 		responseNotFound(rw, req, 86400, err)
 		return
 	}
+	if !noFetch {
+		g.recordHotModule(modulePath)
+	}
 	switch after {
 	case "latest":
 		g.serveFetchQuery(rw, req, target, modulePath, after, noFetch)
@@ -163,6 +321,11 @@ func (g *Goproxy) serveFetch(rw http.ResponseWriter, req *http.Request, target s
 	case "v/list":
 		g.serveFetchList(rw, req, target, modulePath, noFetch)
 		return
+	case "v/list.json":
+		if g.ServeEnrichedList {
+			g.serveFetchEnrichedList(rw, req, target, modulePath, noFetch)
+			return
+		}
 	}
 
 	if !strings.HasPrefix(after, "v/") {
@@ -173,6 +336,11 @@ func (g *Goproxy) serveFetch(rw http.ResponseWriter, req *http.Request, target s
 	ext := path.Ext(after)
 	switch ext {
 	case ".info", ".mod", ".zip":
+	case ".sum":
+		if !g.ZipHashes {
+			responseNotFound(rw, req, 86400, fmt.Sprintf("unexpected extension %q", ext))
+			return
+		}
 	case "":
 		responseNotFound(rw, req, 86400, fmt.Sprintf("no file extension in filename %q", after))
 		return
@@ -192,7 +360,13 @@ func (g *Goproxy) serveFetch(rw http.ResponseWriter, req *http.Request, target s
 		responseNotFound(rw, req, 86400, "invalid version")
 		return
 	}
-	if checkCanonicalVersion(modulePath, moduleVersion) == nil {
+	if ext == ".sum" {
+		if checkCanonicalVersion(modulePath, moduleVersion) != nil {
+			responseNotFound(rw, req, 86400, "unrecognized version")
+			return
+		}
+		g.serveFetchSum(rw, req, target, modulePath, moduleVersion, noFetch)
+	} else if checkCanonicalVersion(modulePath, moduleVersion) == nil {
 		g.serveFetchDownload(rw, req, target, modulePath, moduleVersion, noFetch)
 	} else if ext == ".info" {
 		g.serveFetchQuery(rw, req, target, modulePath, moduleVersion, noFetch)
@@ -207,6 +381,7 @@ func (g *Goproxy) serveFetchQuery(rw http.ResponseWriter, req *http.Request, tar
 		contentType        = "application/json; charset=utf-8"
 		cacheControlMaxAge = 60
 	)
+	fetchTraceFromContext(req.Context()).setModule(modulePath, moduleQuery)
 	if noFetch {
 		g.serveCache(rw, req, target, contentType, cacheControlMaxAge, nil)
 		return
@@ -219,6 +394,7 @@ func (g *Goproxy) serveFetchQuery(rw http.ResponseWriter, req *http.Request, tar
 		})
 		return
 	}
+	fetchTraceFromContext(req.Context()).setFetched()
 	g.servePutCache(rw, req, target, contentType, cacheControlMaxAge, strings.NewReader(marshalInfo(version, time)))
 }
 
@@ -228,6 +404,7 @@ func (g *Goproxy) serveFetchList(rw http.ResponseWriter, req *http.Request, targ
 		contentType        = "text/plain; charset=utf-8"
 		cacheControlMaxAge = 60
 	)
+	fetchTraceFromContext(req.Context()).setModule(modulePath, "")
 	if noFetch {
 		g.serveCache(rw, req, target, contentType, cacheControlMaxAge, nil)
 		return
@@ -240,13 +417,179 @@ func (g *Goproxy) serveFetchList(rw http.ResponseWriter, req *http.Request, targ
 		})
 		return
 	}
+	fetchTraceFromContext(req.Context()).setFetched()
 	g.servePutCache(rw, req, target, contentType, cacheControlMaxAge, strings.NewReader(strings.Join(versions, "\n")))
 }
 
+// enrichedListEntry is an entry of the list served by
+// [Goproxy.serveFetchEnrichedList].
+type enrichedListEntry struct {
+	Version   string
+	Pseudo    bool   `json:",omitempty"`
+	Retracted bool   `json:",omitempty"`
+	Rationale string `json:",omitempty"`
+}
+
+// serveFetchEnrichedList serves fetch enriched list requests. See
+// [Goproxy.ServeEnrichedList] for details.
+func (g *Goproxy) serveFetchEnrichedList(rw http.ResponseWriter, req *http.Request, target, modulePath string, noFetch bool) {
+	const (
+		contentType        = "application/json; charset=utf-8"
+		cacheControlMaxAge = 60
+	)
+	fetchTraceFromContext(req.Context()).setModule(modulePath, "")
+	if noFetch {
+		g.serveCache(rw, req, target, contentType, cacheControlMaxAge, nil)
+		return
+	}
+	entries, err := g.enrichedList(req.Context(), modulePath)
+	if err != nil {
+		g.serveCache(rw, req, target, contentType, cacheControlMaxAge, func() {
+			g.logger.Error("failed to list module versions", "error", err, "target", target)
+			responseError(rw, req, err, true)
+		})
+		return
+	}
+	fetchTraceFromContext(req.Context()).setFetched()
+	b, err := json.Marshal(entries)
+	if err != nil {
+		g.logger.Error("failed to marshal module versions", "error", err, "target", target)
+		responseInternalServerError(rw, req)
+		return
+	}
+	g.servePutCache(rw, req, target, contentType, cacheControlMaxAge, bytes.NewReader(b))
+}
+
+// enrichedList returns the enriched list of versions for the module path.
+func (g *Goproxy) enrichedList(ctx context.Context, modulePath string) ([]enrichedListEntry, error) {
+	versions, err := g.fetcher.List(ctx, modulePath)
+	if err != nil {
+		return nil, err
+	}
+	tagged := slices.Clone(versions)
+
+	if cl, ok := g.Cacher.(interface {
+		List(ctx context.Context, prefix string) (names []string, err error)
+	}); ok {
+		escapedModulePath, err := module.EscapePath(modulePath)
+		if err != nil {
+			return nil, err
+		}
+		prefix := escapedModulePath + "/@v/"
+		names, err := cl.List(ctx, prefix)
+		if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			return nil, err
+		}
+		for _, name := range names {
+			escapedVersion, ok := strings.CutSuffix(strings.TrimPrefix(name, prefix), ".info")
+			if !ok || strings.Contains(escapedVersion, "/") {
+				continue
+			}
+			version, err := module.UnescapeVersion(escapedVersion)
+			if err != nil || !module.IsPseudoVersion(version) || checkCanonicalVersion(modulePath, version) != nil {
+				continue
+			}
+			versions = append(versions, version)
+		}
+	}
+	semver.Sort(versions)
+	versions = slices.Compact(versions)
+
+	entries := make([]enrichedListEntry, 0, len(versions))
+	for _, version := range versions {
+		entries = append(entries, enrichedListEntry{Version: version, Pseudo: module.IsPseudoVersion(version)})
+	}
+	if len(tagged) == 0 {
+		return entries, nil
+	}
+
+	// The latest version is the highest release version, or the highest
+	// pre-release version if there are no release versions.
+	semver.Sort(tagged)
+	latest := tagged[len(tagged)-1]
+	for i := len(tagged) - 1; i >= 0; i-- {
+		if semver.Prerelease(tagged[i]) == "" {
+			latest = tagged[i]
+			break
+		}
+	}
+	// Retractions are best-effort, so the entries are still served without
+	// them if the mod file of the latest version is unavailable or invalid.
+	mod, err := g.modFile(ctx, modulePath, latest)
+	if err != nil {
+		g.logger.Error("failed to get latest module mod file for retractions", "error", err, "module", modulePath, "version", latest)
+		return entries, nil
+	}
+	f, err := modfile.ParseLax("go.mod", mod, nil)
+	if err != nil {
+		g.logger.Error("failed to parse latest module mod file for retractions", "error", err, "module", modulePath, "version", latest)
+		return entries, nil
+	}
+	for i, entry := range entries {
+		for _, r := range f.Retract {
+			if semver.Compare(r.Low, entry.Version) <= 0 && semver.Compare(entry.Version, r.High) <= 0 {
+				entries[i].Retracted = true
+				entries[i].Rationale = r.Rationale
+				break
+			}
+		}
+	}
+	return entries, nil
+}
+
+// modFile returns the content of the mod file for the module path and
+// version, from the g.Cacher if possible. A fetched mod file is put to the
+// g.Cacher.
+func (g *Goproxy) modFile(ctx context.Context, modulePath, moduleVersion string) ([]byte, error) {
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return nil, err
+	}
+	escapedModuleVersion, err := module.EscapeVersion(moduleVersion)
+	if err != nil {
+		return nil, err
+	}
+	name := escapedModulePath + "/@v/" + escapedModuleVersion + ".mod"
+	if content, err := g.cache(ctx, name); err == nil {
+		defer content.Close()
+		return io.ReadAll(content)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	var mod io.ReadSeekCloser
+	if dm, ok := g.fetcher.(interface {
+		DownloadMod(ctx context.Context, path, version string) (mod io.ReadSeekCloser, err error)
+	}); ok {
+		mod, err = dm.DownloadMod(ctx, modulePath, moduleVersion)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var info, zip io.ReadSeekCloser
+		info, mod, zip, err = g.fetcher.Download(ctx, modulePath, moduleVersion)
+		if err != nil {
+			return nil, err
+		}
+		info.Close()
+		zip.Close()
+	}
+	defer mod.Close()
+	if err := g.putCache(ctx, name, mod); err != nil {
+		return nil, err
+	}
+	if _, err := mod.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(mod)
+}
+
 // serveFetchDownload serves fetch download requests.
 func (g *Goproxy) serveFetchDownload(rw http.ResponseWriter, req *http.Request, target, modulePath, moduleVersion string, noFetch bool) {
 	const cacheControlMaxAge = 604800
 
+	fetchTraceFromContext(req.Context()).setModule(modulePath, moduleVersion)
+
 	ext := path.Ext(target)
 	var contentType string
 	switch ext {
@@ -258,6 +601,10 @@ func (g *Goproxy) serveFetchDownload(rw http.ResponseWriter, req *http.Request,
 		contentType = "application/zip"
 	}
 
+	if ext == ".zip" && g.redirectCache(rw, req, target) {
+		return
+	}
+
 	if noFetch {
 		g.serveCache(rw, req, target, contentType, cacheControlMaxAge, nil)
 		return
@@ -265,6 +612,7 @@ func (g *Goproxy) serveFetchDownload(rw http.ResponseWriter, req *http.Request,
 
 	if content, err := g.cache(req.Context(), target); err == nil {
 		defer content.Close()
+		fetchTraceFromContext(req.Context()).setSource(fetchSourceCache, "")
 		responseSuccess(rw, req, content, contentType, cacheControlMaxAge)
 		return
 	} else if !errors.Is(err, fs.ErrNotExist) {
@@ -273,15 +621,71 @@ func (g *Goproxy) serveFetchDownload(rw http.ResponseWriter, req *http.Request,
 		return
 	}
 
-	info, mod, zip, err := g.fetcher.Download(req.Context(), modulePath, moduleVersion)
+	ds, stream := g.fetcher.(interface {
+		DownloadStream(ctx context.Context, path, version string, zipDst io.Writer) (info, mod, zip io.ReadSeekCloser, err error)
+	})
+	stream = stream && g.StreamDownloads && ext == ".zip" && req.Method == http.MethodGet && req.Header.Get("Range") == ""
+	if !stream {
+		var downloadFile func(ctx context.Context, path, version string) (io.ReadSeekCloser, error)
+		switch ext {
+		case ".info":
+			if df, ok := g.fetcher.(interface {
+				DownloadInfo(ctx context.Context, path, version string) (info io.ReadSeekCloser, err error)
+			}); ok {
+				downloadFile = df.DownloadInfo
+			}
+		case ".mod":
+			if df, ok := g.fetcher.(interface {
+				DownloadMod(ctx context.Context, path, version string) (mod io.ReadSeekCloser, err error)
+			}); ok {
+				downloadFile = df.DownloadMod
+			}
+		case ".zip":
+			if df, ok := g.fetcher.(interface {
+				DownloadZip(ctx context.Context, path, version string) (zip io.ReadSeekCloser, err error)
+			}); ok {
+				downloadFile = df.DownloadZip
+			}
+		}
+		if downloadFile != nil {
+			content, err := downloadFile(req.Context(), modulePath, moduleVersion)
+			if err != nil {
+				g.logger.Error("failed to download module file", "error", err, "target", target)
+				responseError(rw, req, err, false)
+				return
+			}
+			defer content.Close()
+			fetchTraceFromContext(req.Context()).setFetched()
+			g.servePutCache(rw, req, target, contentType, cacheControlMaxAge, content)
+			return
+		}
+	}
+
+	var (
+		info, mod, zip io.ReadSeekCloser
+		zipStream      *responseStream
+		err            error
+	)
+	if stream {
+		zipStream = &responseStream{rw: rw, contentType: contentType, cacheControlMaxAge: cacheControlMaxAge}
+		info, mod, zip, err = ds.DownloadStream(req.Context(), modulePath, moduleVersion, zipStream)
+	} else {
+		info, mod, zip, err = g.fetcher.Download(req.Context(), modulePath, moduleVersion)
+	}
 	if err != nil {
 		g.logger.Error("failed to download module version", "error", err, "target", target)
+		if zipStream != nil && zipStream.started {
+			// The client must not mistake the partial content for
+			// a complete module zip file.
+			panic(http.ErrAbortHandler)
+		}
 		responseError(rw, req, err, false)
 		return
 	}
 	defer info.Close()
 	defer mod.Close()
 	defer zip.Close()
+	fetchTraceFromContext(req.Context()).setFetched()
 
 	targetWithoutExt := strings.TrimSuffix(target, path.Ext(target))
 	for _, cache := range []struct {
@@ -294,10 +698,16 @@ func (g *Goproxy) serveFetchDownload(rw http.ResponseWriter, req *http.Request,
 	} {
 		if err := g.putCache(req.Context(), targetWithoutExt+cache.ext, cache.content); err != nil {
 			g.logger.Error("failed to cache module file", "error", err, "target", target)
+			if zipStream != nil && zipStream.started {
+				return // The verified content has already been served.
+			}
 			responseInternalServerError(rw, req)
 			return
 		}
 	}
+	if zipStream != nil && zipStream.started {
+		return
+	}
 
 	var content io.ReadSeeker
 	switch ext {
@@ -316,6 +726,186 @@ func (g *Goproxy) serveFetchDownload(rw http.ResponseWriter, req *http.Request,
 	responseSuccess(rw, req, content, contentType, 604800)
 }
 
+// serveFetchSum serves fetch requests for the go.sum lines of module versions.
+// See [Goproxy.ZipHashes] for details.
+func (g *Goproxy) serveFetchSum(rw http.ResponseWriter, req *http.Request, target, modulePath, moduleVersion string, noFetch bool) {
+	const (
+		contentType        = "text/plain; charset=utf-8"
+		cacheControlMaxAge = 604800
+	)
+
+	fetchTraceFromContext(req.Context()).setModule(modulePath, moduleVersion)
+
+	targetWithoutExt := strings.TrimSuffix(target, path.Ext(target))
+	zipHash, fetched, err := g.zipHash(req.Context(), targetWithoutExt, modulePath, moduleVersion, noFetch)
+	if err != nil {
+		if noFetch && errors.Is(err, fs.ErrNotExist) {
+			responseNotFound(rw, req, 60, "temporarily unavailable")
+			return
+		}
+		g.logger.Error("failed to get module zip hash", "error", err, "target", target)
+		responseError(rw, req, err, false)
+		return
+	}
+
+	var mod []byte
+	if noFetch {
+		var content io.ReadCloser
+		if content, err = g.cache(req.Context(), targetWithoutExt+".mod"); err == nil {
+			mod, err = io.ReadAll(content)
+			content.Close()
+		}
+	} else {
+		mod, err = g.modFile(req.Context(), modulePath, moduleVersion)
+	}
+	if err != nil {
+		if noFetch && errors.Is(err, fs.ErrNotExist) {
+			responseNotFound(rw, req, 60, "temporarily unavailable")
+			return
+		}
+		g.logger.Error("failed to get module mod file", "error", err, "target", target)
+		responseError(rw, req, err, false)
+		return
+	}
+	modHash, err := hashMod(mod)
+	if err != nil {
+		g.logger.Error("failed to hash module mod file", "error", err, "target", target)
+		responseInternalServerError(rw, req)
+		return
+	}
+
+	if fetched {
+		fetchTraceFromContext(req.Context()).setFetched()
+	} else {
+		fetchTraceFromContext(req.Context()).setSource(fetchSourceCache, "")
+	}
+	responseString(rw, req, http.StatusOK, cacheControlMaxAge, fmt.Sprintf(
+		"%s %s %s\n%s %s/go.mod %s\n",
+		modulePath, moduleVersion, zipHash,
+		modulePath, moduleVersion, modHash,
+	))
+}
+
+// CachedZipHash returns the "h1:" hash (as used in go.sum files) of the module
+// zip file cached by g.Cacher under the name, which must end with ".zip". The
+// hash stored alongside the zip file is used if possible (see
+// [Goproxy.VerifyCachedZips]). It never fetches anything, and the returned
+// error matches [fs.ErrNotExist] if the zip file is not cached.
+func (g *Goproxy) CachedZipHash(ctx context.Context, name string) (string, error) {
+	g.initOnce.Do(g.init)
+	nameWithoutExt, ok := strings.CutSuffix(name, ".zip")
+	if !ok {
+		return "", notExistErrorf("not a module zip file: %s", name)
+	}
+	hash, _, err := g.zipHash(ctx, nameWithoutExt, "", "", true)
+	return hash, err
+}
+
+// zipHash returns the "h1:" hash of the module zip file for the module path
+// and version, whose cache name without the extension is the nameWithoutExt.
+// The hash is read from the g.Cacher if possible, otherwise it is computed
+// from the cached zip file, or from a fetched one if the noFetch is false. It
+// reports whether the zip file has been fetched.
+func (g *Goproxy) zipHash(ctx context.Context, nameWithoutExt, modulePath, moduleVersion string, noFetch bool) (hash string, fetched bool, err error) {
+	if content, err := g.cache(ctx, nameWithoutExt+".ziphash"); err == nil {
+		defer content.Close()
+		b, err := io.ReadAll(content)
+		if err != nil {
+			return "", false, err
+		}
+		return strings.TrimSpace(string(b)), false, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", false, err
+	}
+
+	if content, err := g.cache(ctx, nameWithoutExt+".zip"); err == nil {
+		defer content.Close()
+		rs, ok := content.(io.ReadSeeker)
+		if !ok {
+			f, err := os.CreateTemp(g.TempDir, tempDirPattern)
+			if err != nil {
+				return "", false, err
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+			if _, err := io.Copy(f, content); err != nil {
+				return "", false, err
+			}
+			rs = f
+		}
+		hash, err := hashZip(rs, g.TempDir)
+		if err != nil {
+			return "", false, err
+		}
+		if err := g.putCache(ctx, nameWithoutExt+".ziphash", strings.NewReader(hash)); err != nil {
+			return "", false, err
+		}
+		return hash, false, nil
+	} else if !errors.Is(err, fs.ErrNotExist) || noFetch {
+		return "", false, err
+	}
+
+	var zip io.ReadSeekCloser
+	if dz, ok := g.fetcher.(interface {
+		DownloadZip(ctx context.Context, path, version string) (zip io.ReadSeekCloser, err error)
+	}); ok {
+		if zip, err = dz.DownloadZip(ctx, modulePath, moduleVersion); err != nil {
+			return "", false, err
+		}
+		defer zip.Close()
+	} else {
+		var info, mod io.ReadSeekCloser
+		if info, mod, zip, err = g.fetcher.Download(ctx, modulePath, moduleVersion); err != nil {
+			return "", false, err
+		}
+		defer info.Close()
+		defer mod.Close()
+		defer zip.Close()
+		if err := g.putCache(ctx, nameWithoutExt+".info", info); err != nil {
+			return "", false, err
+		}
+		if err := g.putCache(ctx, nameWithoutExt+".mod", mod); err != nil {
+			return "", false, err
+		}
+	}
+	hash, err = hashZip(zip, g.TempDir)
+	if err != nil {
+		return "", false, err
+	}
+	if err := g.putZipCache(ctx, nameWithoutExt+".zip", zip, hash); err != nil {
+		return "", false, err
+	}
+	return hash, true, nil
+}
+
+// verifyCachedZip reports whether the cached zip file content for the name
+// matches its stored "h1:" hash. It also reports true if there is no stored
+// hash or the content does not implement [io.Seeker]. The content is rewound
+// before returning.
+func (g *Goproxy) verifyCachedZip(ctx context.Context, name string, content io.ReadCloser) (bool, error) {
+	rs, ok := content.(io.ReadSeeker)
+	if !ok {
+		return true, nil
+	}
+	hashContent, err := g.Cacher.Get(ctx, strings.TrimSuffix(name, ".zip")+".ziphash")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer hashContent.Close()
+	b, err := io.ReadAll(hashContent)
+	if err != nil {
+		return false, err
+	}
+	hash, err := hashZip(rs, g.TempDir)
+	if err != nil {
+		return false, nil // An unreadable zip file cannot match any hash.
+	}
+	return hash == strings.TrimSpace(string(b)), nil
+}
+
 // serveSumDB serves checksum database proxy requests.
 func (g *Goproxy) serveSumDB(rw http.ResponseWriter, req *http.Request, target string) {
 	name, path, ok := strings.Cut(strings.TrimPrefix(target, "sumdb/"), "/")
@@ -361,7 +951,7 @@ func (g *Goproxy) serveSumDB(rw http.ResponseWriter, req *http.Request, target s
 	}
 	defer os.RemoveAll(tempDir)
 
-	file, err := httpGetTemp(req.Context(), g.httpClient, u.JoinPath(path).String(), tempDir)
+	file, err := httpGetTemp(req.Context(), g.httpClient, g.RetryPolicy, u.JoinPath(path).String(), tempDir)
 	if err != nil {
 		g.serveCache(rw, req, target, contentType, cacheControlMaxAge, func() {
 			g.logger.Error("failed to proxy checksum database", "error", err, "target", target)
@@ -369,9 +959,39 @@ func (g *Goproxy) serveSumDB(rw http.ResponseWriter, req *http.Request, target s
 		})
 		return
 	}
+	fetchTraceFromContext(req.Context()).setSource(fetchSourceProxy, u.Redacted())
 	g.servePutCacheFile(rw, req, target, contentType, cacheControlMaxAge, file)
 }
 
+// redirectCache redirects the client to the URL returned by the g.Cacher for
+// the cache name if g.RedirectCachedZips is true. It reports whether the
+// client has been redirected.
+func (g *Goproxy) redirectCache(rw http.ResponseWriter, req *http.Request, name string) bool {
+	if !g.RedirectCachedZips {
+		return false
+	}
+	cr, ok := g.Cacher.(interface {
+		RedirectURL(ctx context.Context, name string) (url string, err error)
+	})
+	if !ok {
+		return false
+	}
+	u, err := cr.RedirectURL(req.Context(), name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			g.logger.Error("failed to get cache redirect URL", "error", err, "name", name)
+		}
+		return false
+	}
+	if u == "" {
+		return false
+	}
+	fetchTraceFromContext(req.Context()).setSource(fetchSourceCache, "")
+	setResponseCacheControlHeader(rw, -1)
+	http.Redirect(rw, req, u, http.StatusFound)
+	return true
+}
+
 // serveCache serves requests with cached content.
 func (g *Goproxy) serveCache(rw http.ResponseWriter, req *http.Request, name, contentType string, cacheControlMaxAge int, onNotFound func()) {
 	content, err := g.cache(req.Context(), name)
@@ -389,6 +1009,7 @@ func (g *Goproxy) serveCache(rw http.ResponseWriter, req *http.Request, name, co
 		return
 	}
 	defer content.Close()
+	fetchTraceFromContext(req.Context()).setSource(fetchSourceCache, "")
 	responseSuccess(rw, req, content, contentType, cacheControlMaxAge)
 }
 
@@ -425,7 +1046,34 @@ func (g *Goproxy) cache(ctx context.Context, name string) (io.ReadCloser, error)
 	if g.Cacher == nil {
 		return nil, fs.ErrNotExist
 	}
-	return g.Cacher.Get(ctx, name)
+	content, err := g.Cacher.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if g.CacheDigests && hasCacheDigest(name) {
+		if ok, err := g.verifyCacheDigest(ctx, name, content); err != nil {
+			content.Close()
+			return nil, err
+		} else if !ok {
+			g.logger.Error("cached content does not match its digest", "name", name)
+			if err := g.quarantineCache(ctx, name, content.(io.ReadSeeker)); err != nil {
+				g.logger.Error("failed to quarantine cached content", "error", err, "name", name)
+			}
+			content.Close()
+			return nil, fs.ErrNotExist
+		}
+	}
+	if g.VerifyCachedZips && strings.HasSuffix(name, ".zip") && !strings.HasPrefix(name, "sumdb/") {
+		if ok, err := g.verifyCachedZip(ctx, name, content); err != nil {
+			content.Close()
+			return nil, err
+		} else if !ok {
+			content.Close()
+			g.logger.Warn("cached module zip file does not match its hash", "name", name)
+			return nil, fs.ErrNotExist
+		}
+	}
+	return content, nil
 }
 
 // putCache puts a cache to the g.Cacher for the name with the content.
@@ -433,7 +1081,48 @@ func (g *Goproxy) putCache(ctx context.Context, name string, content io.ReadSeek
 	if g.Cacher == nil {
 		return nil
 	}
-	return g.Cacher.Put(ctx, name, content)
+	if g.ZipHashes && strings.HasSuffix(name, ".zip") && !strings.HasPrefix(name, "sumdb/") {
+		hash, err := hashZip(content, g.TempDir)
+		if err != nil {
+			return err
+		}
+		return g.putZipCache(ctx, name, content, hash)
+	}
+	return g.putCacheEntry(ctx, name, content)
+}
+
+// putZipCache is like [putCache] but for the module zip file with the known
+// hash, which is put to the g.Cacher right after the zip file.
+func (g *Goproxy) putZipCache(ctx context.Context, name string, content io.ReadSeeker, hash string) error {
+	if g.Cacher == nil {
+		return nil
+	}
+	if err := g.putCacheEntry(ctx, name, content); err != nil {
+		return err
+	}
+	return g.putCacheEntry(ctx, strings.TrimSuffix(name, ".zip")+".ziphash", strings.NewReader(hash))
+}
+
+// putCacheEntry puts a single cache to the g.Cacher for the name with the
+// content, along with its digest if g.CacheDigests is true.
+func (g *Goproxy) putCacheEntry(ctx context.Context, name string, content io.ReadSeeker) error {
+	var digest string
+	if g.CacheDigests && hasCacheDigest(name) {
+		var err error
+		if digest, err = sha256Digest(content); err != nil {
+			return err
+		}
+	}
+	if err := g.Cacher.Put(ctx, name, content); err != nil {
+		return err
+	}
+	if digest != "" {
+		if err := g.Cacher.Put(ctx, name+cacheDigestSuffix, strings.NewReader(digest)); err != nil {
+			return err
+		}
+	}
+	fetchTraceFromContext(ctx).addCached(name)
+	return nil
 }
 
 // putCacheFile is like [putCache] but reads the content from the local file.
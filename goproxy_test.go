@@ -23,6 +23,9 @@ import (
 	"time"
 
 	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/note"
 )
 
 var dialableTCPAddrs sync.Map
@@ -677,6 +680,202 @@ func TestGoproxyServeFetchList(t *testing.T) {
 	}
 }
 
+func TestGoproxyServeFetchEnrichedList(t *testing.T) {
+	list := "v1.0.0\nv1.1.0\nv1.2.0-pre"
+	mod := "module example.com\n\nretract v1.0.0 // Bad release.\n\nretract [v1.2.0-pre, v1.2.0]\n"
+	pseudoVersion := "v0.0.0-20000101000000-abcdefabcdef"
+	proxyHandler := func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/example.com/@v/list":
+			responseSuccess(rw, req, strings.NewReader(list), "text/plain; charset=utf-8", -2)
+		case "/example.com/@v/v1.1.0.mod":
+			responseSuccess(rw, req, strings.NewReader(mod), "text/plain; charset=utf-8", -2)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}
+	enrichedList := `[{"Version":"` + pseudoVersion + `","Pseudo":true},` +
+		`{"Version":"v1.0.0","Retracted":true,"Rationale":"Bad release."},` +
+		`{"Version":"v1.1.0"},` +
+		`{"Version":"v1.2.0-pre","Retracted":true}]`
+	for _, tt := range []struct {
+		n              int
+		proxyHandler   http.HandlerFunc
+		cacher         Cacher
+		noFetch        bool
+		wantStatusCode int
+		wantContent    string
+	}{
+		{
+			n:              1,
+			wantStatusCode: http.StatusOK,
+			wantContent:    enrichedList,
+		},
+		{
+			n: 2,
+			cacher: &testCacher{
+				Cacher: DirCacher(t.TempDir()),
+				get: func(ctx context.Context, c Cacher, name string) (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader(enrichedList)), nil
+				},
+			},
+			noFetch:        true,
+			wantStatusCode: http.StatusOK,
+			wantContent:    enrichedList,
+		},
+		{
+			n:              3,
+			proxyHandler:   func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, -2) },
+			wantStatusCode: http.StatusNotFound,
+			wantContent:    "not found",
+		},
+		{
+			n: 4,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				if req.URL.Path == "/example.com/@v/list" {
+					responseSuccess(rw, req, strings.NewReader(list), "text/plain; charset=utf-8", -2)
+					return
+				}
+				responseNotFound(rw, req, -2)
+			},
+			wantStatusCode: http.StatusOK,
+			wantContent: `[{"Version":"` + pseudoVersion + `","Pseudo":true},` +
+				`{"Version":"v1.0.0"},` +
+				`{"Version":"v1.1.0"},` +
+				`{"Version":"v1.2.0-pre"}]`,
+		},
+		{
+			n: 5,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				switch req.URL.Path {
+				case "/example.com/@v/list":
+					responseSuccess(rw, req, strings.NewReader(list), "text/plain; charset=utf-8", -2)
+				case "/example.com/@v/v1.1.0.mod":
+					responseSuccess(rw, req, strings.NewReader("module example.com\n\nretract [v1.0.0\n"), "text/plain; charset=utf-8", -2)
+				default:
+					responseNotFound(rw, req, -2)
+				}
+			},
+			wantStatusCode: http.StatusOK,
+			wantContent: `[{"Version":"` + pseudoVersion + `","Pseudo":true},` +
+				`{"Version":"v1.0.0"},` +
+				`{"Version":"v1.1.0"},` +
+				`{"Version":"v1.2.0-pre"}]`,
+		},
+		{
+			n: 6,
+			proxyHandler: func(rw http.ResponseWriter, req *http.Request) {
+				responseSuccess(rw, req, strings.NewReader(""), "text/plain; charset=utf-8", -2)
+			},
+			wantStatusCode: http.StatusOK,
+			wantContent:    `[{"Version":"` + pseudoVersion + `","Pseudo":true}]`,
+		},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			if tt.proxyHandler == nil {
+				tt.proxyHandler = proxyHandler
+			}
+			proxyServer := newHTTPTestServer(t, tt.proxyHandler)
+			if tt.cacher == nil {
+				dirCacher := DirCacher(t.TempDir())
+				for _, name := range []string{
+					"example.com/@v/" + pseudoVersion + ".info",
+					"example.com/@v/" + pseudoVersion + ".mod",
+					"example.com/@v/invalid.info",
+					"example.com/foo/@v/v0.0.0-20000101000000-000000000000.info",
+				} {
+					if err := dirCacher.Put(t.Context(), name, strings.NewReader("")); err != nil {
+						t.Fatalf("unexpected error %v", err)
+					}
+				}
+				tt.cacher = dirCacher
+			}
+
+			g := &Goproxy{
+				Fetcher: &GoFetcher{
+					Env:     []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+					TempDir: t.TempDir(),
+				},
+				Cacher:            tt.cacher,
+				TempDir:           t.TempDir(),
+				ServeEnrichedList: true,
+				Logger:            slog.New(slog.DiscardHandler),
+			}
+			g.initOnce.Do(g.init)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/example.com/@v/list.json", nil)
+			if tt.noFetch {
+				req.Header.Set("Disable-Module-Fetch", "true")
+			}
+			g.ServeHTTP(rec, req)
+			recr := rec.Result()
+			if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+			if tt.wantStatusCode == http.StatusOK {
+				if got, want := recr.Header.Get("Content-Type"), "application/json; charset=utf-8"; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+			if got, want := recr.Header.Get("Cache-Control"), "public, max-age=60"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if b, err := io.ReadAll(recr.Body); err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if got, want := string(b), tt.wantContent; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		g := &Goproxy{
+			Fetcher: &GoFetcher{
+				Env:     []string{"GOPROXY=off", "GOSUMDB=off"},
+				TempDir: t.TempDir(),
+			},
+			Logger: slog.New(slog.DiscardHandler),
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/example.com/@v/list.json", nil))
+		if got, want := rec.Code, http.StatusNotFound; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := rec.Body.String(), `not found: unexpected extension ".json"`; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("ModFileCached", func(t *testing.T) {
+		proxyServer := newHTTPTestServer(t, http.HandlerFunc(proxyHandler))
+		cacher := DirCacher(t.TempDir())
+		g := &Goproxy{
+			Fetcher: &GoFetcher{
+				Env:     []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+				TempDir: t.TempDir(),
+			},
+			Cacher:  cacher,
+			TempDir: t.TempDir(),
+			Logger:  slog.New(slog.DiscardHandler),
+		}
+		g.initOnce.Do(g.init)
+
+		b, err := g.modFile(t.Context(), "example.com", "v1.1.0")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := string(b), mod; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if b, err := os.ReadFile(filepath.Join(string(cacher), filepath.FromSlash("example.com/@v/v1.1.0.mod"))); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), mod; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
 func TestGoproxyServeFetchDownload(t *testing.T) {
 	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
 	mod := "module example.com"
@@ -918,6 +1117,352 @@ func TestGoproxyServeFetchDownload(t *testing.T) {
 			t.Errorf("got status %d, want %d", got, want)
 		}
 	})
+
+	t.Run("PerFile", func(t *testing.T) {
+		for _, tt := range []struct {
+			n      int
+			target string
+		}{
+			{1, "example.com/@v/v1.0.0.info"},
+			{2, "example.com/@v/v1.0.0.mod"},
+			{3, "example.com/@v/v1.0.0.zip"},
+		} {
+			t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+				var requests []string
+				proxyServer := newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					requests = append(requests, req.URL.Path)
+					proxyHandler(rw, req)
+				}))
+				cacher := DirCacher(t.TempDir())
+				g := &Goproxy{
+					Fetcher: &GoFetcher{
+						Env:     []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+						TempDir: t.TempDir(),
+					},
+					Cacher:  cacher,
+					TempDir: t.TempDir(),
+					Logger:  slog.New(slog.DiscardHandler),
+				}
+				g.initOnce.Do(g.init)
+
+				rec := httptest.NewRecorder()
+				g.serveFetchDownload(rec, httptest.NewRequest("", "/", nil), tt.target, "example.com", "v1.0.0", false)
+				if got, want := rec.Code, http.StatusOK; got != want {
+					t.Errorf("got %d, want %d", got, want)
+				}
+				if got, want := strings.Join(requests, ","), "/"+tt.target; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+				for _, ext := range []string{".info", ".mod", ".zip"} {
+					name := "example.com/@v/v1.0.0" + ext
+					rc, err := cacher.Get(t.Context(), name)
+					if name == tt.target {
+						if err != nil {
+							t.Errorf("unexpected error %v", err)
+						} else {
+							rc.Close()
+						}
+					} else if got, want := err, fs.ErrNotExist; !compareErrors(got, want) {
+						t.Errorf("got %v, want %v", got, want)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("StreamDownloads", func(t *testing.T) {
+		zipFile, err := makeTempFile(t, zip)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		zipHash, err := dirhash.HashZip(zipFile, dirhash.DefaultHash)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		modHash, err := dirhash.DefaultHash([]string{"go.mod"}, func(string) (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(mod)), nil })
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		skey, vkey, err := note.GenerateKey(nil, "sumdb.example.com")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for _, tt := range []struct {
+			n          int
+			zipHash    string
+			wantCached bool
+			wantErr    bool
+		}{
+			{1, zipHash, true, false},
+			{2, "h1:invalid", false, true},
+		} {
+			t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+				proxyServer := newHTTPTestServer(t, http.HandlerFunc(proxyHandler))
+				sumdbServer := newHTTPTestServer(t, sumdb.NewServer(sumdb.NewTestServer(skey, func(modulePath, moduleVersion string) ([]byte, error) {
+					gosum := fmt.Sprintf("%s %s %s\n", modulePath, moduleVersion, tt.zipHash)
+					gosum += fmt.Sprintf("%s %s/go.mod %s\n", modulePath, moduleVersion, modHash)
+					return []byte(gosum), nil
+				})))
+				cacher := DirCacher(t.TempDir())
+				g := &Goproxy{
+					Fetcher: &GoFetcher{
+						Env:     []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=" + vkey + " " + sumdbServer.URL},
+						TempDir: t.TempDir(),
+					},
+					Cacher:          cacher,
+					TempDir:         t.TempDir(),
+					StreamDownloads: true,
+					Logger:          slog.New(slog.DiscardHandler),
+				}
+				server := newHTTPTestServer(t, g)
+
+				resp, err := http.Get(server.URL + "/example.com/@v/v1.0.0.zip")
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				defer resp.Body.Close()
+				if got, want := resp.StatusCode, http.StatusOK; got != want {
+					t.Errorf("got %d, want %d", got, want)
+				}
+				if got, want := resp.Header.Get("Content-Type"), "application/zip"; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+				b, err := io.ReadAll(resp.Body)
+				if tt.wantErr {
+					if err == nil {
+						t.Error("expected error")
+					}
+				} else if err != nil {
+					t.Errorf("unexpected error %v", err)
+				} else if got, want := string(b), string(zip); got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+
+				_, err = cacher.Get(t.Context(), "example.com/@v/v1.0.0.zip")
+				if tt.wantCached {
+					if err != nil {
+						t.Errorf("unexpected error %v", err)
+					}
+				} else if got, want := err, fs.ErrNotExist; !compareErrors(got, want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			})
+		}
+	})
+}
+
+func TestGoproxyServeFetchSum(t *testing.T) {
+	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	mod := "module example.com"
+	zip, err := makeZip(map[string][]byte{"example.com@v1.0.0/go.mod": []byte(mod)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	zipFile := filepath.Join(t.TempDir(), "v1.0.0.zip")
+	if err := os.WriteFile(zipFile, zip, 0o644); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	zipHash, err := dirhash.HashZip(zipFile, dirhash.DefaultHash)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	modHash, err := dirhash.DefaultHash([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(mod)), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sum := "example.com v1.0.0 " + zipHash + "\nexample.com v1.0.0/go.mod " + modHash + "\n"
+	proxyHandler := func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/example.com/@v/v1.0.0.info":
+			responseSuccess(rw, req, strings.NewReader(info), "application/json; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.mod":
+			responseSuccess(rw, req, strings.NewReader(mod), "text/plain; charset=utf-8", -2)
+		case "/example.com/@v/v1.0.0.zip":
+			responseSuccess(rw, req, bytes.NewReader(zip), "application/zip", -2)
+		default:
+			responseNotFound(rw, req, -2)
+		}
+	}
+	for _, tt := range []struct {
+		n                    int
+		proxyHandler         http.HandlerFunc
+		caches               map[string]string
+		noFetch              bool
+		path                 string
+		wantStatusCode       int
+		wantCacheControl     string
+		wantContent          string
+		wantZipHashCacheFile bool
+	}{
+		{
+			n:                    1,
+			path:                 "/example.com/@v/v1.0.0.sum",
+			wantStatusCode:       http.StatusOK,
+			wantCacheControl:     "public, max-age=604800",
+			wantContent:          sum,
+			wantZipHashCacheFile: true,
+		},
+		{
+			n:                    2,
+			proxyHandler:         func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, -2) },
+			caches:               map[string]string{"example.com/@v/v1.0.0.ziphash": zipHash, "example.com/@v/v1.0.0.mod": mod},
+			noFetch:              true,
+			path:                 "/example.com/@v/v1.0.0.sum",
+			wantStatusCode:       http.StatusOK,
+			wantCacheControl:     "public, max-age=604800",
+			wantContent:          sum,
+			wantZipHashCacheFile: true,
+		},
+		{
+			n:                    3,
+			caches:               map[string]string{"example.com/@v/v1.0.0.zip": string(zip), "example.com/@v/v1.0.0.mod": mod},
+			noFetch:              true,
+			path:                 "/example.com/@v/v1.0.0.sum",
+			wantStatusCode:       http.StatusOK,
+			wantCacheControl:     "public, max-age=604800",
+			wantContent:          sum,
+			wantZipHashCacheFile: true,
+		},
+		{
+			n:                4,
+			noFetch:          true,
+			path:             "/example.com/@v/v1.0.0.sum",
+			wantStatusCode:   http.StatusNotFound,
+			wantCacheControl: "public, max-age=60",
+			wantContent:      "not found: temporarily unavailable",
+		},
+		{
+			n:                5,
+			caches:           map[string]string{"example.com/@v/v1.0.0.ziphash": zipHash},
+			noFetch:          true,
+			path:             "/example.com/@v/v1.0.0.sum",
+			wantStatusCode:   http.StatusNotFound,
+			wantCacheControl: "public, max-age=60",
+			wantContent:      "not found: temporarily unavailable",
+		},
+		{
+			n:                6,
+			proxyHandler:     func(rw http.ResponseWriter, req *http.Request) { responseNotFound(rw, req, -2) },
+			path:             "/example.com/@v/v1.0.0.sum",
+			wantStatusCode:   http.StatusNotFound,
+			wantCacheControl: "public, max-age=600",
+			wantContent:      "not found",
+		},
+		{
+			n:                7,
+			path:             "/example.com/@v/v1.sum",
+			wantStatusCode:   http.StatusNotFound,
+			wantCacheControl: "public, max-age=86400",
+			wantContent:      "not found: unrecognized version",
+		},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			if tt.proxyHandler == nil {
+				tt.proxyHandler = proxyHandler
+			}
+			proxyServer := newHTTPTestServer(t, tt.proxyHandler)
+			cacher := DirCacher(t.TempDir())
+			for name, content := range tt.caches {
+				if err := cacher.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+			}
+			g := &Goproxy{
+				Fetcher: &GoFetcher{
+					Env:     []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+					TempDir: t.TempDir(),
+				},
+				Cacher:    cacher,
+				TempDir:   t.TempDir(),
+				ZipHashes: true,
+				Logger:    slog.New(slog.DiscardHandler),
+			}
+			g.initOnce.Do(g.init)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.noFetch {
+				req.Header.Set("Disable-Module-Fetch", "true")
+			}
+			g.ServeHTTP(rec, req)
+			recr := rec.Result()
+			if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+			if got, want := recr.Header.Get("Cache-Control"), tt.wantCacheControl; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if b, err := io.ReadAll(recr.Body); err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if got, want := string(b), tt.wantContent; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if tt.wantZipHashCacheFile {
+				if b, err := os.ReadFile(filepath.Join(string(cacher), filepath.FromSlash("example.com/@v/v1.0.0.ziphash"))); err != nil {
+					t.Errorf("unexpected error %v", err)
+				} else if got, want := string(b), zipHash; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		g := &Goproxy{
+			Fetcher: &GoFetcher{
+				Env:     []string{"GOPROXY=off", "GOSUMDB=off"},
+				TempDir: t.TempDir(),
+			},
+			Logger: slog.New(slog.DiscardHandler),
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.0.0.sum", nil))
+		if got, want := rec.Code, http.StatusNotFound; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := rec.Body.String(), `not found: unexpected extension ".sum"`; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("VerifyCachedZips", func(t *testing.T) {
+		proxyServer := newHTTPTestServer(t, http.HandlerFunc(proxyHandler))
+		cacher := DirCacher(t.TempDir())
+		for name, content := range map[string]string{
+			"example.com/@v/v1.0.0.zip":     "corrupted",
+			"example.com/@v/v1.0.0.ziphash": zipHash,
+		} {
+			if err := cacher.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		g := &Goproxy{
+			Fetcher: &GoFetcher{
+				Env:     []string{"GOPROXY=" + proxyServer.URL, "GOSUMDB=off"},
+				TempDir: t.TempDir(),
+			},
+			Cacher:           cacher,
+			TempDir:          t.TempDir(),
+			ZipHashes:        true,
+			VerifyCachedZips: true,
+			Logger:           slog.New(slog.DiscardHandler),
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/example.com/@v/v1.0.0.zip", nil))
+		if got, want := rec.Code, http.StatusOK; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := rec.Body.String(), string(zip); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if b, err := os.ReadFile(filepath.Join(string(cacher), filepath.FromSlash("example.com/@v/v1.0.0.zip"))); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), string(zip); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
 }
 
 func TestGoproxyServeSumDB(t *testing.T) {
@@ -1140,6 +1685,102 @@ func TestGoproxyServeCache(t *testing.T) {
 	}
 }
 
+func TestGoproxyRedirectCache(t *testing.T) {
+	zip, err := makeZip(map[string][]byte{"example.com@v1.0.0/go.mod": []byte("module example.com")})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, tt := range []struct {
+		n                  int
+		redirectCachedZips bool
+		target             string
+		redirectURL        string
+		redirectErr        error
+		wantStatusCode     int
+		wantLocation       string
+	}{
+		{
+			n:                  1,
+			redirectCachedZips: true,
+			target:             "example.com/@v/v1.0.0.zip",
+			redirectURL:        "https://cdn.example.com/example.com/@v/v1.0.0.zip",
+			wantStatusCode:     http.StatusFound,
+			wantLocation:       "https://cdn.example.com/example.com/@v/v1.0.0.zip",
+		},
+		{
+			n:              2,
+			target:         "example.com/@v/v1.0.0.zip",
+			redirectURL:    "https://cdn.example.com/example.com/@v/v1.0.0.zip",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			n:                  3,
+			redirectCachedZips: true,
+			target:             "example.com/@v/v1.0.0.mod",
+			redirectURL:        "https://cdn.example.com/example.com/@v/v1.0.0.mod",
+			wantStatusCode:     http.StatusOK,
+		},
+		{
+			n:                  4,
+			redirectCachedZips: true,
+			target:             "example.com/@v/v1.0.0.zip",
+			wantStatusCode:     http.StatusOK,
+		},
+		{
+			n:                  5,
+			redirectCachedZips: true,
+			target:             "example.com/@v/v1.0.0.zip",
+			redirectErr:        fs.ErrNotExist,
+			wantStatusCode:     http.StatusOK,
+		},
+		{
+			n:                  6,
+			redirectCachedZips: true,
+			target:             "example.com/@v/v1.0.0.zip",
+			redirectErr:        errors.New("cannot redirect"),
+			wantStatusCode:     http.StatusOK,
+		},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			dc := DirCacher(t.TempDir())
+			for name, content := range map[string][]byte{
+				"example.com/@v/v1.0.0.mod": []byte("module example.com"),
+				"example.com/@v/v1.0.0.zip": zip,
+			} {
+				if err := dc.Put(t.Context(), name, bytes.NewReader(content)); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+			}
+			g := &Goproxy{
+				Cacher: &redirectTestCacher{
+					Cacher:      dc,
+					redirectURL: tt.redirectURL,
+					redirectErr: tt.redirectErr,
+				},
+				TempDir:            t.TempDir(),
+				RedirectCachedZips: tt.redirectCachedZips,
+				Logger:             slog.New(slog.DiscardHandler),
+			}
+			req := httptest.NewRequest(http.MethodGet, "/"+tt.target, nil)
+			req.Header.Set("Disable-Module-Fetch", "true")
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, req)
+			recr := rec.Result()
+			if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+			if got, want := recr.Header.Get("Location"), tt.wantLocation; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if tt.wantStatusCode == http.StatusFound {
+				if got, want := recr.Header.Get("Cache-Control"), "must-revalidate, no-cache, no-store"; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
 func TestGoproxyServePutCache(t *testing.T) {
 	for _, tt := range []struct {
 		n              int
@@ -1284,6 +1925,40 @@ func TestGoproxyCache(t *testing.T) {
 		}
 	})
 
+	t.Run("VerifyCachedZips", func(t *testing.T) {
+		zip, err := makeZip(map[string][]byte{"example.com@v1.0.0/go.mod": []byte("module example.com")})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		g := &Goproxy{Cacher: DirCacher(t.TempDir()), TempDir: t.TempDir(), ZipHashes: true, VerifyCachedZips: true}
+		g.initOnce.Do(g.init)
+		if err := g.putCache(t.Context(), "example.com/@v/v1.0.0.zip", bytes.NewReader(zip)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		rc, err := g.cache(t.Context(), "example.com/@v/v1.0.0.zip")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(rc); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), string(zip); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		rc.Close()
+
+		if err := g.Cacher.Put(t.Context(), "example.com/@v/v1.0.0.zip", strings.NewReader("corrupted")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		_, err = g.cache(t.Context(), "example.com/@v/v1.0.0.zip")
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if got, want := err, fs.ErrNotExist; !compareErrors(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
 	t.Run("NoCacher", func(t *testing.T) {
 		g := &Goproxy{TempDir: t.TempDir()}
 		g.initOnce.Do(g.init)
@@ -1317,6 +1992,43 @@ func TestGoproxyPutCache(t *testing.T) {
 		}
 	})
 
+	t.Run("ZipHashes", func(t *testing.T) {
+		zip, err := makeZip(map[string][]byte{"example.com@v1.0.0/go.mod": []byte("module example.com")})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		zipFile := filepath.Join(t.TempDir(), "v1.0.0.zip")
+		if err := os.WriteFile(zipFile, zip, 0o644); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		zipHash, err := dirhash.HashZip(zipFile, dirhash.DefaultHash)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		cacheDir := t.TempDir()
+		g := &Goproxy{Cacher: DirCacher(cacheDir), TempDir: t.TempDir(), ZipHashes: true}
+		g.initOnce.Do(g.init)
+
+		if err := g.putCache(t.Context(), "example.com/@v/v1.0.0.zip", bytes.NewReader(zip)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := os.ReadFile(filepath.Join(cacheDir, filepath.FromSlash("example.com/@v/v1.0.0.zip"))); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), string(zip); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if b, err := os.ReadFile(filepath.Join(cacheDir, filepath.FromSlash("example.com/@v/v1.0.0.ziphash"))); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), zipHash; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if err := g.putCache(t.Context(), "example.com/@v/v1.1.0.zip", strings.NewReader("invalid")); err == nil {
+			t.Error("expected error")
+		}
+	})
+
 	t.Run("NoCacher", func(t *testing.T) {
 		g := &Goproxy{TempDir: t.TempDir()}
 		g.initOnce.Do(g.init)
@@ -1488,3 +2200,51 @@ func (c *testCacher) Put(ctx context.Context, name string, content io.ReadSeeker
 	}
 	return c.Cacher.Put(ctx, name, content)
 }
+
+type redirectTestCacher struct {
+	Cacher
+	redirectURL string
+	redirectErr error
+}
+
+func (c *redirectTestCacher) RedirectURL(ctx context.Context, name string) (string, error) {
+	return c.redirectURL, c.redirectErr
+}
+
+func TestGoproxyCachedZipHash(t *testing.T) {
+	zip, err := makeZip(map[string][]byte{"example.com@v1.0.0/go.mod": []byte("module example.com")})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	wantHash, err := hashZip(bytes.NewReader(zip), t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	dirCacher := DirCacher(t.TempDir())
+	if err := dirCacher.Put(t.Context(), "example.com/@v/v1.0.0.zip", bytes.NewReader(zip)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	g := &Goproxy{
+		Fetcher: &GoFetcher{Env: []string{"GOPROXY=off"}},
+		Cacher:  dirCacher,
+		TempDir: t.TempDir(),
+		Logger:  slog.New(slog.DiscardHandler),
+	}
+
+	hash, err := g.CachedZipHash(t.Context(), "example.com/@v/v1.0.0.zip")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got, want := hash, wantHash; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := dirCacher.Get(t.Context(), "example.com/@v/v1.0.0.ziphash"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	for _, name := range []string{"example.com/@v/v2.0.0.zip", "example.com/@v/v1.0.0.mod"} {
+		if _, err := g.CachedZipHash(t.Context(), name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+	}
+}
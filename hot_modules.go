@@ -0,0 +1,302 @@
+package goproxy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+const (
+	// hotModulesTrackedFactor is the factor of [Goproxy.HotModules] that
+	// limits the number of module paths whose request frequencies are
+	// tracked.
+	hotModulesTrackedFactor = 100
+
+	// hotModulesMinPause is the initial duration for which
+	// [Goproxy.RefreshHotModules] pauses after upstreams fail.
+	hotModulesMinPause = time.Minute
+
+	// hotModulesMaxPause is the maximum duration for which
+	// [Goproxy.RefreshHotModules] pauses after upstreams fail.
+	hotModulesMaxPause = time.Hour
+)
+
+// hotModuleTracker tracks request frequencies of module paths, and the
+// versions last seen by refreshes of them.
+type hotModuleTracker struct {
+	mu          sync.Mutex
+	counts      map[string]int
+	versions    map[string][]string
+	pause       time.Duration
+	pausedUntil time.Time
+}
+
+// record records a request for the modulePath. The limit is the maximum
+// number of tracked module paths.
+func (hmt *hotModuleTracker) record(modulePath string, limit int) {
+	hmt.mu.Lock()
+	defer hmt.mu.Unlock()
+	if hmt.counts == nil {
+		hmt.counts = make(map[string]int)
+	}
+	if _, ok := hmt.counts[modulePath]; !ok && len(hmt.counts) >= limit {
+		hmt.decay()
+		if len(hmt.counts) >= limit {
+			return
+		}
+	}
+	hmt.counts[modulePath]++
+}
+
+// decay halves all counts and forgets module paths whose count drops to zero,
+// so that recent requests outweigh older ones. The seen versions of module
+// paths forgotten by the previous decay are forgotten as well, so that they
+// are kept for the refresh that follows the decay. The hmt.mu must be held.
+func (hmt *hotModuleTracker) decay() {
+	for modulePath := range hmt.versions {
+		if _, ok := hmt.counts[modulePath]; !ok {
+			delete(hmt.versions, modulePath)
+		}
+	}
+	for modulePath, count := range hmt.counts {
+		if count /= 2; count == 0 {
+			delete(hmt.counts, modulePath)
+		} else {
+			hmt.counts[modulePath] = count
+		}
+	}
+}
+
+// top returns up to n most requested module paths, ordered by decreasing
+// request frequency, and then decays the counts. It returns nil if the hmt is
+// paused at the now.
+func (hmt *hotModuleTracker) top(n int, now time.Time) []string {
+	hmt.mu.Lock()
+	defer hmt.mu.Unlock()
+	if now.Before(hmt.pausedUntil) {
+		return nil
+	}
+	modulePaths := make([]string, 0, len(hmt.counts))
+	for modulePath := range hmt.counts {
+		modulePaths = append(modulePaths, modulePath)
+	}
+	slices.SortFunc(modulePaths, func(a, b string) int {
+		if c := cmp.Compare(hmt.counts[b], hmt.counts[a]); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	hmt.decay()
+	return modulePaths[:min(n, len(modulePaths))]
+}
+
+// seenVersions returns the versions last seen by a refresh of the modulePath,
+// and reports whether the modulePath has been refreshed since it was tracked.
+func (hmt *hotModuleTracker) seenVersions(modulePath string) ([]string, bool) {
+	hmt.mu.Lock()
+	defer hmt.mu.Unlock()
+	versions, ok := hmt.versions[modulePath]
+	return versions, ok
+}
+
+// see records the versions seen by a refresh of the modulePath.
+func (hmt *hotModuleTracker) see(modulePath string, versions []string) {
+	hmt.mu.Lock()
+	defer hmt.mu.Unlock()
+	if hmt.versions == nil {
+		hmt.versions = make(map[string][]string)
+	}
+	hmt.versions[modulePath] = versions
+}
+
+// failed pauses the hmt from the now for an exponentially growing duration.
+func (hmt *hotModuleTracker) failed(now time.Time) {
+	hmt.mu.Lock()
+	defer hmt.mu.Unlock()
+	hmt.pause = min(max(2*hmt.pause, hotModulesMinPause), hotModulesMaxPause)
+	hmt.pausedUntil = now.Add(hmt.pause)
+}
+
+// succeeded resets the pause duration of the hmt.
+func (hmt *hotModuleTracker) succeeded() {
+	hmt.mu.Lock()
+	defer hmt.mu.Unlock()
+	hmt.pause = 0
+}
+
+// recordHotModule records a request for the modulePath if g.HotModules is
+// greater than zero.
+func (g *Goproxy) recordHotModule(modulePath string) {
+	if g.HotModules > 0 {
+		g.hotModules.record(modulePath, hotModulesTrackedFactor*g.HotModules)
+	}
+}
+
+// RefreshHotModules fetches the "@v/list" and "@latest" of the
+// [Goproxy.HotModules] most frequently requested modules through the Fetcher
+// and puts them to the Cacher, so that subsequent requests can be served from
+// the cache even if upstreams are unavailable. If
+// [Goproxy.PrefetchNewVersions] is true, it also fetches the module files of
+// versions that have newly appeared in the "@v/list" of each module since it
+// was last refreshed, or since it was last cached for the first refresh.
+// Versions whose zip files are already cached are skipped.
+//
+// Modules are refreshed one at a time, so that RefreshHotModules uses at most
+// one slot of [GoFetcher.MaxConcurrentDirectFetches] (if the default
+// [GoFetcher] is used) and does not starve requests being served. If a fetch
+// fails for a reason other than the module not existing, which usually means
+// that upstreams are failing, the refresh is stopped and subsequent calls do
+// nothing for a period that grows exponentially from one minute to one hour
+// until a refresh succeeds again.
+//
+// RefreshHotModules does nothing if g.HotModules is zero or g.Cacher is nil.
+// It is safe to call RefreshHotModules while g is serving requests, such as
+// periodically in a background goroutine.
+func (g *Goproxy) RefreshHotModules(ctx context.Context) error {
+	g.initOnce.Do(g.init)
+	if g.HotModules <= 0 || g.Cacher == nil {
+		return nil
+	}
+	var errs []error
+	for _, modulePath := range g.hotModules.top(g.HotModules, time.Now()) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := g.refreshHotModule(ctx, modulePath); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			errs = append(errs, fmt.Errorf("refresh %s: %w", modulePath, err))
+			if !errors.Is(err, fs.ErrNotExist) {
+				g.hotModules.failed(time.Now())
+				return errors.Join(errs...)
+			}
+		}
+	}
+	g.hotModules.succeeded()
+	return errors.Join(errs...)
+}
+
+// refreshHotModule refreshes the cached "@v/list" and "@latest" of the
+// modulePath, and prefetches its new versions if g.PrefetchNewVersions is
+// true.
+func (g *Goproxy) refreshHotModule(ctx context.Context, modulePath string) error {
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return err
+	}
+
+	// The cached "@v/list" cannot tell new versions once a request has
+	// rewritten it, so it is only used before the first refresh.
+	var (
+		seenVersions []string
+		seen         bool
+	)
+	if g.PrefetchNewVersions {
+		if seenVersions, seen = g.hotModules.seenVersions(modulePath); !seen {
+			content, err := g.cache(ctx, escapedModulePath+"/@v/list")
+			if err == nil {
+				b, err := io.ReadAll(content)
+				content.Close()
+				if err != nil {
+					return err
+				}
+				seenVersions, seen = strings.Fields(string(b)), true
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	versions, err := g.fetcher.List(ctx, modulePath)
+	if err != nil {
+		return err
+	}
+	if err := g.putCache(ctx, escapedModulePath+"/@v/list", strings.NewReader(strings.Join(versions, "\n"))); err != nil {
+		return err
+	}
+
+	version, time, err := g.fetcher.Query(ctx, modulePath, "latest")
+	if err != nil {
+		return err
+	}
+	if err := g.putCache(ctx, escapedModulePath+"/@latest", strings.NewReader(marshalInfo(version, time))); err != nil {
+		return err
+	}
+
+	if !g.PrefetchNewVersions {
+		return nil
+	}
+
+	// Versions are only prefetched if the list has been seen before, so
+	// that the first refresh of a module does not fetch all of its history.
+	if seen {
+		for _, version := range versions {
+			if slices.Contains(seenVersions, version) {
+				continue
+			}
+			if err := g.prefetchVersion(ctx, escapedModulePath, modulePath, version); err != nil {
+				return err
+			}
+		}
+	}
+	g.hotModules.see(modulePath, versions)
+	return nil
+}
+
+// prefetchVersion fetches the module files of the modulePath and
+// moduleVersion through the g.fetcher and puts them to the g.Cacher, unless
+// the module zip file is already cached. The fetch is reported to the
+// g.OnFetch (if not nil).
+func (g *Goproxy) prefetchVersion(ctx context.Context, escapedModulePath, modulePath, moduleVersion string) error {
+	if checkCanonicalVersion(modulePath, moduleVersion) != nil {
+		return nil
+	}
+	escapedModuleVersion, err := module.EscapeVersion(moduleVersion)
+	if err != nil {
+		return err
+	}
+	nameWithoutExt := escapedModulePath + "/@v/" + escapedModuleVersion
+	if content, err := g.cache(ctx, nameWithoutExt+".zip"); err == nil {
+		content.Close()
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if g.OnFetch != nil {
+		startTime := time.Now()
+		ft := &fetchTrace{target: nameWithoutExt + ".zip", modulePath: modulePath, moduleVersion: moduleVersion}
+		ctx = withFetchTrace(ctx, ft)
+		defer func() {
+			if fe, ok := ft.fetchEvent(nil, startTime); ok {
+				g.OnFetch(fe)
+			}
+		}()
+	}
+
+	info, mod, zip, err := g.fetcher.Download(ctx, modulePath, moduleVersion)
+	if err != nil {
+		return err
+	}
+	fetchTraceFromContext(ctx).setFetched()
+	defer info.Close()
+	defer mod.Close()
+	defer zip.Close()
+	if err := g.putCache(ctx, nameWithoutExt+".info", info); err != nil {
+		return err
+	}
+	if err := g.putCache(ctx, nameWithoutExt+".mod", mod); err != nil {
+		return err
+	}
+	return g.putCache(ctx, nameWithoutExt+".zip", zip)
+}
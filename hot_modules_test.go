@@ -0,0 +1,394 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hotModuleTestFetcher is a [Fetcher] that serves the versions of every
+// module and records the downloaded module versions.
+type hotModuleTestFetcher struct {
+	t         *testing.T
+	mu        sync.Mutex
+	versions  []string
+	err       error
+	calls     int
+	downloads []string
+}
+
+// fetch records a call and returns the versions and error of the f.
+func (f *hotModuleTestFetcher) fetch() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return slices.Clone(f.versions), f.err
+}
+
+// Query implements [Fetcher].
+func (f *hotModuleTestFetcher) Query(ctx context.Context, path, query string) (string, time.Time, error) {
+	versions, err := f.fetch()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return versions[len(versions)-1], time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), nil
+}
+
+// List implements [Fetcher].
+func (f *hotModuleTestFetcher) List(ctx context.Context, path string) ([]string, error) {
+	return f.fetch()
+}
+
+// Download implements [Fetcher].
+func (f *hotModuleTestFetcher) Download(ctx context.Context, path, version string) (io.ReadSeekCloser, io.ReadSeekCloser, io.ReadSeekCloser, error) {
+	if _, err := f.fetch(); err != nil {
+		return nil, nil, nil, err
+	}
+	f.mu.Lock()
+	f.downloads = append(f.downloads, path+"@"+version)
+	f.mu.Unlock()
+	zipContent, err := makeZip(map[string][]byte{path + "@" + version + "/go.mod": []byte("module " + path)})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var files []io.ReadSeekCloser
+	for _, content := range [][]byte{
+		[]byte(marshalInfo(version, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))),
+		[]byte("module " + path),
+		zipContent,
+	} {
+		name, err := makeTempFile(f.t, content)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, file)
+	}
+	return files[0], files[1], files[2], nil
+}
+
+func TestHotModuleTracker(t *testing.T) {
+	var hmt hotModuleTracker
+	for range 4 {
+		hmt.record("example.com/a", 2)
+	}
+	for range 2 {
+		hmt.record("example.com/b", 2)
+	}
+	hmt.record("example.com/c", 2) // Decays to a=2, b=1, then is dropped.
+	if got, want := hmt.counts, map[string]int{"example.com/a": 2, "example.com/b": 1}; !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	hmt.record("example.com/b", 2)
+	hmt.record("example.com/b", 2)
+	now := time.Now()
+	if got, want := hmt.top(1, now), []string{"example.com/b"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := hmt.top(5, now), []string{"example.com/a", "example.com/b"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		hmt.failed(now)
+		if got := hmt.pausedUntil.Sub(now); got != want {
+			t.Errorf("test(%d): got %v, want %v", i, got, want)
+		}
+	}
+	if got := hmt.top(5, now); got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+	hmt.succeeded()
+	hmt.failed(now)
+	if got, want := hmt.pausedUntil.Sub(now), time.Minute; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	hmt.pause = 0
+	for range 10 {
+		hmt.failed(now)
+	}
+	if got, want := hmt.pausedUntil.Sub(now), time.Hour; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGoproxyRefreshHotModules(t *testing.T) {
+	request := func(t *testing.T, g *Goproxy, target string, header http.Header, wantCode int) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/"+target, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+		if got, want := rec.Code, wantCode; got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		}
+	}
+	readCache := func(t *testing.T, cacheDir, name string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(cacheDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return string(b)
+	}
+
+	t.Run("Normal", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		f := &hotModuleTestFetcher{t: t, versions: []string{"v1.0.0"}}
+		g := &Goproxy{
+			Fetcher:    f,
+			Cacher:     DirCacher(cacheDir),
+			TempDir:    t.TempDir(),
+			HotModules: 1,
+			Logger:     slog.New(slog.DiscardHandler),
+		}
+		request(t, g, "example.com/a/@v/list", nil, http.StatusOK)
+		request(t, g, "example.com/a/@latest", nil, http.StatusOK)
+		request(t, g, "example.com/!b/@v/list", nil, http.StatusOK)
+		request(t, g, "example.com/c/@v/list", http.Header{"Disable-Module-Fetch": {"true"}}, http.StatusNotFound)
+		if _, ok := g.hotModules.counts["example.com/c"]; ok {
+			t.Error("unexpected count for noFetch request")
+		}
+
+		f.versions = []string{"v1.0.0", "v1.1.0"}
+		if err := g.RefreshHotModules(t.Context()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := readCache(t, cacheDir, "example.com/a/@v/list"), "v1.0.0\nv1.1.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := readCache(t, cacheDir, "example.com/a/@latest"), marshalInfo("v1.1.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := readCache(t, cacheDir, "example.com/!b/@v/list"), "v1.0.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got := f.downloads; len(got) != 0 {
+			t.Errorf("got %v, want none", got)
+		}
+	})
+
+	t.Run("PrefetchNewVersions", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		f := &hotModuleTestFetcher{t: t, versions: []string{"v1.0.0"}}
+		g := &Goproxy{
+			Fetcher:             f,
+			Cacher:              DirCacher(cacheDir),
+			TempDir:             t.TempDir(),
+			HotModules:          10,
+			PrefetchNewVersions: true,
+			Logger:              slog.New(slog.DiscardHandler),
+		}
+		request(t, g, "example.com/a/@v/list", nil, http.StatusOK)
+		request(t, g, "example.com/b/@latest", nil, http.StatusOK)
+
+		f.versions = []string{"v1.0.0", "v1.1.0", "v1.2.0-rc.1"}
+		if err := os.MkdirAll(filepath.Join(cacheDir, "example.com", "a", "@v"), 0o755); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(cacheDir, "example.com", "a", "@v", "v1.2.0-rc.1.zip"), []byte("cached"), 0o644); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := g.RefreshHotModules(t.Context()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := f.downloads, []string{"example.com/a@v1.1.0"}; !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		for _, name := range []string{"v1.1.0.info", "v1.1.0.mod", "v1.1.0.zip"} {
+			if _, err := os.Stat(filepath.Join(cacheDir, "example.com", "a", "@v", name)); err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		}
+		if got, want := readCache(t, cacheDir, "example.com/b/@v/list"), "v1.0.0\nv1.1.0\nv1.2.0-rc.1"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("PrefetchAfterListRewritten", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		f := &hotModuleTestFetcher{t: t, versions: []string{"v1.0.0"}}
+		g := &Goproxy{
+			Fetcher:             f,
+			Cacher:              DirCacher(cacheDir),
+			TempDir:             t.TempDir(),
+			HotModules:          10,
+			PrefetchNewVersions: true,
+			Logger:              slog.New(slog.DiscardHandler),
+		}
+		request(t, g, "example.com/a/@v/list", nil, http.StatusOK)
+		if err := g.RefreshHotModules(t.Context()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got := f.downloads; len(got) != 0 {
+			t.Errorf("got %v, want none", got)
+		}
+
+		f.versions = []string{"v1.0.0", "v1.1.0"}
+		request(t, g, "example.com/a/@v/list", nil, http.StatusOK)
+		if got, want := readCache(t, cacheDir, "example.com/a/@v/list"), "v1.0.0\nv1.1.0"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if err := g.RefreshHotModules(t.Context()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := f.downloads, []string{"example.com/a@v1.1.0"}; !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("PrefetchOnFetch", func(t *testing.T) {
+		f := &hotModuleTestFetcher{t: t, versions: []string{"v1.0.0"}}
+		var fetchEvents []FetchEvent
+		g := &Goproxy{
+			Fetcher:             f,
+			Cacher:              DirCacher(t.TempDir()),
+			TempDir:             t.TempDir(),
+			HotModules:          10,
+			PrefetchNewVersions: true,
+			OnFetch:             func(fe FetchEvent) { fetchEvents = append(fetchEvents, fe) },
+			Logger:              slog.New(slog.DiscardHandler),
+		}
+		request(t, g, "example.com/a/@v/list", nil, http.StatusOK)
+		if err := g.RefreshHotModules(t.Context()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		f.versions = []string{"v1.0.0", "v1.1.0"}
+		request(t, g, "example.com/a/@latest", nil, http.StatusOK)
+		fetchEvents = nil
+		if err := g.RefreshHotModules(t.Context()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := len(fetchEvents), 1; got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		}
+		fe := fetchEvents[0]
+		if got, want := fe.Target, "example.com/a/@v/v1.1.0.zip"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := fe.ModulePath, "example.com/a"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := fe.ModuleVersion, "v1.1.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := fe.Cached, []string{
+			"example.com/a/@v/v1.1.0.info",
+			"example.com/a/@v/v1.1.0.mod",
+			"example.com/a/@v/v1.1.0.zip",
+		}; !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if fe.Client != "" {
+			t.Errorf("got %q, want empty", fe.Client)
+		}
+	})
+
+	t.Run("NotExist", func(t *testing.T) {
+		f := &hotModuleTestFetcher{t: t, versions: []string{"v1.0.0"}}
+		g := &Goproxy{
+			Fetcher:    f,
+			Cacher:     DirCacher(t.TempDir()),
+			TempDir:    t.TempDir(),
+			HotModules: 2,
+			Logger:     slog.New(slog.DiscardHandler),
+		}
+		request(t, g, "example.com/a/@v/list", nil, http.StatusOK)
+		request(t, g, "example.com/b/@v/list", nil, http.StatusOK)
+
+		f.err = notExistErrorf("not found")
+		f.calls = 0
+		if err := g.RefreshHotModules(t.Context()); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("got %v, want %v", err, fs.ErrNotExist)
+		}
+		if got, want := f.calls, 2; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if !g.hotModules.pausedUntil.IsZero() {
+			t.Errorf("got %v, want zero", g.hotModules.pausedUntil)
+		}
+	})
+
+	t.Run("UpstreamFailing", func(t *testing.T) {
+		f := &hotModuleTestFetcher{t: t, versions: []string{"v1.0.0"}}
+		g := &Goproxy{
+			Fetcher:    f,
+			Cacher:     DirCacher(t.TempDir()),
+			TempDir:    t.TempDir(),
+			HotModules: 2,
+			Logger:     slog.New(slog.DiscardHandler),
+		}
+		for i := range 2 {
+			request(t, g, fmt.Sprintf("example.com/m%d/@v/list", i), nil, http.StatusOK)
+		}
+
+		f.err = errBadUpstream
+		f.calls = 0
+		if err := g.RefreshHotModules(t.Context()); !errors.Is(err, errBadUpstream) {
+			t.Fatalf("got %v, want %v", err, errBadUpstream)
+		}
+		if got, want := f.calls, 1; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got := time.Until(g.hotModules.pausedUntil); got <= 0 || got > time.Minute {
+			t.Errorf("got %v, want within 1m", got)
+		}
+
+		request(t, g, "example.com/m0/@v/list", nil, http.StatusOK) // Served from the cache.
+		f.err = nil
+		f.calls = 0
+		if err := g.RefreshHotModules(t.Context()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := f.calls, 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+
+		g.hotModules.pausedUntil = time.Time{}
+		if err := g.RefreshHotModules(t.Context()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := f.calls, 2; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got, want := g.hotModules.pause, time.Duration(0); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		f := &hotModuleTestFetcher{t: t, versions: []string{"v1.0.0"}}
+		g := &Goproxy{Fetcher: f, Cacher: DirCacher(t.TempDir()), TempDir: t.TempDir()}
+		request(t, g, "example.com/a/@v/list", nil, http.StatusOK)
+		f.calls = 0
+		if err := g.RefreshHotModules(t.Context()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := f.calls, 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if got := g.hotModules.counts; got != nil {
+			t.Errorf("got %v, want nil", got)
+		}
+	})
+}
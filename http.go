@@ -11,8 +11,6 @@ import (
 	"net/url"
 	"os"
 	"time"
-
-	"github.com/aofei/backoff"
 )
 
 var (
@@ -43,56 +41,42 @@ func notExistErrorf(format string, v ...any) error {
 	return &notExistError{err: fmt.Errorf(format, v...)}
 }
 
-// httpGet gets the content from the given url and writes it to the dst.
-func httpGet(ctx context.Context, client *http.Client, url string, dst io.Writer) error {
-	const (
-		maxAttempts = 10
-		backoffBase = 100 * time.Millisecond
-		backoffCap  = time.Second
-	)
+// errAttemptTimedOut indicates an attempt of [httpGet] has exceeded the
+// [RetryPolicy.AttemptTimeout].
+var errAttemptTimedOut = errors.New("attempt timed out")
 
-	var lastErr error
-	for range backoff.Attempts(ctx, maxAttempts, backoffBase, backoffCap) {
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-		if err != nil {
-			return err
-		}
-
-		resp, err := client.Do(req)
-		if err != nil {
-			if isRetryableHTTPClientDoError(err) {
-				lastErr = err
-				continue
-			}
+// httpGet gets the content from the given url and writes it to the dst,
+// retrying according to the rp. A nil rp means the default [RetryPolicy].
+func httpGet(ctx context.Context, client *http.Client, rp *RetryPolicy, url string, dst io.Writer) error {
+	maxAttempts := rp.maxAttempts()
+	var (
+		lastErr error
+		timer   *time.Timer
+	)
+	for attempt := range maxAttempts {
+		resp, retry, err := httpGetAttempt(ctx, client, rp, url, dst)
+		if err == nil || !retry {
 			return err
 		}
-		if resp.StatusCode == http.StatusOK {
-			if dst != nil {
-				_, err = io.Copy(dst, resp.Body)
-			}
-			resp.Body.Close()
-			return err
+		lastErr = err
+		if attempt+1 == maxAttempts {
+			break
 		}
 
-		respBody, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			return err
+		delay := rp.delay(attempt, resp)
+		if delay <= 0 {
+			continue
 		}
-		switch resp.StatusCode {
-		case http.StatusBadRequest,
-			http.StatusNotFound,
-			http.StatusGone:
-			return notExistErrorf("%s", respBody)
-		case http.StatusTooManyRequests,
-			http.StatusInternalServerError,
-			http.StatusBadGateway,
-			http.StatusServiceUnavailable:
-			lastErr = errBadUpstream
-		case http.StatusGatewayTimeout:
-			lastErr = errFetchTimedOut
-		default:
-			return fmt.Errorf("GET %s: %s: %s", resp.Request.URL.Redacted(), resp.Status, respBody)
+		if timer == nil {
+			timer = time.NewTimer(delay)
+			defer timer.Stop()
+		} else {
+			timer.Reset(delay)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
 		}
 	}
 	if err := ctx.Err(); err != nil {
@@ -101,9 +85,77 @@ func httpGet(ctx context.Context, client *http.Client, url string, dst io.Writer
 	return lastErr
 }
 
+// httpGetAttempt makes a single attempt of [httpGet]. It returns the response
+// (with its body closed) if one has been received, and whether the attempt
+// should be retried if it fails.
+func httpGetAttempt(ctx context.Context, client *http.Client, rp *RetryPolicy, url string, dst io.Writer) (resp *http.Response, retry bool, err error) {
+	attemptCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var timer *time.Timer
+	if timeout := rp.attemptTimeout(); timeout > 0 {
+		timer = time.AfterFunc(timeout, func() { cancel(errAttemptTimedOut) })
+		defer timer.Stop()
+	}
+	timedOut := func() bool {
+		return ctx.Err() == nil && context.Cause(attemptCtx) == errAttemptTimedOut
+	}
+
+	req, err := http.NewRequestWithContext(attemptCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		if timedOut() {
+			return nil, true, errFetchTimedOut
+		}
+		return nil, isRetryableHTTPClientDoError(err), err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		if timer != nil && !timer.Stop() {
+			return resp, true, errFetchTimedOut
+		}
+		if dst != nil {
+			_, err = io.Copy(dst, resp.Body)
+		}
+		return resp, false, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if timedOut() {
+			return resp, true, errFetchTimedOut
+		}
+		return resp, false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusBadRequest,
+		http.StatusNotFound,
+		http.StatusGone:
+		err = notExistErrorf("%s", respBody)
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable:
+		err = errBadUpstream
+	case http.StatusGatewayTimeout:
+		err = errFetchTimedOut
+	default:
+		err = fmt.Errorf("GET %s: %s: %s", resp.Request.URL.Redacted(), resp.Status, respBody)
+	}
+	return resp, rp.isRetryableStatus(resp.StatusCode), err
+}
+
 // httpGetTemp is like [httpGet] but writes the content to a new temporary file
 // in tempDir.
-func httpGetTemp(ctx context.Context, client *http.Client, url, tempDir string) (tempFile string, err error) {
+func httpGetTemp(ctx context.Context, client *http.Client, rp *RetryPolicy, url, tempDir string) (tempFile string, err error) {
+	return httpGetTempTee(ctx, client, rp, url, tempDir, nil)
+}
+
+// httpGetTempTee is like [httpGetTemp] but also writes the content to the tee
+// as it is received, unless the tee is nil.
+func httpGetTempTee(ctx context.Context, client *http.Client, rp *RetryPolicy, url, tempDir string, tee io.Writer) (tempFile string, err error) {
 	f, err := os.CreateTemp(tempDir, "")
 	if err != nil {
 		return "", err
@@ -113,7 +165,11 @@ func httpGetTemp(ctx context.Context, client *http.Client, url, tempDir string)
 			os.Remove(f.Name())
 		}
 	}()
-	if err := httpGet(ctx, client, url, f); err != nil {
+	var dst io.Writer = f
+	if tee != nil {
+		dst = io.MultiWriter(f, tee)
+	}
+	if err := httpGet(ctx, client, rp, url, dst); err != nil {
 		f.Close()
 		return "", err
 	}
@@ -123,7 +179,7 @@ func httpGetTemp(ctx context.Context, client *http.Client, url, tempDir string)
 // isRetryableHTTPClientDoError reports whether the err is a retryable error
 // returned by [http.Client.Do].
 func isRetryableHTTPClientDoError(err error) bool {
-	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errClusterPeerUnreachable) {
 		return false
 	}
 	if ue, ok := err.(*url.Error); ok {
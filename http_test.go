@@ -14,6 +14,8 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -188,7 +190,7 @@ func TestHTTPGet(t *testing.T) {
 				}
 
 				var content bytes.Buffer
-				err := httpGet(ctx, client, server.URL, &content)
+				err := httpGet(ctx, client, nil, server.URL, &content)
 				if wantErr != nil {
 					if err == nil {
 						t.Fatal("expected error")
@@ -208,8 +210,118 @@ func TestHTTPGet(t *testing.T) {
 		}
 	})
 
+	t.Run("RetryPolicy", func(t *testing.T) {
+		for _, tt := range []struct {
+			n            int
+			rp           *RetryPolicy
+			handler      func(attempt int, rw http.ResponseWriter, req *http.Request)
+			wantContent  string
+			wantErr      error
+			wantAttempts int
+		}{
+			{
+				n:  1,
+				rp: &RetryPolicy{MaxAttempts: 3},
+				handler: func(attempt int, rw http.ResponseWriter, req *http.Request) {
+					rw.WriteHeader(http.StatusInternalServerError)
+				},
+				wantErr:      errBadUpstream,
+				wantAttempts: 3,
+			},
+			{
+				n:  2,
+				rp: &RetryPolicy{MaxAttempts: 2, RetryableStatuses: []int{http.StatusNotFound}},
+				handler: func(attempt int, rw http.ResponseWriter, req *http.Request) {
+					rw.WriteHeader(http.StatusNotFound)
+				},
+				wantErr:      fs.ErrNotExist,
+				wantAttempts: 2,
+			},
+			{
+				n:  3,
+				rp: &RetryPolicy{RetryableStatuses: []int{}},
+				handler: func(attempt int, rw http.ResponseWriter, req *http.Request) {
+					rw.WriteHeader(http.StatusServiceUnavailable)
+				},
+				wantErr:      errBadUpstream,
+				wantAttempts: 1,
+			},
+			{
+				n:  4,
+				rp: &RetryPolicy{AttemptTimeout: 50 * time.Millisecond},
+				handler: func(attempt int, rw http.ResponseWriter, req *http.Request) {
+					if attempt == 1 {
+						select {
+						case <-req.Context().Done():
+						case <-time.After(time.Second):
+						}
+					}
+					fmt.Fprint(rw, "foobar")
+				},
+				wantContent:  "foobar",
+				wantAttempts: 2,
+			},
+			{
+				n:  5,
+				rp: &RetryPolicy{MaxAttempts: 2, AttemptTimeout: 50 * time.Millisecond},
+				handler: func(attempt int, rw http.ResponseWriter, req *http.Request) {
+					select {
+					case <-req.Context().Done():
+					case <-time.After(time.Second):
+					}
+				},
+				wantErr:      errFetchTimedOut,
+				wantAttempts: 2,
+			},
+			{
+				n:  6,
+				rp: &RetryPolicy{BackoffBase: time.Minute, DisableJitter: true, HonorRetryAfter: true},
+				handler: func(attempt int, rw http.ResponseWriter, req *http.Request) {
+					if attempt == 1 {
+						rw.Header().Set("Retry-After", "0")
+						rw.WriteHeader(http.StatusTooManyRequests)
+						return
+					}
+					fmt.Fprint(rw, "foobar")
+				},
+				wantContent:  "foobar",
+				wantAttempts: 2,
+			},
+		} {
+			t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+				var attempts atomic.Int64
+				server := newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					tt.handler(int(attempts.Add(1)), rw, req)
+				}))
+
+				ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+				defer cancel()
+				var content bytes.Buffer
+				err := httpGet(ctx, http.DefaultClient, tt.rp, server.URL, &content)
+				if tt.wantErr != nil {
+					if err == nil {
+						t.Fatal("expected error")
+					}
+					if got, want := err, tt.wantErr; !compareErrors(got, want) {
+						t.Errorf("got %v, want %v", got, want)
+					}
+				} else {
+					if err != nil {
+						t.Fatalf("unexpected error %v", err)
+					}
+					if got, want := content.String(), tt.wantContent; got != want {
+						t.Errorf("got %q, want %q", got, want)
+					}
+				}
+				if got, want := int(attempts.Load()), tt.wantAttempts; got != want {
+					t.Errorf("got %d, want %d", got, want)
+				}
+			})
+		}
+	})
+
 	t.Run("InvalidURL", func(t *testing.T) {
-		if err := httpGet(t.Context(), http.DefaultClient, "::", nil); err == nil {
+		if err := httpGet(t.Context(), http.DefaultClient, nil, "::", nil); err == nil {
 			t.Fatal("expected error")
 		}
 	})
@@ -248,7 +360,7 @@ func TestHTTPGetTemp(t *testing.T) {
 				tt.tempDir = t.TempDir()
 			}
 
-			tempFile, err := httpGetTemp(t.Context(), http.DefaultClient, server.URL, tt.tempDir)
+			tempFile, err := httpGetTemp(t.Context(), http.DefaultClient, nil, server.URL, tt.tempDir)
 			if tt.wantErr != nil {
 				if err == nil {
 					t.Fatal("expected error")
@@ -275,6 +387,24 @@ func TestHTTPGetTemp(t *testing.T) {
 	}
 }
 
+func TestHTTPGetTempTee(t *testing.T) {
+	server := newHTTPTestServer(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) { fmt.Fprint(rw, "foobar") }))
+
+	var tee strings.Builder
+	tempFile, err := httpGetTempTee(t.Context(), http.DefaultClient, nil, server.URL, t.TempDir(), &tee)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if b, err := os.ReadFile(tempFile); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if got, want := string(b), "foobar"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := tee.String(), "foobar"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 func TestIsRetryableHTTPClientDoError(t *testing.T) {
 	for _, tt := range []struct {
 		n               int
@@ -288,6 +418,7 @@ func TestIsRetryableHTTPClientDoError(t *testing.T) {
 		{5, &url.Error{Err: errors.New("oops")}, true},
 		{6, &url.Error{Err: x509.UnknownAuthorityError{}}, false},
 		{7, &url.Error{Err: http.ErrSchemeMismatch}, false},
+		{8, &url.Error{Err: errClusterPeerUnreachable}, false},
 	} {
 		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
 			if got, want := isRetryableHTTPClientDoError(tt.err), tt.wantIsRetryable; got != want {
@@ -0,0 +1,31 @@
+/*
+Package cachecontent provides helpers for the content of caches shared by the
+object storage Cacher implementations.
+*/
+package cachecontent
+
+import (
+	"path"
+	"strings"
+)
+
+// Type returns the content type of the cache for the name.
+func Type(name string) string {
+	nameExt := path.Ext(name)
+	switch {
+	case nameExt == ".info", strings.HasSuffix(name, "/@latest"), strings.HasSuffix(name, "/@v/list.json"):
+		return "application/json; charset=utf-8"
+	case nameExt == ".mod", strings.HasSuffix(name, "/@v/list"):
+		return "text/plain; charset=utf-8"
+	case nameExt == ".zip":
+		return "application/zip"
+	case strings.HasPrefix(name, "sumdb/"):
+		if elems := strings.Split(name, "/"); len(elems) >= 3 {
+			switch elems[2] {
+			case "latest", "lookup":
+				return "text/plain; charset=utf-8"
+			}
+		}
+	}
+	return "application/octet-stream"
+}
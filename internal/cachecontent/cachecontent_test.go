@@ -0,0 +1,30 @@
+package cachecontent
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestType(t *testing.T) {
+	for _, tt := range []struct {
+		n    int
+		name string
+		want string
+	}{
+		{n: 1, name: "example.com/@v/v1.0.0.info", want: "application/json; charset=utf-8"},
+		{n: 2, name: "example.com/@latest", want: "application/json; charset=utf-8"},
+		{n: 3, name: "example.com/@v/list.json", want: "application/json; charset=utf-8"},
+		{n: 4, name: "example.com/@v/v1.0.0.mod", want: "text/plain; charset=utf-8"},
+		{n: 5, name: "example.com/@v/list", want: "text/plain; charset=utf-8"},
+		{n: 6, name: "example.com/@v/v1.0.0.zip", want: "application/zip"},
+		{n: 7, name: "sumdb/sum.golang.org/latest", want: "text/plain; charset=utf-8"},
+		{n: 8, name: "sumdb/sum.golang.org/lookup/example.com@v1.0.0", want: "text/plain; charset=utf-8"},
+		{n: 9, name: "sumdb/sum.golang.org/tile/8/0/000", want: "application/octet-stream"},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			if got, want := Type(tt.name), tt.want; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Locker defines a method used to provide mutual exclusion by name, typically
+// across multiple hosts. It is used by [GoFetcher] to lock direct fetches.
+//
+// The name is a slash-separated path (such as "example.com/@v/v1.0.0") that
+// follows the same naming rules as [Cacher].
+type Locker interface {
+	// Lock blocks until the lock for the name is acquired, and returns a
+	// function that releases it. It returns the error of the ctx if the
+	// ctx is done before the lock is acquired.
+	//
+	// A lock held by a holder that has gone away without releasing it
+	// must eventually be released, for example by letting it expire.
+	Lock(ctx context.Context, name string) (unlock func(), err error)
+}
+
+const (
+	// dirLockerPollInterval is the interval between attempts of
+	// [DirLocker.Lock] to acquire a lock held by another holder.
+	dirLockerPollInterval = 100 * time.Millisecond
+
+	// dirLockerRefreshInterval is the interval between refreshes of the
+	// modification time of a lock file held by a [DirLocker].
+	dirLockerRefreshInterval = 10 * time.Second
+
+	// dirLockerStaleAge is the age of the modification time after which a
+	// lock file is considered stale by a [DirLocker].
+	dirLockerStaleAge = 6 * dirLockerRefreshInterval
+)
+
+// DirLocker implements [Locker] using lock files in a directory, which may be
+// on a file system shared by multiple hosts (such as NFS).
+//
+// A lock is acquired by exclusively creating its lock file, whose modification
+// time is refreshed while the lock is held. A lock file that has not been
+// refreshed for a minute is considered left behind by a holder that has gone
+// away, and is removed.
+//
+// If the directory does not exist, it will be created with 0755 permissions.
+type DirLocker string
+
+// Lock implements [Locker].
+func (dl DirLocker) Lock(ctx context.Context, name string) (unlock func(), err error) {
+	file := filepath.Join(string(dl), filepath.FromSlash(name)+".lock")
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return nil, err
+	}
+	for {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			if err := f.Close(); err != nil {
+				os.Remove(file)
+				return nil, err
+			}
+			return dirLockerHold(file), nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if err := dirLockerRemoveStale(file); err != nil {
+			return nil, err
+		}
+
+		timer := time.NewTimer(dirLockerPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// dirLockerHold keeps refreshing the modification time of the lock file until
+// the returned function is called, which then removes the lock file.
+func dirLockerHold(file string) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(dirLockerRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(file, now, now)
+			}
+		}
+	}()
+	return sync.OnceFunc(func() {
+		close(stop)
+		<-done
+		os.Remove(file)
+	})
+}
+
+// dirLockerRemoveStale removes the lock file if it is stale.
+//
+// To prevent two holders from removing each other's lock files, the removal
+// is guarded by another lock file whose name has the ".stale" suffix appended,
+// and the staleness is checked again after acquiring it.
+func dirLockerRemoveStale(file string) error {
+	if !dirLockerIsStale(file) {
+		return nil
+	}
+	guardFile := file + ".stale"
+	f, err := os.OpenFile(guardFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			if dirLockerIsStale(guardFile) {
+				os.Remove(guardFile)
+			}
+			return nil
+		}
+		return err
+	}
+	f.Close()
+	defer os.Remove(guardFile)
+	if dirLockerIsStale(file) {
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirLockerIsStale reports whether the lock file exists and is stale.
+func dirLockerIsStale(file string) bool {
+	fi, err := os.Stat(file)
+	return err == nil && time.Since(fi.ModTime()) > dirLockerStaleAge
+}
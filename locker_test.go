@@ -0,0 +1,98 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirLocker(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		dl := DirLocker(t.TempDir())
+		unlock, err := dl.Lock(t.Context(), "example.com/@v/v1.0.0")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(string(dl), "example.com", "@v", "v1.0.0.lock")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		unlock2, err := dl.Lock(t.Context(), "example.com/@v/v1.1.0")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		unlock2()
+
+		lockedCh := make(chan func(), 1)
+		go func() {
+			unlock, err := dl.Lock(t.Context(), "example.com/@v/v1.0.0")
+			if err != nil {
+				t.Errorf("unexpected error %v", err)
+				unlock = func() {}
+			}
+			lockedCh <- unlock
+		}()
+		time.Sleep(2 * dirLockerPollInterval)
+		select {
+		case <-lockedCh:
+			t.Fatal("expected lock to be held")
+		default:
+		}
+		unlock()
+		unlock() // Unlock is idempotent.
+		(<-lockedCh)()
+
+		if _, err := os.Stat(filepath.Join(string(dl), "example.com", "@v", "v1.0.0.lock")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, os.ErrNotExist)
+		}
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		dl := DirLocker(t.TempDir())
+		lockFile := filepath.Join(string(dl), "example.com", "@latest.lock")
+		if err := os.MkdirAll(filepath.Dir(lockFile), 0o755); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := os.WriteFile(lockFile, nil, 0o644); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		modTime := time.Now().Add(-2 * dirLockerStaleAge)
+		if err := os.Chtimes(lockFile, modTime, modTime); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+		defer cancel()
+		unlock, err := dl.Lock(ctx, "example.com/@latest")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if fi, err := os.Stat(lockFile); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if time.Since(fi.ModTime()) > time.Minute {
+			t.Errorf("got %v, want recent time", fi.ModTime())
+		}
+		if _, err := os.Stat(lockFile + ".stale"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, os.ErrNotExist)
+		}
+		unlock()
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		dl := DirLocker(t.TempDir())
+		unlock, err := dl.Lock(t.Context(), "example.com/@v/list")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer unlock()
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		if _, err := dl.Lock(ctx, "example.com/@v/list"); !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want %v", err, context.Canceled)
+		}
+	})
+}
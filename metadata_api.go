@@ -0,0 +1,368 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// MetadataAPI is an [http.Handler] that serves a read-only JSON API for the
+// metadata of modules cached by a [Goproxy]. It never fetches anything.
+//
+// The following endpoints are served, relative to where MetadataAPI is
+// mounted:
+//   - GET /modules/<module>: the known versions of the module, with their
+//     times and the kinds of module files that are cached.
+//   - GET /modules/<module>/@v/<version>: the metadata of the module
+//     version, including its "go.mod" file parsed into requires and
+//     replaces, and its "go.sum" lines computed from the cached module
+//     files. Note that replaces only take effect in the main module, so
+//     they do not change the dependency view of the module version. A
+//     "go.mod" file that cannot be parsed strictly is parsed as a
+//     dependency, without main-module-only directives such as "replace".
+//   - GET /recent: the most recent fetch events, newest first.
+//
+// The <module> and <version> are escaped as in the GOPROXY protocol.
+//
+// The versions of a module are only fully known if the Cacher implements
+// the List method documented in [Cacher]. Otherwise, only the versions in
+// the cached version list are known.
+//
+// The recent fetch events are only known if [MetadataAPI.RecordFetch] is
+// called for them, typically by setting it as [Goproxy.OnFetch].
+type MetadataAPI struct {
+	// Goproxy is the [Goproxy] whose cache is queried.
+	Goproxy *Goproxy
+
+	// MaxRecentFetches is the maximum number of recent fetch events to
+	// keep.
+	//
+	// If MaxRecentFetches is zero, 100 is used.
+	MaxRecentFetches int
+
+	mu     sync.Mutex
+	recent []FetchEvent
+}
+
+// metadataModule is the response of the "/modules/<module>" endpoint.
+type metadataModule struct {
+	Path     string
+	Versions []metadataVersion
+}
+
+// metadataVersion is a version in [metadataModule].
+type metadataVersion struct {
+	Version string
+	Time    time.Time `json:",omitzero"`
+	Cached  []string
+}
+
+// metadataModuleVersion is the response of the
+// "/modules/<module>/@v/<version>" endpoint.
+type metadataModuleVersion struct {
+	Path    string
+	Version string
+	Time    time.Time `json:",omitzero"`
+	Cached  []string
+	GoMod   *metadataGoMod `json:",omitempty"`
+	GoSum   []string       `json:",omitempty"`
+}
+
+// metadataGoMod is the parsed "go.mod" file in [metadataModuleVersion].
+type metadataGoMod struct {
+	Module  string
+	Go      string `json:",omitempty"`
+	Require []metadataRequire
+	Replace []metadataReplace
+}
+
+// metadataRequire is a "require" directive in [metadataGoMod].
+type metadataRequire struct {
+	Path     string
+	Version  string
+	Indirect bool `json:",omitempty"`
+}
+
+// metadataReplace is a "replace" directive in [metadataGoMod].
+type metadataReplace struct {
+	Old module.Version
+	New module.Version
+}
+
+// RecordFetch records the fe as a recent fetch event. It can be used as
+// [Goproxy.OnFetch].
+func (api *MetadataAPI) RecordFetch(fe FetchEvent) {
+	maxRecentFetches := api.MaxRecentFetches
+	if maxRecentFetches <= 0 {
+		maxRecentFetches = 100
+	}
+	api.mu.Lock()
+	api.recent = append(api.recent, fe)
+	if n := len(api.recent) - maxRecentFetches; n > 0 {
+		api.recent = slices.Delete(api.recent, 0, n)
+	}
+	api.mu.Unlock()
+}
+
+// ServeHTTP implements [http.Handler].
+func (api *MetadataAPI) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	g := api.Goproxy
+	g.initOnce.Do(g.init)
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		responseMethodNotAllowed(rw, req, 86400)
+		return
+	}
+
+	p := cleanPath(req.URL.Path)
+	if p != req.URL.Path {
+		responseNotFound(rw, req, -1)
+		return
+	}
+	if p == "/recent" {
+		api.serveRecent(rw, req)
+		return
+	}
+	target, ok := strings.CutPrefix(p, "/modules/")
+	if !ok {
+		responseNotFound(rw, req, -1)
+		return
+	}
+	escapedModulePath, escapedModuleVersion, hasVersion := strings.Cut(target, "/@v/")
+	modulePath, err := module.UnescapePath(escapedModulePath)
+	if err != nil {
+		responseNotFound(rw, req, -1, err)
+		return
+	}
+	if !hasVersion {
+		api.serveModule(rw, req, modulePath)
+		return
+	}
+	moduleVersion, err := module.UnescapeVersion(escapedModuleVersion)
+	if err != nil {
+		responseNotFound(rw, req, -1, err)
+		return
+	}
+	if err := checkCanonicalVersion(modulePath, moduleVersion); err != nil {
+		responseNotFound(rw, req, -1, err)
+		return
+	}
+	api.serveModuleVersion(rw, req, modulePath, moduleVersion)
+}
+
+// serveRecent serves the "/recent" endpoint.
+func (api *MetadataAPI) serveRecent(rw http.ResponseWriter, req *http.Request) {
+	api.mu.Lock()
+	recent := slices.Clone(api.recent)
+	api.mu.Unlock()
+	slices.Reverse(recent)
+	if recent == nil {
+		recent = []FetchEvent{}
+	}
+	api.serveJSON(rw, req, recent)
+}
+
+// serveModule serves the "/modules/<module>" endpoint.
+func (api *MetadataAPI) serveModule(rw http.ResponseWriter, req *http.Request, modulePath string) {
+	g := api.Goproxy
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		responseNotFound(rw, req, -1, err)
+		return
+	}
+	prefix := escapedModulePath + "/@v/"
+
+	cached := map[string][]string{}
+	if list, err := api.cacheContent(req.Context(), prefix+"list"); err == nil {
+		for version := range strings.FieldsSeq(string(list)) {
+			if checkCanonicalVersion(modulePath, version) == nil {
+				cached[version] = []string{}
+			}
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		g.logger.Error("failed to get cached version list", "error", err, "module_path", modulePath)
+		responseInternalServerError(rw, req)
+		return
+	}
+	if cl, ok := g.Cacher.(interface {
+		List(ctx context.Context, prefix string) (names []string, err error)
+	}); ok {
+		names, err := cl.List(req.Context(), prefix)
+		if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			g.logger.Error("failed to list caches", "error", err, "module_path", modulePath)
+			responseInternalServerError(rw, req)
+			return
+		}
+		for _, name := range names {
+			file := strings.TrimPrefix(name, prefix)
+			ext := path.Ext(file)
+			switch ext {
+			case ".info", ".mod", ".zip":
+			default:
+				continue
+			}
+			version, err := module.UnescapeVersion(strings.TrimSuffix(file, ext))
+			if err != nil || checkCanonicalVersion(modulePath, version) != nil {
+				continue
+			}
+			cached[version] = append(cached[version], ext)
+		}
+	}
+	if len(cached) == 0 {
+		responseNotFound(rw, req, -1)
+		return
+	}
+
+	m := metadataModule{Path: modulePath, Versions: make([]metadataVersion, 0, len(cached))}
+	for version, exts := range cached {
+		mv := metadataVersion{Version: version, Cached: exts}
+		if slices.Contains(exts, ".info") {
+			if mv.Time, err = api.infoTime(req.Context(), escapedModulePath, version); err != nil {
+				g.logger.Error("failed to get cached info file", "error", err, "module_path", modulePath, "module_version", version)
+				responseInternalServerError(rw, req)
+				return
+			}
+		}
+		m.Versions = append(m.Versions, mv)
+	}
+	slices.SortFunc(m.Versions, func(a, b metadataVersion) int { return semver.Compare(a.Version, b.Version) })
+	api.serveJSON(rw, req, m)
+}
+
+// serveModuleVersion serves the "/modules/<module>/@v/<version>" endpoint.
+func (api *MetadataAPI) serveModuleVersion(rw http.ResponseWriter, req *http.Request, modulePath, moduleVersion string) {
+	g := api.Goproxy
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		responseNotFound(rw, req, -1, err)
+		return
+	}
+	escapedModuleVersion, err := module.EscapeVersion(moduleVersion)
+	if err != nil {
+		responseNotFound(rw, req, -1, err)
+		return
+	}
+	nameWithoutExt := escapedModulePath + "/@v/" + escapedModuleVersion
+
+	mv := metadataModuleVersion{Path: modulePath, Version: moduleVersion, Cached: []string{}}
+	if t, err := api.infoTime(req.Context(), escapedModulePath, moduleVersion); err == nil {
+		mv.Time = t
+		mv.Cached = append(mv.Cached, ".info")
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		g.logger.Error("failed to get cached info file", "error", err, "module_path", modulePath, "module_version", moduleVersion)
+		responseInternalServerError(rw, req)
+		return
+	}
+
+	var modHash string
+	if mod, err := api.cacheContent(req.Context(), nameWithoutExt+".mod"); err == nil {
+		mv.Cached = append(mv.Cached, ".mod")
+		f, err := modfile.Parse("go.mod", mod, nil)
+		if err != nil {
+			f, err = modfile.ParseLax("go.mod", mod, nil)
+		}
+		if err != nil {
+			g.logger.Error("failed to parse cached mod file", "error", err, "module_path", modulePath, "module_version", moduleVersion)
+			responseInternalServerError(rw, req)
+			return
+		}
+		mv.GoMod = newMetadataGoMod(f)
+		modHash, err = hashMod(mod)
+		if err != nil {
+			g.logger.Error("failed to hash cached mod file", "error", err, "module_path", modulePath, "module_version", moduleVersion)
+			responseInternalServerError(rw, req)
+			return
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		g.logger.Error("failed to get cached mod file", "error", err, "module_path", modulePath, "module_version", moduleVersion)
+		responseInternalServerError(rw, req)
+		return
+	}
+
+	zipHash, err := g.CachedZipHash(req.Context(), nameWithoutExt+".zip")
+	if err == nil {
+		mv.Cached = append(mv.Cached, ".zip")
+		mv.GoSum = append(mv.GoSum, modulePath+" "+moduleVersion+" "+zipHash)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		g.logger.Error("failed to hash cached zip file", "error", err, "module_path", modulePath, "module_version", moduleVersion)
+		responseInternalServerError(rw, req)
+		return
+	}
+	if modHash != "" {
+		mv.GoSum = append(mv.GoSum, modulePath+" "+moduleVersion+"/go.mod "+modHash)
+	}
+
+	if len(mv.Cached) == 0 {
+		responseNotFound(rw, req, -1)
+		return
+	}
+	api.serveJSON(rw, req, mv)
+}
+
+// serveJSON serves the v as JSON.
+func (api *MetadataAPI) serveJSON(rw http.ResponseWriter, req *http.Request, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		api.Goproxy.logger.Error("failed to marshal JSON", "error", err)
+		responseInternalServerError(rw, req)
+		return
+	}
+	responseSuccess(rw, req, bytes.NewReader(b), "application/json; charset=utf-8", -1)
+}
+
+// cacheContent returns the content of the matched cache for the name.
+func (api *MetadataAPI) cacheContent(ctx context.Context, name string) ([]byte, error) {
+	content, err := api.Goproxy.cache(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	return io.ReadAll(content)
+}
+
+// infoTime returns the time in the cached info file for the module version.
+func (api *MetadataAPI) infoTime(ctx context.Context, escapedModulePath, moduleVersion string) (time.Time, error) {
+	escapedModuleVersion, err := module.EscapeVersion(moduleVersion)
+	if err != nil {
+		return time.Time{}, err
+	}
+	info, err := api.cacheContent(ctx, escapedModulePath+"/@v/"+escapedModuleVersion+".info")
+	if err != nil {
+		return time.Time{}, err
+	}
+	_, t, err := unmarshalInfo(string(info))
+	return t, err
+}
+
+// newMetadataGoMod creates a new [metadataGoMod] from the f.
+func newMetadataGoMod(f *modfile.File) *metadataGoMod {
+	gm := &metadataGoMod{Require: []metadataRequire{}, Replace: []metadataReplace{}}
+	if f.Module != nil {
+		gm.Module = f.Module.Mod.Path
+	}
+	if f.Go != nil {
+		gm.Go = f.Go.Version
+	}
+	for _, r := range f.Require {
+		gm.Require = append(gm.Require, metadataRequire{Path: r.Mod.Path, Version: r.Mod.Version, Indirect: r.Indirect})
+	}
+	for _, r := range f.Replace {
+		gm.Replace = append(gm.Replace, metadataReplace{Old: r.Old, New: r.New})
+	}
+	return gm
+}
@@ -0,0 +1,215 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+func TestMetadataAPI(t *testing.T) {
+	info := marshalInfo("v1.0.0", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	mod := "module example.com\n\ngo 1.21\n\nrequire (\n\texample.com/foo v1.0.0\n\texample.com/bar v1.1.0 // indirect\n)\n\nreplace example.com/foo => ../foo\n"
+	zip, err := makeZip(map[string][]byte{"example.com@v1.0.0/go.mod": []byte(mod)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	zipFile, err := makeTempFile(t, zip)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	zipHash, err := dirhash.HashZip(zipFile, dirhash.DefaultHash)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	modHash, err := dirhash.DefaultHash([]string{"go.mod"}, func(string) (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(mod)), nil })
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	mod2 := "module example.com\n\nunknowndirective v1.0.0\n"
+	mod2Hash, err := dirhash.DefaultHash([]string{"go.mod"}, func(string) (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(mod2)), nil })
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	cacher := DirCacher(t.TempDir())
+	for name, content := range map[string]string{
+		"example.com/@v/list":        "v1.0.0\nv1.1.0\n",
+		"example.com/@v/v1.0.0.info": info,
+		"example.com/@v/v1.0.0.mod":  mod,
+		"example.com/@v/v1.0.0.zip":  string(zip),
+		"example.com/@v/v1.2.0.mod":  mod2,
+		"example.com/@v/invalid.mod": "",
+		"example.com/@v/v1.0.0.foo":  "",
+	} {
+		if err := cacher.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	dedupCacher := &DedupCacher{Cacher: &testCacher{Cacher: DirCacher(t.TempDir())}}
+	if err := dedupCacher.Put(t.Context(), "example.com/@v/list", strings.NewReader("v1.0.0\nv1.1.0\n")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for _, tt := range []struct {
+		n              int
+		cacher         Cacher
+		method         string
+		path           string
+		wantStatusCode int
+		wantContent    string
+	}{
+		{
+			n:              1,
+			path:           "/modules/example.com",
+			wantStatusCode: http.StatusOK,
+			wantContent: `{"Path":"example.com","Versions":[` +
+				`{"Version":"v1.0.0","Time":"2000-01-01T00:00:00Z","Cached":[".info",".mod",".zip"]},` +
+				`{"Version":"v1.1.0","Cached":[]},` +
+				`{"Version":"v1.2.0","Cached":[".mod"]}]}`,
+		},
+		{
+			n:              2,
+			cacher:         &testCacher{Cacher: cacher},
+			path:           "/modules/example.com",
+			wantStatusCode: http.StatusOK,
+			wantContent: `{"Path":"example.com","Versions":[` +
+				`{"Version":"v1.0.0","Cached":[]},` +
+				`{"Version":"v1.1.0","Cached":[]}]}`,
+		},
+		{
+			n:              3,
+			path:           "/modules/example.com/@v/v1.0.0",
+			wantStatusCode: http.StatusOK,
+			wantContent: `{"Path":"example.com","Version":"v1.0.0","Time":"2000-01-01T00:00:00Z","Cached":[".info",".mod",".zip"],` +
+				`"GoMod":{"Module":"example.com","Go":"1.21",` +
+				`"Require":[{"Path":"example.com/foo","Version":"v1.0.0"},{"Path":"example.com/bar","Version":"v1.1.0","Indirect":true}],` +
+				`"Replace":[{"Old":{"Path":"example.com/foo"},"New":{"Path":"../foo"}}]},` +
+				fmt.Sprintf(`"GoSum":["example.com v1.0.0 %s","example.com v1.0.0/go.mod %s"]}`, zipHash, modHash),
+		},
+		{
+			n:              4,
+			path:           "/modules/example.com/@v/v1.2.0",
+			wantStatusCode: http.StatusOK,
+			wantContent: `{"Path":"example.com","Version":"v1.2.0","Cached":[".mod"],` +
+				`"GoMod":{"Module":"example.com","Require":[],"Replace":[]},` +
+				fmt.Sprintf(`"GoSum":["example.com v1.2.0/go.mod %s"]}`, mod2Hash),
+		},
+		{
+			n:              5,
+			path:           "/modules/example.com/@v/v1.1.0",
+			wantStatusCode: http.StatusNotFound,
+			wantContent:    "not found",
+		},
+		{
+			n:              6,
+			path:           "/modules/example.com/@v/v1",
+			wantStatusCode: http.StatusNotFound,
+			wantContent:    "not found: example.com@v1: invalid version: not a canonical version",
+		},
+		{
+			n:              7,
+			path:           "/modules/example.com/foo",
+			wantStatusCode: http.StatusNotFound,
+			wantContent:    "not found",
+		},
+		{
+			n:              8,
+			path:           "/modules/Example.com",
+			wantStatusCode: http.StatusNotFound,
+			wantContent:    `not found: invalid escaped module path "Example.com"`,
+		},
+		{
+			n:              9,
+			path:           "/recent",
+			wantStatusCode: http.StatusOK,
+			wantContent:    `[]`,
+		},
+		{
+			n:              10,
+			path:           "/",
+			wantStatusCode: http.StatusNotFound,
+			wantContent:    "not found",
+		},
+		{
+			n:              11,
+			method:         http.MethodPost,
+			path:           "/recent",
+			wantStatusCode: http.StatusMethodNotAllowed,
+			wantContent:    "method not allowed",
+		},
+		{
+			n:              12,
+			cacher:         dedupCacher,
+			path:           "/modules/example.com",
+			wantStatusCode: http.StatusOK,
+			wantContent: `{"Path":"example.com","Versions":[` +
+				`{"Version":"v1.0.0","Cached":[]},` +
+				`{"Version":"v1.1.0","Cached":[]}]}`,
+		},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			if tt.cacher == nil {
+				tt.cacher = cacher
+			}
+			api := &MetadataAPI{Goproxy: &Goproxy{
+				Cacher:  tt.cacher,
+				TempDir: t.TempDir(),
+				Logger:  slog.New(slog.DiscardHandler),
+			}}
+
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			recr := rec.Result()
+			if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+			if tt.wantStatusCode == http.StatusOK {
+				if got, want := recr.Header.Get("Content-Type"), "application/json; charset=utf-8"; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+			if b, err := io.ReadAll(recr.Body); err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if got, want := string(b), tt.wantContent; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestMetadataAPIRecordFetch(t *testing.T) {
+	api := &MetadataAPI{
+		Goproxy:          &Goproxy{Logger: slog.New(slog.DiscardHandler)},
+		MaxRecentFetches: 2,
+	}
+	for _, target := range []string{"example.com/@v/list", "example.com/@latest", "example.com/@v/v1.0.0.zip"} {
+		api.RecordFetch(FetchEvent{Time: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), Target: target, ModulePath: "example.com"})
+	}
+
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/recent", nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	var recent []FetchEvent
+	if err := json.Unmarshal(rec.Body.Bytes(), &recent); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var targets []string
+	for _, fe := range recent {
+		targets = append(targets, fe.Target)
+	}
+	if got, want := strings.Join(targets, ","), "example.com/@v/v1.0.0.zip,example.com/@latest"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
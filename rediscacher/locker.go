@@ -0,0 +1,116 @@
+package rediscacher
+
+import (
+	"context"
+	"crypto/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// lockerPollInterval is the interval between attempts of [Locker.Lock]
+	// to acquire a lock held by another holder.
+	lockerPollInterval = 100 * time.Millisecond
+
+	// lockerRefreshScript is the Lua script that refreshes the TTL of a
+	// lock only if it is still held by the same holder.
+	lockerRefreshScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) else return 0 end`
+
+	// lockerReleaseScript is the Lua script that releases a lock only if it
+	// is still held by the same holder.
+	lockerReleaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+)
+
+// Locker implements [github.com/goproxy/goproxy.Locker] using the
+// Redis-protocol server of a [Cacher].
+//
+// Each lock is a key holding a random token of its holder. The key expires
+// after the TTL unless refreshed by its holder, so that a lock held by a
+// holder that has gone away is eventually released.
+//
+// Make sure to set all fields before calling any methods. A Locker must not
+// be copied after first use.
+type Locker struct {
+	// Cacher is the [Cacher] whose connections to the Redis-protocol server
+	// are used.
+	Cacher *Cacher
+
+	// KeyPrefix is the prefix prepended to lock names to form keys. It must
+	// not overlap with the KeyPrefix of the Cacher, which therefore must not
+	// be empty. Otherwise, the locks would be listed as caches.
+	//
+	// If KeyPrefix is empty, "goproxy-lock:" is used.
+	KeyPrefix string
+
+	// TTL is the time to live of the locks. It is refreshed every third of
+	// the TTL while a lock is held.
+	//
+	// If TTL is zero, 30 seconds is used.
+	TTL time.Duration
+
+	initOnce  sync.Once
+	keyPrefix string
+	ttl       time.Duration
+}
+
+// init initializes the l.
+func (l *Locker) init() {
+	l.Cacher.initOnce.Do(l.Cacher.init)
+	l.keyPrefix = l.KeyPrefix
+	if l.keyPrefix == "" {
+		l.keyPrefix = "goproxy-lock:"
+	}
+	l.ttl = l.TTL
+	if l.ttl == 0 {
+		l.ttl = 30 * time.Second
+	}
+}
+
+// Lock implements [github.com/goproxy/goproxy.Locker].
+func (l *Locker) Lock(ctx context.Context, name string) (unlock func(), err error) {
+	l.initOnce.Do(l.init)
+	key := l.keyPrefix + name
+	token := rand.Text()
+	ttl := strconv.FormatInt(l.ttl.Milliseconds(), 10)
+	for {
+		reply, err := l.Cacher.do(ctx, "SET", key, token, "NX", "PX", ttl)
+		if err != nil {
+			return nil, err
+		}
+		if reply != nil {
+			break
+		}
+
+		timer := time.NewTimer(lockerPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	holdCtx, cancelHold := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-holdCtx.Done():
+				return
+			case <-ticker.C:
+				l.Cacher.do(holdCtx, "EVAL", lockerRefreshScript, "1", key, token, ttl)
+			}
+		}
+	}()
+	return sync.OnceFunc(func() {
+		cancelHold()
+		<-done
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), l.ttl)
+		defer cancel()
+		l.Cacher.do(releaseCtx, "EVAL", lockerReleaseScript, "1", key, token)
+	}), nil
+}
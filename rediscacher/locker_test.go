@@ -0,0 +1,95 @@
+package rediscacher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLocker(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		fr := newFakeRedis(t, "", "")
+		l := &Locker{Cacher: &Cacher{Addr: fr.addr}}
+		unlock, err := l.Lock(t.Context(), "example.com/@v/v1.0.0")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if entry, ok := fr.entry(0, "goproxy-lock:example.com/@v/v1.0.0"); !ok {
+			t.Fatal("expected lock key")
+		} else if got, want := entry.expireAt.Sub(fr.now), 30*time.Second; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		lockedCh := make(chan func(), 1)
+		go func() {
+			unlock, err := l.Lock(t.Context(), "example.com/@v/v1.0.0")
+			if err != nil {
+				t.Errorf("unexpected error %v", err)
+				unlock = func() {}
+			}
+			lockedCh <- unlock
+		}()
+		time.Sleep(2 * lockerPollInterval)
+		select {
+		case <-lockedCh:
+			t.Fatal("expected lock to be held")
+		default:
+		}
+		unlock()
+		unlock() // Unlock is idempotent.
+		(<-lockedCh)()
+
+		if _, ok := fr.entry(0, "goproxy-lock:example.com/@v/v1.0.0"); ok {
+			t.Error("unexpected lock key")
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		fr := newFakeRedis(t, "", "")
+		l := &Locker{Cacher: &Cacher{Addr: fr.addr}, KeyPrefix: "locks/", TTL: time.Minute}
+		unlock, err := l.Lock(t.Context(), "example.com/@latest")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		fr.advance(time.Minute)
+
+		ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+		defer cancel()
+		unlock2, err := l.Lock(ctx, "example.com/@latest")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		entry, _ := fr.entry(0, "locks/example.com/@latest")
+
+		// Releasing the expired lock must not release the lock of the
+		// new holder.
+		unlock()
+		if got, ok := fr.entry(0, "locks/example.com/@latest"); !ok {
+			t.Fatal("expected lock key")
+		} else if got != entry {
+			t.Errorf("got %v, want %v", got, entry)
+		}
+
+		unlock2()
+		if _, ok := fr.entry(0, "locks/example.com/@latest"); ok {
+			t.Error("unexpected lock key")
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		fr := newFakeRedis(t, "", "")
+		l := &Locker{Cacher: &Cacher{Addr: fr.addr}}
+		unlock, err := l.Lock(t.Context(), "example.com/@v/list")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer unlock()
+
+		ctx, cancel := context.WithTimeout(t.Context(), 2*lockerPollInterval)
+		defer cancel()
+		if _, err := l.Lock(ctx, "example.com/@v/list"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
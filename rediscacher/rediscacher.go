@@ -0,0 +1,374 @@
+/*
+Package rediscacher implements [github.com/goproxy/goproxy.Cacher] using a
+Redis-protocol server, such as Redis or Valkey.
+
+It is meant for small caches, such as module version lists and queries, that
+should be shared by multiple [github.com/goproxy/goproxy.Goproxy] instances.
+Large caches, such as module zip files, can be put to another
+[github.com/goproxy/goproxy.Cacher] instead.
+
+The same Redis-protocol server can also be used to lock direct fetches across
+hosts using [Locker].
+*/
+package rediscacher
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goproxy/goproxy"
+)
+
+// Cacher implements [github.com/goproxy/goproxy.Cacher] using a
+// Redis-protocol server.
+//
+// Each cache is stored as a string value holding its modification time
+// followed by its content. Caches larger than the MaxSize are put to the
+// Fallback instead, which is also consulted when a cache is not found in the
+// Redis-protocol server.
+//
+// In addition to Get and Put, Cacher also implements the List, Delete, and
+// RedirectURL methods documented in [github.com/goproxy/goproxy.Cacher]. They
+// also take effect on the Fallback if it implements them.
+//
+// Make sure to set all fields before calling any methods. A Cacher must not
+// be copied after first use.
+type Cacher struct {
+	// Addr is the address of the Redis-protocol server.
+	//
+	// If Addr is empty, "localhost:6379" is used.
+	Addr string
+
+	// Username is the username used to authenticate connections. It takes
+	// effect only if Password is not empty.
+	Username string
+
+	// Password is the password used to authenticate connections.
+	//
+	// If Password is empty, connections are not authenticated.
+	Password string
+
+	// DB is the logical database to select.
+	DB int
+
+	// TLSConfig is the TLS configuration used to connect to the
+	// Redis-protocol server.
+	//
+	// If TLSConfig is nil, TLS is not used.
+	TLSConfig *tls.Config
+
+	// KeyPrefix is the prefix prepended to cache names to form keys, such
+	// as "goproxy:".
+	KeyPrefix string
+
+	// TTL is the time to live of the caches put to the Redis-protocol
+	// server.
+	//
+	// If TTL is zero, the caches never expire.
+	TTL time.Duration
+
+	// MaxSize is the maximum size in bytes of the caches put to the
+	// Redis-protocol server.
+	//
+	// If MaxSize is zero, 1 MiB is used.
+	MaxSize int64
+
+	// Fallback is the [github.com/goproxy/goproxy.Cacher] used for the
+	// caches larger than the MaxSize. Put deletes the key of such a cache
+	// from Redis before putting it to the Fallback.
+	//
+	// If Fallback is nil, Put returns an error for such caches.
+	Fallback goproxy.Cacher
+
+	// MaxIdleConns is the maximum number of idle connections kept for
+	// reuse.
+	//
+	// If MaxIdleConns is zero, 10 is used.
+	MaxIdleConns int
+
+	initOnce     sync.Once
+	addr         string
+	maxSize      int64
+	maxIdleConns int
+	idleConnsMu  sync.Mutex
+	idleConns    []*conn
+}
+
+// init initializes the c.
+func (c *Cacher) init() {
+	c.addr = c.Addr
+	if c.addr == "" {
+		c.addr = "localhost:6379"
+	}
+	c.maxSize = c.MaxSize
+	if c.maxSize == 0 {
+		c.maxSize = 1 << 20
+	}
+	c.maxIdleConns = c.MaxIdleConns
+	if c.maxIdleConns == 0 {
+		c.maxIdleConns = 10
+	}
+}
+
+// dial dials a new connection to the Redis-protocol server.
+func (c *Cacher) dial(ctx context.Context) (*conn, error) {
+	var (
+		netConn net.Conn
+		err     error
+	)
+	if c.TLSConfig != nil {
+		netConn, err = (&tls.Dialer{Config: c.TLSConfig}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		netConn, err = (&net.Dialer{}).DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cn := newConn(netConn)
+	if c.Password != "" {
+		args := []string{"AUTH", c.Password}
+		if c.Username != "" {
+			args = []string{"AUTH", c.Username, c.Password}
+		}
+		if _, err := cn.do(ctx, args...); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.DB != 0 {
+		if _, err := cn.do(ctx, "SELECT", strconv.Itoa(c.DB)); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// do sends the command made up of the args using an idle or new connection,
+// and returns its reply.
+func (c *Cacher) do(ctx context.Context, args ...string) (any, error) {
+	c.idleConnsMu.Lock()
+	var cn *conn
+	if n := len(c.idleConns); n > 0 {
+		cn = c.idleConns[n-1]
+		c.idleConns = c.idleConns[:n-1]
+	}
+	c.idleConnsMu.Unlock()
+	if cn == nil {
+		var err error
+		if cn, err = c.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := cn.do(ctx, args...)
+	if err != nil && !errors.As(err, new(redisError)) {
+		cn.Close()
+		return nil, err
+	}
+	c.idleConnsMu.Lock()
+	if len(c.idleConns) < c.maxIdleConns {
+		c.idleConns = append(c.idleConns, cn)
+		cn = nil
+	}
+	c.idleConnsMu.Unlock()
+	if cn != nil {
+		cn.Close()
+	}
+	return reply, err
+}
+
+// Get implements [github.com/goproxy/goproxy.Cacher].
+func (c *Cacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	c.initOnce.Do(c.init)
+	reply, err := c.do(ctx, "GET", c.KeyPrefix+name)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		if c.Fallback != nil {
+			return c.Fallback.Get(ctx, name)
+		}
+		return nil, fs.ErrNotExist
+	}
+	b, ok := reply.([]byte)
+	if !ok || len(b) < 8 {
+		return nil, fmt.Errorf("invalid cache value for %s", name)
+	}
+	content := b[8:]
+	etag := sha256.Sum256(content)
+	return &cache{
+		Reader:       bytes.NewReader(content),
+		lastModified: time.Unix(0, int64(binary.BigEndian.Uint64(b))),
+		etag:         strconv.Quote(hex.EncodeToString(etag[:16])),
+	}, nil
+}
+
+// Put implements [github.com/goproxy/goproxy.Cacher].
+func (c *Cacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	c.initOnce.Do(c.init)
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if size > c.maxSize {
+		if c.Fallback != nil {
+			// A stale copy in Redis would shadow the new content in
+			// the Fallback.
+			if _, err := c.do(ctx, "DEL", c.KeyPrefix+name); err != nil {
+				return err
+			}
+			return c.Fallback.Put(ctx, name, content)
+		}
+		return fmt.Errorf("cache size %d exceeds the maximum size %d", size, c.maxSize)
+	}
+
+	var value bytes.Buffer
+	value.Grow(8 + int(size))
+	binary.Write(&value, binary.BigEndian, uint64(time.Now().UnixNano()))
+	if _, err := io.Copy(&value, content); err != nil {
+		return err
+	}
+	args := []string{"SET", c.KeyPrefix + name, value.String()}
+	if c.TTL > 0 {
+		args = append(args, "PX", strconv.FormatInt(c.TTL.Milliseconds(), 10))
+	}
+	_, err = c.do(ctx, args...)
+	return err
+}
+
+// List lists the names of all caches that start with the prefix, in lexical
+// order. See [github.com/goproxy/goproxy.Cacher] for details.
+func (c *Cacher) List(ctx context.Context, prefix string) ([]string, error) {
+	c.initOnce.Do(c.init)
+	var names []string
+	if cl, ok := c.Fallback.(interface {
+		List(ctx context.Context, prefix string) ([]string, error)
+	}); ok {
+		var err error
+		if names, err = cl.List(ctx, prefix); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			return nil, err
+		}
+	}
+
+	pattern := globEscaper.Replace(c.KeyPrefix+prefix) + "*"
+	cursor := "0"
+	for {
+		reply, err := c.do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			return nil, err
+		}
+		replies, ok := reply.([]any)
+		if !ok || len(replies) != 2 {
+			return nil, errors.New("invalid SCAN reply")
+		}
+		nextCursor, ok := replies[0].([]byte)
+		if !ok {
+			return nil, errors.New("invalid SCAN reply")
+		}
+		keys, _ := replies[1].([]any)
+		for _, key := range keys {
+			if key, ok := key.([]byte); ok {
+				names = append(names, strings.TrimPrefix(string(key), c.KeyPrefix))
+			}
+		}
+		cursor = string(nextCursor)
+		if cursor == "0" {
+			break
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// globEscaper escapes the special characters of Redis glob-style patterns.
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// Delete deletes the cache for the name. It returns [fs.ErrNotExist] if not
+// found. See [github.com/goproxy/goproxy.Cacher] for details.
+func (c *Cacher) Delete(ctx context.Context, name string) error {
+	c.initOnce.Do(c.init)
+	reply, err := c.do(ctx, "DEL", c.KeyPrefix+name)
+	if err != nil {
+		return err
+	}
+	deleted := reply == int64(1)
+	if cd, ok := c.Fallback.(interface {
+		Delete(ctx context.Context, name string) error
+	}); ok {
+		if err := cd.Delete(ctx, name); err == nil {
+			deleted = true
+		} else if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+	if !deleted {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// RedirectURL returns a URL from which the client can get the cache for the
+// name directly. It returns [fs.ErrNotExist] if not found. See
+// [github.com/goproxy/goproxy.Cacher] for details.
+//
+// RedirectURL returns an empty URL for caches stored in the Redis-protocol
+// server, and delegates to the Fallback otherwise.
+func (c *Cacher) RedirectURL(ctx context.Context, name string) (string, error) {
+	c.initOnce.Do(c.init)
+	reply, err := c.do(ctx, "EXISTS", c.KeyPrefix+name)
+	if err != nil {
+		return "", err
+	}
+	if reply == int64(1) {
+		return "", nil
+	}
+	if cr, ok := c.Fallback.(interface {
+		RedirectURL(ctx context.Context, name string) (string, error)
+	}); ok {
+		return cr.RedirectURL(ctx, name)
+	}
+	if c.Fallback != nil {
+		return "", nil
+	}
+	return "", fs.ErrNotExist
+}
+
+// cache is the cache returned by [Cacher.Get].
+type cache struct {
+	*bytes.Reader
+	lastModified time.Time
+	etag         string
+}
+
+// Close implements [io.Closer].
+func (c *cache) Close() error {
+	return nil
+}
+
+// LastModified implements [github.com/goproxy/goproxy.Cacher.Get].
+func (c *cache) LastModified() time.Time {
+	return c.lastModified
+}
+
+// ETag implements [github.com/goproxy/goproxy.Cacher.Get].
+func (c *cache) ETag() string {
+	return c.etag
+}
@@ -0,0 +1,557 @@
+package rediscacher
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goproxy/goproxy"
+)
+
+// fakeRedisEntry is an entry stored by [fakeRedis].
+type fakeRedisEntry struct {
+	value    string
+	expireAt time.Time
+}
+
+// fakeRedis is an in-process stand-in of a Redis-protocol server that
+// supports the commands used by [Cacher] and [Locker].
+type fakeRedis struct {
+	addr     string
+	username string
+	password string
+	pageSize int
+
+	mu      sync.Mutex
+	now     time.Time
+	dbs     map[int]map[string]fakeRedisEntry
+	dials   int
+	lastSET []string
+}
+
+// newFakeRedis returns a new [fakeRedis] listening on a local address that
+// requires the username and password if the password is not empty.
+func newFakeRedis(t *testing.T, username, password string) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	fr := &fakeRedis{
+		addr:     l.Addr().String(),
+		username: username,
+		password: password,
+		pageSize: 2,
+		now:      time.Now(),
+		dbs:      map[int]map[string]fakeRedisEntry{},
+	}
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		l.Close()
+		wg.Wait()
+	})
+	wg.Go(func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			fr.mu.Lock()
+			fr.dials++
+			fr.mu.Unlock()
+			conns = append(conns, conn)
+			go fr.serve(conn)
+		}
+	})
+	return fr
+}
+
+// serve serves the conn.
+func (fr *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	authenticated := fr.password == ""
+	db := 0
+	for {
+		args, err := readCommand(br)
+		if err != nil {
+			return
+		}
+		var reply string
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			username, password := "default", args[len(args)-1]
+			if len(args) == 3 {
+				username = args[1]
+			}
+			if password != fr.password || (fr.username != "" && username != fr.username) {
+				reply = "-WRONGPASS invalid username-password pair\r\n"
+				break
+			}
+			authenticated = true
+			reply = "+OK\r\n"
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case cmd == "SELECT":
+			db, _ = strconv.Atoi(args[1])
+			reply = "+OK\r\n"
+		default:
+			reply = fr.exec(db, cmd, args[1:])
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// exec executes the command in the db and returns its reply.
+func (fr *fakeRedis) exec(db int, cmd string, args []string) string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	entries := fr.dbs[db]
+	if entries == nil {
+		entries = map[string]fakeRedisEntry{}
+		fr.dbs[db] = entries
+	}
+	for key, entry := range entries {
+		if !entry.expireAt.IsZero() && !fr.now.Before(entry.expireAt) {
+			delete(entries, key)
+		}
+	}
+	switch cmd {
+	case "GET":
+		entry, ok := entries[args[0]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulkString(entry.value)
+	case "SET":
+		fr.lastSET = args
+		entry := fakeRedisEntry{value: args[1]}
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				if _, ok := entries[args[0]]; ok {
+					return "$-1\r\n"
+				}
+			case "PX":
+				i++
+				ms, _ := strconv.ParseInt(args[i], 10, 64)
+				entry.expireAt = fr.now.Add(time.Duration(ms) * time.Millisecond)
+			}
+		}
+		entries[args[0]] = entry
+		return "+OK\r\n"
+	case "EVAL":
+		// Only the scripts used by Locker are supported.
+		script, key, token := args[0], args[2], args[3]
+		entry, ok := entries[key]
+		if !ok || entry.value != token {
+			return ":0\r\n"
+		}
+		switch script {
+		case lockerRefreshScript:
+			ms, _ := strconv.ParseInt(args[4], 10, 64)
+			entry.expireAt = fr.now.Add(time.Duration(ms) * time.Millisecond)
+			entries[key] = entry
+		case lockerReleaseScript:
+			delete(entries, key)
+		default:
+			return "-ERR unsupported script\r\n"
+		}
+		return ":1\r\n"
+	case "DEL", "EXISTS":
+		n := 0
+		for _, key := range args {
+			if _, ok := entries[key]; ok {
+				n++
+				if cmd == "DEL" {
+					delete(entries, key)
+				}
+			}
+		}
+		return ":" + strconv.Itoa(n) + "\r\n"
+	case "SCAN":
+		cursor, _ := strconv.Atoi(args[0])
+		prefix := ""
+		if len(args) >= 3 && strings.ToUpper(args[1]) == "MATCH" {
+			pattern := args[2]
+			if !strings.HasSuffix(pattern, "*") {
+				return "-ERR unsupported pattern\r\n"
+			}
+			prefix = globUnescaper.Replace(strings.TrimSuffix(pattern, "*"))
+		}
+		var keys []string
+		for key := range entries {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		end := min(cursor+fr.pageSize, len(keys))
+		nextCursor := 0
+		if end < len(keys) {
+			nextCursor = end
+		}
+		reply := "*2\r\n" + bulkString(strconv.Itoa(nextCursor)) + "*" + strconv.Itoa(end-cursor) + "\r\n"
+		for _, key := range keys[cursor:end] {
+			reply += bulkString(key)
+		}
+		return reply
+	}
+	return "-ERR unknown command '" + cmd + "'\r\n"
+}
+
+// entry returns the entry for the key in the db.
+func (fr *fakeRedis) entry(db int, key string) (fakeRedisEntry, bool) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	entry, ok := fr.dbs[db][key]
+	return entry, ok
+}
+
+// setEntry sets the entry for the key in the db.
+func (fr *fakeRedis) setEntry(db int, key string, entry fakeRedisEntry) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if fr.dbs[db] == nil {
+		fr.dbs[db] = map[string]fakeRedisEntry{}
+	}
+	fr.dbs[db][key] = entry
+}
+
+// advance advances the clock of the fr by the d.
+func (fr *fakeRedis) advance(d time.Duration) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.now = fr.now.Add(d)
+}
+
+// stats returns the number of accepted connections and the arguments of the
+// last SET command.
+func (fr *fakeRedis) stats() (dials int, lastSET []string) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.dials, fr.lastSET
+}
+
+// globUnescaper unescapes the special characters of Redis glob-style
+// patterns.
+var globUnescaper = strings.NewReplacer(`\\`, `\`, `\*`, `*`, `\?`, `?`, `\[`, `[`, `\]`, `]`)
+
+// readCommand reads a command from the br.
+func readCommand(br *bufio.Reader) ([]string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("invalid command %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+// bulkString returns the s as a RESP bulk string.
+func bulkString(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+func TestCacher(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		fr := newFakeRedis(t, "goproxy", "secret")
+		c := &Cacher{
+			Addr:      fr.addr,
+			Username:  "goproxy",
+			Password:  "secret",
+			DB:        1,
+			KeyPrefix: "goproxy:",
+		}
+		for name, content := range map[string]string{
+			"example.com/@v/list":        "v1.0.0\nv1.1.0",
+			"example.com/@latest":        `{"Version":"v1.1.0"}`,
+			"example.com/@v/v1.0.0.info": "{}",
+			"example.com/@v/v1.0.0.mod":  "",
+		} {
+			if err := c.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		if _, ok := fr.entry(1, "goproxy:example.com/@v/list"); !ok {
+			t.Error("expected key to exist in DB 1")
+		}
+		if _, lastSET := fr.stats(); len(lastSET) != 2 {
+			t.Errorf("got %q, want no options", lastSET)
+		}
+
+		rc, err := c.Get(t.Context(), "example.com/@v/list")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(rc); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "v1.0.0\nv1.1.0"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if _, ok := rc.(io.Seeker); !ok {
+			t.Error("expected io.Seeker")
+		}
+		if lastModified := rc.(interface{ LastModified() time.Time }).LastModified(); time.Since(lastModified) > time.Minute {
+			t.Errorf("got %v, want recent time", lastModified)
+		}
+		etag := rc.(interface{ ETag() string }).ETag()
+		if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+			t.Errorf("got %q, want quoted ETag", etag)
+		}
+		rc.Close()
+
+		if rc, err := c.Get(t.Context(), "example.com/@v/v1.0.0.mod"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if b, err := io.ReadAll(rc); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if len(b) != 0 {
+			t.Errorf("got %q, want empty", b)
+		}
+
+		names, err := c.List(t.Context(), "example.com/")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := names, []string{
+			"example.com/@latest",
+			"example.com/@v/list",
+			"example.com/@v/v1.0.0.info",
+			"example.com/@v/v1.0.0.mod",
+		}; !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if err := c.Delete(t.Context(), "example.com/@v/list"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := c.Get(t.Context(), "example.com/@v/list"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+		if err := c.Delete(t.Context(), "example.com/@v/list"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+
+		if dials, _ := fr.stats(); dials != 1 {
+			t.Errorf("got %d dials, want 1", dials)
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		fr := newFakeRedis(t, "", "")
+		c := &Cacher{Addr: fr.addr, TTL: time.Minute}
+		if err := c.Put(t.Context(), "example.com/@v/list", strings.NewReader("v1.0.0")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, lastSET := fr.stats(); !slices.Equal(lastSET[2:], []string{"PX", "60000"}) {
+			t.Errorf("got %q, want PX 60000", lastSET[2:])
+		}
+		if _, err := c.Get(t.Context(), "example.com/@v/list"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		fr.advance(time.Minute)
+		if _, err := c.Get(t.Context(), "example.com/@v/list"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("MaxSize", func(t *testing.T) {
+		fr := newFakeRedis(t, "", "")
+		c := &Cacher{Addr: fr.addr, MaxSize: 4}
+		if err := c.Put(t.Context(), "example.com/@v/v1.0.0.info", strings.NewReader("{}")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := c.Put(t.Context(), "example.com/@v/v1.0.0.zip", strings.NewReader("zip file")); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), "cache size 8 exceeds the maximum size 4"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if _, ok := fr.entry(0, "example.com/@v/v1.0.0.zip"); ok {
+			t.Error("expected key to not exist")
+		}
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		fr := newFakeRedis(t, "", "")
+		dc := goproxy.DirCacher(t.TempDir())
+		c := &Cacher{Addr: fr.addr, MaxSize: 4, Fallback: dc}
+		for name, content := range map[string]string{
+			"example.com/@v/v1.0.0.info": "{}",
+			"example.com/@v/v1.0.0.zip":  "zip file",
+		} {
+			if err := c.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		if _, ok := fr.entry(0, "example.com/@v/v1.0.0.zip"); ok {
+			t.Error("expected key to not exist")
+		}
+		if _, err := dc.Get(t.Context(), "example.com/@v/v1.0.0.info"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+
+		for name, want := range map[string]string{
+			"example.com/@v/v1.0.0.info": "{}",
+			"example.com/@v/v1.0.0.zip":  "zip file",
+		} {
+			rc, err := c.Get(t.Context(), name)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if b, err := io.ReadAll(rc); err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if got := string(b); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			rc.Close()
+		}
+
+		names, err := c.List(t.Context(), "example.com/@v/")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := names, []string{"example.com/@v/v1.0.0.info", "example.com/@v/v1.0.0.zip"}; !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if err := c.Put(t.Context(), "example.com/@v/v1.0.0.info", strings.NewReader(`{"Version":"v1.0.0"}`)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, ok := fr.entry(0, "example.com/@v/v1.0.0.info"); ok {
+			t.Error("expected key to not exist")
+		}
+		if rc, err := c.Get(t.Context(), "example.com/@v/v1.0.0.info"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else {
+			if b, err := io.ReadAll(rc); err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if got, want := string(b), `{"Version":"v1.0.0"}`; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			rc.Close()
+		}
+
+		if err := c.Delete(t.Context(), "example.com/@v/v1.0.0.zip"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := c.Get(t.Context(), "example.com/@v/v1.0.0.zip"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("RedirectURL", func(t *testing.T) {
+		fr := newFakeRedis(t, "", "")
+		c := &Cacher{Addr: fr.addr, MaxSize: 4, Fallback: &redirectTestCacher{DirCacher: goproxy.DirCacher(t.TempDir())}}
+		for name, content := range map[string]string{
+			"example.com/@v/v1.0.0.info": "{}",
+			"example.com/@v/v1.0.0.zip":  "zip file",
+		} {
+			if err := c.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		if url, err := c.RedirectURL(t.Context(), "example.com/@v/v1.0.0.info"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if url != "" {
+			t.Errorf("got %q, want empty", url)
+		}
+		if url, err := c.RedirectURL(t.Context(), "example.com/@v/v1.0.0.zip"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if got, want := url, "https://cdn.example.com/example.com/@v/v1.0.0.zip"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if _, err := c.RedirectURL(t.Context(), "example.com/@v/v1.1.0.zip"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		fr := newFakeRedis(t, "", "secret")
+		c := &Cacher{Addr: fr.addr, Password: "wrong"}
+		if _, err := c.Get(t.Context(), "example.com/@v/list"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), "redis: WRONGPASS invalid username-password pair"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("NoAuth", func(t *testing.T) {
+		fr := newFakeRedis(t, "", "secret")
+		c := &Cacher{Addr: fr.addr}
+		if _, err := c.Get(t.Context(), "example.com/@v/list"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), "redis: NOAUTH Authentication required."; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("GlobPrefix", func(t *testing.T) {
+		fr := newFakeRedis(t, "", "")
+		c := &Cacher{Addr: fr.addr, KeyPrefix: "[goproxy]*"}
+		if err := c.Put(t.Context(), "example.com/@v/list", strings.NewReader("v1.0.0")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		fr.setEntry(0, "goproxy:example.com/@v/list", fakeRedisEntry{value: "other"})
+		names, err := c.List(t.Context(), "")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := names, []string{"example.com/@v/list"}; !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+// redirectTestCacher is a [goproxy.DirCacher] that implements RedirectURL.
+type redirectTestCacher struct {
+	goproxy.DirCacher
+}
+
+// RedirectURL implements [goproxy.Cacher].
+func (rc *redirectTestCacher) RedirectURL(ctx context.Context, name string) (string, error) {
+	rc2, err := rc.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	rc2.Close()
+	return "https://cdn.example.com/" + name, nil
+}
@@ -0,0 +1,146 @@
+package rediscacher
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// redisError is an error reply from the Redis server.
+type redisError string
+
+// Error implements [error].
+func (re redisError) Error() string {
+	return "redis: " + string(re)
+}
+
+// conn is a connection to a Redis server speaking RESP2.
+type conn struct {
+	netConn net.Conn
+	br      *bufio.Reader
+	bw      *bufio.Writer
+}
+
+// newConn returns a new [conn] using the netConn.
+func newConn(netConn net.Conn) *conn {
+	return &conn{
+		netConn: netConn,
+		br:      bufio.NewReader(netConn),
+		bw:      bufio.NewWriter(netConn),
+	}
+}
+
+// do sends the command made up of the args and returns its reply, which is
+// one of string, int64, []byte, []any, or nil. An error reply is returned as
+// a [redisError], after which the c remains usable.
+func (c *conn) do(ctx context.Context, args ...string) (any, error) {
+	deadline, _ := ctx.Deadline()
+	if err := c.netConn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		c.netConn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	reply, err := c.roundTrip(args)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) && !deadline.IsZero() && !time.Now().Before(deadline) {
+		// The deadline of the c.netConn may pass slightly before the
+		// ctx notices it.
+		return nil, context.DeadlineExceeded
+	}
+	return reply, err
+}
+
+// roundTrip writes the command made up of the args and reads its reply.
+func (c *conn) roundTrip(args []string) (any, error) {
+	fmt.Fprintf(c.bw, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.bw, "$%d\r\n", len(arg))
+		c.bw.WriteString(arg)
+		c.bw.WriteString("\r\n")
+	}
+	if err := c.bw.Flush(); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// readReply reads a reply.
+func (c *conn) readReply() (any, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: invalid reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid integer reply: %w", err)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: invalid bulk string length %q", line[1:])
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.br, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: invalid array length %q", line[1:])
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		replies := make([]any, n)
+		for i := range replies {
+			var err error
+			if replies[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply type %q", line[0])
+}
+
+// readLine reads a line without the trailing CRLF.
+func (c *conn) readLine() (string, error) {
+	line, err := c.br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("redis: invalid line terminator")
+	}
+	return line[:len(line)-2], nil
+}
+
+// Close closes the c.
+func (c *conn) Close() error {
+	return c.netConn.Close()
+}
@@ -71,6 +71,16 @@ func responseSuccess(rw http.ResponseWriter, req *http.Request, content io.Reade
 	rw.Header().Set("Content-Type", contentType)
 	setResponseCacheControlHeader(rw, cacheControlMaxAge)
 
+	if ec, ok := content.(interface {
+		EncodedContent() (content io.Reader, contentCoding string)
+	}); ok {
+		rw.Header().Add("Vary", "Accept-Encoding")
+		if encoded, contentCoding := ec.EncodedContent(); acceptsContentCoding(req, contentCoding) {
+			rw.Header().Set("Content-Encoding", contentCoding)
+			content = encoded
+		}
+	}
+
 	var lastModified time.Time
 	if lm, ok := content.(interface{ LastModified() time.Time }); ok {
 		lastModified = lm.LastModified()
@@ -105,6 +115,61 @@ func responseSuccess(rw http.ResponseWriter, req *http.Request, content io.Reade
 	}
 }
 
+// acceptsContentCoding reports whether the Accept-Encoding request header of
+// the req accepts the contentCoding.
+func acceptsContentCoding(req *http.Request, contentCoding string) bool {
+	accepted := false
+	for _, v := range req.Header.Values("Accept-Encoding") {
+		for part := range strings.SplitSeq(v, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			coding = strings.TrimSpace(coding)
+			if !strings.EqualFold(coding, contentCoding) && coding != "*" {
+				continue
+			}
+			q := 1.0
+			for param := range strings.SplitSeq(params, ";") {
+				if k, v, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.EqualFold(k, "q") {
+					if f, err := strconv.ParseFloat(v, 64); err == nil {
+						q = f
+					}
+				}
+			}
+			if coding != "*" {
+				return q > 0
+			}
+			accepted = q > 0
+		}
+	}
+	return accepted
+}
+
+// responseStream is an [io.Writer] that streams a success response to the
+// client with the contentType and cacheControlMaxAge. The response header is
+// written on the first call to Write, and the written content is flushed to
+// the client immediately.
+type responseStream struct {
+	rw                 http.ResponseWriter
+	contentType        string
+	cacheControlMaxAge int
+	started            bool
+}
+
+// Write implements [io.Writer].
+func (rs *responseStream) Write(p []byte) (int, error) {
+	if !rs.started {
+		rs.started = true
+		rs.rw.Header().Set("Content-Type", rs.contentType)
+		setResponseCacheControlHeader(rs.rw, rs.cacheControlMaxAge)
+		rs.rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rs.rw.Write(p)
+	if err != nil {
+		return n, err
+	}
+	http.NewResponseController(rs.rw).Flush()
+	return n, nil
+}
+
 // responseError responses error to the client with the err and cacheSensitive.
 func responseError(rw http.ResponseWriter, req *http.Request, err error, cacheSensitive bool) {
 	if errors.Is(err, fs.ErrNotExist) {
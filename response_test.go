@@ -1,6 +1,8 @@
 package goproxy
 
 import (
+	"bytes"
+	"compress/gzip"
 	"errors"
 	"io"
 	"io/fs"
@@ -295,6 +297,178 @@ func TestResponseSuccess(t *testing.T) {
 	}
 }
 
+func TestResponseSuccessEncoded(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("foobar"))
+	zw.Close()
+	newContent := func() io.Reader {
+		return &compressedContent{
+			contentMetadata: contentMetadata{etag: `"foobar"`},
+			encoded:         buf.Bytes(),
+		}
+	}
+
+	for _, tt := range []struct {
+		n                   int
+		acceptEncoding      string
+		rangeHeader         string
+		ifNoneMatch         string
+		wantStatusCode      int
+		wantContentEncoding string
+		wantETag            string
+		wantContent         string
+	}{
+		{
+			n:              1,
+			wantStatusCode: http.StatusOK,
+			wantETag:       `"foobar"`,
+			wantContent:    "foobar",
+		},
+		{
+			n:                   2,
+			acceptEncoding:      "gzip, deflate",
+			wantStatusCode:      http.StatusOK,
+			wantContentEncoding: "gzip",
+			wantETag:            `"foobar-gzip"`,
+			wantContent:         buf.String(),
+		},
+		{
+			n:              3,
+			acceptEncoding: "gzip;q=0",
+			wantStatusCode: http.StatusOK,
+			wantETag:       `"foobar"`,
+			wantContent:    "foobar",
+		},
+		{
+			n:              4,
+			rangeHeader:    "bytes=3-",
+			wantStatusCode: http.StatusPartialContent,
+			wantETag:       `"foobar"`,
+			wantContent:    "bar",
+		},
+		{
+			n:                   5,
+			acceptEncoding:      "gzip",
+			rangeHeader:         "bytes=0-1",
+			wantStatusCode:      http.StatusPartialContent,
+			wantContentEncoding: "gzip",
+			wantETag:            `"foobar-gzip"`,
+			wantContent:         buf.String()[:2],
+		},
+		{
+			n:                   6,
+			acceptEncoding:      "gzip",
+			ifNoneMatch:         `"foobar"`,
+			wantStatusCode:      http.StatusOK,
+			wantContentEncoding: "gzip",
+			wantETag:            `"foobar-gzip"`,
+			wantContent:         buf.String(),
+		},
+		{
+			n:              7,
+			acceptEncoding: "gzip",
+			ifNoneMatch:    `"foobar-gzip"`,
+			wantStatusCode: http.StatusNotModified,
+			wantETag:       `"foobar-gzip"`,
+		},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+			responseSuccess(rec, req, newContent(), "text/plain; charset=utf-8", 60)
+			recr := rec.Result()
+			if got, want := recr.StatusCode, tt.wantStatusCode; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+			if got, want := recr.Header.Get("Vary"), "Accept-Encoding"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := recr.Header.Get("Content-Encoding"), tt.wantContentEncoding; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := recr.Header.Get("ETag"), tt.wantETag; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if b, err := io.ReadAll(recr.Body); err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if got, want := string(b), tt.wantContent; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestAcceptsContentCoding(t *testing.T) {
+	for _, tt := range []struct {
+		n              int
+		acceptEncoding []string
+		want           bool
+	}{
+		{n: 1, want: false},
+		{n: 2, acceptEncoding: []string{"gzip"}, want: true},
+		{n: 3, acceptEncoding: []string{"deflate, GZIP;q=0.5"}, want: true},
+		{n: 4, acceptEncoding: []string{"br", "gzip"}, want: true},
+		{n: 5, acceptEncoding: []string{"gzip;q=0"}, want: false},
+		{n: 6, acceptEncoding: []string{"*"}, want: true},
+		{n: 7, acceptEncoding: []string{"*, gzip;q=0"}, want: false},
+		{n: 8, acceptEncoding: []string{"gzip;q=0, *"}, want: false},
+		{n: 9, acceptEncoding: []string{"identity"}, want: false},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, v := range tt.acceptEncoding {
+				req.Header.Add("Accept-Encoding", v)
+			}
+			if got, want := acceptsContentCoding(req, "gzip"), tt.want; got != want {
+				t.Errorf("got %t, want %t", got, want)
+			}
+		})
+	}
+}
+
+func TestResponseStream(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rs := &responseStream{rw: rec, contentType: "application/zip", cacheControlMaxAge: 60}
+	for _, p := range []string{"foo", "bar"} {
+		if n, err := rs.Write([]byte(p)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if got, want := n, len(p); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		if !rec.Flushed {
+			t.Error("expected flushed")
+		}
+	}
+	if !rs.started {
+		t.Error("expected started")
+	}
+	recr := rec.Result()
+	if got, want := recr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if got, want := recr.Header.Get("Content-Type"), "application/zip"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := recr.Header.Get("Cache-Control"), "public, max-age=60"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if b, err := io.ReadAll(recr.Body); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if got, want := string(b), "foobar"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 func TestResponseError(t *testing.T) {
 	for _, tt := range []struct {
 		n                int
@@ -0,0 +1,147 @@
+package goproxy
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aofei/backoff"
+)
+
+// maxRetryAfter is the maximum delay honored from the "Retry-After" header.
+const maxRetryAfter = time.Minute
+
+// defaultRetryableStatuses is the default value of
+// [RetryPolicy.RetryableStatuses].
+var defaultRetryableStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy is the policy for retrying failed outgoing HTTP requests to
+// upstreams, such as module proxies and checksum databases. Requests are
+// retried after network errors (except those that will not go away by
+// retrying, such as TLS certificate errors) and after responses with one of
+// the retryable statuses, with an exponential backoff between attempts.
+//
+// The zero value is the default policy.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of each request,
+	// including the first one.
+	//
+	// If MaxAttempts is zero, 10 is used. Use 1 to disable retries.
+	MaxAttempts int
+
+	// BackoffBase is the base delay of the exponential backoff between
+	// attempts, which doubles after each attempt up to BackoffCap.
+	//
+	// If BackoffBase is zero, 100 milliseconds is used.
+	BackoffBase time.Duration
+
+	// BackoffCap is the maximum delay of the exponential backoff between
+	// attempts.
+	//
+	// If BackoffCap is zero, 1 second is used.
+	BackoffCap time.Duration
+
+	// DisableJitter indicates whether to always wait for the full backoff
+	// delay between attempts, rather than a random delay between zero and
+	// the full backoff delay ("full jitter").
+	DisableJitter bool
+
+	// RetryableStatuses is the list of response statuses after which a
+	// request is retried.
+	//
+	// If RetryableStatuses is nil, 429, 500, 502, 503, and 504 are used.
+	RetryableStatuses []int
+
+	// HonorRetryAfter indicates whether to wait for the delay specified by
+	// the "Retry-After" header of a response with a retryable status
+	// instead of the backoff delay. Delays longer than one minute are
+	// capped at one minute.
+	HonorRetryAfter bool
+
+	// AttemptTimeout is the maximum amount of time each attempt waits for
+	// the response headers (and for the body of an unsuccessful response).
+	// An attempt that times out is retried. It does not limit the time
+	// spent reading the body of a successful response, which can be large.
+	//
+	// If AttemptTimeout is zero, there is no limit.
+	AttemptTimeout time.Duration
+}
+
+// maxAttempts returns the maximum number of attempts of the rp.
+func (rp *RetryPolicy) maxAttempts() int {
+	if rp == nil || rp.MaxAttempts <= 0 {
+		return 10
+	}
+	return rp.MaxAttempts
+}
+
+// attemptTimeout returns the attempt timeout of the rp.
+func (rp *RetryPolicy) attemptTimeout() time.Duration {
+	if rp == nil {
+		return 0
+	}
+	return rp.AttemptTimeout
+}
+
+// isRetryableStatus reports whether the status is retryable under the rp.
+func (rp *RetryPolicy) isRetryableStatus(status int) bool {
+	if rp == nil || rp.RetryableStatuses == nil {
+		return slices.Contains(defaultRetryableStatuses, status)
+	}
+	return slices.Contains(rp.RetryableStatuses, status)
+}
+
+// delay returns the delay before the attempt following the attempt (starting
+// from zero), which has got the resp (if not nil).
+func (rp *RetryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	base, backoffCap := 100*time.Millisecond, time.Second
+	var disableJitter, honorRetryAfter bool
+	if rp != nil {
+		if rp.BackoffBase > 0 {
+			base = rp.BackoffBase
+		}
+		if rp.BackoffCap > 0 {
+			backoffCap = rp.BackoffCap
+		}
+		disableJitter, honorRetryAfter = rp.DisableJitter, rp.HonorRetryAfter
+	}
+	if honorRetryAfter && resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return d
+		}
+	}
+	if !disableJitter {
+		return backoff.Duration(base, backoffCap, attempt)
+	}
+	if attempt >= 63 || base > backoffCap>>attempt {
+		return backoffCap
+	}
+	return base << attempt
+}
+
+// parseRetryAfter parses the value of the "Retry-After" header, which is
+// either a number of seconds or an HTTP date, relative to the now. The result
+// is capped at maxRetryAfter.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(min(seconds, int64(maxRetryAfter/time.Second))) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return min(max(t.Sub(now), 0), maxRetryAfter), true
+}
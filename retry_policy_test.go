@@ -0,0 +1,100 @@
+package goproxy
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		for _, rp := range []*RetryPolicy{nil, {}} {
+			if got, want := rp.maxAttempts(), 10; got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+			if got, want := rp.attemptTimeout(), time.Duration(0); got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			for _, status := range []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+				if !rp.isRetryableStatus(status) {
+					t.Errorf("expected status %d to be retryable", status)
+				}
+			}
+			for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotImplemented} {
+				if rp.isRetryableStatus(status) {
+					t.Errorf("expected status %d to be non-retryable", status)
+				}
+			}
+			for attempt := range 8 {
+				if got := rp.delay(attempt, nil); got < 0 || got >= time.Second {
+					t.Errorf("got %v, want within [0, 1s)", got)
+				}
+			}
+		}
+	})
+
+	t.Run("Delay", func(t *testing.T) {
+		rp := &RetryPolicy{BackoffBase: 10 * time.Millisecond, BackoffCap: 50 * time.Millisecond, DisableJitter: true}
+		for _, tt := range []struct {
+			n       int
+			attempt int
+			want    time.Duration
+		}{
+			{1, 0, 10 * time.Millisecond},
+			{2, 1, 20 * time.Millisecond},
+			{3, 2, 40 * time.Millisecond},
+			{4, 3, 50 * time.Millisecond},
+			{5, 100, 50 * time.Millisecond},
+		} {
+			t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+				if got, want := rp.delay(tt.attempt, nil), tt.want; got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			})
+		}
+	})
+
+	t.Run("RetryAfter", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{"Retry-After": {"3"}}}
+		rp := &RetryPolicy{BackoffBase: 10 * time.Millisecond, DisableJitter: true}
+		if got, want := rp.delay(0, resp), 10*time.Millisecond; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		rp.HonorRetryAfter = true
+		if got, want := rp.delay(0, resp), 3*time.Second; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		resp.Header.Set("Retry-After", "invalid")
+		if got, want := rp.delay(0, resp), 10*time.Millisecond; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		n      int
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{1, "", 0, false},
+		{2, "0", 0, true},
+		{3, "30", 30 * time.Second, true},
+		{4, "3600", time.Minute, true},
+		{5, "-1", 0, false},
+		{6, now.Add(10 * time.Second).Format(http.TimeFormat), 10 * time.Second, true},
+		{7, now.Add(-10 * time.Second).Format(http.TimeFormat), 0, true},
+		{8, now.Add(time.Hour).Format(http.TimeFormat), time.Minute, true},
+		{9, "foobar", 0, false},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			got, gotOK := parseRetryAfter(tt.value, now)
+			if got != tt.want || gotOK != tt.wantOK {
+				t.Errorf("got (%v, %t), want (%v, %t)", got, gotOK, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+/*
+Package s3cacher implements [github.com/goproxy/goproxy.Cacher] using an
+S3-compatible service.
+*/
+package s3cacher
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goproxy/goproxy/internal/cachecontent"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// Cacher implements [github.com/goproxy/goproxy.Cacher] using an
+// S3-compatible service.
+//
+// In addition to Get and Put, Cacher also implements the List, Delete, and
+// RedirectURL methods documented in [github.com/goproxy/goproxy.Cacher].
+//
+// Make sure to set all fields before calling any methods. A Cacher must not
+// be copied after first use.
+type Cacher struct {
+	// Endpoint is the endpoint of the S3-compatible service.
+	//
+	// If Endpoint is empty, "s3.amazonaws.com" is used.
+	Endpoint string
+
+	// DisableTLS indicates whether to disable TLS when connecting to the
+	// Endpoint.
+	DisableTLS bool
+
+	// ForcePathStyle indicates whether to force path-style addressing of the
+	// Bucket instead of virtual-hosted-style addressing.
+	ForcePathStyle bool
+
+	// Region is the region of the Bucket.
+	//
+	// If Region is empty, it is looked up from the S3-compatible service.
+	Region string
+
+	// Bucket is the name of the bucket.
+	Bucket string
+
+	// KeyPrefix is the prefix prepended to cache names to form object
+	// keys, such as "goproxy/".
+	KeyPrefix string
+
+	// AccessKeyID is the access key ID used to authenticate requests.
+	//
+	// If AccessKeyID is empty, credentials are retrieved from the first
+	// available source of the following: the AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY environment variables, the MINIO_ROOT_USER and
+	// MINIO_ROOT_PASSWORD environment variables, the shared credentials
+	// file (see the AWS_SHARED_CREDENTIALS_FILE and AWS_PROFILE environment
+	// variables), and the IAM role of the EC2 instance, ECS task, or EKS pod.
+	// Requests are sent anonymously if none is available.
+	AccessKeyID string
+
+	// SecretAccessKey is the secret access key used to authenticate
+	// requests. It takes effect only if AccessKeyID is not empty.
+	SecretAccessKey string
+
+	// SessionToken is the session token used to authenticate requests with
+	// temporary credentials. It takes effect only if AccessKeyID is not
+	// empty.
+	SessionToken string
+
+	// ServerSideEncryption is the server-side encryption applied to the
+	// objects put by the Cacher. Valid values are "AES256" (SSE-S3) and
+	// "aws:kms" (SSE-KMS).
+	//
+	// If ServerSideEncryption is empty, the default encryption of the
+	// Bucket applies.
+	ServerSideEncryption string
+
+	// SSEKMSKeyID is the ID of the KMS key used for SSE-KMS. It takes effect
+	// only if ServerSideEncryption is "aws:kms".
+	//
+	// If SSEKMSKeyID is empty, the AWS managed key is used.
+	SSEKMSKeyID string
+
+	// SSECustomerKey is the 32-byte customer-provided key used for
+	// server-side encryption with customer-provided keys (SSE-C). The same
+	// key is required to read the objects back, and TLS must be enabled.
+	//
+	// SSECustomerKey cannot be used together with ServerSideEncryption.
+	SSECustomerKey []byte
+
+	// StorageClass is the storage class of the objects put by the Cacher,
+	// such as "STANDARD_IA".
+	//
+	// If StorageClass is empty, the default storage class of the
+	// S3-compatible service applies.
+	StorageClass string
+
+	// RedirectBaseURL is the base URL, such as a CDN URL fronting the
+	// Bucket, that the RedirectURL method joins with object keys to form
+	// redirect URLs.
+	//
+	// If RedirectBaseURL is empty, presigned URLs are used.
+	RedirectBaseURL string
+
+	// PresignExpiry is the validity duration of the presigned URLs
+	// returned by the RedirectURL method.
+	//
+	// If PresignExpiry is zero, 15 minutes is used.
+	PresignExpiry time.Duration
+
+	// PartSize is the part size in bytes of multipart uploads.
+	//
+	// If PartSize is zero, 100 MiB is used.
+	PartSize int64
+
+	// Transport is used to perform HTTP requests.
+	//
+	// If Transport is nil, [http.DefaultTransport] is used.
+	Transport http.RoundTripper
+
+	initOnce sync.Once
+	initErr  error
+	client   *minio.Client
+	sse      encrypt.ServerSide
+}
+
+// init initializes the c.
+func (c *Cacher) init() {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	var creds *credentials.Credentials
+	if c.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(c.AccessKeyID, c.SecretAccessKey, c.SessionToken)
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: transport}},
+		})
+	}
+
+	switch {
+	case len(c.SSECustomerKey) > 0:
+		if c.ServerSideEncryption != "" {
+			c.initErr = errors.New("server-side encryption and customer-provided key cannot be used together")
+			return
+		}
+		c.sse, c.initErr = encrypt.NewSSEC(c.SSECustomerKey)
+	case c.ServerSideEncryption == "AES256":
+		c.sse = encrypt.NewSSE()
+	case c.ServerSideEncryption == "aws:kms":
+		c.sse, c.initErr = encrypt.NewSSEKMS(c.SSEKMSKeyID, nil)
+	case c.ServerSideEncryption != "":
+		c.initErr = errors.New("invalid server-side encryption: " + strconv.Quote(c.ServerSideEncryption))
+	}
+	if c.initErr != nil {
+		return
+	}
+
+	clientOpts := &minio.Options{
+		Creds:        creds,
+		Secure:       !c.DisableTLS,
+		Transport:    transport,
+		Region:       c.Region,
+		BucketLookup: minio.BucketLookupDNS,
+	}
+	if c.ForcePathStyle {
+		clientOpts.BucketLookup = minio.BucketLookupPath
+	}
+	c.client, c.initErr = minio.New(endpoint, clientOpts)
+}
+
+// getOptions returns the [minio.GetObjectOptions] for the c.
+func (c *Cacher) getOptions() minio.GetObjectOptions {
+	var opts minio.GetObjectOptions
+	if c.sse != nil && c.sse.Type() == encrypt.SSEC {
+		opts.ServerSideEncryption = c.sse
+	}
+	return opts
+}
+
+// Get implements [github.com/goproxy/goproxy.Cacher].
+func (c *Cacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return nil, c.initErr
+	}
+	o, err := c.client.GetObject(ctx, c.Bucket, c.KeyPrefix+name, c.getOptions())
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+	oi, err := o.Stat()
+	if err != nil {
+		o.Close()
+		if isNotFound(err) {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+	return &cache{o, oi}, nil
+}
+
+// Put implements [github.com/goproxy/goproxy.Cacher].
+func (c *Cacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return c.initErr
+	}
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	partSize := c.PartSize
+	if partSize == 0 {
+		partSize = 100 << 20
+	}
+	_, err = c.client.PutObject(ctx, c.Bucket, c.KeyPrefix+name, content, size, minio.PutObjectOptions{
+		ContentType:          cachecontent.Type(name),
+		ServerSideEncryption: c.sse,
+		StorageClass:         c.StorageClass,
+		PartSize:             uint64(partSize),
+		SendContentMd5:       true,
+	})
+	return err
+}
+
+// List lists the names of all caches that start with the prefix, in lexical
+// order. See [github.com/goproxy/goproxy.Cacher] for details.
+func (c *Cacher) List(ctx context.Context, prefix string) ([]string, error) {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return nil, c.initErr
+	}
+	var names []string
+	for oi := range c.client.ListObjects(ctx, c.Bucket, minio.ListObjectsOptions{Prefix: c.KeyPrefix + prefix, Recursive: true}) {
+		if oi.Err != nil {
+			return nil, oi.Err
+		}
+		names = append(names, strings.TrimPrefix(oi.Key, c.KeyPrefix))
+	}
+	return names, nil
+}
+
+// Delete deletes the cache for the name. It returns [fs.ErrNotExist] if not
+// found. See [github.com/goproxy/goproxy.Cacher] for details.
+func (c *Cacher) Delete(ctx context.Context, name string) error {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return c.initErr
+	}
+	if _, err := c.client.StatObject(ctx, c.Bucket, c.KeyPrefix+name, c.getOptions()); err != nil {
+		if isNotFound(err) {
+			return fs.ErrNotExist
+		}
+		return err
+	}
+	return c.client.RemoveObject(ctx, c.Bucket, c.KeyPrefix+name, minio.RemoveObjectOptions{})
+}
+
+// RedirectURL returns a URL from which the client can get the cache for the
+// name directly. It returns [fs.ErrNotExist] if not found. See
+// [github.com/goproxy/goproxy.Cacher] for details.
+//
+// The URL is joined from the RedirectBaseURL if it is not empty, or is
+// presigned to be valid for the PresignExpiry otherwise. RedirectURL returns
+// an empty URL if the SSECustomerKey is set, because clients cannot provide
+// it.
+func (c *Cacher) RedirectURL(ctx context.Context, name string) (string, error) {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return "", c.initErr
+	}
+	if len(c.SSECustomerKey) > 0 {
+		return "", nil
+	}
+	key := c.KeyPrefix + name
+	if _, err := c.client.StatObject(ctx, c.Bucket, key, minio.StatObjectOptions{}); err != nil {
+		if isNotFound(err) {
+			return "", fs.ErrNotExist
+		}
+		return "", err
+	}
+	if c.RedirectBaseURL != "" {
+		return url.JoinPath(c.RedirectBaseURL, key)
+	}
+	expiry := c.PresignExpiry
+	if expiry == 0 {
+		expiry = 15 * time.Minute
+	}
+	u, err := c.client.PresignedGetObject(ctx, c.Bucket, key, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// isNotFound reports whether the err is a "not found" error from the
+// S3-compatible service.
+func isNotFound(err error) bool {
+	return minio.ToErrorResponse(err).StatusCode == http.StatusNotFound
+}
+
+// cache is the cache returned by [Cacher.Get].
+type cache struct {
+	*minio.Object
+	minio.ObjectInfo
+}
+
+// LastModified implements [github.com/goproxy/goproxy.Cacher.Get].
+func (c *cache) LastModified() time.Time {
+	return c.ObjectInfo.LastModified
+}
+
+// ETag implements [github.com/goproxy/goproxy.Cacher.Get].
+func (c *cache) ETag() string {
+	if c.ObjectInfo.ETag != "" {
+		return strconv.Quote(c.ObjectInfo.ETag)
+	}
+	return ""
+}
@@ -0,0 +1,387 @@
+package s3cacher
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3Object is an object stored by [fakeS3].
+type fakeS3Object struct {
+	content []byte
+	header  http.Header
+	modTime time.Time
+}
+
+// fakeS3 is an in-process fake of an S3-compatible service that supports
+// path-style addressing of a single bucket.
+type fakeS3 struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string]fakeS3Object
+}
+
+// ServeHTTP implements [http.Handler].
+func (fs3 *fakeS3) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/"), "/")
+	if bucket != fs3.bucket {
+		fs3.responseError(rw, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	fs3.mu.Lock()
+	defer fs3.mu.Unlock()
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		if key == "" {
+			fs3.serveList(rw, req)
+			return
+		}
+		o, ok := fs3.objects[key]
+		if !ok {
+			fs3.responseError(rw, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for k, v := range o.header {
+			rw.Header()[k] = v
+		}
+		rw.Header().Set("ETag", strconv.Quote(strconv.Itoa(len(o.content))))
+		http.ServeContent(rw, req, "", o.modTime, bytes.NewReader(o.content))
+	case http.MethodPut:
+		content, err := io.ReadAll(req.Body)
+		if err != nil {
+			fs3.responseError(rw, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		header := http.Header{}
+		for k, v := range req.Header {
+			if k == "Content-Type" || strings.HasPrefix(k, "X-Amz-Server-Side-Encryption") || k == "X-Amz-Storage-Class" {
+				header[k] = v
+			}
+		}
+		fs3.objects[key] = fakeS3Object{content: content, header: header, modTime: time.Now()}
+		rw.Header().Set("ETag", strconv.Quote(strconv.Itoa(len(content))))
+		rw.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		delete(fs3.objects, key)
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		fs3.responseError(rw, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// serveList serves ListObjectsV2 requests.
+func (fs3 *fakeS3) serveList(rw http.ResponseWriter, req *http.Request) {
+	type contents struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	result := struct {
+		XMLName      xml.Name `xml:"ListBucketResult"`
+		Name         string
+		Prefix       string
+		KeyCount     int
+		MaxKeys      int
+		IsTruncated  bool
+		EncodingType string `xml:",omitempty"`
+		Contents     []contents
+	}{
+		Name:    fs3.bucket,
+		Prefix:  req.URL.Query().Get("prefix"),
+		MaxKeys: 1000,
+	}
+	urlEncoding := req.URL.Query().Get("encoding-type") == "url"
+	if urlEncoding {
+		result.EncodingType = "url"
+	}
+	for key, o := range fs3.objects {
+		if !strings.HasPrefix(key, result.Prefix) {
+			continue
+		}
+		if urlEncoding {
+			key = url.QueryEscape(key)
+		}
+		result.Contents = append(result.Contents, contents{
+			Key:          key,
+			LastModified: o.modTime.UTC().Format(time.RFC3339),
+			ETag:         strconv.Quote(strconv.Itoa(len(o.content))),
+			Size:         len(o.content),
+		})
+	}
+	slices.SortFunc(result.Contents, func(a, b contents) int { return strings.Compare(a.Key, b.Key) })
+	result.KeyCount = len(result.Contents)
+	rw.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(rw).Encode(result)
+}
+
+// responseError responses an S3 error to the client.
+func (fs3 *fakeS3) responseError(rw http.ResponseWriter, statusCode int, code string) {
+	rw.Header().Set("Content-Type", "application/xml")
+	rw.WriteHeader(statusCode)
+	xml.NewEncoder(rw).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+	}{Code: code})
+}
+
+// newFakeS3Cacher returns a new [Cacher] backed by a new [fakeS3].
+func newFakeS3Cacher(t *testing.T) (*Cacher, *fakeS3) {
+	fs3 := &fakeS3{bucket: "test", objects: map[string]fakeS3Object{}}
+	server := httptest.NewTLSServer(fs3)
+	t.Cleanup(server.Close)
+	c := &Cacher{
+		Endpoint:        strings.TrimPrefix(server.URL, "https://"),
+		ForcePathStyle:  true,
+		Region:          "us-east-1",
+		Bucket:          fs3.bucket,
+		AccessKeyID:     "test",
+		SecretAccessKey: "test",
+		Transport:       server.Client().Transport,
+	}
+	return c, fs3
+}
+
+func TestCacher(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		c, fs3 := newFakeS3Cacher(t)
+		c.KeyPrefix = "goproxy/"
+		c.StorageClass = "STANDARD_IA"
+		c.ServerSideEncryption = "AES256"
+		for name, content := range map[string]string{
+			"example.com/@v/v1.0.0.info": "{}",
+			"example.com/@v/v1.0.0.mod":  "module example.com",
+			"example.com/@v/v1.0.0.zip":  "zip",
+		} {
+			if err := c.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+
+		o, ok := fs3.objects["goproxy/example.com/@v/v1.0.0.mod"]
+		if !ok {
+			t.Fatal("expected object to exist")
+		}
+		for k, want := range map[string]string{
+			"Content-Type":                 "text/plain; charset=utf-8",
+			"X-Amz-Storage-Class":          "STANDARD_IA",
+			"X-Amz-Server-Side-Encryption": "AES256",
+		} {
+			if got := o.header.Get(k); got != want {
+				t.Errorf("%s: got %q, want %q", k, got, want)
+			}
+		}
+
+		rc, err := c.Get(t.Context(), "example.com/@v/v1.0.0.mod")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(rc); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "module example.com"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := rc.(interface{ ETag() string }).ETag(), `"18"`; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if rc.(interface{ LastModified() time.Time }).LastModified().IsZero() {
+			t.Error("expected non-zero last modified time")
+		}
+		if _, err := rc.(io.Seeker).Seek(7, io.SeekStart); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(rc); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "example.com"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		rc.Close()
+
+		names, err := c.List(t.Context(), "example.com/@v/v1.0.0.")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := strings.Join(names, ","), "example.com/@v/v1.0.0.info,example.com/@v/v1.0.0.mod,example.com/@v/v1.0.0.zip"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if err := c.Delete(t.Context(), "example.com/@v/v1.0.0.mod"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := c.Delete(t.Context(), "example.com/@v/v1.0.0.mod"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err, fs.ErrNotExist; !errors.Is(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if _, err := c.Get(t.Context(), "example.com/@v/v1.0.0.mod"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err, fs.ErrNotExist; !errors.Is(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("SSEKMS", func(t *testing.T) {
+		c, fs3 := newFakeS3Cacher(t)
+		c.ServerSideEncryption = "aws:kms"
+		c.SSEKMSKeyID = "key"
+		if err := c.Put(t.Context(), "foo", strings.NewReader("bar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for k, want := range map[string]string{
+			"X-Amz-Server-Side-Encryption":                "aws:kms",
+			"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "key",
+		} {
+			if got := fs3.objects["foo"].header.Get(k); got != want {
+				t.Errorf("%s: got %q, want %q", k, got, want)
+			}
+		}
+	})
+
+	t.Run("SSEC", func(t *testing.T) {
+		c, fs3 := newFakeS3Cacher(t)
+		c.SSECustomerKey = bytes.Repeat([]byte{1}, 32)
+		if err := c.Put(t.Context(), "foo", strings.NewReader("bar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := fs3.objects["foo"].header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"), "AES256"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		rc, err := c.Get(t.Context(), "foo")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		rc.Close()
+	})
+
+	t.Run("RedirectURL", func(t *testing.T) {
+		c, _ := newFakeS3Cacher(t)
+		c.KeyPrefix = "goproxy/"
+		if err := c.Put(t.Context(), "example.com/!foo/@v/v1.0.0.zip", strings.NewReader("zip")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		redirectURL, err := c.RedirectURL(t.Context(), "example.com/!foo/@v/v1.0.0.zip")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		u, err := url.Parse(redirectURL)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := u.Path, "/test/goproxy/example.com/!foo/@v/v1.0.0.zip"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := u.Query().Get("X-Amz-Expires"), "900"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if u.Query().Get("X-Amz-Signature") == "" {
+			t.Error("expected presigned URL")
+		}
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, redirectURL, nil)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		res, err := c.Transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer res.Body.Close()
+		if b, err := io.ReadAll(res.Body); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "zip"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if _, err := c.RedirectURL(t.Context(), "example.com/@v/v1.0.0.zip"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err, fs.ErrNotExist; !errors.Is(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("RedirectBaseURL", func(t *testing.T) {
+		c, _ := newFakeS3Cacher(t)
+		c.KeyPrefix = "goproxy/"
+		c.RedirectBaseURL = "https://cdn.example.com/modules/"
+		if err := c.Put(t.Context(), "example.com/@v/v1.0.0.zip", strings.NewReader("zip")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if redirectURL, err := c.RedirectURL(t.Context(), "example.com/@v/v1.0.0.zip"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if got, want := redirectURL, "https://cdn.example.com/modules/goproxy/example.com/@v/v1.0.0.zip"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("RedirectURLSSEC", func(t *testing.T) {
+		c, _ := newFakeS3Cacher(t)
+		c.SSECustomerKey = bytes.Repeat([]byte{1}, 32)
+		if err := c.Put(t.Context(), "example.com/@v/v1.0.0.zip", strings.NewReader("zip")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if redirectURL, err := c.RedirectURL(t.Context(), "example.com/@v/v1.0.0.zip"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if got, want := redirectURL, ""; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("InvalidServerSideEncryption", func(t *testing.T) {
+		for _, tt := range []struct {
+			n       int
+			c       *Cacher
+			wantErr string
+		}{
+			{
+				n:       1,
+				c:       &Cacher{ServerSideEncryption: "foo"},
+				wantErr: `invalid server-side encryption: "foo"`,
+			},
+			{
+				n:       2,
+				c:       &Cacher{ServerSideEncryption: "AES256", SSECustomerKey: bytes.Repeat([]byte{1}, 32)},
+				wantErr: "server-side encryption and customer-provided key cannot be used together",
+			},
+		} {
+			t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+				if _, err := tt.c.Get(t.Context(), "foo"); err == nil {
+					t.Fatal("expected error")
+				} else if got, want := err.Error(), tt.wantErr; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+				if err := tt.c.Put(t.Context(), "foo", strings.NewReader("bar")); err == nil {
+					t.Fatal("expected error")
+				}
+				if _, err := tt.c.List(t.Context(), ""); err == nil {
+					t.Fatal("expected error")
+				}
+				if err := tt.c.Delete(t.Context(), "foo"); err == nil {
+					t.Fatal("expected error")
+				}
+			})
+		}
+	})
+
+	t.Run("EnvCredentials", func(t *testing.T) {
+		c, _ := newFakeS3Cacher(t)
+		c.AccessKeyID = ""
+		c.SecretAccessKey = ""
+		t.Setenv("AWS_ACCESS_KEY_ID", "test")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+		if err := c.Put(t.Context(), "foo", strings.NewReader("bar")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
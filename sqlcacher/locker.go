@@ -0,0 +1,120 @@
+package sqlcacher
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// lockerPollInterval is the interval between attempts of [Locker.Lock] to
+// acquire a lock held by another holder.
+const lockerPollInterval = 100 * time.Millisecond
+
+// Locker implements [github.com/goproxy/goproxy.Locker] using the database of
+// a [Cacher].
+//
+// Locks are stored in a table named after the table of the Cacher with the
+// "_locks" suffix, which is created if it does not exist. Each lock is a row
+// holding a random token of its holder and an expiration time, which is
+// refreshed by its holder, so that a lock held by a holder that has gone away
+// is eventually released.
+//
+// For SQLite, make sure that the DB has a busy timeout set (such as using the
+// "_pragma=busy_timeout(5000)" DSN parameter of "modernc.org/sqlite"), since
+// lock attempts from multiple connections write concurrently.
+//
+// Make sure to set all fields before calling any methods. A Locker must not
+// be copied after first use.
+type Locker struct {
+	// Cacher is the [Cacher] whose DB, Dialect, and Table are used.
+	Cacher *Cacher
+
+	// TTL is the time to live of the locks. It is refreshed every third of
+	// the TTL while a lock is held.
+	//
+	// If TTL is zero, 30 seconds is used.
+	TTL time.Duration
+
+	initOnce sync.Once
+	initErr  error
+	table    string
+	ttl      time.Duration
+}
+
+// init initializes the l.
+func (l *Locker) init() {
+	l.Cacher.initOnce.Do(l.Cacher.init)
+	if l.Cacher.initErr != nil {
+		l.initErr = l.Cacher.initErr
+		return
+	}
+	l.table = l.Cacher.table + "_locks"
+	l.ttl = l.TTL
+	if l.ttl == 0 {
+		l.ttl = 30 * time.Second
+	}
+	_, l.initErr = l.Cacher.DB.Exec(`CREATE TABLE IF NOT EXISTS ` + l.table + ` (
+		name TEXT PRIMARY KEY,
+		token TEXT NOT NULL,
+		expires_at BIGINT NOT NULL
+	)`)
+}
+
+// Lock implements [github.com/goproxy/goproxy.Locker].
+func (l *Locker) Lock(ctx context.Context, name string) (unlock func(), err error) {
+	l.initOnce.Do(l.init)
+	if l.initErr != nil {
+		return nil, l.initErr
+	}
+	token := rand.Text()
+	for {
+		now := time.Now()
+		result, err := l.Cacher.DB.ExecContext(ctx, `INSERT INTO `+l.table+` (name, token, expires_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (name) DO UPDATE SET
+				token = excluded.token,
+				expires_at = excluded.expires_at
+			WHERE `+l.table+`.expires_at <= $4`,
+			name, token, now.Add(l.ttl).UnixMilli(), now.UnixMilli())
+		if err != nil {
+			return nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if n > 0 {
+			break
+		}
+
+		timer := time.NewTimer(lockerPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	holdCtx, cancelHold := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-holdCtx.Done():
+				return
+			case now := <-ticker.C:
+				l.Cacher.DB.ExecContext(holdCtx, `UPDATE `+l.table+` SET expires_at = $1 WHERE name = $2 AND token = $3`, now.Add(l.ttl).UnixMilli(), name, token)
+			}
+		}
+	}()
+	return sync.OnceFunc(func() {
+		cancelHold()
+		<-done
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), l.ttl)
+		defer cancel()
+		l.Cacher.DB.ExecContext(releaseCtx, `DELETE FROM `+l.table+` WHERE name = $1 AND token = $2`, name, token)
+	}), nil
+}
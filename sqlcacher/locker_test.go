@@ -0,0 +1,108 @@
+package sqlcacher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLocker(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		l := &Locker{Cacher: newSQLiteCacher(t)}
+		unlock, err := l.Lock(t.Context(), "example.com/@v/v1.0.0")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		var expiresAt int64
+		if err := l.Cacher.DB.QueryRow(`SELECT expires_at FROM goproxy_caches_locks WHERE name = $1`, "example.com/@v/v1.0.0").Scan(&expiresAt); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got := time.Until(time.UnixMilli(expiresAt)); got <= 0 || got > 30*time.Second {
+			t.Errorf("got %v, want within 30s", got)
+		}
+
+		lockedCh := make(chan func(), 1)
+		go func() {
+			unlock, err := l.Lock(t.Context(), "example.com/@v/v1.0.0")
+			if err != nil {
+				t.Errorf("unexpected error %v", err)
+				unlock = func() {}
+			}
+			lockedCh <- unlock
+		}()
+		time.Sleep(2 * lockerPollInterval)
+		select {
+		case <-lockedCh:
+			t.Fatal("expected lock to be held")
+		default:
+		}
+		unlock()
+		unlock() // Unlock is idempotent.
+		(<-lockedCh)()
+
+		var n int
+		if err := l.Cacher.DB.QueryRow(`SELECT COUNT(*) FROM goproxy_caches_locks`).Scan(&n); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := n, 0; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		c := newSQLiteCacher(t)
+		c.Table = "caches"
+		l := &Locker{Cacher: c}
+		unlock, err := l.Lock(t.Context(), "example.com/@latest")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := c.DB.Exec(`UPDATE caches_locks SET expires_at = $1`, time.Now().Add(-time.Second).UnixMilli()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+		defer cancel()
+		unlock2, err := l.Lock(ctx, "example.com/@latest")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		// Releasing the expired lock must not release the lock of the
+		// new holder.
+		unlock()
+		var n int
+		if err := c.DB.QueryRow(`SELECT COUNT(*) FROM caches_locks`).Scan(&n); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := n, 1; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+		unlock2()
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		l := &Locker{Cacher: newSQLiteCacher(t)}
+		unlock, err := l.Lock(t.Context(), "example.com/@v/list")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer unlock()
+
+		ctx, cancel := context.WithTimeout(t.Context(), 2*lockerPollInterval)
+		defer cancel()
+		if _, err := l.Lock(ctx, "example.com/@v/list"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("InvalidCacher", func(t *testing.T) {
+		l := &Locker{Cacher: &Cacher{}}
+		if _, err := l.Lock(t.Context(), "example.com/@v/list"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), "missing database"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,422 @@
+/*
+Package sqlcacher implements [github.com/goproxy/goproxy.Cacher] using a SQL
+database, such as SQLite or PostgreSQL.
+
+Caches are stored in a single table (see [Cacher.Table]) with the following
+columns, which can also be queried directly:
+
+	name           TEXT PRIMARY KEY  -- cache name
+	module_path    TEXT NOT NULL     -- module path, or empty if not applicable
+	module_version TEXT NOT NULL     -- module version, or empty if not applicable
+	size           BIGINT NOT NULL   -- content size in bytes
+	content        BLOB              -- content, or NULL if stored in Cacher.BlobCacher
+	checksum       TEXT NOT NULL     -- hex-encoded SHA-256 checksum of the content
+	mod_time       BIGINT NOT NULL   -- time the cache was put, in Unix milliseconds
+
+The database driver is not imported by this package, so it must be
+registered by the caller, for example by importing "modernc.org/sqlite".
+
+The same database can also be used to lock direct fetches across hosts using
+[Locker].
+*/
+package sqlcacher
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/goproxy/goproxy"
+	"golang.org/x/mod/module"
+)
+
+// Cacher implements [github.com/goproxy/goproxy.Cacher] using a SQL database.
+//
+// Each Put is a single atomic upsert, so a cache is either fully visible or
+// not at all. In addition to Get and Put, Cacher also implements the List
+// and Delete methods documented in [github.com/goproxy/goproxy.Cacher], and
+// provides the [Cacher.Modules] and [Cacher.Entries] methods for querying the
+// index of cached modules.
+//
+// Make sure to set all fields before calling any methods. A Cacher must not
+// be copied after first use.
+type Cacher struct {
+	// DB is the database.
+	DB *sql.DB
+
+	// Dialect is the SQL dialect of the DB. Valid values are "sqlite" and
+	// "postgres". For "postgres", the name column is created with the "C"
+	// collation, so that listing caches by prefix can use its index.
+	//
+	// If Dialect is empty, "sqlite" is used.
+	Dialect string
+
+	// Table is the name of the table that stores the caches. It is created
+	// along with its indexes if it does not exist.
+	//
+	// If Table is empty, "goproxy_caches" is used.
+	Table string
+
+	// BlobCacher is the [github.com/goproxy/goproxy.Cacher] used to store the
+	// content of caches, in which case the DB only stores their metadata.
+	//
+	// If BlobCacher is nil, the content is stored in the DB.
+	BlobCacher goproxy.Cacher
+
+	initOnce sync.Once
+	initErr  error
+	table    string
+	collate  string
+}
+
+// tableNameRegexp matches valid table names.
+var tableNameRegexp = regexp.MustCompile(`^[A-Za-z_][0-9A-Za-z_]*$`)
+
+// init initializes the c.
+func (c *Cacher) init() {
+	if c.DB == nil {
+		c.initErr = errors.New("missing database")
+		return
+	}
+
+	var blobType string
+	switch c.Dialect {
+	case "", "sqlite":
+		blobType = "BLOB"
+	case "postgres":
+		blobType = "BYTEA"
+
+		// Names are compared byte by byte in range predicates, which is
+		// what the "C" collation does.
+		c.collate = ` COLLATE "C"`
+	default:
+		c.initErr = errors.New("invalid dialect: " + strconv.Quote(c.Dialect))
+		return
+	}
+
+	c.table = c.Table
+	if c.table == "" {
+		c.table = "goproxy_caches"
+	} else if !tableNameRegexp.MatchString(c.table) {
+		c.initErr = errors.New("invalid table name: " + strconv.Quote(c.table))
+		return
+	}
+
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS ` + c.table + ` (
+			name TEXT` + c.collate + ` PRIMARY KEY,
+			module_path TEXT NOT NULL,
+			module_version TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			content ` + blobType + `,
+			checksum TEXT NOT NULL,
+			mod_time BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS ` + c.table + `_module ON ` + c.table + ` (module_path, module_version)`,
+		`CREATE INDEX IF NOT EXISTS ` + c.table + `_mod_time ON ` + c.table + ` (mod_time)`,
+	} {
+		if _, err := c.DB.Exec(stmt); err != nil {
+			c.initErr = err
+			return
+		}
+	}
+}
+
+// Get implements [github.com/goproxy/goproxy.Cacher].
+func (c *Cacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return nil, c.initErr
+	}
+	var (
+		content  []byte
+		checksum string
+		modTime  int64
+	)
+	if err := c.DB.QueryRowContext(ctx, `SELECT content, checksum, mod_time FROM `+c.table+` WHERE name = $1`, name).Scan(&content, &checksum, &modTime); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+	if content == nil && c.BlobCacher != nil {
+		return c.BlobCacher.Get(ctx, name)
+	}
+	return &cache{
+		Reader:       bytes.NewReader(content),
+		lastModified: time.UnixMilli(modTime),
+		etag:         strconv.Quote(checksum),
+	}, nil
+}
+
+// Put implements [github.com/goproxy/goproxy.Cacher].
+func (c *Cacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return c.initErr
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	var (
+		size int64
+		b    []byte
+	)
+	if c.BlobCacher != nil {
+		var err error
+		if size, err = io.Copy(h, content); err != nil {
+			return err
+		}
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := c.BlobCacher.Put(ctx, name, content); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if b, err = io.ReadAll(io.TeeReader(content, h)); err != nil {
+			return err
+		}
+		size = int64(len(b))
+	}
+
+	modulePath, moduleVersion := parseName(name)
+	_, err := c.DB.ExecContext(ctx, `INSERT INTO `+c.table+` (name, module_path, module_version, size, content, checksum, mod_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (name) DO UPDATE SET
+			module_path = excluded.module_path,
+			module_version = excluded.module_version,
+			size = excluded.size,
+			content = excluded.content,
+			checksum = excluded.checksum,
+			mod_time = excluded.mod_time`,
+		name, modulePath, moduleVersion, size, b, hex.EncodeToString(h.Sum(nil)), time.Now().UnixMilli())
+	return err
+}
+
+// List lists the names of all caches that start with the prefix, in lexical
+// order. See [github.com/goproxy/goproxy.Cacher] for details.
+func (c *Cacher) List(ctx context.Context, prefix string) ([]string, error) {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return nil, c.initErr
+	}
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	if upperBound, ok := prefixUpperBound(prefix); ok {
+		rows, err = c.DB.QueryContext(ctx, `SELECT name FROM `+c.table+` WHERE name`+c.collate+` >= $1 AND name`+c.collate+` < $2`, prefix, upperBound)
+	} else {
+		rows, err = c.DB.QueryContext(ctx, `SELECT name FROM `+c.table+` WHERE name`+c.collate+` >= $1`, prefix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// Delete deletes the cache for the name. It returns [fs.ErrNotExist] if not
+// found. See [github.com/goproxy/goproxy.Cacher] for details.
+func (c *Cacher) Delete(ctx context.Context, name string) error {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return c.initErr
+	}
+	result, err := c.DB.ExecContext(ctx, `DELETE FROM `+c.table+` WHERE name = $1`, name)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fs.ErrNotExist
+	}
+	if cd, ok := c.BlobCacher.(interface {
+		Delete(ctx context.Context, name string) error
+	}); ok {
+		if err := cd.Delete(ctx, name); err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Modules returns the paths of all modules that have caches, in lexical
+// order.
+func (c *Cacher) Modules(ctx context.Context) ([]string, error) {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return nil, c.initErr
+	}
+	rows, err := c.DB.QueryContext(ctx, `SELECT DISTINCT module_path FROM `+c.table+` WHERE module_path <> ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var modulePaths []string
+	for rows.Next() {
+		var modulePath string
+		if err := rows.Scan(&modulePath); err != nil {
+			return nil, err
+		}
+		modulePaths = append(modulePaths, modulePath)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(modulePaths)
+	return modulePaths, nil
+}
+
+// Entry is an entry of the index of caches returned by [Cacher.Entries].
+type Entry struct {
+	Name          string
+	ModulePath    string
+	ModuleVersion string
+	Size          int64
+	ModTime       time.Time
+}
+
+// Entries returns the index entries of all caches for the modulePath, in
+// lexical order of their names.
+func (c *Cacher) Entries(ctx context.Context, modulePath string) ([]Entry, error) {
+	c.initOnce.Do(c.init)
+	if c.initErr != nil {
+		return nil, c.initErr
+	}
+	rows, err := c.DB.QueryContext(ctx, `SELECT name, module_path, module_version, size, mod_time FROM `+c.table+` WHERE module_path = $1`, modulePath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []Entry
+	for rows.Next() {
+		var (
+			entry   Entry
+			modTime int64
+		)
+		if err := rows.Scan(&entry.Name, &entry.ModulePath, &entry.ModuleVersion, &entry.Size, &modTime); err != nil {
+			return nil, err
+		}
+		entry.ModTime = time.UnixMilli(modTime)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return entries, nil
+}
+
+// prefixUpperBound returns the exclusive upper bound of the range of strings
+// that start with the prefix. It reports false if there is no such bound in
+// valid UTF-8.
+func prefixUpperBound(prefix string) (string, bool) {
+	for prefix != "" {
+		r, size := utf8.DecodeLastRuneInString(prefix)
+		prefix = prefix[:len(prefix)-size]
+		if r == utf8.RuneError && size == 1 {
+			return "", false
+		}
+		if r < utf8.MaxRune {
+			r++
+			if utf16.IsSurrogate(r) {
+				r = 0xe000
+			}
+			return prefix + string(r), true
+		}
+	}
+	return "", false
+}
+
+// parseName parses the module path and version from the cache name. Either
+// is empty if not applicable.
+func parseName(name string) (modulePath, moduleVersion string) {
+	name = strings.TrimPrefix(name, "quarantine/")
+	escapedModulePath, rest, ok := strings.Cut(name, "/@")
+	if !ok {
+		return "", ""
+	}
+	modulePath, err := module.UnescapePath(escapedModulePath)
+	if err != nil {
+		return "", ""
+	}
+	file, ok := strings.CutPrefix(rest, "v/")
+	if !ok {
+		return modulePath, ""
+	}
+	versionEnd := -1
+	for _, ext := range []string{".info", ".mod", ".zip", ".ziphash"} {
+		i := strings.LastIndex(file, ext)
+		if i <= 0 || i < versionEnd {
+			continue
+		}
+		if suffix := file[i+len(ext):]; suffix == "" || suffix[0] == '.' {
+			versionEnd = i
+		}
+	}
+	if versionEnd < 0 {
+		return modulePath, ""
+	}
+	moduleVersion, err = module.UnescapeVersion(file[:versionEnd])
+	if err != nil {
+		return modulePath, ""
+	}
+	return modulePath, moduleVersion
+}
+
+// cache is the cache returned by [Cacher.Get].
+type cache struct {
+	*bytes.Reader
+	lastModified time.Time
+	etag         string
+}
+
+// Close implements [io.Closer].
+func (c *cache) Close() error {
+	return nil
+}
+
+// LastModified implements [github.com/goproxy/goproxy.Cacher.Get].
+func (c *cache) LastModified() time.Time {
+	return c.lastModified
+}
+
+// ETag implements [github.com/goproxy/goproxy.Cacher.Get].
+func (c *cache) ETag() string {
+	return c.etag
+}
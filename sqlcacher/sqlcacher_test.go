@@ -0,0 +1,300 @@
+package sqlcacher
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goproxy/goproxy"
+	_ "modernc.org/sqlite"
+)
+
+// newSQLiteCacher returns a new [Cacher] backed by a new SQLite database.
+func newSQLiteCacher(t *testing.T) *Cacher {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "caches.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &Cacher{DB: db}
+}
+
+func TestCacher(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		c := newSQLiteCacher(t)
+		for name, content := range map[string]string{
+			"example.com/@v/list":         "v1.0.0",
+			"example.com/@latest":         `{"Version":"v1.0.0"}`,
+			"example.com/@v/v1.0.0.info":  "{}",
+			"example.com/@v/v1.0.0.mod":   "",
+			"example.com/@v/v1.0.0.zip":   "zip",
+			"sumdb/sum.golang.org/latest": "latest",
+		} {
+			if err := c.Put(t.Context(), name, strings.NewReader(content)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		if err := c.Put(t.Context(), "example.com/@v/v1.0.0.info", strings.NewReader(`{"Version":"v1.0.0"}`)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		rc, err := c.Get(t.Context(), "example.com/@v/v1.0.0.info")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(rc); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), `{"Version":"v1.0.0"}`; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if _, ok := rc.(io.Seeker); !ok {
+			t.Error("expected io.Seeker")
+		}
+		if got, want := rc.(interface{ ETag() string }).ETag(), fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(`{"Version":"v1.0.0"}`))); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if lastModified := rc.(interface{ LastModified() time.Time }).LastModified(); time.Since(lastModified) > time.Minute {
+			t.Errorf("got %v, want recent time", lastModified)
+		}
+		rc.Close()
+
+		if rc, err := c.Get(t.Context(), "example.com/@v/v1.0.0.mod"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if b, err := io.ReadAll(rc); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if len(b) != 0 {
+			t.Errorf("got %q, want empty", b)
+		}
+
+		names, err := c.List(t.Context(), "example.com/@v/")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := names, []string{
+			"example.com/@v/list",
+			"example.com/@v/v1.0.0.info",
+			"example.com/@v/v1.0.0.mod",
+			"example.com/@v/v1.0.0.zip",
+		}; !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if names, err := c.List(t.Context(), "example.com/@v/v1.0.0."); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if got, want := names, []string{
+			"example.com/@v/v1.0.0.info",
+			"example.com/@v/v1.0.0.mod",
+			"example.com/@v/v1.0.0.zip",
+		}; !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if names, err := c.List(t.Context(), "EXAMPLE.COM/"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if len(names) != 0 {
+			t.Errorf("got %q, want none", names)
+		}
+
+		if err := c.Delete(t.Context(), "example.com/@v/v1.0.0.zip"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := c.Get(t.Context(), "example.com/@v/v1.0.0.zip"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+		if err := c.Delete(t.Context(), "example.com/@v/v1.0.0.zip"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("Index", func(t *testing.T) {
+		c := newSQLiteCacher(t)
+		for _, name := range []string{
+			"example.com/!foo/@v/v1.0.0.info",
+			"example.com/!foo/@v/v1.0.0.zip",
+			"example.com/!foo/@v/v1.0.0.zip.sha256",
+			"example.com/!foo/@v/v1.1.0-rc.mod.info",
+			"example.com/!foo/@v/list",
+			"example.com/bar/@v/v0.1.0.ziphash",
+			"quarantine/example.com/bar/@v/v0.2.0.zip",
+			"sumdb/sum.golang.org/latest",
+		} {
+			if err := c.Put(t.Context(), name, strings.NewReader(name)); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+
+		modulePaths, err := c.Modules(t.Context())
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := modulePaths, []string{"example.com/Foo", "example.com/bar"}; !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		entries, err := c.Entries(t.Context(), "example.com/Foo")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.Name+" "+entry.ModuleVersion+" "+strconv.FormatInt(entry.Size, 10))
+			if time.Since(entry.ModTime) > time.Minute {
+				t.Errorf("got %v, want recent time", entry.ModTime)
+			}
+		}
+		if want := []string{
+			"example.com/!foo/@v/list  24",
+			"example.com/!foo/@v/v1.0.0.info v1.0.0 31",
+			"example.com/!foo/@v/v1.0.0.zip v1.0.0 30",
+			"example.com/!foo/@v/v1.0.0.zip.sha256 v1.0.0 37",
+			"example.com/!foo/@v/v1.1.0-rc.mod.info v1.1.0-rc.mod 38",
+		}; !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if entries, err := c.Entries(t.Context(), "example.com/bar"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		} else if got, want := len(entries), 2; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("BlobCacher", func(t *testing.T) {
+		c := newSQLiteCacher(t)
+		dc := goproxy.DirCacher(t.TempDir())
+		c.BlobCacher = dc
+		if err := c.Put(t.Context(), "example.com/@v/v1.0.0.zip", strings.NewReader("zip")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		var content []byte
+		var size int64
+		if err := c.DB.QueryRow(`SELECT content, size FROM goproxy_caches WHERE name = $1`, "example.com/@v/v1.0.0.zip").Scan(&content, &size); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if content != nil {
+			t.Errorf("got %q, want nil", content)
+		}
+		if got, want := size, int64(3); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+
+		rc, err := c.Get(t.Context(), "example.com/@v/v1.0.0.zip")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b, err := io.ReadAll(rc); err != nil {
+			t.Errorf("unexpected error %v", err)
+		} else if got, want := string(b), "zip"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		rc.Close()
+
+		if err := c.Delete(t.Context(), "example.com/@v/v1.0.0.zip"); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := dc.Get(t.Context(), "example.com/@v/v1.0.0.zip"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("Table", func(t *testing.T) {
+		c := newSQLiteCacher(t)
+		c.Table = "caches"
+		if err := c.Put(t.Context(), "example.com/@v/list", strings.NewReader("v1.0.0")); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		var n int
+		if err := c.DB.QueryRow(`SELECT COUNT(*) FROM caches`).Scan(&n); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got, want := n, 1; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("InvalidTable", func(t *testing.T) {
+		c := newSQLiteCacher(t)
+		c.Table = "caches; DROP TABLE caches"
+		if _, err := c.Get(t.Context(), "example.com/@v/list"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), `invalid table name: "caches; DROP TABLE caches"`; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("InvalidDialect", func(t *testing.T) {
+		c := newSQLiteCacher(t)
+		c.Dialect = "foo"
+		if _, err := c.Get(t.Context(), "example.com/@v/list"); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := err.Error(), `invalid dialect: "foo"`; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestParseName(t *testing.T) {
+	for _, tt := range []struct {
+		n                 int
+		name              string
+		wantModulePath    string
+		wantModuleVersion string
+	}{
+		{n: 1, name: "example.com/@v/v1.0.0.info", wantModulePath: "example.com", wantModuleVersion: "v1.0.0"},
+		{n: 2, name: "example.com/!foo/@v/v1.0.0.zip", wantModulePath: "example.com/Foo", wantModuleVersion: "v1.0.0"},
+		{n: 3, name: "example.com/@v/v1.0.0.ziphash", wantModulePath: "example.com", wantModuleVersion: "v1.0.0"},
+		{n: 4, name: "example.com/@v/v1.0.0.mod.sha256", wantModulePath: "example.com", wantModuleVersion: "v1.0.0"},
+		{n: 5, name: "example.com/@v/v1.0.0-!r!c.info", wantModulePath: "example.com", wantModuleVersion: "v1.0.0-RC"},
+		{n: 6, name: "example.com/@v/list", wantModulePath: "example.com"},
+		{n: 7, name: "example.com/@latest", wantModulePath: "example.com"},
+		{n: 8, name: "quarantine/example.com/@v/v1.0.0.zip", wantModulePath: "example.com", wantModuleVersion: "v1.0.0"},
+		{n: 9, name: "sumdb/sum.golang.org/latest"},
+		{n: 10, name: "example.com/Foo/@v/v1.0.0.info"},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			modulePath, moduleVersion := parseName(tt.name)
+			if got, want := modulePath, tt.wantModulePath; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := moduleVersion, tt.wantModuleVersion; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestPrefixUpperBound(t *testing.T) {
+	for _, tt := range []struct {
+		n              int
+		prefix         string
+		wantUpperBound string
+		wantOK         bool
+	}{
+		{n: 1, prefix: "example.com/@v/", wantUpperBound: "example.com/@v0", wantOK: true},
+		{n: 2, prefix: "a", wantUpperBound: "b", wantOK: true},
+		{n: 3, prefix: "a\u00ff", wantUpperBound: "a\u0100", wantOK: true},
+		{n: 4, prefix: "a\ud7ff", wantUpperBound: "a\ue000", wantOK: true},
+		{n: 5, prefix: "a\U0010ffff", wantUpperBound: "b", wantOK: true},
+		{n: 6, prefix: "\U0010ffff"},
+		{n: 7, prefix: ""},
+		{n: 8, prefix: "a\xff"},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			upperBound, ok := prefixUpperBound(tt.prefix)
+			if got, want := ok, tt.wantOK; got != want {
+				t.Errorf("got %t, want %t", got, want)
+			}
+			if got, want := upperBound, tt.wantUpperBound; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -25,12 +25,14 @@ type sumdbClientOps struct {
 	urlDetermineErr   error
 	envGOPROXY        string
 	httpClient        *http.Client
+	retryPolicy       *RetryPolicy
+	securityError     func(msg string)
 }
 
 // newSumdbClientOps creates a new [sumdbClientOps].
-func newSumdbClientOps(envGOPROXY, envGOSUMDB string, httpClient *http.Client) (*sumdbClientOps, error) {
+func newSumdbClientOps(envGOPROXY, envGOSUMDB string, httpClient *http.Client, retryPolicy *RetryPolicy) (*sumdbClientOps, error) {
 	var (
-		sco         = &sumdbClientOps{envGOPROXY: envGOPROXY, httpClient: httpClient}
+		sco         = &sumdbClientOps{envGOPROXY: envGOPROXY, httpClient: httpClient, retryPolicy: retryPolicy}
 		u           *url.URL
 		isDirectURL bool
 		err         error
@@ -60,9 +62,9 @@ func (sco *sumdbClientOps) url() (*url.URL, error) {
 	}
 
 	u := sco.directURL
-	err := walkEnvGOPROXY(sco.envGOPROXY, func(proxy *url.URL) error {
+	err := walkEnvGOPROXY(sco.envGOPROXY, nil, func(proxy *url.URL) error {
 		pu := proxy.JoinPath("sumdb", sco.name)
-		if err := httpGet(context.Background(), sco.httpClient, pu.JoinPath("/supported").String(), nil); err != nil {
+		if err := httpGet(context.Background(), sco.httpClient, sco.retryPolicy, pu.JoinPath("/supported").String(), nil); err != nil {
 			return err
 		}
 		u = pu
@@ -86,7 +88,7 @@ func (sco *sumdbClientOps) ReadRemote(path string) ([]byte, error) {
 		return nil, err
 	}
 	var buf bytes.Buffer
-	if err := httpGet(context.Background(), sco.httpClient, u.JoinPath(path).String(), &buf); err != nil {
+	if err := httpGet(context.Background(), sco.httpClient, sco.retryPolicy, u.JoinPath(path).String(), &buf); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
@@ -116,4 +118,8 @@ func (*sumdbClientOps) WriteCache(file string, data []byte) {}
 func (*sumdbClientOps) Log(msg string) {}
 
 // SecurityError implements [golang.org/x/mod/sumdb.ClientOps].
-func (*sumdbClientOps) SecurityError(msg string) {}
+func (sco *sumdbClientOps) SecurityError(msg string) {
+	if sco.securityError != nil {
+		sco.securityError(msg)
+	}
+}
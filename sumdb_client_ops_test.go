@@ -21,7 +21,7 @@ func TestNewSumDBClientOps(t *testing.T) {
 		{3, "", errors.New("missing GOSUMDB")},
 	} {
 		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
-			sco, err := newSumdbClientOps(defaultEnvGOPROXY, tt.envGOSUMDB, http.DefaultClient)
+			sco, err := newSumdbClientOps(defaultEnvGOPROXY, tt.envGOSUMDB, http.DefaultClient, nil)
 			if tt.wantErr != nil {
 				if err == nil {
 					t.Fatal("expected error")
@@ -107,7 +107,7 @@ func TestSumDBClientOpsURL(t *testing.T) {
 			proxyServer := newHTTPTestServer(t, tt.proxyHandler)
 			envGOPROXY := tt.envGOPROXY(proxyServer.URL)
 
-			sco, err := newSumdbClientOps(envGOPROXY, tt.envGOSUMDB, http.DefaultClient)
+			sco, err := newSumdbClientOps(envGOPROXY, tt.envGOSUMDB, http.DefaultClient, nil)
 			if err != nil {
 				t.Fatalf("unexpected error %v", err)
 			}
@@ -172,7 +172,7 @@ func TestSumDBClientOpsReadRemote(t *testing.T) {
 		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
 			proxyServer := newHTTPTestServer(t, tt.proxyHandler)
 
-			sco, err := newSumdbClientOps(proxyServer.URL, defaultEnvGOSUMDB, http.DefaultClient)
+			sco, err := newSumdbClientOps(proxyServer.URL, defaultEnvGOSUMDB, http.DefaultClient, nil)
 			if err != nil {
 				t.Fatalf("unexpected error %v", err)
 			}
@@ -220,7 +220,7 @@ func TestSumDBClientOpsReadConfig(t *testing.T) {
 		},
 	} {
 		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
-			sco, err := newSumdbClientOps("direct", defaultEnvGOSUMDB, http.DefaultClient)
+			sco, err := newSumdbClientOps("direct", defaultEnvGOSUMDB, http.DefaultClient, nil)
 			if err != nil {
 				t.Fatalf("unexpected error %v", err)
 			}
@@ -286,6 +286,18 @@ func TestSumDBClientOpsExtraCalls(t *testing.T) {
 			},
 			wantErr: fs.ErrNotExist,
 		},
+		{
+			n: 6,
+			call: func(sco *sumdbClientOps) error {
+				var got string
+				sco.securityError = func(msg string) { got = msg }
+				sco.SecurityError("foobar")
+				if want := "foobar"; got != want {
+					return fmt.Errorf("got %q, want %q", got, want)
+				}
+				return nil
+			},
+		},
 	} {
 		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
 			err := tt.call(&sumdbClientOps{})
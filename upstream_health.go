@@ -0,0 +1,191 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states of upstream proxies. See [UpstreamHealth].
+const (
+	UpstreamClosed   = "closed"
+	UpstreamOpen     = "open"
+	UpstreamHalfOpen = "half-open"
+)
+
+// UpstreamHealth is the health of an upstream proxy in the GOPROXY list of a
+// [GoFetcher]. See [GoFetcher.UpstreamHealth].
+type UpstreamHealth struct {
+	// URL is the URL of the upstream proxy, with any password redacted.
+	URL string
+
+	// State is the state of the circuit breaker of the upstream proxy,
+	// which is one of [UpstreamClosed], [UpstreamOpen], and
+	// [UpstreamHalfOpen].
+	State string
+
+	// ConsecutiveFailures is the number of failures since the last success.
+	ConsecutiveFailures int
+
+	// Successes is the total number of successful requests. Responses that
+	// report the requested content does not exist are also successful.
+	Successes int64
+
+	// Failures is the total number of failed requests.
+	Failures int64
+
+	// LastError is the error of the last failed request, if any.
+	LastError string `json:",omitempty"`
+
+	// LastFailureTime is the time of the last failed request, if any.
+	LastFailureTime time.Time `json:",omitzero"`
+}
+
+// UpstreamHealth returns the health of each upstream proxy in the GOPROXY
+// list of the gf, in the order they appear in the list. It returns nil if the
+// gf fails to initialize. See [GoFetcher.CircuitBreakerThreshold].
+func (gf *GoFetcher) UpstreamHealth() []UpstreamHealth {
+	gf.initOnce.Do(gf.init)
+	if gf.initErr != nil {
+		return nil
+	}
+	return gf.upstreamHealth.snapshot()
+}
+
+// upstreamHealth is the mutable health of an upstream proxy.
+type upstreamHealth struct {
+	UpstreamHealth
+	openedAt time.Time
+	probing  bool
+}
+
+// upstreamHealthTracker tracks the health of upstream proxies and implements
+// their circuit breakers.
+type upstreamHealthTracker struct {
+	threshold    int
+	openDuration time.Duration
+
+	mu        sync.Mutex
+	upstreams map[string]*upstreamHealth
+	order     []string
+}
+
+// newUpstreamHealthTracker returns a new [upstreamHealthTracker] for the
+// upstream proxies in the envGOPROXY. Circuit breakers trip after the
+// threshold (0 means never) consecutive failures and stay open for the
+// openDuration before a half-open probe is allowed.
+func newUpstreamHealthTracker(envGOPROXY string, threshold int, openDuration time.Duration) *upstreamHealthTracker {
+	uht := &upstreamHealthTracker{
+		threshold:    threshold,
+		openDuration: openDuration,
+		upstreams:    map[string]*upstreamHealth{},
+	}
+	for proxy := range strings.FieldsFuncSeq(envGOPROXY, func(r rune) bool { return r == ',' || r == '|' }) {
+		switch proxy {
+		case "direct", "off":
+			continue
+		}
+		if u, err := url.Parse(proxy); err == nil {
+			uht.upstream(u)
+		}
+	}
+	return uht
+}
+
+// upstream returns the health of the proxy, registering it if necessary. The
+// uht.mu must be held, unless the uht is not yet shared.
+func (uht *upstreamHealthTracker) upstream(proxy *url.URL) *upstreamHealth {
+	key := proxy.String()
+	uh, ok := uht.upstreams[key]
+	if !ok {
+		uh = &upstreamHealth{UpstreamHealth: UpstreamHealth{URL: proxy.Redacted(), State: UpstreamClosed}}
+		uht.upstreams[key] = uh
+		uht.order = append(uht.order, key)
+	}
+	return uh
+}
+
+// allow reports whether a request to the proxy is allowed by its circuit
+// breaker. Once the circuit breaker has been open for the uht.openDuration,
+// a single request is allowed as a half-open probe, whose result then closes
+// or reopens the circuit breaker.
+func (uht *upstreamHealthTracker) allow(proxy *url.URL) bool {
+	if uht == nil || uht.threshold <= 0 {
+		return true
+	}
+	uht.mu.Lock()
+	defer uht.mu.Unlock()
+	uh := uht.upstream(proxy)
+	switch uh.State {
+	case UpstreamOpen:
+		if time.Since(uh.openedAt) < uht.openDuration {
+			return false
+		}
+		uh.State = UpstreamHalfOpen
+	case UpstreamHalfOpen:
+		if uh.probing {
+			return false
+		}
+	default:
+		return true
+	}
+	uh.probing = true
+	return true
+}
+
+// healthy reports whether the circuit breaker of the proxy is closed.
+func (uht *upstreamHealthTracker) healthy(proxy *url.URL) bool {
+	if uht == nil || uht.threshold <= 0 {
+		return true
+	}
+	uht.mu.Lock()
+	defer uht.mu.Unlock()
+	return uht.upstream(proxy).State == UpstreamClosed
+}
+
+// record records the result of a request to the proxy made with the ctx.
+// Errors matching [fs.ErrNotExist] count as successes, since the proxy has
+// responded, and errors of requests whose ctx is done are ignored, since they
+// are caused by the client or by losing a hedged race. Other timeouts, such as
+// those of dials or reads, count as failures.
+func (uht *upstreamHealthTracker) record(ctx context.Context, proxy *url.URL, err error) {
+	if uht == nil {
+		return
+	}
+	uht.mu.Lock()
+	defer uht.mu.Unlock()
+	uh := uht.upstream(proxy)
+	uh.probing = false
+	switch {
+	case err == nil, errors.Is(err, fs.ErrNotExist):
+		uh.Successes++
+		uh.ConsecutiveFailures = 0
+		uh.State = UpstreamClosed
+	case ctx.Err() != nil:
+	default:
+		uh.Failures++
+		uh.ConsecutiveFailures++
+		uh.LastError = err.Error()
+		uh.LastFailureTime = time.Now()
+		if uht.threshold > 0 && (uh.State == UpstreamHalfOpen || uh.ConsecutiveFailures >= uht.threshold) {
+			uh.State = UpstreamOpen
+			uh.openedAt = uh.LastFailureTime
+		}
+	}
+}
+
+// snapshot returns the health of all upstream proxies in the order they were
+// registered.
+func (uht *upstreamHealthTracker) snapshot() []UpstreamHealth {
+	uht.mu.Lock()
+	defer uht.mu.Unlock()
+	health := make([]UpstreamHealth, 0, len(uht.order))
+	for _, key := range uht.order {
+		health = append(health, uht.upstreams[key].UpstreamHealth)
+	}
+	return health
+}
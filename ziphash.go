@@ -0,0 +1,65 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// hashZip returns the "h1:" hash of the zip content, as [dirhash.HashZip] does
+// for a zip file. It uses a temporary file in the tempDir if the content does
+// not implement [io.ReaderAt]. The content is rewound before returning.
+func hashZip(content io.ReadSeeker, tempDir string) (string, error) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	defer content.Seek(0, io.SeekStart)
+
+	ra, ok := content.(io.ReaderAt)
+	if !ok {
+		f, err := os.CreateTemp(tempDir, "")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if _, err := io.Copy(f, content); err != nil {
+			return "", err
+		}
+		ra = f
+	}
+
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return "", err
+	}
+	files := make([]string, 0, len(zr.File))
+	zfiles := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files = append(files, f.Name)
+		zfiles[f.Name] = f
+	}
+	return dirhash.DefaultHash(files, func(name string) (io.ReadCloser, error) {
+		f := zfiles[name]
+		if f == nil {
+			return nil, fmt.Errorf("file %q not found in zip", name)
+		}
+		return f.Open()
+	})
+}
+
+// hashMod returns the "h1:" hash of the mod file content, as used in the
+// "/go.mod" lines of go.sum files.
+func hashMod(mod []byte) (string, error) {
+	return dirhash.DefaultHash([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(mod)), nil
+	})
+}
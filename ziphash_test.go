@@ -0,0 +1,95 @@
+package goproxy
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+func TestHashZip(t *testing.T) {
+	zip, err := makeZip(map[string][]byte{
+		"example.com@v1.0.0/go.mod":  []byte("module example.com"),
+		"example.com@v1.0.0/main.go": []byte("package main"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	zipFile := filepath.Join(t.TempDir(), "v1.0.0.zip")
+	if err := os.WriteFile(zipFile, zip, 0o644); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	wantHash, err := dirhash.HashZip(zipFile, dirhash.DefaultHash)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for _, tt := range []struct {
+		n        int
+		content  io.ReadSeeker
+		wantHash string
+		wantErr  bool
+	}{
+		{
+			n:        1,
+			content:  bytes.NewReader(zip),
+			wantHash: wantHash,
+		},
+		{
+			n:        2,
+			content:  &testReadSeeker{ReadSeeker: bytes.NewReader(zip)},
+			wantHash: wantHash,
+		},
+		{
+			n:       3,
+			content: strings.NewReader("invalid"),
+			wantErr: true,
+		},
+	} {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			if _, err := tt.content.Seek(1, io.SeekStart); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			hash, err := hashZip(tt.content, t.TempDir())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if got, want := hash, tt.wantHash; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+			if offset, err := tt.content.Seek(0, io.SeekCurrent); err != nil {
+				t.Errorf("unexpected error %v", err)
+			} else if got, want := offset, int64(0); got != want {
+				t.Errorf("got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestHashMod(t *testing.T) {
+	mod := "module example.com\n"
+	modFile := filepath.Join(t.TempDir(), "go.mod")
+	if err := os.WriteFile(modFile, []byte(mod), 0o644); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	wantHash, err := dirhash.DefaultHash([]string{"go.mod"}, func(string) (io.ReadCloser, error) { return os.Open(modFile) })
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if hash, err := hashMod([]byte(mod)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if got, want := hash, wantHash; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}